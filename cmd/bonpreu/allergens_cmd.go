@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// runAllergensCommand lists the products that are free from, or contain,
+// the given EU allergens, such as the gluten-free products of a category.
+func runAllergensCommand(args []string) error {
+	fs, flags := newFlagSet("allergens")
+	retailer := fs.String("retailer", "", "only list products of this retailer")
+	category := fs.String("category", "", "only list products in this category (any level of the category path)")
+	freeFrom := fs.String("free-from", "", "comma-separated allergens the products must neither contain nor may contain: "+strings.Join(models.Allergens, ", "))
+	contains := fs.String("contains", "", "comma-separated allergens the products must contain")
+	limit := fs.Int("limit", 50, "maximum number of products to list (0 lists all)")
+	format := fs.String("format", "text", "output format: text or json")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+	if *retailer != "" && !models.IsRetailer(*retailer) {
+		return fmt.Errorf("invalid -retailer %q: must be one of %v", *retailer, models.RetailerNames)
+	}
+
+	filter := services.AllergenFilter{Retailer: *retailer, Category: *category, Limit: *limit}
+	if filter.FreeFrom, err = parseAllergenList(*freeFrom); err != nil {
+		return fmt.Errorf("invalid -free-from: %w", err)
+	}
+	if filter.Contains, err = parseAllergenList(*contains); err != nil {
+		return fmt.Errorf("invalid -contains: %w", err)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	products, err := dbService.GetProductsByAllergens(filter)
+	if err != nil {
+		return fmt.Errorf("error loading products by allergens: %w", err)
+	}
+	if *format == "json" {
+		return writeJSON(products)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "RETAILER\tPRODUCT\tNAME\tBRAND\tCONTAINS\tMAY CONTAIN")
+	for _, product := range products {
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\n", product.Retailer, product.ProductID,
+			product.ProductName, orDash(product.ProductBrand),
+			orDash(strings.Join(product.Contains, ",")), orDash(strings.Join(product.MayContain, ",")))
+	}
+	return writer.Flush()
+}
+
+// parseAllergenList parses a comma-separated list of EU allergens.
+func parseAllergenList(value string) ([]string, error) {
+	var allergens []string
+	for _, allergen := range strings.Split(value, ",") {
+		if allergen = strings.ToLower(strings.TrimSpace(allergen)); allergen == "" {
+			continue
+		}
+		if !models.IsAllergen(allergen) {
+			return nil, fmt.Errorf("unknown allergen %q: must be one of %s", allergen, strings.Join(models.Allergens, ", "))
+		}
+		allergens = append(allergens, allergen)
+	}
+	return allergens, nil
+}
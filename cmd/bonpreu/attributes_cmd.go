@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// attributesUsage describes the attributes subcommands.
+const attributesUsage = "usage: attributes titles|list [flags]"
+
+// runAttributesCommand reports the bopData field titles that products have,
+// and lists the attributes of products, with their history, so that fields
+// the API adds can be explored without a code change.
+func runAttributesCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(attributesUsage)
+	}
+
+	fs, flags := newFlagSet("attributes " + args[0])
+	format := fs.String("format", "text", "output format: text or json")
+	retailer := fs.String("retailer", "", "list: only list attributes of this retailer")
+	productID := fs.Int("product", 0, "list: only list attributes of this product ID")
+	title := fs.String("title", "", "list: only list attributes with this title")
+	search := fs.String("search", "", "list: only list attributes whose text contains this")
+	history := fs.Bool("history", false, "list: include the versions that are no longer current")
+	limit := fs.Int("limit", 50, "list: maximum number of attributes to list (0 lists all)")
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+	if *retailer != "" && !models.IsRetailer(*retailer) {
+		return fmt.Errorf("invalid -retailer %q: must be one of %v", *retailer, models.RetailerNames)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	switch args[0] {
+	case "titles":
+		stats, err := dbService.GetAttributeTitleStats()
+		if err != nil {
+			return fmt.Errorf("error loading attribute titles: %w", err)
+		}
+		if *format == "json" {
+			return writeJSON(stats)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "TITLE\tPRODUCTS\tSHARE\tVERSIONS\tFIRST SEEN\tLAST SEEN")
+		for _, stat := range stats {
+			fmt.Fprintf(writer, "%s\t%d\t%.1f%%\t%d\t%s\t%s\n", stat.Title, stat.Products, stat.Share*100,
+				stat.Versions, stat.FirstSeenAt.Format("2006-01-02"), stat.LastSeenAt.Format("2006-01-02"))
+		}
+		return writer.Flush()
+
+	case "list":
+		versions, err := dbService.GetProductAttributes(services.AttributeFilter{
+			Retailer:  *retailer,
+			ProductID: *productID,
+			Title:     *title,
+			Search:    *search,
+			History:   *history,
+			Limit:     *limit,
+		})
+		if err != nil {
+			return fmt.Errorf("error loading product attributes: %w", err)
+		}
+		if *format == "json" {
+			return writeJSON(versions)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "RETAILER\tPRODUCT\tNAME\tTITLE\tCONTENT\tFIRST SEEN\tLAST SEEN\tCURRENT")
+		for _, version := range versions {
+			fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%v\n", version.Retailer, version.ProductID,
+				version.ProductName, version.Title, truncate(version.ContentText, 60),
+				version.FirstObservedAt.Format(time.RFC3339), version.LastObservedAt.Format(time.RFC3339), version.Current)
+		}
+		return writer.Flush()
+
+	default:
+		return fmt.Errorf("unknown attributes subcommand %q, %s", args[0], attributesUsage)
+	}
+}
+
+// truncate shortens text to at most length runes, marking the cut with an ellipsis.
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
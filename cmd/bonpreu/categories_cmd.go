@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// runCategoriesCommand prints the category taxonomy with product counts and
+// unit price aggregates per node, or the products that moved category.
+func runCategoriesCommand(args []string) error {
+	fs, flags := newFlagSet("categories")
+	format := fs.String("format", "text", "output format: text or json")
+	depth := fs.Int("depth", 0, "maximum depth of the tree to print (0 prints every level)")
+	rebuild := fs.Bool("rebuild", false, "rebuild the taxonomy from the categories of every stored product first")
+	moves := fs.Bool("moves", false, "list the products that moved category instead of the tree")
+	sinceFlag := fs.String("since", "", "with -moves, only list moves since this date (default 30 days ago)")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+
+	since := time.Now().AddDate(0, 0, -30)
+	if *sinceFlag != "" {
+		if since, err = parseTimeFlag(*sinceFlag); err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	if *rebuild {
+		if err := dbService.RebuildCategories(); err != nil {
+			return fmt.Errorf("error rebuilding categories: %w", err)
+		}
+	}
+
+	if *moves {
+		categoryMoves, err := dbService.GetCategoryMoves(since)
+		if err != nil {
+			return fmt.Errorf("error loading category moves: %w", err)
+		}
+		if *format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(categoryMoves)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "PRODUCT\tNAME\tFROM\tTO\tDATE")
+		for _, move := range categoryMoves {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n", move.ProductID, move.ProductName,
+				strings.Join(move.OldPath, " > "), strings.Join(move.NewPath, " > "), move.MovedAt.Format("2006-01-02"))
+		}
+		return writer.Flush()
+	}
+
+	tree, err := dbService.GetCategoryTree()
+	if err != nil {
+		return fmt.Errorf("error loading category tree: %w", err)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tree)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "CATEGORY\tID\tPRODUCTS\tAVG\tMEDIAN")
+	writeCategoryNodes(writer, tree, 0, *depth)
+	return writer.Flush()
+}
+
+// writeCategoryNodes writes nodes and their children as indented table rows,
+// down to maxDepth levels when it is positive.
+func writeCategoryNodes(w io.Writer, nodes []*models.CategoryNode, level, maxDepth int) {
+	if maxDepth > 0 && level >= maxDepth {
+		return
+	}
+	for _, node := range nodes {
+		avg, median := "-", "-"
+		if node.PricedCount > 0 {
+			avg = fmt.Sprintf("%.2f/%s", node.AvgUnitPrice, node.UnitPriceBase)
+			median = fmt.Sprintf("%.2f/%s", node.MedianUnitPrice, node.UnitPriceBase)
+		}
+		fmt.Fprintf(w, "%s%s\t%d\t%d\t%s\t%s\n", strings.Repeat("  ", level), node.Name,
+			node.ID, node.ProductCount, avg, median)
+		writeCategoryNodes(w, node.Children, level+1, maxDepth)
+	}
+}
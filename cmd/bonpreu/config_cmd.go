@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runConfigCommand implements "config print", which shows the effective
+// configuration after applying the profile, config file, environment and
+// flags, with secrets redacted.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: config print [flags]")
+	}
+
+	fs, flags := newFlagSet("config print")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: config print [flags]\n\nPrints the effective configuration as YAML, with secrets redacted.\n\n")
+		fs.PrintDefaults()
+	}
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return encoder.Encode(cfg.Redacted())
+}
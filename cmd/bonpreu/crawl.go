@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bonpreu-go/pkg/cassette"
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// runCrawlCommand orchestrates the entire data fetching and storage process:
+// 1. Loads the configuration
+// 2. Initializes all required services (retailers, product, database)
+// 3. Discovers the product IDs of each configured retailer
+// 4. Asynchronously fetches detailed product data for each product ID
+// 5. Saves all data to the PostgreSQL database
+// 6. Reports final statistics and execution duration
+// When configured, crawl metrics are exposed on /metrics while running
+// and pushed to a Pushgateway once the run has finished.
+func runCrawlCommand(args []string) error {
+	start := time.Now()
+
+	fs, flags := newFlagSet("crawl")
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+
+	logger := utils.NewLogger("Main")
+	logger.Info("Starting Bonpreu Go application")
+	logger.Info("Loaded configuration (profile %s)", cfg.Profile)
+
+	// Expose metrics while the crawl is running
+	if cfg.Metrics.ListenAddr != "" {
+		server, err := metrics.Default.Serve(cfg.Metrics.ListenAddr)
+		if err != nil {
+			logger.Error("Error starting metrics server: %v", err)
+		} else {
+			logger.Info("Serving metrics on %s/metrics", cfg.Metrics.ListenAddr)
+			defer server.Close()
+		}
+	}
+
+	err = runCrawl(cfg, logger)
+
+	metrics.CrawlDuration.Set(time.Since(start).Seconds())
+	if err == nil {
+		metrics.CrawlLastSuccess.Set(float64(time.Now().Unix()))
+	}
+
+	// Push metrics for one-shot runs
+	if cfg.Metrics.PushURL != "" {
+		if pushErr := metrics.Default.Push(cfg.Metrics.PushURL, cfg.Metrics.JobName); pushErr != nil {
+			logger.Error("Error pushing metrics: %v", pushErr)
+		} else {
+			logger.Info("Pushed metrics to %s", cfg.Metrics.PushURL)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logger.LogDuration("Application execution", start)
+	return nil
+}
+
+// runCrawl discovers, fetches and saves the products of every retailer.
+// Errors are logged where they happen and returned so that main can exit non-zero.
+func runCrawl(cfg *config.Configuration, logger *utils.Logger) error {
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		logger.Error("Error initializing database service: %v", err)
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	return crawlAll(context.Background(), cfg, logger, dbService)
+}
+
+// newProductService creates the product service, recording or replaying
+// upstream responses when a cassette mode is configured. The cassette
+// transport, or nil, is returned for the retailers to share.
+func newProductService(cfg *config.Configuration, logger *utils.Logger) (*services.ProductService, http.RoundTripper, error) {
+	productService := services.NewProductService(200)
+	productService.SetFailureRateThreshold(cfg.FailureRateThreshold)
+
+	transport, err := cassetteTransport(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	if transport != nil {
+		productService.SetTransport(transport)
+	}
+	return productService, transport, nil
+}
+
+// newRetailers creates the named retailers with their configured endpoints.
+func newRetailers(cfg *config.Configuration, names []string, transport http.RoundTripper) ([]services.Retailer, error) {
+	retailers := make([]services.Retailer, 0, len(names))
+	for _, name := range names {
+		retailer, err := newRetailer(cfg, name, transport)
+		if err != nil {
+			return nil, err
+		}
+		retailers = append(retailers, retailer)
+	}
+	return retailers, nil
+}
+
+// newAvailableRetailers creates those of the named retailers that can be
+// created with the configuration. The others, e.g. Esclat without an API
+// base URL, are returned with the reason instead of failing the rest.
+func newAvailableRetailers(cfg *config.Configuration, names []string, transport http.RoundTripper) ([]services.Retailer, map[string]error) {
+	retailers := make([]services.Retailer, 0, len(names))
+	unavailable := make(map[string]error)
+	for _, name := range names {
+		retailer, err := newRetailer(cfg, name, transport)
+		if err != nil {
+			unavailable[name] = err
+			continue
+		}
+		retailers = append(retailers, retailer)
+	}
+	return retailers, unavailable
+}
+
+// newRetailer creates the named retailer with its configured endpoints.
+func newRetailer(cfg *config.Configuration, name string, transport http.RoundTripper) (services.Retailer, error) {
+	endpoints := cfg.Retailer(name)
+	retailer, err := services.NewRetailer(name, services.RetailerOptions{
+		SitemapURL: endpoints.SitemapURL,
+		APIBaseURL: endpoints.APIBaseURL,
+		Transport:  transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing retailer: %w", err)
+	}
+	return retailer, nil
+}
+
+// discoverProductIDs fetches the IDs of every product of the retailer.
+func discoverProductIDs(logger *utils.Logger, retailer services.Retailer) ([]int, error) {
+	logger.Info("Fetching %s product IDs...", retailer.Name())
+
+	productIDs, err := retailer.DiscoverProductIDs()
+	if err != nil {
+		logger.Error("Error fetching %s product IDs: %v", retailer.Name(), err)
+		return nil, fmt.Errorf("error fetching %s product IDs: %w", retailer.Name(), err)
+	}
+
+	logger.Info("Successfully fetched %d %s product IDs", len(productIDs), retailer.Name())
+	return productIDs, nil
+}
+
+// crawlAll discovers and fetches the products of every configured retailer,
+// saves the results and sends the daily digest if the crawl succeeded. A
+// retailer that fails does not stop the others from being crawled; a
+// cancelled ctx stops the crawl after saving what was fetched.
+func crawlAll(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService) error {
+	productService, transport, err := newProductService(cfg, logger)
+	if err != nil {
+		return err
+	}
+	retailers, err := newRetailers(cfg, cfg.Retailers, transport)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Initialized services")
+
+	var health models.RunHealth
+	var errs []error
+	for _, retailer := range retailers {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("crawl stopped before %s: %w", retailer.Name(), err))
+			break
+		}
+		productIDs, err := discoverProductIDs(logger, retailer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		productService.SetRetailer(retailer)
+		runHealth, err := fetchAndSave(ctx, cfg, logger, productService, dbService, productIDs)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		health.Add(runHealth)
+	}
+
+	sendRunDigest(cfg, logger, dbService, health, errs)
+	return errors.Join(errs...)
+}
+
+// sendRunDigest sends the daily digest with the health of a crawl run,
+// unless a retailer failed or too many products failed to fetch. The digest
+// of such a run would list the products that were not crawled as no longer
+// listed, and would keep the day's correct digest from being sent later, by
+// the next crawl or the daemon's reports.
+func sendRunDigest(cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService, health models.RunHealth, errs []error) {
+	if cfg.Email.SMTPHost == "" {
+		return
+	}
+	if len(errs) > 0 || health.FailureRate() > cfg.FailureRateThreshold {
+		logger.Warn("Not sending the email digest after a failed run (%.2f%% of the products failed, %d errors)",
+			health.FailurePercent(), len(errs))
+		return
+	}
+	sendDailyDigest(cfg, logger, dbService, &health)
+}
+
+// sendDailyDigest emails the digest of the catalogue changes of the last 24
+// hours, unless it was already sent today. Problems are logged but do not
+// fail the crawl.
+func sendDailyDigest(cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService, health *models.RunHealth) {
+	if cfg.Email.SMTPHost == "" {
+		return
+	}
+
+	now := time.Now()
+	sent, err := dbService.DigestSent(now)
+	if err != nil {
+		logger.Error("Error checking email digest: %v", err)
+		return
+	}
+	if sent {
+		logger.Info("Email digest for %s already sent", now.Format("2006-01-02"))
+		return
+	}
+
+	digest, err := dbService.BuildDigest(now.Add(-24*time.Hour), now, cfg.Email.DigestLimit)
+	if err != nil {
+		logger.Error("Error building email digest: %v", err)
+		return
+	}
+	digest.RunHealth = health
+
+	if err := services.NewEmailService(cfg.Email).SendDigest(digest); err != nil {
+		logger.Error("Error sending email digest: %v", err)
+		return
+	}
+	if err := dbService.RecordDigestSent(now, len(cfg.Email.To)); err != nil {
+		logger.Error("Error recording email digest: %v", err)
+	}
+}
+
+// cassetteTransport returns the transport recording or replaying upstream
+// responses when a cassette mode is configured, and nil otherwise.
+func cassetteTransport(cfg *config.Configuration, logger *utils.Logger) (http.RoundTripper, error) {
+	if cfg.HTTPClient.CassetteMode == string(cassette.ModeOff) {
+		return nil, nil
+	}
+
+	transport, err := cassette.Transport(
+		cassette.Mode(cfg.HTTPClient.CassetteMode),
+		cfg.HTTPClient.CassetteDir,
+		nil,
+		cassette.Options{Match: cassette.MatchMode(cfg.HTTPClient.CassetteMatch)},
+	)
+	if err != nil {
+		logger.Error("Error initializing HTTP cassette: %v", err)
+		return nil, fmt.Errorf("error initializing HTTP cassette: %w", err)
+	}
+
+	logger.Info("HTTP cassette mode %s using %s", cfg.HTTPClient.CassetteMode, cfg.HTTPClient.CassetteDir)
+	return transport, nil
+}
+
+// notifyWatchers sends webhook events for the watched products that changed
+// since their previous observation, and records undeliverable events in the
+// dead-letter log. Notification problems are logged but do not fail the crawl.
+func notifyWatchers(cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService, products []models.Product) {
+	if cfg.Webhooks.URL == "" {
+		return
+	}
+
+	events, err := dbService.DetectWatchedEvents(products)
+	if err != nil {
+		logger.Error("Error detecting watchlist events: %v", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	deadLetters := services.NewWebhookService(cfg.Webhooks).DeliverAll(events)
+	if err := dbService.SaveWebhookDeadLetters(deadLetters); err != nil {
+		logger.Error("Error saving webhook dead letters: %v", err)
+	}
+}
+
+// fetchAndSave fetches the given products of the product service's retailer,
+// saves the results and records the products that failed so that they can be
+// retried later. When the failure rate exceeds the threshold, the fetched
+// products are still saved before the summary error is returned. When ctx is
+// cancelled, the products fetched so far are saved in the same way. It returns
+// the product counts of the fetch.
+func fetchAndSave(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, productService *services.ProductService, dbService *services.DatabaseService, productIDs []int) (models.RunHealth, error) {
+	retailer := productService.Retailer().Name()
+	if cfg.RequestDuration > 0 {
+		logger.Info("Fetching product data for %d %s products over %v...", len(productIDs), retailer, cfg.RequestDuration)
+	} else {
+		logger.Info("Fetching product data for %d %s products (no rate limiting)...", len(productIDs), retailer)
+	}
+
+	products, nutritionalData, report, fetchErr := productService.FetchAllProductsData(ctx, productIDs, cfg.RequestDuration)
+	var summaryErr *services.FetchSummaryError
+	interrupted := fetchErr != nil && ctx.Err() != nil && errors.Is(fetchErr, ctx.Err())
+	if fetchErr != nil && !errors.As(fetchErr, &summaryErr) && !interrupted {
+		logger.Error("Error fetching product data: %v", fetchErr)
+		return report.Health, fmt.Errorf("error fetching product data: %w", fetchErr)
+	}
+
+	logger.Info("Successfully fetched data for %d products", len(products))
+	logger.Info("Total nutritional data entries: %d", len(nutritionalData))
+
+	logger.Info("Saving data to database...")
+	if err := dbService.SaveAllData(products, nutritionalData); err != nil {
+		logger.Error("Error saving data to database: %v", err)
+		return report.Health, fmt.Errorf("error saving data to database: %w", err)
+	}
+
+	notifyWatchers(cfg, logger, dbService, products)
+
+	fetchedIDs := make([]int, 0, len(products))
+	for _, product := range products {
+		fetchedIDs = append(fetchedIDs, product.ProductID)
+	}
+	if err := dbService.RecordFetchFailures(retailer, fetchedIDs, report.Failures); err != nil {
+		logger.Error("Error recording fetch failures: %v", err)
+		return report.Health, fmt.Errorf("error recording fetch failures: %w", err)
+	}
+
+	productCount, err := dbService.GetProductCount()
+	if err != nil {
+		logger.Error("Error getting product count: %v", err)
+	} else {
+		logger.Info("Total products in database: %d", productCount)
+	}
+
+	nutritionalCount, err := dbService.GetNutritionalDataCount()
+	if err != nil {
+		logger.Error("Error getting nutritional data count: %v", err)
+	} else {
+		logger.Info("Total nutritional data entries in database: %d", nutritionalCount)
+	}
+
+	if interrupted {
+		logger.Warn("Crawl stopped before every product was fetched: %v", fetchErr)
+		return report.Health, fmt.Errorf("error fetching product data: %w", fetchErr)
+	}
+	if summaryErr != nil {
+		logger.Error("Crawl exceeded the failure-rate threshold: %v", summaryErr)
+		return report.Health, fmt.Errorf("error fetching product data: %w", summaryErr)
+	}
+
+	return report.Health, nil
+}
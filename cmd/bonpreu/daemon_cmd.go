@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	// Embed the time zone database so that schedules work in minimal containers
+	_ "time/tzdata"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/scheduler"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// Lock keys of the daemon jobs. The full and incremental crawls share a key
+// so that they never run at the same time.
+const (
+	crawlLockKey  = "crawl"
+	reportLockKey = "reports"
+	imageLockKey  = "images"
+)
+
+// shrinkflationReportWindow is how far back the report job looks for pack size changes.
+const shrinkflationReportWindow = 30 * 24 * time.Hour
+
+// runDaemonCommand runs the crawler as a long-running process. The full
+// crawl, the incremental crawl, the report and the image download jobs run
+// on their cron schedules, each starting after a random jitter. Runs are
+// serialised across processes with Postgres advisory locks, so several
+// replicas can be deployed without crawling twice. /healthz, which fails
+// when the scheduler has stopped or the database cannot be reached, /status,
+// /metrics, the stored Nutri-Scores at /nutriscore and the category tree and
+// moves at /categories are served on the daemon listen address. The daemon
+// stops on SIGINT or SIGTERM once the running jobs have finished.
+func runDaemonCommand(args []string) error {
+	fs, flags := newFlagSet("daemon")
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+
+	logger := utils.NewLogger("Daemon")
+	logger.Info("Starting Bonpreu daemon (profile %s)", cfg.Profile)
+
+	location, err := time.LoadLocation(cfg.Daemon.Timezone)
+	if err != nil {
+		return fmt.Errorf("error loading time zone: %w", err)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		logger.Error("Error initializing database service: %v", err)
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	sched := scheduler.New(location, cfg.Daemon.Jitter, dbService.TryAdvisoryLock)
+	sched.OnResult = func(job, result string) {
+		metrics.DaemonJobRuns.Inc(job, result)
+	}
+	sched.HealthCheck = dbService.Ping
+
+	jobs := []struct {
+		name    string
+		spec    string
+		lockKey string
+		run     func(ctx context.Context) error
+	}{
+		{"full-crawl", cfg.Daemon.FullCrawlSchedule, crawlLockKey, func(ctx context.Context) error {
+			return runFullCrawlJob(ctx, cfg, logger, dbService)
+		}},
+		{"incremental-crawl", cfg.Daemon.IncrementalSchedule, crawlLockKey, func(ctx context.Context) error {
+			return crawlIncremental(ctx, cfg, logger, dbService)
+		}},
+		{"reports", cfg.Daemon.ReportSchedule, reportLockKey, func(ctx context.Context) error {
+			return runReports(ctx, cfg, logger, dbService)
+		}},
+		{"images", cfg.Daemon.ImageSchedule, imageLockKey, func(ctx context.Context) error {
+			return downloadImages(ctx, cfg, logger, dbService, services.ImageDownloadFilter{})
+		}},
+	}
+
+	enabled := 0
+	for _, job := range jobs {
+		if job.spec == "" {
+			logger.Info("Job %s is disabled", job.name)
+			continue
+		}
+		schedule, err := scheduler.ParseCron(job.spec)
+		if err != nil {
+			return fmt.Errorf("error parsing %s schedule: %w", job.name, err)
+		}
+		sched.Add(scheduler.Job{Name: job.name, Schedule: schedule, LockKey: job.lockKey, Run: job.run})
+		enabled++
+	}
+	if enabled == 0 {
+		return errors.New("no daemon jobs are scheduled")
+	}
+
+	if cfg.Daemon.ListenAddr != "" {
+		server, err := serveDaemonStatus(cfg.Daemon.ListenAddr, logger, sched, dbService)
+		if err != nil {
+			logger.Error("Error starting status server: %v", err)
+			return err
+		}
+		logger.Info("Serving /healthz, /status, /metrics, /nutriscore and /categories on %s", cfg.Daemon.ListenAddr)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("Scheduled %d jobs in %s with up to %v of jitter", enabled, location, cfg.Daemon.Jitter)
+	sched.Run(ctx)
+
+	logger.Info("Daemon stopped")
+	return nil
+}
+
+// serveDaemonStatus serves the scheduler status, the metrics, the stored
+// Nutri-Scores and the category tree on addr in the background.
+func serveDaemonStatus(addr string, logger *utils.Logger, sched *scheduler.Scheduler, dbService *services.DatabaseService) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start status server on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.Handle("/nutriscore", nutriScoreHandler(logger, dbService))
+	mux.Handle("/categories", categoryTreeHandler(logger, dbService))
+	mux.Handle("/categories/moves", categoryMovesHandler(logger, dbService))
+	mux.Handle("/", sched.Handler())
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	return server, nil
+}
+
+// nutriScoreHandler serves the stored Nutri-Scores as JSON. The retailer,
+// product_id, category, grade and status query parameters filter them like
+// the nutriscore list flags, and limit caps the number of results (100 by
+// default). Database errors are logged and answered with a generic 500.
+func nutriScoreHandler(logger *utils.Logger, dbService *services.DatabaseService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := services.NutriScoreFilter{
+			Retailer: query.Get("retailer"),
+			Category: query.Get("category"),
+			Limit:    100,
+		}
+		if value := query.Get("product_id"); value != "" {
+			productID, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "invalid product_id", http.StatusBadRequest)
+				return
+			}
+			filter.ProductID = productID
+		}
+		if value := query.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+		for _, grade := range query["grade"] {
+			filter.Grades = append(filter.Grades, strings.ToUpper(grade))
+		}
+		filter.Statuses = query["status"]
+
+		products, err := dbService.GetNutriScores(filter)
+		if err != nil {
+			logger.Error("Error loading Nutri-Scores for %s: %v", r.URL.RequestURI(), err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if products == nil {
+			products = []models.NutriScoreProduct{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(products); err != nil {
+			logger.Warn("Error writing Nutri-Scores: %v", err)
+		}
+	})
+}
+
+// categoryTreeHandler serves the category tree with the product counts and
+// unit price aggregates of every node as JSON. Database errors are logged and
+// answered with a generic 500.
+func categoryTreeHandler(logger *utils.Logger, dbService *services.DatabaseService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tree, err := dbService.GetCategoryTree()
+		if err != nil {
+			logger.Error("Error loading the category tree: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if tree == nil {
+			tree = []*models.CategoryNode{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(tree); err != nil {
+			logger.Warn("Error writing the category tree: %v", err)
+		}
+	})
+}
+
+// categoryMovesHandler serves the products that moved category as JSON, since
+// the date or RFC 3339 time in the since query parameter (30 days ago by
+// default). Database errors are logged and answered with a generic 500.
+func categoryMovesHandler(logger *utils.Logger, dbService *services.DatabaseService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since := time.Now().AddDate(0, 0, -30)
+		if value := r.URL.Query().Get("since"); value != "" {
+			var err error
+			if since, err = parseTimeFlag(value); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+
+		moves, err := dbService.GetCategoryMoves(since)
+		if err != nil {
+			logger.Error("Error loading category moves for %s: %v", r.URL.RequestURI(), err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if moves == nil {
+			moves = []models.CategoryMove{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(moves); err != nil {
+			logger.Warn("Error writing category moves: %v", err)
+		}
+	})
+}
+
+// runFullCrawlJob crawls every product in the sitemap and updates the crawl metrics.
+func runFullCrawlJob(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService) error {
+	start := time.Now()
+	err := crawlAll(ctx, cfg, logger, dbService)
+
+	metrics.CrawlDuration.Set(time.Since(start).Seconds())
+	if err == nil {
+		metrics.CrawlLastSuccess.Set(float64(time.Now().Unix()))
+	}
+	return err
+}
+
+// crawlIncremental fetches only the products that are new in the sitemap,
+// failed in previous runs, or are watched, so that new products, repairs and
+// watchlist notifications do not wait for the next full crawl. Each configured
+// retailer is crawled in turn, until ctx is cancelled.
+func crawlIncremental(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService) error {
+	productService, transport, err := newProductService(cfg, logger)
+	if err != nil {
+		return err
+	}
+	retailers, err := newRetailers(cfg, cfg.Retailers, transport)
+	if err != nil {
+		return err
+	}
+	failures, err := dbService.GetFetchFailures(nil, 0)
+	if err != nil {
+		return fmt.Errorf("error loading fetch failures: %w", err)
+	}
+
+	var errs []error
+	for _, retailer := range retailers {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("crawl stopped before %s: %w", retailer.Name(), err))
+			break
+		}
+		productService.SetRetailer(retailer)
+		if err := crawlRetailerIncremental(ctx, cfg, logger, productService, dbService, failures); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// crawlRetailerIncremental runs the incremental crawl of the product
+// service's retailer.
+func crawlRetailerIncremental(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, productService *services.ProductService, dbService *services.DatabaseService, failures []models.ProductFetchFailure) error {
+	retailer := productService.Retailer()
+
+	sitemapIDs, err := discoverProductIDs(logger, retailer)
+	if err != nil {
+		return err
+	}
+	knownIDs, err := dbService.GetProductIDs(retailer.Name())
+	if err != nil {
+		return fmt.Errorf("error loading known products: %w", err)
+	}
+	watchedIDs, err := dbService.GetWatchedProductIDs(retailer.Name())
+	if err != nil {
+		return fmt.Errorf("error loading watched products: %w", err)
+	}
+
+	known := make(map[int]bool, len(knownIDs))
+	for _, productID := range knownIDs {
+		known[productID] = true
+	}
+
+	selected := make(map[int]bool)
+	newCount := 0
+	for _, productID := range sitemapIDs {
+		if !known[productID] && !selected[productID] {
+			selected[productID] = true
+			newCount++
+		}
+	}
+	failedCount := 0
+	for _, failure := range failures {
+		if failure.Retailer == retailer.Name() {
+			selected[failure.ProductID] = true
+			failedCount++
+		}
+	}
+	for _, productID := range watchedIDs {
+		selected[productID] = true
+	}
+
+	if len(selected) == 0 {
+		logger.Info("Incremental %s crawl: nothing to fetch", retailer.Name())
+		return nil
+	}
+
+	productIDs := make([]int, 0, len(selected))
+	for productID := range selected {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Ints(productIDs)
+
+	logger.Info("Incremental %s crawl: %d new, %d failed and %d watched products (%d distinct)",
+		retailer.Name(), newCount, failedCount, len(watchedIDs), len(productIDs))
+	_, err = fetchAndSave(ctx, cfg, logger, productService, dbService, productIDs)
+	return err
+}
+
+// runReports records the shrinkflation events of the last 30 days, matches
+// the products of the configured retailers when there are several, and sends
+// the daily digest if no crawl has sent it yet today. The remaining reports
+// are skipped once ctx is cancelled.
+func runReports(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService) error {
+	events, err := dbService.DetectShrinkflation(time.Now().Add(-shrinkflationReportWindow))
+	if err != nil {
+		return fmt.Errorf("error detecting shrinkflation: %w", err)
+	}
+	logger.Info("Detected %d shrinkflation events", len(events))
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("reports stopped: %w", err)
+	}
+	if len(cfg.Retailers) > 1 {
+		matches, err := dbService.MatchRetailerProducts(cfg.Retailers, models.DefaultMatchOptions)
+		if err != nil {
+			return fmt.Errorf("error matching products: %w", err)
+		}
+		logger.Info("Matched %d products across retailers", len(matches))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("reports stopped: %w", err)
+	}
+	sendDailyDigest(cfg, logger, dbService, nil)
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// newHandlerTestDatabase returns a DatabaseService on a fresh test schema.
+func newHandlerTestDatabase(t *testing.T) *services.DatabaseService {
+	t.Helper()
+	cfg, err := config.ProfileConfig(config.ProfileTesting)
+	if err != nil {
+		t.Fatalf("ProfileConfig: %v", err)
+	}
+	return newTestDatabase(t, cfg)
+}
+
+// serveRequest sends a GET request for target to handler and returns the response.
+func serveRequest(handler http.Handler, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+func TestCategoryMovesHandlerInvalidSince(t *testing.T) {
+	// Invalid parameters are rejected before the database is queried
+	handler := categoryMovesHandler(utils.NewLogger("Daemon"), nil)
+	for _, target := range []string{"/categories/moves?since=yesterday", "/categories/moves?since=2026-13-01"} {
+		if resp := serveRequest(handler, target); resp.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want %d", target, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCategoryHandlers(t *testing.T) {
+	dbService := newHandlerTestDatabase(t)
+	logger := utils.NewLogger("Daemon")
+
+	product := func(id int, categories ...string) models.Product {
+		return models.Product{
+			Retailer:          models.RetailerBonpreu,
+			ProductID:         id,
+			ProductName:       "Product",
+			ProductCategories: categories,
+			CreatedAt:         time.Now(),
+		}
+	}
+	// Product 2 moves from Aigua to Sucs while product 1 stays
+	for _, products := range [][]models.Product{
+		{product(1, "Begudes", "Aigua"), product(2, "Begudes", "Aigua")},
+		{product(1, "Begudes", "Aigua"), product(2, "Begudes", "Sucs")},
+	} {
+		if err := dbService.SaveProducts(products); err != nil {
+			t.Fatalf("SaveProducts: %v", err)
+		}
+		if err := dbService.SyncCategories(products); err != nil {
+			t.Fatalf("SyncCategories: %v", err)
+		}
+	}
+
+	resp := serveRequest(categoryTreeHandler(logger, dbService), "/categories")
+	var tree []*models.CategoryNode
+	if resp.Code != http.StatusOK || json.Unmarshal(resp.Body.Bytes(), &tree) != nil {
+		t.Fatalf("GET /categories = %d %s", resp.Code, resp.Body)
+	}
+	if len(tree) != 1 || tree[0].Name != "Begudes" || tree[0].ProductCount != 2 || len(tree[0].Children) != 2 {
+		t.Errorf("category tree = %s, want Begudes with 2 products in 2 children", resp.Body)
+	}
+
+	resp = serveRequest(categoryMovesHandler(logger, dbService), "/categories/moves")
+	var moves []models.CategoryMove
+	if resp.Code != http.StatusOK || json.Unmarshal(resp.Body.Bytes(), &moves) != nil {
+		t.Fatalf("GET /categories/moves = %d %s", resp.Code, resp.Body)
+	}
+	if len(moves) != 1 || moves[0].ProductID != 2 || strings.Join(moves[0].NewPath, "/") != "Begudes/Sucs" {
+		t.Errorf("category moves = %s, want product 2 moved to Begudes/Sucs", resp.Body)
+	}
+
+	// Moves before since are left out, and an empty result is an empty list
+	since := time.Now().Add(time.Hour).Format(time.RFC3339)
+	resp = serveRequest(categoryMovesHandler(logger, dbService), "/categories/moves?since="+since)
+	if resp.Code != http.StatusOK || strings.TrimSpace(resp.Body.String()) != "[]" {
+		t.Errorf("GET /categories/moves?since=%s = %d %s, want an empty list", since, resp.Code, resp.Body)
+	}
+}
+
+func TestCategoryHandlersDatabaseError(t *testing.T) {
+	dbService := newHandlerTestDatabase(t)
+	dbService.Close()
+	logger := utils.NewLogger("Daemon")
+
+	// Database errors are answered with a generic 500
+	for target, handler := range map[string]http.Handler{
+		"/categories":       categoryTreeHandler(logger, dbService),
+		"/categories/moves": categoryMovesHandler(logger, dbService),
+	} {
+		resp := serveRequest(handler, target)
+		if resp.Code != http.StatusInternalServerError || strings.TrimSpace(resp.Body.String()) != http.StatusText(http.StatusInternalServerError) {
+			t.Errorf("GET %s = %d %q, want a generic 500", target, resp.Code, resp.Body)
+		}
+	}
+}
+
+func TestNutriScoreHandlerInvalidParameters(t *testing.T) {
+	// Invalid parameters are rejected before the database is queried
+	handler := nutriScoreHandler(utils.NewLogger("Daemon"), nil)
+	for _, target := range []string{"/nutriscore?product_id=abc", "/nutriscore?limit=-1", "/nutriscore?limit=ten"} {
+		if resp := serveRequest(handler, target); resp.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want %d", target, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestNutriScoreHandler(t *testing.T) {
+	dbService := newHandlerTestDatabase(t)
+
+	product := func(retailer string, id int, grade string, score int) models.Product {
+		return models.Product{
+			Retailer:    retailer,
+			ProductID:   id,
+			ProductName: "Product",
+			CreatedAt:   time.Now(),
+			NutriScore: models.NutriScore{
+				Status:   models.NutriScoreGraded,
+				Category: models.NutriScoreGeneral,
+				Grade:    grade,
+				Score:    &score,
+			},
+		}
+	}
+	products := []models.Product{
+		product(models.RetailerBonpreu, 1, "A", -2),
+		product(models.RetailerBonpreu, 2, "D", 15),
+		product(models.RetailerEsclat, 3, "A", 0),
+	}
+	if err := dbService.SaveProducts(products); err != nil {
+		t.Fatalf("SaveProducts: %v", err)
+	}
+	if err := dbService.SaveNutriScores(products); err != nil {
+		t.Fatalf("SaveNutriScores: %v", err)
+	}
+
+	handler := nutriScoreHandler(utils.NewLogger("Daemon"), dbService)
+	for _, tt := range []struct {
+		target string
+		want   []int
+	}{
+		{"/nutriscore", []int{1, 3, 2}},
+		{"/nutriscore?retailer=bonpreu", []int{1, 2}},
+		{"/nutriscore?grade=a", []int{1, 3}},
+		{"/nutriscore?grade=a&grade=d&retailer=esclat", []int{3}},
+		{"/nutriscore?product_id=2", []int{2}},
+		{"/nutriscore?limit=1", []int{1}},
+		{"/nutriscore?grade=e", []int{}},
+	} {
+		resp := serveRequest(handler, tt.target)
+		var scores []models.NutriScoreProduct
+		if resp.Code != http.StatusOK || json.Unmarshal(resp.Body.Bytes(), &scores) != nil {
+			t.Errorf("GET %s = %d %s", tt.target, resp.Code, resp.Body)
+			continue
+		}
+		got := make([]int, 0, len(scores))
+		for _, score := range scores {
+			got = append(got, score.ProductID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("GET %s returned products %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestNutriScoreHandlerDatabaseError(t *testing.T) {
+	dbService := newHandlerTestDatabase(t)
+	dbService.Close()
+
+	// Database errors are answered with a generic 500
+	resp := serveRequest(nutriScoreHandler(utils.NewLogger("Daemon"), dbService), "/nutriscore?retailer=bonpreu")
+	if resp.Code != http.StatusInternalServerError || strings.TrimSpace(resp.Body.String()) != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf("GET /nutriscore = %d %q, want a generic 500", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"bonpreu-go/pkg/services"
+)
+
+// runDigestCommand builds the digest of the catalogue changes since a given
+// time and emails it, or prints it for preview. Unlike the digest sent after a
+// crawl, it has no run health section and is sent even if today's digest
+// already went out.
+func runDigestCommand(args []string) error {
+	fs, flags := newFlagSet("digest")
+	sinceFlag := fs.String("since", "", "summarise changes since this date or RFC 3339 time (default 24 hours ago)")
+	preview := fs.String("preview", "", "print the digest instead of sending it: text, html or json")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	switch *preview {
+	case "", "text", "html", "json":
+	default:
+		return fmt.Errorf("invalid -preview %q: must be text, html or json", *preview)
+	}
+	if *preview == "" && cfg.Email.SMTPHost == "" {
+		return fmt.Errorf("no SMTP server configured: set SMTP_HOST or use -preview")
+	}
+
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+	if *sinceFlag != "" {
+		if since, err = parseTimeFlag(*sinceFlag); err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	digest, err := dbService.BuildDigest(since, now, cfg.Email.DigestLimit)
+	if err != nil {
+		return fmt.Errorf("error building digest: %w", err)
+	}
+
+	switch *preview {
+	case "json":
+		return writeJSON(digest)
+	case "text", "html":
+		text, html, err := services.RenderDigest(digest)
+		if err != nil {
+			return err
+		}
+		if *preview == "html" {
+			fmt.Print(html)
+		} else {
+			fmt.Print(text)
+		}
+		return nil
+	}
+
+	if err := services.NewEmailService(cfg.Email).SendDigest(digest); err != nil {
+		return fmt.Errorf("error sending digest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// imagesUsage describes the images subcommands.
+const imagesUsage = "usage: images download|changes [flags]"
+
+// imageSaveBatchSize is the number of downloaded images recorded at a time.
+const imageSaveBatchSize = 100
+
+// runImagesCommand mirrors the product images to the local image directory
+// and lists the images whose content changed, such as packaging redesigns.
+func runImagesCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(imagesUsage)
+	}
+
+	fs, flags := newFlagSet("images " + args[0])
+	retailer := fs.String("retailer", "", "only handle images of this retailer")
+	size := fs.String("size", "", "download: only download images of this size, e.g. 300x300 or original")
+	refresh := fs.Bool("refresh", false, "download: download again images downloaded before, to notice replaced contents")
+	sinceFlag := fs.String("since", "", "changes: only list changes since this date (default 30 days ago)")
+	limit := fs.Int("limit", 0, "maximum number of images to download, or changes to list (0 is unlimited)")
+	format := fs.String("format", "text", "changes: output format: text or json")
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+	if *retailer != "" && !models.IsRetailer(*retailer) {
+		return fmt.Errorf("invalid -retailer %q: must be one of %v", *retailer, models.RetailerNames)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	switch args[0] {
+	case "download":
+		return downloadImages(context.Background(), cfg, utils.NewLogger("Images"), dbService, services.ImageDownloadFilter{
+			Retailer: *retailer,
+			Size:     *size,
+			Refresh:  *refresh,
+			Limit:    *limit,
+		})
+
+	case "changes":
+		since := time.Now().AddDate(0, 0, -30)
+		if *sinceFlag != "" {
+			if since, err = parseTimeFlag(*sinceFlag); err != nil {
+				return fmt.Errorf("invalid -since: %w", err)
+			}
+		}
+
+		changes, err := dbService.GetImageChanges(since, *retailer, *limit)
+		if err != nil {
+			return fmt.Errorf("error loading image changes: %w", err)
+		}
+		if *format == "json" {
+			return writeJSON(changes)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "CHANGED\tRETAILER\tPRODUCT\tNAME\tPOSITION\tSIZE\tPATH")
+		for _, change := range changes {
+			fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%d\t%s\t%s\n", change.ChangedAt.Format("2006-01-02 15:04"),
+				change.Retailer, change.ProductID, change.ProductName, change.Position, change.Size, change.Path)
+		}
+		return writer.Flush()
+
+	default:
+		return fmt.Errorf("unknown images subcommand %q, %s", args[0], imagesUsage)
+	}
+}
+
+// downloadImages mirrors the product images selected by the filter to the
+// configured image directory and records their contents in batches as they
+// are downloaded. When ctx is cancelled, downloads stop before the next
+// image and the images downloaded so far are recorded.
+func downloadImages(ctx context.Context, cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService, filter services.ImageDownloadFilter) error {
+	images, err := dbService.GetImagesToDownload(filter)
+	if err != nil {
+		return fmt.Errorf("error loading images to download: %w", err)
+	}
+	logger.Info("Downloading %d product images to %s", len(images), cfg.Images.Dir)
+
+	var changed int64
+	downloaded, failed, err := services.NewImageService(cfg.Images).DownloadAll(ctx, images, imageSaveBatchSize,
+		func(downloads []models.ImageDownload) error {
+			count, err := dbService.SaveImageDownloads(downloads)
+			changed += count
+			if err != nil {
+				return fmt.Errorf("error saving image downloads: %w", err)
+			}
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("error downloading images: %w", err)
+	}
+	logger.Info("Downloaded %d product images (%d with new content), %d failed", downloaded, changed, failed)
+	return nil
+}
@@ -1,102 +1,122 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
-	"time"
+	"os"
+	"strings"
 
 	"bonpreu-go/pkg/config"
-	"bonpreu-go/pkg/services"
 	"bonpreu-go/pkg/utils"
 
 	"github.com/joho/godotenv"
 )
 
+// command is a subcommand of the bonpreu binary.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the available subcommands. The first one is the default
+// when the binary is run without a command.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"crawl", "Fetch the sitemap and every product, and save them to the database", runCrawlCommand},
+		{"retry-failures", "Refetch only the products that failed in previous runs", runRetryFailuresCommand},
+		{"promotions", "List the promotions active now or at a given date", runPromotionsCommand},
+		{"rank", "Rank products by effective price per kg, litre or unit", runRankCommand},
+		{"shrinkflation", "Detect and export pack size decreases without a price decrease", runShrinkflationCommand},
+		{"allergens", "List products free from or containing EU allergens, e.g. gluten-free products of a category", runAllergensCommand},
+		{"attributes", "Report bopData field titles and list product attributes with their history (attributes titles|list)", runAttributesCommand},
+		{"images", "Mirror product images and list image changes such as packaging redesigns (images download|changes)", runImagesCommand},
+		{"nutriscore", "Compute the Nutri-Score of products and list or export grades with missing inputs (nutriscore compute|list)", runNutriScoreCommand},
+		{"openfoodfacts", "Export products in the Open Food Facts schema and report products missing required fields (openfoodfacts export|report)", runOpenFoodFactsCommand},
+		{"categories", "Show the category tree with per-category aggregates, or category moves", runCategoriesCommand},
+		{"match", "Match products across retailers and review uncertain matches (match run|list|review|approve|reject)", runMatchCommand},
+		{"watch", "Manage the watchlist for webhook notifications (watch list|add|remove|dead-letters)", runWatchCommand},
+		{"digest", "Email or preview the digest of recent catalogue changes", runDigestCommand},
+		{"daemon", "Run scheduled crawls and reports continuously, with a health and status endpoint", runDaemonCommand},
+		{"queue", "Distributed crawl: enqueue products and run workers (queue enqueue|work|status)", runQueueCommand},
+		{"config", "Configuration utilities (config print)", runConfigCommand},
+	}
+}
+
 // main is the entry point of the Bonpreu Go application.
-// It orchestrates the entire data fetching and storage process:
-// 1. Loads environment variables and configuration
-// 2. Initializes all required services (sitemap, product, database)
-// 3. Fetches product IDs from the Bonpreu sitemap
-// 4. Asynchronously fetches detailed product data for each product ID
-// 5. Saves all data to the PostgreSQL database
-// 6. Reports final statistics and execution duration
+// It loads environment variables from a .env file and dispatches to the
+// requested subcommand, defaulting to a full crawl.
 func main() {
-	start := time.Now()
 	logger := utils.NewLogger("Main")
 
-	logger.Info("Starting Bonpreu Go application")
-
 	// Load environment variables from .env file
 	if err := godotenv.Load(); err != nil {
-		logger.Info("No .env file found, using system environment variables")
+		logger.Debug("No .env file found, using system environment variables")
 	}
 
-	// Load configuration
-	cfg := config.DefaultConfig()
-
-	logger.Info("Loaded configuration")
-
-	// Initialize services
-	sitemapService := services.NewSitemapService()
-	productService := services.NewProductService(200)
-	dbService, err := services.NewDatabaseService(cfg)
-	if err != nil {
-		logger.Error("Error initializing database service: %v", err)
-		log.Fatalf("Error initializing database service: %v", err)
+	name, args := commands[0].name, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
-	defer dbService.Close()
-
-	logger.Info("Initialized services")
 
-	logger.Info("Fetching product IDs from sitemap...")
-
-	productIDs, err := sitemapService.FetchProductIds(cfg.SitemapURL)
-	if err != nil {
-		logger.Error("Error fetching product IDs: %v", err)
-		log.Fatalf("Error fetching product IDs: %v", err)
+	if name == "help" {
+		printUsage()
+		return
 	}
 
-	logger.Info("Successfully fetched %d product IDs", len(productIDs))
-
-	// Extract product IDs as integers for the product service
-	var productIDInts []int
-	for _, item := range productIDs {
-		productIDInts = append(productIDInts, item.ProductID)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
 	}
 
-	if cfg.RequestDuration > 0 {
-		logger.Info("Fetching product data for %d products over %v...", len(productIDInts), cfg.RequestDuration)
-	} else {
-		logger.Info("Fetching product data for %d products (no rate limiting)...", len(productIDInts))
-	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage()
+	os.Exit(2)
+}
 
-	products, nutritionalData, err := productService.FetchAllProductsData(productIDInts, cfg.RequestDuration)
-	if err != nil {
-		logger.Error("Error fetching product data: %v", err)
-		log.Fatalf("Error fetching product data: %v", err)
+// printUsage lists the available subcommands.
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
 
-	logger.Info("Successfully fetched data for %d products", len(products))
-	logger.Info("Total nutritional data entries: %d", len(nutritionalData))
+// newFlagSet creates the flag set of a subcommand with the configuration flags registered.
+func newFlagSet(name string) (*flag.FlagSet, *config.Flags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return fs, config.RegisterFlags(fs)
+}
 
-	logger.Info("Saving data to database...")
-	if err := dbService.SaveAllData(products, nutritionalData); err != nil {
-		logger.Error("Error saving data to database: %v", err)
-		log.Fatalf("Error saving data to database: %v", err)
+// loadConfig parses the command line, resolves the configuration and sets up
+// logging for the run. Every record logged afterwards carries the run ID.
+func loadConfig(fs *flag.FlagSet, flags *config.Flags, args []string) (*config.Configuration, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	productCount, err := dbService.GetProductCount()
+	cfg, err := config.Load(flags)
 	if err != nil {
-		logger.Error("Error getting product count: %v", err)
-	} else {
-		logger.Info("Total products in database: %d", productCount)
+		return nil, err
 	}
 
-	nutritionalCount, err := dbService.GetNutritionalDataCount()
-	if err != nil {
-		logger.Error("Error getting nutritional data count: %v", err)
-	} else {
-		logger.Info("Total nutritional data entries in database: %d", nutritionalCount)
+	if err := utils.ConfigureLogging(utils.LogOptions{
+		Level:           cfg.Logging.Level,
+		Format:          cfg.Logging.Format,
+		ComponentLevels: cfg.Logging.ComponentLevels,
+	}); err != nil {
+		return nil, fmt.Errorf("error configuring logging: %w", err)
 	}
 
-	logger.LogDuration("Application execution", start)
+	// Tag every log record of this run so that runs can be told apart in the log pipeline
+	utils.SetGlobalAttrs(utils.RunIDKey, utils.NewRunID())
+	return cfg, nil
 }
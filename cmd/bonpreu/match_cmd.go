@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// matchUsage describes the match subcommands.
+const matchUsage = "usage: match run|list|review|approve|reject [flags]"
+
+// runMatchCommand links the products of the configured retailers by GTIN,
+// or by brand, pack size and name, and manages the review queue of the
+// low-confidence matches.
+func runMatchCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(matchUsage)
+	}
+
+	fs, flags := newFlagSet("match " + args[0])
+	acceptScore := fs.Float64("accept-score", models.DefaultMatchOptions.AcceptScore, "run: minimum score of fuzzy matches accepted without review")
+	reviewScore := fs.Float64("review-score", models.DefaultMatchOptions.ReviewScore, "run: minimum score of fuzzy matches sent to the review queue")
+	status := fs.String("status", "", "list: comma-separated statuses to list (default all): "+strings.Join(models.MatchStatuses, ", "))
+	limit := fs.Int("limit", 50, "list and review: maximum number of matches to list (0 lists all)")
+	format := fs.String("format", "text", "list and review: output format: text or json")
+	id := fs.Int64("id", 0, "approve and reject: ID of the match to review")
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+	if *reviewScore < 0 || *reviewScore > *acceptScore || *acceptScore > 1 {
+		return fmt.Errorf("invalid scores: need 0 <= -review-score <= -accept-score <= 1")
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	switch args[0] {
+	case "run":
+		if len(cfg.Retailers) < 2 {
+			return fmt.Errorf("matching needs at least two retailers, got %v", cfg.Retailers)
+		}
+		matches, err := dbService.MatchRetailerProducts(cfg.Retailers, models.MatchOptions{
+			AcceptScore: *acceptScore,
+			ReviewScore: *reviewScore,
+		})
+		if err != nil {
+			return fmt.Errorf("error matching products: %w", err)
+		}
+
+		counts := make(map[string]int)
+		for _, match := range matches {
+			counts[match.Method+" "+match.Status]++
+		}
+		fmt.Printf("Matched %d products: %d by GTIN, %d fuzzy accepted, %d to review\n", len(matches),
+			counts[models.MatchMethodGTIN+" "+models.MatchStatusAccepted],
+			counts[models.MatchMethodFuzzy+" "+models.MatchStatusAccepted],
+			counts[models.MatchMethodFuzzy+" "+models.MatchStatusPending])
+		return nil
+
+	case "list", "review":
+		filter := services.ProductMatchFilter{Limit: *limit}
+		if args[0] == "review" {
+			filter.Statuses = []string{models.MatchStatusPending}
+		}
+		for _, value := range strings.Split(*status, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			if !isMatchStatus(value) {
+				return fmt.Errorf("invalid status %q: must be one of %s", value, strings.Join(models.MatchStatuses, ", "))
+			}
+			filter.Statuses = append(filter.Statuses, value)
+		}
+
+		matches, err := dbService.GetProductMatches(filter)
+		if err != nil {
+			return fmt.Errorf("error loading product matches: %w", err)
+		}
+		if *format == "json" {
+			return writeJSON(matches)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tMETHOD\tSCORE\tSTATUS\tLEFT\tNAME\tPRICE\tRIGHT\tNAME\tPRICE")
+		for _, match := range matches {
+			fmt.Fprintf(writer, "%d\t%s\t%.2f\t%s\t%s:%d\t%s\t%.2f\t%s:%d\t%s\t%.2f\n",
+				match.ID, match.Method, match.Score, match.Status,
+				match.LeftRetailer, match.LeftProductID, match.LeftProductName, match.LeftPriceAmount,
+				match.RightRetailer, match.RightProductID, match.RightProductName, match.RightPriceAmount)
+		}
+		return writer.Flush()
+
+	case "approve", "reject":
+		if *id <= 0 {
+			return fmt.Errorf("match %s needs -id", args[0])
+		}
+		approve := args[0] == "approve"
+		if err := dbService.ReviewProductMatch(*id, approve); err != nil {
+			return fmt.Errorf("error reviewing product match: %w", err)
+		}
+		if approve {
+			fmt.Printf("Approved match %d\n", *id)
+		} else {
+			fmt.Printf("Rejected match %d\n", *id)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown match subcommand %q, %s", args[0], matchUsage)
+	}
+}
+
+// isMatchStatus reports whether status is a known match status.
+func isMatchStatus(status string) bool {
+	for _, known := range models.MatchStatuses {
+		if status == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// nutriScoreUsage describes the nutriscore subcommands.
+const nutriScoreUsage = "usage: nutriscore compute|list [flags]"
+
+// runNutriScoreCommand recomputes the Nutri-Score of the stored products, or
+// lists and exports the stored Nutri-Scores with their missing inputs.
+func runNutriScoreCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(nutriScoreUsage)
+	}
+
+	fs, flags := newFlagSet("nutriscore " + args[0])
+	retailer := fs.String("retailer", "", "only handle products of this retailer")
+	productID := fs.Int("product", 0, "list: only list this product ID")
+	category := fs.String("category", "", "list: only list products in this category (any level of the category path)")
+	grade := fs.String("grade", "", "list: comma-separated grades to list (default all): "+strings.Join(models.NutriScoreGrades, ", "))
+	status := fs.String("status", "", "list: comma-separated statuses to list (default all): graded, missing_inputs, not_applicable")
+	limit := fs.Int("limit", 50, "list: maximum number of products to list (0 lists all)")
+	format := fs.String("format", "text", "list: output format: text, csv or json")
+	output := fs.String("output", "", "list: write the list to this file instead of stdout")
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "text", "csv", "json":
+	default:
+		return fmt.Errorf("invalid -format %q: must be text, csv or json", *format)
+	}
+	if *retailer != "" && !models.IsRetailer(*retailer) {
+		return fmt.Errorf("invalid -retailer %q: must be one of %v", *retailer, models.RetailerNames)
+	}
+
+	filter := services.NutriScoreFilter{Retailer: *retailer, ProductID: *productID, Category: *category, Limit: *limit}
+	for _, value := range strings.Split(*grade, ",") {
+		if value = strings.ToUpper(strings.TrimSpace(value)); value == "" {
+			continue
+		}
+		if !containsString(models.NutriScoreGrades, value) {
+			return fmt.Errorf("invalid grade %q: must be one of %s", value, strings.Join(models.NutriScoreGrades, ", "))
+		}
+		filter.Grades = append(filter.Grades, value)
+	}
+	statuses := []string{models.NutriScoreGraded, models.NutriScoreMissingInputs, models.NutriScoreNotApplicable}
+	for _, value := range strings.Split(*status, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if !containsString(statuses, value) {
+			return fmt.Errorf("invalid status %q: must be one of %s", value, strings.Join(statuses, ", "))
+		}
+		filter.Statuses = append(filter.Statuses, value)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	switch args[0] {
+	case "compute":
+		count, err := dbService.RecomputeNutriScores(*retailer)
+		if err != nil {
+			return fmt.Errorf("error computing Nutri-Scores: %w", err)
+		}
+		fmt.Printf("Computed the Nutri-Score of %d products\n", count)
+		return nil
+
+	case "list":
+		products, err := dbService.GetNutriScores(filter)
+		if err != nil {
+			return fmt.Errorf("error loading Nutri-Scores: %w", err)
+		}
+
+		var out io.Writer = os.Stdout
+		if *output != "" {
+			file, err := os.Create(*output)
+			if err != nil {
+				return fmt.Errorf("error creating %s: %w", *output, err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		switch *format {
+		case "json":
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(products)
+		case "csv":
+			err = writeNutriScoreCSV(out, products)
+		default:
+			err = writeNutriScoreTable(out, products)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing Nutri-Scores: %w", err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown nutriscore subcommand %q, %s", args[0], nutriScoreUsage)
+	}
+}
+
+// writeNutriScoreCSV writes Nutri-Scores as CSV with a header row. Missing
+// nutrients are empty cells.
+func writeNutriScoreCSV(w io.Writer, products []models.NutriScoreProduct) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"retailer", "product_id", "product_name", "product_brand", "status", "category", "grade", "score",
+		"negative_points", "positive_points", "missing", "assumed", "energy_kj_100g", "fat_100g",
+		"saturated_fat_100g", "sugars_100g", "fibre_100g", "protein_100g", "salt_100g", "computed_at",
+	})
+
+	formatFacts := func(facts models.NutritionFacts) []string {
+		var values []string
+		for _, nutrient := range []string{models.NutrientEnergy, models.NutrientFat, models.NutrientSaturatedFat,
+			models.NutrientSugars, models.NutrientFibre, models.NutrientProtein, models.NutrientSalt} {
+			value, ok := facts.Value(nutrient)
+			if !ok {
+				values = append(values, "")
+				continue
+			}
+			values = append(values, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		return values
+	}
+	for _, product := range products {
+		nutriScore := product.NutriScore
+		score := ""
+		if nutriScore.Score != nil {
+			score = strconv.Itoa(*nutriScore.Score)
+		}
+		record := []string{
+			product.Retailer,
+			strconv.Itoa(product.ProductID),
+			product.ProductName,
+			product.ProductBrand,
+			nutriScore.Status,
+			nutriScore.Category,
+			nutriScore.Grade,
+			score,
+			strconv.Itoa(nutriScore.NegativePoints),
+			strconv.Itoa(nutriScore.PositivePoints),
+			strings.Join(nutriScore.Missing, ","),
+			strings.Join(nutriScore.Assumed, ","),
+		}
+		record = append(record, formatFacts(nutriScore.Facts)...)
+		record = append(record, product.ComputedAt.Format(time.RFC3339))
+		writer.Write(record)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeNutriScoreTable writes Nutri-Scores as an aligned table.
+func writeNutriScoreTable(w io.Writer, products []models.NutriScoreProduct) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "RETAILER\tPRODUCT\tNAME\tCATEGORY\tGRADE\tSCORE\tMISSING")
+	for _, product := range products {
+		nutriScore := product.NutriScore
+		score := "-"
+		if nutriScore.Score != nil {
+			score = strconv.Itoa(*nutriScore.Score)
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", product.Retailer, product.ProductID, product.ProductName,
+			nutriScore.Category, orDash(nutriScore.Grade), score, orDash(strings.Join(nutriScore.Missing, ",")))
+	}
+	return writer.Flush()
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, known := range values {
+		if value == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// openFoodFactsUsage describes the openfoodfacts subcommands.
+const openFoodFactsUsage = "usage: openfoodfacts export|report [flags]"
+
+// runOpenFoodFactsCommand exports the stored products in the Open Food
+// Facts product schema, or reports the products that cannot be exported
+// because required fields are missing.
+func runOpenFoodFactsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(openFoodFactsUsage)
+	}
+
+	fs, flags := newFlagSet("openfoodfacts " + args[0])
+	retailer := fs.String("retailer", "", "only handle products of this retailer")
+	format := fs.String("format", "", "output format: csv or json for export (default csv), text, csv or json for report (default text)")
+	output := fs.String("output", "", "write to this file instead of stdout")
+	missing := fs.String("missing", "", "report: only list products missing this field: "+strings.Join(models.OpenFoodFactsRequiredFields, ", "))
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *retailer != "" && !models.IsRetailer(*retailer) {
+		return fmt.Errorf("invalid -retailer %q: must be one of %v", *retailer, models.RetailerNames)
+	}
+	if *missing != "" && !containsString(models.OpenFoodFactsRequiredFields, *missing) {
+		return fmt.Errorf("invalid -missing %q: must be one of %s", *missing, strings.Join(models.OpenFoodFactsRequiredFields, ", "))
+	}
+
+	switch args[0] {
+	case "export":
+		if *format == "" {
+			*format = "csv"
+		}
+		if *format != "csv" && *format != "json" {
+			return fmt.Errorf("invalid -format %q: must be csv or json", *format)
+		}
+	case "report":
+		if *format == "" {
+			*format = "text"
+		}
+		if *format != "text" && *format != "csv" && *format != "json" {
+			return fmt.Errorf("invalid -format %q: must be text, csv or json", *format)
+		}
+	default:
+		return fmt.Errorf("unknown openfoodfacts subcommand %q, %s", args[0], openFoodFactsUsage)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	products, err := dbService.GetOpenFoodFactsProducts(*retailer)
+	if err != nil {
+		return fmt.Errorf("error loading products: %w", err)
+	}
+	exported, issues := models.ExportOpenFoodFacts(products)
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", *output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if args[0] == "export" {
+		switch *format {
+		case "json":
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(exported)
+		default:
+			err = writeOpenFoodFactsCSV(out, exported)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing Open Food Facts export: %w", err)
+		}
+		// The summary goes to stderr so that it does not mix with an export on stdout
+		fmt.Fprintf(os.Stderr, "Exported %d products; %d products lack required fields (see openfoodfacts report)\n",
+			len(exported), len(issues))
+		return nil
+	}
+
+	if *missing != "" {
+		var filtered []models.OpenFoodFactsIssue
+		for _, issue := range issues {
+			if containsString(issue.Missing, *missing) {
+				filtered = append(filtered, issue)
+			}
+		}
+		issues = filtered
+	}
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(issues)
+	case "csv":
+		err = writeOpenFoodFactsIssuesCSV(out, issues)
+	default:
+		err = writeOpenFoodFactsIssuesTable(out, issues, len(exported))
+	}
+	if err != nil {
+		return fmt.Errorf("error writing Open Food Facts report: %w", err)
+	}
+	return nil
+}
+
+// writeOpenFoodFactsCSV writes products as CSV with the column names of the
+// Open Food Facts export. Tags are comma-separated and missing nutriments
+// are empty cells.
+func writeOpenFoodFactsCSV(w io.Writer, products []models.OpenFoodFactsProduct) error {
+	writer := csv.NewWriter(w)
+	header := []string{
+		"code", "product_name", "lang", "quantity", "product_quantity", "product_quantity_unit", "brands",
+		"brands_tags", "categories", "categories_tags", "countries", "stores", "origins", "ingredients_text",
+		"allergens", "traces", "nutriscore_grade", "image_url", "nutrition_data_per",
+	}
+	writer.Write(append(header, models.OpenFoodFactsNutrimentKeys...))
+
+	for _, product := range products {
+		productQuantity := ""
+		if product.ProductQuantity > 0 {
+			productQuantity = strconv.FormatFloat(product.ProductQuantity, 'f', -1, 64)
+		}
+		record := []string{
+			product.Code,
+			product.ProductName,
+			product.Lang,
+			product.Quantity,
+			productQuantity,
+			product.ProductQuantityUnit,
+			product.Brands,
+			strings.Join(product.BrandsTags, ","),
+			product.Categories,
+			strings.Join(product.CategoriesTags, ","),
+			product.Countries,
+			product.Stores,
+			product.Origins,
+			product.IngredientsText,
+			product.Allergens,
+			product.Traces,
+			product.NutriScoreGrade,
+			product.ImageURL,
+			product.NutritionDataPer,
+		}
+		for _, key := range models.OpenFoodFactsNutrimentKeys {
+			value, ok := product.Nutriments[key]
+			if !ok {
+				record = append(record, "")
+				continue
+			}
+			record = append(record, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		writer.Write(record)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeOpenFoodFactsIssuesCSV writes the products that cannot be exported as
+// CSV with a header row.
+func writeOpenFoodFactsIssuesCSV(w io.Writer, issues []models.OpenFoodFactsIssue) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"retailer", "product_id", "product_name", "product_brand", "missing"})
+	for _, issue := range issues {
+		writer.Write([]string{
+			issue.Retailer,
+			strconv.Itoa(issue.ProductID),
+			issue.ProductName,
+			issue.ProductBrand,
+			strings.Join(issue.Missing, ","),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeOpenFoodFactsIssuesTable writes the products that cannot be exported
+// as an aligned table, preceded by how many products lack each field.
+func writeOpenFoodFactsIssuesTable(w io.Writer, issues []models.OpenFoodFactsIssue, exported int) error {
+	counts := make(map[string]int)
+	for _, issue := range issues {
+		for _, field := range issue.Missing {
+			counts[field]++
+		}
+	}
+	fmt.Fprintf(w, "%d products can be exported, %d cannot\n", exported, len(issues))
+	for _, field := range models.OpenFoodFactsRequiredFields {
+		if counts[field] > 0 {
+			fmt.Fprintf(w, "  missing %s: %d\n", field, counts[field])
+		}
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "RETAILER\tPRODUCT\tNAME\tBRAND\tMISSING")
+	for _, issue := range issues {
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\n", issue.Retailer, issue.ProductID, orDash(issue.ProductName),
+			orDash(issue.ProductBrand), strings.Join(issue.Missing, ","))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/services"
+)
+
+// runPromotionsCommand lists the promotions that are active at a given time,
+// taken from the latest observation of each product, as a table or as JSON.
+func runPromotionsCommand(args []string) error {
+	fs, flags := newFlagSet("promotions")
+	atFlag := fs.String("at", "", "list promotions active at this date or RFC 3339 time (default now)")
+	format := fs.String("format", "text", "output format: text or json")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+
+	at := time.Now()
+	if *atFlag != "" {
+		if at, err = parseTimeFlag(*atFlag); err != nil {
+			return fmt.Errorf("invalid -at: %w", err)
+		}
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	promotions, err := dbService.GetActivePromotions(at)
+	if err != nil {
+		return fmt.Errorf("error loading active promotions: %w", err)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(promotions)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PRODUCT\tNAME\tTYPE\tDESCRIPTION\tQTY\tPRICE\tPROMO PRICE\tUNTIL")
+	for _, promotion := range promotions {
+		promoPrice, until := "-", "-"
+		if promotion.DiscountedPrice > 0 {
+			promoPrice = fmt.Sprintf("%.2f", promotion.DiscountedPrice)
+		}
+		if promotion.EndDate != nil {
+			until = promotion.EndDate.Format("2006-01-02")
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%d\t%.2f\t%s\t%s\n",
+			promotion.ProductID, promotion.ProductName, promotion.Type, promotion.Description,
+			promotion.RequiredQuantity, promotion.ProductPriceAmount, promoPrice, until)
+	}
+	return writer.Flush()
+}
+
+// parseTimeFlag parses a command-line time given as an RFC 3339 time or a plain date.
+func parseTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date (2006-01-02) or RFC 3339 time", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// queueUsage describes the queue subcommands.
+const queueUsage = "usage: queue enqueue|work|status [flags]"
+
+// runQueueCommand runs the distributed crawl. The coordinator (queue enqueue)
+// puts every product of the configured retailers into the crawl_jobs table; any number of
+// worker processes (queue work), on one or several machines, claim batches
+// of jobs with FOR UPDATE SKIP LOCKED, fetch them and save the results. Each
+// worker renews the lease of its jobs with heartbeats, so the jobs of a dead
+// worker are picked up by the others once the lease expires.
+func runQueueCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(queueUsage)
+	}
+
+	fs, flags := newFlagSet("queue " + args[0])
+	wait := fs.Bool("wait", false, "enqueue: wait until the run has finished, then send the email digest")
+	exitWhenIdle := fs.Bool("exit-when-idle", false, "work: exit once no job is pending or running instead of polling")
+	runID := fs.String("run", "", "status: run to show (default the latest)")
+	format := fs.String("format", "text", "status: output format, text or json")
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+
+	logger := utils.NewLogger("Queue")
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		logger.Error("Error initializing database service: %v", err)
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	switch args[0] {
+	case "enqueue":
+		return enqueueCrawl(cfg, logger, dbService, *wait)
+	case "work":
+		return runQueueWorker(cfg, logger, dbService, *exitWhenIdle)
+	case "status":
+		return printQueueStatus(cfg, dbService, *runID, *format)
+	default:
+		return fmt.Errorf("unknown queue subcommand %q, %s", args[0], queueUsage)
+	}
+}
+
+// enqueueCrawl queues every product of the configured retailers as a new run.
+// A retailer whose products cannot be discovered does not stop the others
+// from being queued. With wait, it then follows the run until every job is
+// done or failed and sends the email digest with the run's health if it
+// succeeded, like the crawl command does.
+func enqueueCrawl(cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService, wait bool) error {
+	start := time.Now()
+
+	_, transport, err := newProductService(cfg, logger)
+	if err != nil {
+		return err
+	}
+	retailers, err := newRetailers(cfg, cfg.Retailers, transport)
+	if err != nil {
+		return err
+	}
+
+	runID := utils.NewRunID()
+	var added int64
+	var errs []error
+	for _, retailer := range retailers {
+		productIDs, err := discoverProductIDs(logger, retailer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		count, err := dbService.EnqueueCrawlJobs(runID, retailer.Name(), productIDs)
+		if err != nil {
+			return fmt.Errorf("error enqueueing crawl jobs: %w", err)
+		}
+		added += count
+	}
+	fmt.Printf("Queued %d products as run %s\n", added, runID)
+	if !wait || added == 0 {
+		return errors.Join(errs...)
+	}
+
+	ticker := time.NewTicker(cfg.Queue.PollInterval)
+	defer ticker.Stop()
+	lastLog := time.Now()
+	for {
+		stats, err := dbService.GetCrawlQueueStats(runID)
+		if err != nil {
+			return fmt.Errorf("error loading run %s: %w", runID, err)
+		}
+		if stats.Finished() {
+			health := stats.RunHealth(time.Since(start))
+			logger.Info("Run %s finished in %v: %d done (%d not found), %d failed",
+				runID, time.Since(start).Round(time.Second), stats.Done, stats.NotFound, stats.Failed)
+
+			sendRunDigest(cfg, logger, dbService, health, errs)
+			if health.FailureRate() > cfg.FailureRateThreshold {
+				errs = append(errs, fmt.Errorf("run %s failed: %.2f%% of the products failed", runID, health.FailurePercent()))
+			}
+			return errors.Join(errs...)
+		}
+		if time.Since(lastLog) >= time.Minute {
+			logger.Info("Run %s: %d pending, %d running, %d done, %d failed",
+				runID, stats.Pending, stats.Running, stats.Done, stats.Failed)
+			lastLog = time.Now()
+		}
+		<-ticker.C
+	}
+}
+
+// runQueueWorker claims and processes batches of crawl jobs until it is
+// stopped with SIGINT or SIGTERM, or, with exitWhenIdle, until the queue is
+// empty. A stopped worker finishes its current batch first.
+func runQueueWorker(cfg *config.Configuration, logger *utils.Logger, dbService *services.DatabaseService, exitWhenIdle bool) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	workerID := fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), utils.NewRunID()[:6])
+	logger = logger.With("worker", workerID)
+
+	productService, transport, err := newProductService(cfg, logger)
+	if err != nil {
+		return err
+	}
+	if err := dbService.RegisterCrawlWorker(workerID, hostname); err != nil {
+		return fmt.Errorf("error registering worker: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Renew the leases of the batch being processed, and only those
+	var processingMu sync.Mutex
+	var processing []int64
+	heartbeatCtx, stopHeartbeats := context.WithCancel(context.Background())
+	defer stopHeartbeats()
+	go func() {
+		ticker := time.NewTicker(cfg.Queue.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+				processingMu.Lock()
+				jobIDs := processing
+				processingMu.Unlock()
+				if _, err := dbService.HeartbeatCrawlWorker(workerID, jobIDs, cfg.Queue.Lease); err != nil {
+					logger.Error("Error sending heartbeat: %v", err)
+				}
+			}
+		}
+	}()
+
+	logger.Info("Worker %s started", workerID)
+	for ctx.Err() == nil {
+		jobs, err := dbService.ClaimCrawlJobs(workerID, cfg.Queue.BatchSize, cfg.Queue.Lease, cfg.Queue.MaxAttempts)
+		if err != nil {
+			logger.Error("Error claiming crawl jobs: %v", err)
+		}
+
+		if len(jobs) > 0 {
+			jobIDs := make([]int64, 0, len(jobs))
+			for _, job := range jobs {
+				jobIDs = append(jobIDs, job.ID)
+			}
+			processingMu.Lock()
+			processing = jobIDs
+			processingMu.Unlock()
+
+			err := processCrawlJobs(cfg, logger, productService, transport, dbService, workerID, jobs)
+
+			processingMu.Lock()
+			processing = nil
+			processingMu.Unlock()
+			if err != nil {
+				// Give the batch back to the queue rather than holding it
+				logger.Error("Error processing crawl jobs: %v", err)
+				if _, releaseErr := dbService.ReleaseCrawlJobs(workerID, jobIDs, cfg.Queue.MaxAttempts, err.Error()); releaseErr != nil {
+					logger.Error("Error releasing crawl jobs: %v", releaseErr)
+				}
+			}
+			continue
+		}
+
+		if exitWhenIdle && err == nil {
+			open, err := dbService.HasOpenCrawlJobs()
+			if err != nil {
+				logger.Error("Error checking crawl queue: %v", err)
+			} else if !open {
+				logger.Info("Crawl queue is empty, stopping worker %s", workerID)
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.Queue.PollInterval):
+		}
+	}
+
+	logger.Info("Worker %s stopped", workerID)
+	return nil
+}
+
+// processCrawlJobs fetches the products of a batch of claimed jobs, retailer
+// by retailer, saves them like a crawl does and records the result of every
+// job. Jobs of any retailer may be queued, not only the configured ones, so
+// only the retailers of the batch are created; the jobs of a retailer that
+// cannot be created fail without holding up the others.
+func processCrawlJobs(cfg *config.Configuration, logger *utils.Logger, productService *services.ProductService, transport http.RoundTripper, dbService *services.DatabaseService, workerID string, jobs []models.CrawlJob) error {
+	var results []models.CrawlJobResult
+	fetched, failed := 0, 0
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, job := range jobs {
+		if !seen[job.Retailer] {
+			seen[job.Retailer] = true
+			names = append(names, job.Retailer)
+		}
+	}
+	retailers, unavailable := newAvailableRetailers(cfg, names, transport)
+	for name, err := range unavailable {
+		logger.Warn("Failing the %s jobs of the batch: %v", name, err)
+	}
+	for _, job := range jobs {
+		if err, ok := unavailable[job.Retailer]; ok {
+			results = append(results, models.CrawlJobResult{
+				JobID:        job.ID,
+				Status:       models.CrawlJobFailed,
+				ErrorMessage: err.Error(),
+			})
+			failed++
+		}
+	}
+
+	for _, retailer := range retailers {
+		var retailerJobs []models.CrawlJob
+		for _, job := range jobs {
+			if job.Retailer == retailer.Name() {
+				retailerJobs = append(retailerJobs, job)
+			}
+		}
+		if len(retailerJobs) == 0 {
+			continue
+		}
+
+		productService.SetRetailer(retailer)
+		retailerResults, err := processRetailerJobs(cfg, logger, productService, dbService, retailerJobs)
+		if err != nil {
+			return err
+		}
+		for _, result := range retailerResults {
+			if result.ErrorCategory == "" {
+				fetched++
+			} else {
+				failed++
+			}
+		}
+		results = append(results, retailerResults...)
+	}
+
+	completed, err := dbService.CompleteCrawlJobs(workerID, results)
+	if err != nil {
+		return fmt.Errorf("error completing crawl jobs: %w", err)
+	}
+	logger.Info("Completed %d crawl jobs (%d fetched, %d failed)", completed, fetched, failed)
+	return nil
+}
+
+// processRetailerJobs fetches and saves the products of claimed jobs of the
+// product service's retailer and returns the result of every job.
+func processRetailerJobs(cfg *config.Configuration, logger *utils.Logger, productService *services.ProductService, dbService *services.DatabaseService, jobs []models.CrawlJob) ([]models.CrawlJobResult, error) {
+	productIDs := make([]int, 0, len(jobs))
+	for _, job := range jobs {
+		productIDs = append(productIDs, job.ProductID)
+	}
+
+	// Spread the batch at the worker's request rate
+	var duration time.Duration
+	if cfg.Queue.RequestsPerSecond > 0 {
+		duration = time.Duration(float64(len(productIDs)) / cfg.Queue.RequestsPerSecond * float64(time.Second))
+	}
+
+	// The batch is finished even when the worker is stopping, so that every job gets a result
+	products, nutritionalData, report, err := productService.FetchAllProductsData(context.Background(), productIDs, duration)
+	var summaryErr *services.FetchSummaryError
+	if err != nil && !errors.As(err, &summaryErr) {
+		return nil, fmt.Errorf("error fetching product data: %w", err)
+	}
+
+	if err := dbService.SaveAllData(products, nutritionalData); err != nil {
+		return nil, fmt.Errorf("error saving data to database: %w", err)
+	}
+	notifyWatchers(cfg, logger, dbService, products)
+
+	fetchedIDs := make([]int, 0, len(products))
+	for _, product := range products {
+		fetchedIDs = append(fetchedIDs, product.ProductID)
+	}
+	if err := dbService.RecordFetchFailures(productService.Retailer().Name(), fetchedIDs, report.Failures); err != nil {
+		return nil, fmt.Errorf("error recording fetch failures: %w", err)
+	}
+
+	failuresByProduct := make(map[int]models.ProductFetchFailure, len(report.Failures))
+	for _, failure := range report.Failures {
+		failuresByProduct[failure.ProductID] = failure
+	}
+
+	results := make([]models.CrawlJobResult, 0, len(jobs))
+	for _, job := range jobs {
+		result := models.CrawlJobResult{JobID: job.ID}
+		if failure, ok := failuresByProduct[job.ProductID]; ok {
+			result.ErrorCategory = failure.ErrorCategory
+			result.ErrorMessage = failure.ErrorMessage
+		}
+		result.Status = result.NextStatus(job.Attempts, cfg.Queue.MaxAttempts)
+		if result.Status == models.CrawlJobPending {
+			// The queue requests the product again in a later batch
+			metrics.HTTPRetries.Inc("product", result.ErrorCategory)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// printQueueStatus prints the job counts of a run and the live workers.
+func printQueueStatus(cfg *config.Configuration, dbService *services.DatabaseService, runID, format string) error {
+	stats, err := dbService.GetCrawlQueueStats(runID)
+	if errors.Is(err, services.ErrNoCrawlRuns) {
+		fmt.Println("No crawl runs queued")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error loading crawl queue: %w", err)
+	}
+
+	// Workers silent for longer than a lease are dead; show them for a while
+	workers, err := dbService.GetCrawlWorkers(time.Now().Add(-10 * cfg.Queue.Lease))
+	if err != nil {
+		return fmt.Errorf("error loading crawl workers: %w", err)
+	}
+
+	if format == "json" {
+		return writeJSON(struct {
+			Run     models.CrawlQueueStats `json:"run"`
+			Workers []models.CrawlWorker   `json:"workers"`
+		}{stats, workers})
+	}
+
+	fmt.Printf("Run %s, queued %s\n", stats.RunID, stats.EnqueuedAt.Format(time.RFC3339))
+	fmt.Printf("  Pending: %d  Running: %d  Done: %d (%d not found)  Failed: %d  Total: %d\n\n",
+		stats.Pending, stats.Running, stats.Done, stats.NotFound, stats.Failed, stats.Total())
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "WORKER\tSTATE\tRUNNING\tDONE\tFAILED\tLAST HEARTBEAT")
+	for _, worker := range workers {
+		state := "alive"
+		if time.Since(worker.HeartbeatAt) > cfg.Queue.Lease {
+			state = "dead"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%d\t%s\n", worker.WorkerID, state, worker.RunningJobs,
+			worker.JobsDone, worker.JobsFailed, worker.HeartbeatAt.Format(time.RFC3339))
+	}
+	return writer.Flush()
+}
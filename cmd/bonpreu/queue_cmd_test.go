@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/testdb"
+	"bonpreu-go/pkg/utils"
+)
+
+// newTestDatabase returns a DatabaseService on a fresh schema of the test
+// database, see testdb.URL, and points cfg at it.
+func newTestDatabase(t *testing.T, cfg *config.Configuration) *services.DatabaseService {
+	t.Helper()
+	cfg.Database.URL = testdb.URL(t)
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabaseService: %v", err)
+	}
+	t.Cleanup(func() { dbService.Close() })
+	return dbService
+}
+
+func TestNewAvailableRetailersDefaultConfig(t *testing.T) {
+	cfg, err := config.ProfileConfig(config.ProfileProduction)
+	if err != nil {
+		t.Fatalf("ProfileConfig: %v", err)
+	}
+
+	// Esclat has no API base URL by default; Bonpreu is still created
+	retailers, unavailable := newAvailableRetailers(cfg, models.RetailerNames, nil)
+	if len(retailers) != 1 || retailers[0].Name() != models.RetailerBonpreu {
+		t.Errorf("got %d retailers, want only %s", len(retailers), models.RetailerBonpreu)
+	}
+	if _, ok := unavailable[models.RetailerEsclat]; !ok || len(unavailable) != 1 {
+		t.Errorf("unavailable = %v, want only %s", unavailable, models.RetailerEsclat)
+	}
+}
+
+func TestQueueWorkerDefaultConfig(t *testing.T) {
+	cfg, err := config.ProfileConfig(config.ProfileProduction)
+	if err != nil {
+		t.Fatalf("ProfileConfig: %v", err)
+	}
+	cfg.Queue.PollInterval = 10 * time.Millisecond
+	dbService := newTestDatabase(t, cfg)
+
+	// A job of a retailer that is not configured fails on its own
+	if _, err := dbService.EnqueueCrawlJobs("run-1", models.RetailerEsclat, []int{1001}); err != nil {
+		t.Fatalf("EnqueueCrawlJobs: %v", err)
+	}
+
+	if err := runQueueWorker(cfg, utils.NewLogger("Queue"), dbService, true); err != nil {
+		t.Fatalf("runQueueWorker: %v", err)
+	}
+
+	stats, err := dbService.GetCrawlQueueStats("run-1")
+	if err != nil {
+		t.Fatalf("GetCrawlQueueStats: %v", err)
+	}
+	if stats.Failed != 1 || stats.Pending != 0 || stats.Running != 0 {
+		t.Errorf("run stats = %+v, want the esclat job failed", stats)
+	}
+}
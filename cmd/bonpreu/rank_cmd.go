@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// runRankCommand ranks products by the effective price per kg, litre or unit
+// of their latest observation, with promotions applied.
+func runRankCommand(args []string) error {
+	fs, flags := newFlagSet("rank")
+	retailer := fs.String("retailer", "", "only rank products of this retailer")
+	category := fs.String("category", "", "only rank products in this category (any level of the category path)")
+	unit := fs.String("unit", "", "only rank products priced per this base unit: kg, l or unit")
+	limit := fs.Int("limit", 20, "maximum number of products to list (0 lists all)")
+	format := fs.String("format", "text", "output format: text or json")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+	if *retailer != "" && !models.IsRetailer(*retailer) {
+		return fmt.Errorf("invalid -retailer %q: must be one of %v", *retailer, models.RetailerNames)
+	}
+	switch *unit {
+	case "", "kg", "l", "unit":
+	default:
+		return fmt.Errorf("invalid -unit %q: must be kg, l or unit", *unit)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	ranking, err := dbService.RankProductsByEffectivePrice(services.RankingOptions{
+		Retailer: *retailer,
+		Category: *category,
+		BaseUnit: *unit,
+		Limit:    *limit,
+	})
+	if err != nil {
+		return fmt.Errorf("error ranking products: %w", err)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ranking)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "RETAILER\tPRODUCT\tNAME\tBRAND\tPRICE\tEFFECTIVE\tPER UNIT\tPROMOTION")
+	for _, product := range ranking {
+		promotion := "-"
+		if product.Mechanic != "" {
+			promotion = fmt.Sprintf("%s (buy %d)", product.Mechanic, product.RequiredQuantity)
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%.2f\t%.2f\t%.2f/%s\t%s\n",
+			product.Retailer, product.ProductID, product.ProductName, product.ProductBrand, product.ProductPriceAmount,
+			product.EffectiveUnitPrice, product.PricePerUnit, product.BaseUnit, promotion)
+	}
+	return writer.Flush()
+}
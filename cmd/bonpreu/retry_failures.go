@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// runRetryFailuresCommand refetches only the products recorded in the
+// product_fetch_failures table by previous runs, so that a bad night can be
+// repaired without a full crawl. Products that now succeed, or turn out not to
+// exist, are removed from the table; the rest stay queued for the next retry.
+func runRetryFailuresCommand(args []string) error {
+	fs, flags := newFlagSet("retry-failures")
+	categories := fs.String("category", "", "only retry failures in these comma-separated error categories (e.g. rate_limited,transport)")
+	limit := fs.Int("limit", 0, "retry at most this many products (0 retries all)")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+
+	logger := utils.NewLogger("Main")
+	logger.Info("Retrying failed products (profile %s)", cfg.Profile)
+
+	var categoryFilter []string
+	for _, category := range strings.Split(*categories, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categoryFilter = append(categoryFilter, category)
+		}
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		logger.Error("Error initializing database service: %v", err)
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	failures, err := dbService.GetFetchFailures(categoryFilter, *limit)
+	if err != nil {
+		logger.Error("Error loading fetch failures: %v", err)
+		return fmt.Errorf("error loading fetch failures: %w", err)
+	}
+	if len(failures) == 0 {
+		logger.Info("No failed products to retry")
+		return nil
+	}
+
+	// Retry the failures of each retailer, including retailers no longer crawled
+	productIDs := make(map[string][]int)
+	var names []string
+	for _, failure := range failures {
+		if productIDs[failure.Retailer] == nil {
+			names = append(names, failure.Retailer)
+		}
+		productIDs[failure.Retailer] = append(productIDs[failure.Retailer], failure.ProductID)
+	}
+	sort.Strings(names)
+
+	productService, transport, err := newProductService(cfg, logger)
+	if err != nil {
+		return err
+	}
+	// Failures of a retailer that is no longer configured stay for a later retry
+	retailers, unavailable := newAvailableRetailers(cfg, names, transport)
+	for _, name := range names {
+		if err, ok := unavailable[name]; ok {
+			logger.Warn("Skipping %d failed %s products: %v", len(productIDs[name]), name, err)
+		}
+	}
+
+	var errs []error
+	for _, retailer := range retailers {
+		productService.SetRetailer(retailer)
+		if _, err := fetchAndSave(context.Background(), cfg, logger, productService, dbService, productIDs[retailer.Name()]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
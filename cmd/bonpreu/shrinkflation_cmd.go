@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/utils"
+)
+
+// runShrinkflationCommand runs the shrinkflation detector over the observations
+// since a given date, records the results in the shrinkflation_reports table
+// and exports them as a table, CSV or JSON.
+func runShrinkflationCommand(args []string) error {
+	fs, flags := newFlagSet("shrinkflation")
+	sinceFlag := fs.String("since", "", "only consider pack size changes observed since this date (default 30 days ago)")
+	format := fs.String("format", "text", "output format: text, csv or json")
+	output := fs.String("output", "", "write the export to this file instead of standard output")
+	reportOnly := fs.Bool("report-only", false, "export the recorded events without running detection")
+
+	cfg, err := loadConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "text", "csv", "json":
+	default:
+		return fmt.Errorf("invalid -format %q: must be text, csv or json", *format)
+	}
+
+	since := time.Now().AddDate(0, 0, -30)
+	if *sinceFlag != "" {
+		if since, err = parseTimeFlag(*sinceFlag); err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+
+	logger := utils.NewLogger("Main")
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	if !*reportOnly {
+		if _, err := dbService.DetectShrinkflation(since); err != nil {
+			return fmt.Errorf("error detecting shrinkflation: %w", err)
+		}
+	}
+
+	events, err := dbService.GetShrinkflationEvents(since)
+	if err != nil {
+		return fmt.Errorf("error loading shrinkflation events: %w", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", *output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(events)
+	case "csv":
+		err = writeShrinkflationCSV(out, events)
+	default:
+		err = writeShrinkflationTable(out, events)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing shrinkflation report: %w", err)
+	}
+
+	if *output != "" {
+		logger.Info("Wrote %d shrinkflation events to %s", len(events), *output)
+	}
+	return nil
+}
+
+// writeShrinkflationCSV writes shrinkflation events as CSV with a header row.
+func writeShrinkflationCSV(w io.Writer, events []models.ShrinkflationEvent) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"product_id", "product_name", "product_brand", "old_observed_at", "new_observed_at",
+		"old_pack_size", "new_pack_size", "old_net_quantity", "new_net_quantity", "base_unit",
+		"old_price", "new_price", "old_unit_price", "new_unit_price", "unit_price_unit",
+		"unit_price_increase_percent", "low_confidence",
+	})
+
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	for _, event := range events {
+		writer.Write([]string{
+			strconv.Itoa(event.ProductID),
+			event.ProductName,
+			event.ProductBrand,
+			event.OldObservedAt.Format(time.RFC3339),
+			event.NewObservedAt.Format(time.RFC3339),
+			event.OldDescription,
+			event.NewDescription,
+			formatFloat(event.OldNetQuantity),
+			formatFloat(event.NewNetQuantity),
+			event.BaseUnit,
+			formatFloat(event.OldPrice),
+			formatFloat(event.NewPrice),
+			formatFloat(event.OldUnitPrice),
+			formatFloat(event.NewUnitPrice),
+			event.UnitPriceUnit,
+			formatFloat(event.UnitPriceIncrease),
+			strconv.FormatBool(event.LowConfidence),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeShrinkflationTable writes shrinkflation events as an aligned table.
+func writeShrinkflationTable(w io.Writer, events []models.ShrinkflationEvent) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PRODUCT\tNAME\tOLD SIZE\tNEW SIZE\tOLD PRICE\tNEW PRICE\tUNIT PRICE\tINCREASE\tDATE")
+	for _, event := range events {
+		name := event.ProductName
+		if event.LowConfidence {
+			name += " (?)"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%.2f\t%.2f\t%.2f -> %.2f/%s\t%+.1f%%\t%s\n",
+			event.ProductID, name, event.OldDescription, event.NewDescription,
+			event.OldPrice, event.NewPrice, event.OldUnitPrice, event.NewUnitPrice, event.UnitPriceUnit,
+			event.UnitPriceIncrease, event.NewObservedAt.Format("2006-01-02"))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// watchUsage describes the watch subcommands.
+const watchUsage = "usage: watch list|add|remove|dead-letters [flags]"
+
+// runWatchCommand manages the watchlist of products whose price, promotion
+// and stock changes are sent to the webhook, and shows undelivered events.
+func runWatchCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(watchUsage)
+	}
+
+	fs, flags := newFlagSet("watch " + args[0])
+	format := fs.String("format", "text", "output format of list and dead-letters: text or json")
+	productID := fs.Int("product", 0, "add: watch this product ID")
+	search := fs.String("search", "", "add: watch products whose name or brand contains this text")
+	category := fs.String("category", "", "add: watch products in this category (any level of the category path)")
+	events := fs.String("events", "", "add: comma-separated event types to send (default all): "+strings.Join(models.EventTypes, ", "))
+	note := fs.String("note", "", "add: free-text note")
+	id := fs.Int("id", 0, "remove: ID of the entry to remove")
+	sinceFlag := fs.String("since", "", "dead-letters: only list events that failed since this date (default 7 days ago)")
+
+	cfg, err := loadConfig(fs, flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be text or json", *format)
+	}
+
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing database service: %w", err)
+	}
+	defer dbService.Close()
+
+	switch args[0] {
+	case "add":
+		entry := models.WatchlistEntry{ProductID: *productID, SearchQuery: *search, Category: *category, Note: *note}
+		if entry.ProductID == 0 && entry.SearchQuery == "" && entry.Category == "" {
+			return fmt.Errorf("watch add needs -product, -search or -category")
+		}
+		for _, eventType := range strings.Split(*events, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType == "" {
+				continue
+			}
+			if !isEventType(eventType) {
+				return fmt.Errorf("invalid event type %q: must be one of %s", eventType, strings.Join(models.EventTypes, ", "))
+			}
+			entry.EventTypes = append(entry.EventTypes, eventType)
+		}
+
+		entryID, err := dbService.AddWatchlistEntry(entry)
+		if err != nil {
+			return fmt.Errorf("error adding watchlist entry: %w", err)
+		}
+		fmt.Printf("Added watchlist entry %d\n", entryID)
+		return nil
+
+	case "remove":
+		if *id <= 0 {
+			return fmt.Errorf("watch remove needs -id")
+		}
+		if err := dbService.RemoveWatchlistEntry(*id); err != nil {
+			return fmt.Errorf("error removing watchlist entry: %w", err)
+		}
+		fmt.Printf("Removed watchlist entry %d\n", *id)
+		return nil
+
+	case "list":
+		entries, err := dbService.GetWatchlist()
+		if err != nil {
+			return fmt.Errorf("error loading watchlist: %w", err)
+		}
+		if *format == "json" {
+			return writeJSON(entries)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tPRODUCT\tSEARCH\tCATEGORY\tEVENTS\tNOTE")
+		for _, entry := range entries {
+			product, eventTypes := "-", "all"
+			if entry.ProductID != 0 {
+				product = strconv.Itoa(entry.ProductID)
+			}
+			if len(entry.EventTypes) > 0 {
+				eventTypes = strings.Join(entry.EventTypes, ",")
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", entry.ID, product,
+				orDash(entry.SearchQuery), orDash(entry.Category), eventTypes, entry.Note)
+		}
+		return writer.Flush()
+
+	case "dead-letters":
+		since := time.Now().AddDate(0, 0, -7)
+		if *sinceFlag != "" {
+			if since, err = parseTimeFlag(*sinceFlag); err != nil {
+				return fmt.Errorf("invalid -since: %w", err)
+			}
+		}
+
+		deadLetters, err := dbService.GetWebhookDeadLetters(since)
+		if err != nil {
+			return fmt.Errorf("error loading webhook dead letters: %w", err)
+		}
+		if *format == "json" {
+			return writeJSON(deadLetters)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tEVENT\tPRODUCT\tATTEMPTS\tSTATUS\tERROR\tFAILED AT")
+		for _, deadLetter := range deadLetters {
+			fmt.Fprintf(writer, "%d\t%s\t%d\t%d\t%d\t%s\t%s\n", deadLetter.ID, deadLetter.EventType,
+				deadLetter.ProductID, deadLetter.Attempts, deadLetter.LastStatus, deadLetter.ErrorMessage,
+				deadLetter.FailedAt.Format(time.RFC3339))
+		}
+		return writer.Flush()
+
+	default:
+		return fmt.Errorf("unknown watch subcommand %q, %s", args[0], watchUsage)
+	}
+}
+
+// isEventType reports whether eventType is a known product event type.
+func isEventType(eventType string) bool {
+	for _, known := range models.EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// orDash returns value, or "-" when it is empty.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// writeJSON writes value to standard output as indented JSON.
+func writeJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
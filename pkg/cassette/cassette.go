@@ -0,0 +1,194 @@
+// Package cassette records upstream HTTP interactions to disk and replays them
+// without network access. A cassette is a directory holding one JSON file per
+// interaction, written as soon as the response has been read so that a long
+// nightly run is preserved even if the process dies half-way.
+package cassette
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mode selects how the HTTP layer uses cassettes.
+type Mode string
+
+const (
+	// ModeOff sends requests to the network without recording.
+	ModeOff Mode = "off"
+	// ModeRecord sends requests to the network and saves every interaction.
+	ModeRecord Mode = "record"
+	// ModeReplay serves saved interactions and never touches the network.
+	ModeReplay Mode = "replay"
+)
+
+// MatchMode selects how replayed requests are matched against recordings.
+type MatchMode string
+
+const (
+	// MatchStrict requires the same method, exact URL and matching request
+	// headers, and serves repeated identical requests in recorded order.
+	MatchStrict MatchMode = "strict"
+	// MatchLenient matches on method, path and query parameters in any order,
+	// ignoring host and headers. The last recording is reused once exhausted.
+	MatchLenient MatchMode = "lenient"
+)
+
+// DefaultScrubHeaders are volatile or sensitive headers removed before saving.
+var DefaultScrubHeaders = []string{
+	"Age",
+	"Authorization",
+	"Cf-Ray",
+	"Cookie",
+	"Date",
+	"Expires",
+	"Last-Modified",
+	"Report-To",
+	"Server-Timing",
+	"Set-Cookie",
+	"X-Amz-Cf-Id",
+	"X-Request-Id",
+}
+
+// strictMatchHeaders are the request headers compared in strict mode.
+// They are the ones that change the shape of the upstream response.
+var strictMatchHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+
+// Options configures recording and replay.
+type Options struct {
+	// Match selects the replay matching mode. Defaults to MatchStrict.
+	Match MatchMode
+	// ScrubHeaders lists headers removed from saved requests and responses.
+	// Defaults to DefaultScrubHeaders.
+	ScrubHeaders []string
+}
+
+// withDefaults fills in unset options.
+func (o Options) withDefaults() Options {
+	if o.Match == "" {
+		o.Match = MatchStrict
+	}
+	if o.ScrubHeaders == nil {
+		o.ScrubHeaders = DefaultScrubHeaders
+	}
+	return o
+}
+
+// Interaction is a single recorded request/response pair.
+type Interaction struct {
+	Request    RecordedRequest  `json:"request"`
+	Response   RecordedResponse `json:"response"`
+	RecordedAt time.Time        `json:"recorded_at"`
+	Duration   time.Duration    `json:"duration"`
+}
+
+// RecordedRequest is the saved form of an outgoing request.
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// RecordedResponse is the saved form of an upstream response. The body is
+// stored exactly as received (still compressed if the server compressed it)
+// and is base64-encoded in the JSON file.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Body       []byte      `json:"body"`
+}
+
+// Transport returns a RoundTripper for the given mode, or base unchanged in ModeOff.
+// base is used for network access in record mode and defaults to http.DefaultTransport.
+func Transport(mode Mode, dir string, base http.RoundTripper, opts Options) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	switch mode {
+	case "", ModeOff:
+		return base, nil
+	case ModeRecord:
+		return NewRecorder(dir, base, opts)
+	case ModeReplay:
+		return NewReplayer(dir, opts)
+	}
+	return nil, fmt.Errorf("invalid cassette mode %q: must be off, record or replay", mode)
+}
+
+// matchKey returns the key used to group interactions for the given match mode.
+func matchKey(method, rawURL string, match MatchMode) string {
+	if match != MatchLenient {
+		return method + " " + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return method + " " + rawURL
+	}
+
+	// url.Values.Encode sorts parameters by key
+	return method + " " + parsed.Path + "?" + parsed.Query().Encode()
+}
+
+// fileKey returns a short, filesystem-safe name for a request.
+func fileKey(method, rawURL string) string {
+	sum := sha256.Sum256([]byte(method + " " + rawURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+// scrubHeaders returns a copy of headers without the scrubbed names.
+func scrubHeaders(headers http.Header, scrub []string) http.Header {
+	cleaned := headers.Clone()
+	if cleaned == nil {
+		cleaned = http.Header{}
+	}
+	for _, name := range scrub {
+		cleaned.Del(name)
+	}
+	return cleaned
+}
+
+// loadInteractions reads every interaction in a cassette directory,
+// ordered by file name so that per-request sequence numbers are respected.
+func loadInteractions(dir string) ([]Interaction, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cassette %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	interactions := make([]Interaction, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette file %s: %w", path, err)
+		}
+
+		var interaction Interaction
+		if err := json.Unmarshal(data, &interaction); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette file %s: %w", path, err)
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions, nil
+}
+
+// headersMatch reports whether the strict-mode headers of two requests are equal.
+func headersMatch(recorded, actual http.Header) bool {
+	for _, name := range strictMatchHeaders {
+		if strings.Join(recorded.Values(name), ",") != strings.Join(actual.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package cassette_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"bonpreu-go/pkg/cassette"
+	"bonpreu-go/pkg/fakeserver"
+)
+
+// get performs a GET request through the transport with the given headers.
+func get(t *testing.T, transport http.RoundTripper, url string, headers map[string]string) (*http.Response, []byte, error) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body, nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	server := fakeserver.NewWithFixtures()
+	server.SetFault(90001, fakeserver.Fault{Encoding: "gzip"})
+	server.SetFault(90002, fakeserver.Fault{Status: http.StatusTooManyRequests, RetryAfter: "3", Times: 1})
+
+	productURL := server.URL + fakeserver.ProductPath + "?retailerProductId="
+	headers := map[string]string{"Accept-Encoding": "gzip", "Cookie": "session=secret"}
+
+	recorder, err := cassette.NewRecorder(dir, http.DefaultTransport, cassette.Options{})
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	_, recordedGzip, err := get(t, recorder, productURL+"90001", headers)
+	if err != nil {
+		t.Fatalf("recording 90001: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := get(t, recorder, productURL+"90002", headers); err != nil {
+			t.Fatalf("recording 90002: %v", err)
+		}
+	}
+
+	// Replay must work without the upstream server
+	server.Close()
+
+	replayer, err := cassette.NewReplayer(dir, cassette.Options{Match: cassette.MatchStrict})
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	if replayer.Len() != 3 {
+		t.Fatalf("loaded %d interactions, want 3", replayer.Len())
+	}
+
+	resp, body, err := get(t, replayer, productURL+"90001", headers)
+	if err != nil {
+		t.Fatalf("replaying 90001: %v", err)
+	}
+	if string(body) != string(recordedGzip) {
+		t.Error("replayed body differs from the recorded bytes")
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	if resp.Header.Get("Date") != "" {
+		t.Error("volatile Date header was not scrubbed")
+	}
+
+	// Repeated requests are served in recorded order
+	resp, _, err = get(t, replayer, productURL+"90002", headers)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "3" {
+		t.Fatalf("first replay of 90002 = %v, %v; want 429 with Retry-After", resp, err)
+	}
+	resp, _, err = get(t, replayer, productURL+"90002", headers)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("second replay of 90002 = %v, %v; want 200", resp, err)
+	}
+	if _, _, err = get(t, replayer, productURL+"90002", headers); !errors.Is(err, cassette.ErrNoMatch) {
+		t.Errorf("third replay of 90002 error = %v, want ErrNoMatch", err)
+	}
+
+	// Strict matching rejects different headers, lenient matching ignores them
+	if _, _, err := get(t, replayer, productURL+"90001", nil); !errors.Is(err, cassette.ErrNoMatch) {
+		t.Errorf("strict replay with different headers error = %v, want ErrNoMatch", err)
+	}
+
+	lenient, err := cassette.NewReplayer(dir, cassette.Options{Match: cassette.MatchLenient})
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	otherHost := "http://example.invalid" + fakeserver.ProductPath + "?retailerProductId=90001"
+	for i := 0; i < 2; i++ {
+		if _, _, err := get(t, lenient, otherHost, nil); err != nil {
+			t.Errorf("lenient replay %d: %v", i, err)
+		}
+	}
+}
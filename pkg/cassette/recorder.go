@@ -0,0 +1,112 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Recorder is an http.RoundTripper that forwards requests to the network and
+// saves every request/response pair to a cassette directory.
+type Recorder struct {
+	dir       string
+	transport http.RoundTripper
+	opts      Options
+
+	mu       sync.Mutex
+	sequence map[string]int
+}
+
+// NewRecorder creates a Recorder writing to dir, creating the directory if needed.
+func NewRecorder(dir string, transport http.RoundTripper, opts Options) (*Recorder, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("cassette directory is required for recording")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory %s: %w", dir, err)
+	}
+
+	return &Recorder{
+		dir:       dir,
+		transport: transport,
+		opts:      opts.withDefaults(),
+		sequence:  make(map[string]int),
+	}, nil
+}
+
+// RoundTrip performs the request and records the interaction. The response body
+// is read fully so it can be saved, then handed back to the caller unchanged.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body for recording: %w", err)
+		}
+		requestBody = body
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	start := time.Now()
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body for recording: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: scrubHeaders(req.Header, r.opts.ScrubHeaders),
+			Body:    requestBody,
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    scrubHeaders(resp.Header, r.opts.ScrubHeaders),
+			Body:       responseBody,
+		},
+		RecordedAt: start.UTC(),
+		Duration:   time.Since(start),
+	}
+
+	if err := r.save(interaction); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// save writes an interaction to its own file, named after the request and
+// a per-request sequence number so that retries are replayed in order.
+func (r *Recorder) save(interaction Interaction) error {
+	key := fileKey(interaction.Request.Method, interaction.Request.URL)
+
+	r.mu.Lock()
+	seq := r.sequence[key]
+	r.sequence[key]++
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode interaction: %w", err)
+	}
+
+	path := filepath.Join(r.dir, fmt.Sprintf("%s_%04d.json", key, seq))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package cassette
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ErrNoMatch is returned when a replayed request has no matching recording.
+var ErrNoMatch = errors.New("no matching cassette interaction")
+
+// Replayer is an http.RoundTripper that serves recorded interactions
+// without any network access.
+type Replayer struct {
+	opts Options
+
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+	served       map[string]int
+}
+
+// NewReplayer loads every interaction in dir for replay.
+func NewReplayer(dir string, opts Options) (*Replayer, error) {
+	opts = opts.withDefaults()
+
+	loaded, err := loadInteractions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	interactions := make(map[string][]Interaction)
+	for _, interaction := range loaded {
+		key := matchKey(interaction.Request.Method, interaction.Request.URL, opts.Match)
+		interactions[key] = append(interactions[key], interaction)
+	}
+
+	return &Replayer{
+		opts:         opts,
+		interactions: interactions,
+		served:       make(map[string]int),
+	}, nil
+}
+
+// Len returns the number of loaded interactions.
+func (r *Replayer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, interactions := range r.interactions {
+		count += len(interactions)
+	}
+	return count
+}
+
+// RoundTrip serves the recorded response matching the request.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	interaction, err := r.next(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := interaction.Response
+	headers := recorded.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(recorded.StatusCode) + " " + http.StatusText(recorded.StatusCode),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		Body:          io.NopCloser(bytes.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// next picks the interaction to serve for a request according to the match mode.
+func (r *Replayer) next(req *http.Request) (Interaction, error) {
+	key := matchKey(req.Method, req.URL.String(), r.opts.Match)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	candidates := r.interactions[key]
+	if len(candidates) == 0 {
+		return Interaction{}, fmt.Errorf("%w: %s %s", ErrNoMatch, req.Method, req.URL)
+	}
+
+	if r.opts.Match == MatchLenient {
+		index := r.served[key]
+		if index >= len(candidates) {
+			index = len(candidates) - 1
+		}
+		r.served[key]++
+		return candidates[index], nil
+	}
+
+	index := r.served[key]
+	if index >= len(candidates) {
+		return Interaction{}, fmt.Errorf("%w: %s %s (all %d recordings already served)", ErrNoMatch, req.Method, req.URL, len(candidates))
+	}
+	interaction := candidates[index]
+	if !headersMatch(interaction.Request.Headers, req.Header) {
+		return Interaction{}, fmt.Errorf("%w: %s %s (request headers differ from recording)", ErrNoMatch, req.Method, req.URL)
+	}
+	r.served[key]++
+	return interaction, nil
+}
@@ -1,97 +1,329 @@
 package config
 
 import (
-	"os"
-	"strconv"
+	"fmt"
+	"net/url"
+	"sort"
 	"time"
+
+	"bonpreu-go/pkg/models"
 )
 
 // Configuration holds all application configuration settings.
-// It includes settings for sitemap URL, request rate limiting,
+// It includes settings for the crawled retailers, request rate limiting,
 // HTTP client configuration, and database connection details.
+// SitemapURL is the Bonpreu sitemap; other retailers have their own section.
+//
+// Values are resolved with the precedence profile < config file < environment < flags;
+// see Load.
 type Configuration struct {
-	SitemapURL      string
-	RequestDuration time.Duration
-	HTTPClient      HTTPClientConfig
-	Database        DatabaseConfig
+	Profile         string           `yaml:"profile" toml:"profile"`
+	Retailers       []string         `yaml:"retailers" toml:"retailers"`
+	SitemapURL      string           `yaml:"sitemap_url" toml:"sitemap_url"`
+	Esclat          RetailerConfig   `yaml:"esclat" toml:"esclat"`
+	RequestDuration time.Duration    `yaml:"request_duration" toml:"request_duration"`
+	HTTPClient      HTTPClientConfig `yaml:"http_client" toml:"http_client"`
+	Database        DatabaseConfig   `yaml:"database" toml:"database"`
+	Metrics         MetricsConfig    `yaml:"metrics" toml:"metrics"`
+	Logging         LoggingConfig    `yaml:"logging" toml:"logging"`
+	Webhooks        WebhookConfig    `yaml:"webhooks" toml:"webhooks"`
+	Email           EmailConfig      `yaml:"email" toml:"email"`
+	Daemon          DaemonConfig     `yaml:"daemon" toml:"daemon"`
+	Queue           QueueConfig      `yaml:"queue" toml:"queue"`
+	Images          ImagesConfig     `yaml:"images" toml:"images"`
+
+	// FailureRateThreshold is the share of failed products, between 0 and 1,
+	// above which a crawl is reported as failed. Products that no longer
+	// exist (404) do not count as failures.
+	FailureRateThreshold float64 `yaml:"failure_rate_threshold" toml:"failure_rate_threshold"`
+}
+
+// RetailerConfig holds the endpoints of a retailer. SitemapURL lists its
+// products; APIBaseURL serves them and defaults to the retailer's public API
+// when empty.
+type RetailerConfig struct {
+	SitemapURL string `yaml:"sitemap_url" toml:"sitemap_url"`
+	APIBaseURL string `yaml:"api_base_url" toml:"api_base_url"`
 }
 
 // HTTPClientConfig holds HTTP client configuration settings.
+// The cassette settings record upstream responses to, or replay them from,
+// CassetteDir; CassetteMode is one of off, record or replay and CassetteMatch
+// is strict or lenient.
 type HTTPClientConfig struct {
-	Timeout int // Timeout in seconds
+	Timeout       int    `yaml:"timeout_seconds" toml:"timeout_seconds"` // Timeout in seconds
+	CassetteMode  string `yaml:"cassette_mode" toml:"cassette_mode"`
+	CassetteDir   string `yaml:"cassette_dir" toml:"cassette_dir"`
+	CassetteMatch string `yaml:"cassette_match" toml:"cassette_match"`
 }
 
 // DatabaseConfig holds database connection configuration.
+// URL, when set, is a full postgres:// connection URL (such as the one Neon
+// hands out) and takes precedence over the individual connection fields.
+// PasswordFile reads the password from a file, e.g. a mounted secret.
+// The pool settings map to the database/sql pool, ConnectRetries retries the
+// initial ping with exponential backoff for cold-starting serverless Postgres,
+// and StatementTimeout aborts statements that run longer (zero disables it).
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
-}
-
-// getEnvWithDefault retrieves an environment variable value or returns a default.
-// It checks if the environment variable exists and is not empty,
-// returning the environment value if present, otherwise the default value.
-func getEnvWithDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+	URL               string        `yaml:"url" toml:"url"`
+	Host              string        `yaml:"host" toml:"host"`
+	Port              int           `yaml:"port" toml:"port"`
+	User              string        `yaml:"user" toml:"user"`
+	Password          string        `yaml:"password" toml:"password"`
+	PasswordFile      string        `yaml:"password_file" toml:"password_file"`
+	DBName            string        `yaml:"name" toml:"name"`
+	SSLMode           string        `yaml:"ssl_mode" toml:"ssl_mode"`
+	MaxOpenConns      int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns      int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime   time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnectRetries    int           `yaml:"connect_retries" toml:"connect_retries"`
+	ConnectRetryDelay time.Duration `yaml:"connect_retry_delay" toml:"connect_retry_delay"`
+	StatementTimeout  time.Duration `yaml:"statement_timeout" toml:"statement_timeout"`
 }
 
-// getEnvIntWithDefault retrieves an environment variable as an integer or returns a default.
-// It attempts to parse the environment variable as an integer,
-// returning the parsed value if successful, otherwise the default value.
-func getEnvIntWithDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
+// MetricsConfig holds Prometheus metrics settings.
+// ListenAddr enables the /metrics endpoint (e.g. ":9090") and PushURL enables
+// pushing to a Pushgateway-compatible endpoint at the end of a one-shot run.
+// Both are disabled when empty.
+type MetricsConfig struct {
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	PushURL    string `yaml:"push_url" toml:"push_url"`
+	JobName    string `yaml:"job_name" toml:"job_name"`
+}
+
+// LoggingConfig holds logging settings.
+// ComponentLevels overrides the level per component, e.g. "ProductService=debug".
+type LoggingConfig struct {
+	Level           string `yaml:"level" toml:"level"`
+	Format          string `yaml:"format" toml:"format"`
+	ComponentLevels string `yaml:"component_levels" toml:"component_levels"`
+}
+
+// WebhookConfig holds the settings of the watchlist webhooks.
+// URL enables notifications (disabled when empty); payloads are signed with
+// an HMAC-SHA256 of Secret. Failed deliveries are retried MaxRetries times,
+// starting after RetryDelay and doubling, before going to the dead-letter log.
+type WebhookConfig struct {
+	URL        string        `yaml:"url" toml:"url"`
+	Secret     string        `yaml:"secret" toml:"secret"`
+	Timeout    time.Duration `yaml:"timeout" toml:"timeout"`
+	MaxRetries int           `yaml:"max_retries" toml:"max_retries"`
+	RetryDelay time.Duration `yaml:"retry_delay" toml:"retry_delay"`
+}
+
+// EmailConfig holds the settings of the daily email digest.
+// SMTPHost enables the digest (disabled when empty). Username and Password
+// are only used when the server offers authentication; STARTTLS is used when
+// offered. DigestLimit caps the number of price increases and decreases listed.
+type EmailConfig struct {
+	SMTPHost    string   `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort    int      `yaml:"smtp_port" toml:"smtp_port"`
+	Username    string   `yaml:"username" toml:"username"`
+	Password    string   `yaml:"password" toml:"password"`
+	From        string   `yaml:"from" toml:"from"`
+	To          []string `yaml:"to" toml:"to"`
+	DigestLimit int      `yaml:"digest_limit" toml:"digest_limit"`
 }
 
-// DefaultConfig returns the default configuration for production use.
-// This configuration includes rate limiting to be respectful to servers,
-// with requests spread over the duration specified in REQUEST_DURATION_MINUTES.
-// Database settings are loaded from environment variables with sensible defaults.
-func DefaultConfig() *Configuration {
+// DaemonConfig holds the settings of the daemon command.
+// The schedules are five-field cron expressions or macros such as @daily,
+// evaluated in Timezone; an empty schedule disables the job. Each run starts
+// up to Jitter after its scheduled time. ListenAddr serves /healthz, /status,
+// /metrics, /nutriscore and /categories.
+type DaemonConfig struct {
+	ListenAddr          string        `yaml:"listen_addr" toml:"listen_addr"`
+	Timezone            string        `yaml:"timezone" toml:"timezone"`
+	FullCrawlSchedule   string        `yaml:"full_crawl_schedule" toml:"full_crawl_schedule"`
+	IncrementalSchedule string        `yaml:"incremental_schedule" toml:"incremental_schedule"`
+	ReportSchedule      string        `yaml:"report_schedule" toml:"report_schedule"`
+	ImageSchedule       string        `yaml:"image_schedule" toml:"image_schedule"`
+	Jitter              time.Duration `yaml:"jitter" toml:"jitter"`
+}
+
+// QueueConfig holds the settings of the distributed crawl workers.
+// A worker claims BatchSize jobs at a time and holds them for Lease, renewed
+// every HeartbeatInterval; jobs of a worker that stops renewing are reassigned
+// once the lease expires, up to MaxAttempts attempts. RequestsPerSecond limits
+// each worker's request rate (zero disables it), and an idle worker polls the
+// queue every PollInterval.
+type QueueConfig struct {
+	BatchSize         int           `yaml:"batch_size" toml:"batch_size"`
+	Lease             time.Duration `yaml:"lease" toml:"lease"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" toml:"heartbeat_interval"`
+	PollInterval      time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	MaxAttempts       int           `yaml:"max_attempts" toml:"max_attempts"`
+	RequestsPerSecond float64       `yaml:"requests_per_second" toml:"requests_per_second"`
+}
+
+// ImagesConfig holds the settings of the image downloader, which mirrors
+// product images to Dir, named after the hash of their content.
+// RequestsPerSecond limits the download rate independently of the crawl
+// (zero disables it), and MaxBytes caps the size of a single image.
+type ImagesConfig struct {
+	Dir               string        `yaml:"dir" toml:"dir"`
+	RequestsPerSecond float64       `yaml:"requests_per_second" toml:"requests_per_second"`
+	Timeout           time.Duration `yaml:"timeout" toml:"timeout"`
+	MaxBytes          int           `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// Names of the built-in configuration profiles.
+const (
+	ProfileProduction = "production"
+	ProfileTesting    = "testing"
+	ProfileLocal      = "local"
+)
+
+// redacted replaces secrets when printing the configuration.
+const redacted = "[REDACTED]"
+
+// profiles holds the built-in profiles. Each returns a fresh configuration
+// that the config file, environment and flags are then layered on top of.
+var profiles = map[string]func() *Configuration{
+	// production spreads requests over a minute to be respectful to servers
+	// and connects to a TLS-enabled database such as Neon.
+	ProfileProduction: func() *Configuration {
+		return baseConfig(ProfileProduction)
+	},
+	// testing disables rate limiting for faster processing; everything else
+	// matches production.
+	ProfileTesting: func() *Configuration {
+		cfg := baseConfig(ProfileTesting)
+		cfg.RequestDuration = 0
+		cfg.Queue.RequestsPerSecond = 0
+		cfg.Images.RequestsPerSecond = 0
+		return cfg
+	},
+	// local targets a Postgres on the developer's machine without TLS,
+	// with no rate limiting and debug logging.
+	ProfileLocal: func() *Configuration {
+		cfg := baseConfig(ProfileLocal)
+		cfg.RequestDuration = 0
+		cfg.Queue.RequestsPerSecond = 0
+		cfg.Images.RequestsPerSecond = 0
+		cfg.Database.User = "postgres"
+		cfg.Database.SSLMode = "disable"
+		cfg.Database.ConnectRetries = 0
+		cfg.Logging.Level = "debug"
+		return cfg
+	},
+}
+
+// baseConfig returns the settings shared by every profile.
+func baseConfig(profile string) *Configuration {
 	return &Configuration{
-		SitemapURL:      getEnvWithDefault("SITEMAP_URL", "https://www.compraonline.bonpreuesclat.cat/sitemaps/sitemap-products-part1.xml"),
-		RequestDuration: time.Duration(getEnvIntWithDefault("REQUEST_DURATION_MINUTES", 1)) * time.Minute,
+		Profile:              profile,
+		Retailers:            []string{models.DefaultRetailer},
+		SitemapURL:           "https://www.compraonline.bonpreuesclat.cat/sitemaps/sitemap-products-part1.xml",
+		RequestDuration:      1 * time.Minute,
+		FailureRateThreshold: 0.1,
 		HTTPClient: HTTPClientConfig{
-			Timeout: getEnvIntWithDefault("HTTP_TIMEOUT_SECONDS", 30),
+			Timeout:       30,
+			CassetteMode:  "off",
+			CassetteDir:   "cassettes",
+			CassetteMatch: "strict",
 		},
 		Database: DatabaseConfig{
-			Host:     getEnvWithDefault("DB_HOST", "localhost"),
-			Port:     getEnvIntWithDefault("DB_PORT", 5432),
-			User:     getEnvWithDefault("DB_USER", ""),
-			Password: getEnvWithDefault("DB_PASSWORD", ""),
-			DBName:   getEnvWithDefault("DB_NAME", "bonpreu_db"),
-			SSLMode:  getEnvWithDefault("DB_SSL_MODE", "require"),
+			Host:              "localhost",
+			Port:              5432,
+			DBName:            "bonpreu_db",
+			SSLMode:           "require",
+			MaxOpenConns:      10,
+			MaxIdleConns:      5,
+			ConnMaxLifetime:   30 * time.Minute,
+			ConnectRetries:    5,
+			ConnectRetryDelay: 2 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			JobName: "bonpreu_crawl",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Webhooks: WebhookConfig{
+			Timeout:    10 * time.Second,
+			MaxRetries: 3,
+			RetryDelay: 2 * time.Second,
+		},
+		Email: EmailConfig{
+			SMTPPort:    587,
+			DigestLimit: 10,
+		},
+		Daemon: DaemonConfig{
+			ListenAddr:          ":8080",
+			Timezone:            "UTC",
+			FullCrawlSchedule:   "0 0 * * *",
+			IncrementalSchedule: "0 6-22/4 * * *",
+			ReportSchedule:      "0 7 * * *",
+			Jitter:              5 * time.Minute,
+		},
+		Queue: QueueConfig{
+			BatchSize:         50,
+			Lease:             2 * time.Minute,
+			HeartbeatInterval: 30 * time.Second,
+			PollInterval:      5 * time.Second,
+			MaxAttempts:       3,
+			RequestsPerSecond: 5,
+		},
+		Images: ImagesConfig{
+			Dir:               "images",
+			RequestsPerSecond: 2,
+			Timeout:           30 * time.Second,
+			MaxBytes:          10 << 20,
 		},
 	}
 }
 
-// TestingConfig returns a configuration optimized for testing and development.
-// This configuration disables rate limiting (RequestDuration = 0) to allow
-// for faster processing during testing. All other settings are identical to DefaultConfig.
-func TestingConfig() *Configuration {
-	return &Configuration{
-		SitemapURL:      getEnvWithDefault("SITEMAP_URL", "https://www.compraonline.bonpreuesclat.cat/sitemaps/sitemap-products-part1.xml"),
-		RequestDuration: 0, // No rate limiting for testing
-		HTTPClient: HTTPClientConfig{
-			Timeout: getEnvIntWithDefault("HTTP_TIMEOUT_SECONDS", 30),
-		},
-		Database: DatabaseConfig{
-			Host:     getEnvWithDefault("DB_HOST", "localhost"),
-			Port:     getEnvIntWithDefault("DB_PORT", 5432),
-			User:     getEnvWithDefault("DB_USER", ""),
-			Password: getEnvWithDefault("DB_PASSWORD", ""),
-			DBName:   getEnvWithDefault("DB_NAME", "bonpreu_db"),
-			SSLMode:  getEnvWithDefault("DB_SSL_MODE", "require"),
-		},
+// ProfileConfig returns the built-in defaults for the named profile.
+func ProfileConfig(name string) (*Configuration, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q: must be one of %v", name, ProfileNames())
+	}
+	return profile(), nil
+}
+
+// ProfileNames returns the names of the built-in profiles in sorted order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Redacted returns a copy of the configuration with secrets replaced,
+// suitable for printing or logging.
+func (c *Configuration) Redacted() *Configuration {
+	clone := *c
+	if clone.Database.Password != "" {
+		clone.Database.Password = redacted
+	}
+	if clone.Email.Password != "" {
+		clone.Email.Password = redacted
+	}
+	if clone.Webhooks.Secret != "" {
+		clone.Webhooks.Secret = redacted
+	}
+	if clone.Database.URL != "" {
+		if parsed, err := url.Parse(clone.Database.URL); err == nil {
+			clone.Database.URL = parsed.Redacted()
+		} else {
+			clone.Database.URL = redacted
+		}
+	}
+	return &clone
+}
+
+// Retailer returns the endpoints of the named retailer.
+func (c *Configuration) Retailer(name string) RetailerConfig {
+	switch name {
+	case models.RetailerBonpreu:
+		return RetailerConfig{SitemapURL: c.SitemapURL}
+	case models.RetailerEsclat:
+		return c.Esclat
+	default:
+		return RetailerConfig{}
 	}
 }
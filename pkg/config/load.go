@@ -0,0 +1,331 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables selecting the profile and config file.
+const (
+	ProfileEnv    = "BONPREU_PROFILE"
+	ConfigFileEnv = "BONPREU_CONFIG"
+)
+
+// setting describes a configuration value that can be overridden from the
+// environment and from a command-line flag. The flag name is derived from
+// the environment variable, e.g. DB_HOST becomes -db-host.
+type setting struct {
+	env   string
+	usage string
+	apply func(cfg *Configuration, value string) error
+}
+
+// settings lists every environment variable and flag understood by Load.
+var settings = []setting{
+	{"RETAILERS", "comma-separated retailers to crawl: bonpreu, esclat", listSetting(func(c *Configuration) *[]string { return &c.Retailers })},
+	{"SITEMAP_URL", "Bonpreu sitemap URL", stringSetting(func(c *Configuration) *string { return &c.SitemapURL })},
+	{"ESCLAT_SITEMAP_URL", "Esclat sitemap URL, required when esclat is crawled", stringSetting(func(c *Configuration) *string { return &c.Esclat.SitemapURL })},
+	{"ESCLAT_API_BASE_URL", "Esclat product API base URL, required when esclat is crawled", stringSetting(func(c *Configuration) *string { return &c.Esclat.APIBaseURL })},
+	{"REQUEST_DURATION_MINUTES", "spread requests over this many minutes (0 disables rate limiting)", minutesSetting(func(c *Configuration) *time.Duration { return &c.RequestDuration })},
+	{"FAILURE_RATE_THRESHOLD", "share of failed products (0-1) above which the crawl fails", floatSetting(func(c *Configuration) *float64 { return &c.FailureRateThreshold })},
+	{"HTTP_TIMEOUT_SECONDS", "HTTP client timeout in seconds", intSetting(func(c *Configuration) *int { return &c.HTTPClient.Timeout })},
+	{"HTTP_CASSETTE_MODE", "record or replay upstream responses: off, record or replay", stringSetting(func(c *Configuration) *string { return &c.HTTPClient.CassetteMode })},
+	{"HTTP_CASSETTE_DIR", "directory holding HTTP cassettes", stringSetting(func(c *Configuration) *string { return &c.HTTPClient.CassetteDir })},
+	{"HTTP_CASSETTE_MATCH", "cassette replay matching: strict or lenient", stringSetting(func(c *Configuration) *string { return &c.HTTPClient.CassetteMatch })},
+	{"DATABASE_URL", "full postgres:// connection URL, overriding the DB_* connection settings", stringSetting(func(c *Configuration) *string { return &c.Database.URL })},
+	{"DB_HOST", "database host", stringSetting(func(c *Configuration) *string { return &c.Database.Host })},
+	{"DB_PORT", "database port", intSetting(func(c *Configuration) *int { return &c.Database.Port })},
+	{"DB_USER", "database user", stringSetting(func(c *Configuration) *string { return &c.Database.User })},
+	{"DB_PASSWORD", "database password", stringSetting(func(c *Configuration) *string { return &c.Database.Password })},
+	{"DB_PASSWORD_FILE", "file containing the database password", stringSetting(func(c *Configuration) *string { return &c.Database.PasswordFile })},
+	{"DB_NAME", "database name", stringSetting(func(c *Configuration) *string { return &c.Database.DBName })},
+	{"DB_SSL_MODE", "database SSL mode", stringSetting(func(c *Configuration) *string { return &c.Database.SSLMode })},
+	{"DB_MAX_OPEN_CONNS", "maximum open database connections (0 is unlimited)", intSetting(func(c *Configuration) *int { return &c.Database.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "maximum idle database connections", intSetting(func(c *Configuration) *int { return &c.Database.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME_SECONDS", "maximum lifetime of a database connection in seconds (0 is unlimited)", secondsSetting(func(c *Configuration) *time.Duration { return &c.Database.ConnMaxLifetime })},
+	{"DB_CONNECT_RETRIES", "times to retry the initial database ping", intSetting(func(c *Configuration) *int { return &c.Database.ConnectRetries })},
+	{"DB_CONNECT_RETRY_DELAY_SECONDS", "initial delay between database ping retries in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Database.ConnectRetryDelay })},
+	{"DB_STATEMENT_TIMEOUT_SECONDS", "abort statements running longer than this many seconds (0 disables)", secondsSetting(func(c *Configuration) *time.Duration { return &c.Database.StatementTimeout })},
+	{"METRICS_LISTEN_ADDR", "address for the /metrics endpoint (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Metrics.ListenAddr })},
+	{"METRICS_PUSH_URL", "Pushgateway URL to push metrics to (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Metrics.PushURL })},
+	{"METRICS_JOB_NAME", "job name used when pushing metrics", stringSetting(func(c *Configuration) *string { return &c.Metrics.JobName })},
+	{"LOG_LEVEL", "minimum log level: debug, info, warn or error", stringSetting(func(c *Configuration) *string { return &c.Logging.Level })},
+	{"LOG_FORMAT", "log format: text or json", stringSetting(func(c *Configuration) *string { return &c.Logging.Format })},
+	{"WEBHOOK_URL", "URL receiving watchlist notifications (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Webhooks.URL })},
+	{"WEBHOOK_SECRET", "secret used to sign webhook payloads", stringSetting(func(c *Configuration) *string { return &c.Webhooks.Secret })},
+	{"WEBHOOK_TIMEOUT_SECONDS", "webhook request timeout in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Webhooks.Timeout })},
+	{"WEBHOOK_MAX_RETRIES", "times to retry a failed webhook delivery", intSetting(func(c *Configuration) *int { return &c.Webhooks.MaxRetries })},
+	{"WEBHOOK_RETRY_DELAY_SECONDS", "initial delay between webhook retries in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Webhooks.RetryDelay })},
+	{"SMTP_HOST", "SMTP server for the daily email digest (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Email.SMTPHost })},
+	{"SMTP_PORT", "SMTP server port", intSetting(func(c *Configuration) *int { return &c.Email.SMTPPort })},
+	{"SMTP_USERNAME", "SMTP username", stringSetting(func(c *Configuration) *string { return &c.Email.Username })},
+	{"SMTP_PASSWORD", "SMTP password", stringSetting(func(c *Configuration) *string { return &c.Email.Password })},
+	{"EMAIL_FROM", "sender address of the email digest", stringSetting(func(c *Configuration) *string { return &c.Email.From })},
+	{"EMAIL_TO", "comma-separated recipients of the email digest", listSetting(func(c *Configuration) *[]string { return &c.Email.To })},
+	{"EMAIL_DIGEST_LIMIT", "price increases and decreases listed in the email digest", intSetting(func(c *Configuration) *int { return &c.Email.DigestLimit })},
+	{"DAEMON_LISTEN_ADDR", "address of the daemon's /healthz, /status, /metrics, /nutriscore and /categories endpoints", stringSetting(func(c *Configuration) *string { return &c.Daemon.ListenAddr })},
+	{"DAEMON_TIMEZONE", "time zone of the daemon schedules, e.g. Europe/Madrid", stringSetting(func(c *Configuration) *string { return &c.Daemon.Timezone })},
+	{"DAEMON_FULL_CRAWL_SCHEDULE", "cron schedule of the daemon's full crawl (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Daemon.FullCrawlSchedule })},
+	{"DAEMON_INCREMENTAL_SCHEDULE", "cron schedule of the daemon's incremental crawl (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Daemon.IncrementalSchedule })},
+	{"DAEMON_REPORT_SCHEDULE", "cron schedule of the daemon's report jobs (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Daemon.ReportSchedule })},
+	{"DAEMON_IMAGE_SCHEDULE", "cron schedule of the daemon's image downloads (disabled when empty)", stringSetting(func(c *Configuration) *string { return &c.Daemon.ImageSchedule })},
+	{"DAEMON_JITTER_SECONDS", "maximum random delay added to each daemon run in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Daemon.Jitter })},
+	{"QUEUE_BATCH_SIZE", "crawl jobs a queue worker claims at a time", intSetting(func(c *Configuration) *int { return &c.Queue.BatchSize })},
+	{"QUEUE_LEASE_SECONDS", "seconds a queue worker holds its jobs without a heartbeat", secondsSetting(func(c *Configuration) *time.Duration { return &c.Queue.Lease })},
+	{"QUEUE_HEARTBEAT_SECONDS", "interval between queue worker heartbeats in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Queue.HeartbeatInterval })},
+	{"QUEUE_POLL_SECONDS", "interval at which an idle queue worker polls for jobs in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Queue.PollInterval })},
+	{"QUEUE_MAX_ATTEMPTS", "times a crawl job is attempted before it fails", intSetting(func(c *Configuration) *int { return &c.Queue.MaxAttempts })},
+	{"QUEUE_REQUESTS_PER_SECOND", "request rate of each queue worker (0 disables rate limiting)", floatSetting(func(c *Configuration) *float64 { return &c.Queue.RequestsPerSecond })},
+	{"IMAGES_DIR", "directory the image downloader mirrors product images to", stringSetting(func(c *Configuration) *string { return &c.Images.Dir })},
+	{"IMAGES_REQUESTS_PER_SECOND", "request rate of the image downloader (0 disables rate limiting)", floatSetting(func(c *Configuration) *float64 { return &c.Images.RequestsPerSecond })},
+	{"IMAGES_TIMEOUT_SECONDS", "image download timeout in seconds", secondsSetting(func(c *Configuration) *time.Duration { return &c.Images.Timeout })},
+	{"IMAGES_MAX_BYTES", "largest image downloaded, in bytes", intSetting(func(c *Configuration) *int { return &c.Images.MaxBytes })},
+	{"LOG_COMPONENT_LEVELS", "per-component log levels, e.g. ProductService=debug", stringSetting(func(c *Configuration) *string { return &c.Logging.ComponentLevels })},
+}
+
+// stringSetting applies a value to a string field.
+func stringSetting(field func(*Configuration) *string) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+// intSetting applies a value to an integer field, rejecting malformed numbers.
+func intSetting(field func(*Configuration) *int) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		intValue, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(cfg) = intValue
+		return nil
+	}
+}
+
+// floatSetting applies a value to a floating-point field, rejecting malformed numbers.
+func floatSetting(field func(*Configuration) *float64) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(cfg) = floatValue
+		return nil
+	}
+}
+
+// listSetting applies a comma-separated value to a string list field.
+// Empty items are dropped.
+func listSetting(field func(*Configuration) *[]string) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(cfg) = items
+		return nil
+	}
+}
+
+// minutesSetting applies a whole number of minutes to a duration field.
+func minutesSetting(field func(*Configuration) *time.Duration) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		minutes, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number of minutes", value)
+		}
+		*field(cfg) = time.Duration(minutes) * time.Minute
+		return nil
+	}
+}
+
+// secondsSetting applies a whole number of seconds to a duration field.
+func secondsSetting(field func(*Configuration) *time.Duration) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number of seconds", value)
+		}
+		*field(cfg) = time.Duration(seconds) * time.Second
+		return nil
+	}
+}
+
+// flagName derives a flag name from an environment variable name.
+func flagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
+
+// Flags holds the command-line flags bound to configuration settings.
+type Flags struct {
+	fs      *flag.FlagSet
+	profile string
+	file    string
+	values  map[string]*string
+}
+
+// RegisterFlags registers -profile, -config and one flag per setting on fs.
+// Only flags explicitly given on the command line override other sources.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{fs: fs, values: make(map[string]*string)}
+
+	fs.StringVar(&flags.profile, "profile", "", fmt.Sprintf("configuration profile %v (env %s)", ProfileNames(), ProfileEnv))
+	fs.StringVar(&flags.file, "config", "", fmt.Sprintf("path to a YAML or TOML config file (env %s)", ConfigFileEnv))
+	for _, s := range settings {
+		flags.values[s.env] = fs.String(flagName(s.env), "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	return flags
+}
+
+// set returns the flags that were explicitly given, keyed by environment variable.
+func (f *Flags) set() map[string]string {
+	given := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) {
+		given[fl.Name] = true
+	})
+
+	values := make(map[string]string)
+	for env, value := range f.values {
+		if given[flagName(env)] {
+			values[env] = *value
+		}
+	}
+	return values
+}
+
+// Load resolves the configuration. The base is the selected profile (flag,
+// then BONPREU_PROFILE, then the config file's profile key, then production);
+// a YAML or TOML config file (flag, then BONPREU_CONFIG) is layered on top,
+// then environment variables, then explicitly given flags. The result is
+// validated, and every problem found is reported in the returned error.
+// flags may be nil when no command line is involved.
+func Load(flags *Flags) (*Configuration, error) {
+	var profile, file string
+	var flagValues map[string]string
+	if flags != nil {
+		profile, file = flags.profile, flags.file
+		flagValues = flags.set()
+	}
+	if profile == "" {
+		profile = os.Getenv(ProfileEnv)
+	}
+	if file == "" {
+		file = os.Getenv(ConfigFileEnv)
+	}
+
+	var fileData []byte
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		fileData = data
+
+		if profile == "" {
+			filePeek := struct {
+				Profile string `yaml:"profile" toml:"profile"`
+			}{}
+			if err := decodeFile(file, fileData, &filePeek, false); err != nil {
+				return nil, err
+			}
+			profile = filePeek.Profile
+		}
+	}
+	if profile == "" {
+		profile = ProfileProduction
+	}
+
+	cfg, err := ProfileConfig(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileData != nil {
+		if err := decodeFile(file, fileData, cfg, true); err != nil {
+			return nil, err
+		}
+		// The profile was already chosen; the file cannot switch it half-way
+		cfg.Profile = profile
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.apply(cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", s.env, err))
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := flagValues[s.env]; ok {
+			if err := s.apply(cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid -%s: %w", flagName(s.env), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Database.resolvePasswordFile(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolvePasswordFile reads the password from PasswordFile, if set.
+// Trailing newlines, as left by most editors and secret mounts, are removed.
+func (d *DatabaseConfig) resolvePasswordFile() error {
+	if d.PasswordFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(d.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("failed to read database password file: %w", err)
+	}
+	d.Password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// decodeFile decodes a YAML or TOML config file, chosen by extension, into out.
+// When strict is set, unknown keys are rejected.
+func decodeFile(path string, data []byte, out interface{}, strict bool) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(strict)
+		if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), out)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); strict && len(undecoded) > 0 {
+			return fmt.Errorf("failed to parse config file %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("unsupported config file %s: extension must be .yaml, .yml or .toml", path)
+	}
+	return nil
+}
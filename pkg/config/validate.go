@@ -0,0 +1,267 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/scheduler"
+	"bonpreu-go/pkg/utils"
+)
+
+// validSSLModes are the sslmode values accepted by lib/pq.
+var validSSLModes = map[string]bool{
+	"disable":     true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// Validate checks the configuration for invalid values. Every problem is
+// reported, so that a broken deployment can be fixed in one go.
+func (c *Configuration) Validate() error {
+	var errs []error
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if len(c.Retailers) == 0 {
+		invalid("retailers", "at least one retailer is required")
+	}
+	seen := make(map[string]bool, len(c.Retailers))
+	for _, retailer := range c.Retailers {
+		if !models.IsRetailer(retailer) {
+			invalid("retailers", "must be one of %v, got %q", models.RetailerNames, retailer)
+		} else if seen[retailer] {
+			invalid("retailers", "lists %s twice", retailer)
+		}
+		seen[retailer] = true
+	}
+	if err := validateHTTPURL(c.SitemapURL); err != nil {
+		invalid("sitemap_url", "%v", err)
+	}
+	if seen[models.RetailerEsclat] {
+		if c.Esclat.SitemapURL == "" {
+			invalid("esclat.sitemap_url", "is required when esclat is crawled")
+		} else if err := validateHTTPURL(c.Esclat.SitemapURL); err != nil {
+			invalid("esclat.sitemap_url", "%v", err)
+		}
+		if c.Esclat.APIBaseURL == "" {
+			invalid("esclat.api_base_url", "is required when esclat is crawled")
+		}
+	}
+	if c.Esclat.APIBaseURL != "" {
+		if err := validateHTTPURL(c.Esclat.APIBaseURL); err != nil {
+			invalid("esclat.api_base_url", "%v", err)
+		}
+	}
+	if c.RequestDuration < 0 {
+		invalid("request_duration", "must not be negative, got %v", c.RequestDuration)
+	}
+	if c.FailureRateThreshold < 0 || c.FailureRateThreshold > 1 {
+		invalid("failure_rate_threshold", "must be between 0 and 1, got %v", c.FailureRateThreshold)
+	}
+
+	if c.HTTPClient.Timeout <= 0 {
+		invalid("http_client.timeout_seconds", "must be positive, got %d", c.HTTPClient.Timeout)
+	}
+	switch c.HTTPClient.CassetteMode {
+	case "off", "record", "replay":
+	default:
+		invalid("http_client.cassette_mode", "must be off, record or replay, got %q", c.HTTPClient.CassetteMode)
+	}
+	if c.HTTPClient.CassetteMode != "off" && c.HTTPClient.CassetteDir == "" {
+		invalid("http_client.cassette_dir", "is required when cassette_mode is %s", c.HTTPClient.CassetteMode)
+	}
+	switch c.HTTPClient.CassetteMatch {
+	case "strict", "lenient":
+	default:
+		invalid("http_client.cassette_match", "must be strict or lenient, got %q", c.HTTPClient.CassetteMatch)
+	}
+
+	if c.Database.URL != "" {
+		parsed, err := url.Parse(c.Database.URL)
+		if err != nil || (parsed.Scheme != "postgres" && parsed.Scheme != "postgresql") || parsed.Host == "" {
+			invalid("database.url", "must be a postgres:// or postgresql:// URL")
+		}
+	} else {
+		if c.Database.Host == "" {
+			invalid("database.host", "is required")
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			invalid("database.port", "must be between 1 and 65535, got %d", c.Database.Port)
+		}
+		if c.Database.DBName == "" {
+			invalid("database.name", "is required")
+		}
+		if !validSSLModes[c.Database.SSLMode] {
+			invalid("database.ssl_mode", "must be disable, require, verify-ca or verify-full, got %q", c.Database.SSLMode)
+		}
+	}
+	if c.Database.Password != "" && c.Database.PasswordFile != "" {
+		invalid("database.password_file", "cannot be combined with database.password")
+	}
+	if c.Database.MaxOpenConns < 0 {
+		invalid("database.max_open_conns", "must not be negative, got %d", c.Database.MaxOpenConns)
+	}
+	if c.Database.MaxIdleConns < 0 {
+		invalid("database.max_idle_conns", "must not be negative, got %d", c.Database.MaxIdleConns)
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		invalid("database.max_idle_conns", "must not exceed max_open_conns (%d), got %d", c.Database.MaxOpenConns, c.Database.MaxIdleConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		invalid("database.conn_max_lifetime", "must not be negative, got %v", c.Database.ConnMaxLifetime)
+	}
+	if c.Database.ConnectRetries < 0 {
+		invalid("database.connect_retries", "must not be negative, got %d", c.Database.ConnectRetries)
+	}
+	if c.Database.ConnectRetryDelay < 0 {
+		invalid("database.connect_retry_delay", "must not be negative, got %v", c.Database.ConnectRetryDelay)
+	}
+	if c.Database.StatementTimeout < 0 {
+		invalid("database.statement_timeout", "must not be negative, got %v", c.Database.StatementTimeout)
+	}
+
+	if c.Metrics.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.ListenAddr); err != nil {
+			invalid("metrics.listen_addr", "must be host:port, got %q", c.Metrics.ListenAddr)
+		}
+	}
+	if c.Metrics.PushURL != "" {
+		if err := validateHTTPURL(c.Metrics.PushURL); err != nil {
+			invalid("metrics.push_url", "%v", err)
+		}
+		if c.Metrics.JobName == "" {
+			invalid("metrics.job_name", "is required when push_url is set")
+		}
+	}
+
+	if c.Webhooks.URL != "" {
+		if err := validateHTTPURL(c.Webhooks.URL); err != nil {
+			invalid("webhooks.url", "%v", err)
+		}
+		if c.Webhooks.Secret == "" {
+			invalid("webhooks.secret", "is required when url is set")
+		}
+	}
+	if c.Webhooks.Timeout <= 0 {
+		invalid("webhooks.timeout", "must be positive, got %v", c.Webhooks.Timeout)
+	}
+	if c.Webhooks.MaxRetries < 0 {
+		invalid("webhooks.max_retries", "must not be negative, got %d", c.Webhooks.MaxRetries)
+	}
+	if c.Webhooks.RetryDelay < 0 {
+		invalid("webhooks.retry_delay", "must not be negative, got %v", c.Webhooks.RetryDelay)
+	}
+
+	if c.Email.SMTPHost != "" {
+		if c.Email.SMTPPort < 1 || c.Email.SMTPPort > 65535 {
+			invalid("email.smtp_port", "must be between 1 and 65535, got %d", c.Email.SMTPPort)
+		}
+		if _, err := mail.ParseAddress(c.Email.From); err != nil {
+			invalid("email.from", "must be an email address when smtp_host is set, got %q", c.Email.From)
+		}
+		if len(c.Email.To) == 0 {
+			invalid("email.to", "is required when smtp_host is set")
+		}
+		for _, to := range c.Email.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				invalid("email.to", "invalid address %q", to)
+			}
+		}
+	}
+	if c.Email.DigestLimit < 1 {
+		invalid("email.digest_limit", "must be positive, got %d", c.Email.DigestLimit)
+	}
+
+	if c.Daemon.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Daemon.ListenAddr); err != nil {
+			invalid("daemon.listen_addr", "must be host:port, got %q", c.Daemon.ListenAddr)
+		}
+	}
+	if _, err := time.LoadLocation(c.Daemon.Timezone); err != nil {
+		invalid("daemon.timezone", "unknown time zone %q", c.Daemon.Timezone)
+	}
+	for _, schedule := range []struct{ field, spec string }{
+		{"daemon.full_crawl_schedule", c.Daemon.FullCrawlSchedule},
+		{"daemon.incremental_schedule", c.Daemon.IncrementalSchedule},
+		{"daemon.report_schedule", c.Daemon.ReportSchedule},
+		{"daemon.image_schedule", c.Daemon.ImageSchedule},
+	} {
+		if schedule.spec == "" {
+			continue
+		}
+		if _, err := scheduler.ParseCron(schedule.spec); err != nil {
+			invalid(schedule.field, "%v", err)
+		}
+	}
+	if c.Daemon.Jitter < 0 {
+		invalid("daemon.jitter", "must not be negative, got %v", c.Daemon.Jitter)
+	}
+
+	if c.Queue.BatchSize < 1 {
+		invalid("queue.batch_size", "must be positive, got %d", c.Queue.BatchSize)
+	}
+	if c.Queue.Lease <= 0 {
+		invalid("queue.lease", "must be positive, got %v", c.Queue.Lease)
+	}
+	if c.Queue.HeartbeatInterval <= 0 || c.Queue.HeartbeatInterval >= c.Queue.Lease {
+		invalid("queue.heartbeat_interval", "must be positive and shorter than the lease (%v), got %v", c.Queue.Lease, c.Queue.HeartbeatInterval)
+	}
+	if c.Queue.PollInterval <= 0 {
+		invalid("queue.poll_interval", "must be positive, got %v", c.Queue.PollInterval)
+	}
+	if c.Queue.MaxAttempts < 1 {
+		invalid("queue.max_attempts", "must be positive, got %d", c.Queue.MaxAttempts)
+	}
+	if c.Queue.RequestsPerSecond < 0 {
+		invalid("queue.requests_per_second", "must not be negative, got %v", c.Queue.RequestsPerSecond)
+	}
+
+	if c.Images.Dir == "" {
+		invalid("images.dir", "is required")
+	}
+	if c.Images.RequestsPerSecond < 0 {
+		invalid("images.requests_per_second", "must not be negative, got %v", c.Images.RequestsPerSecond)
+	}
+	if c.Images.Timeout <= 0 {
+		invalid("images.timeout", "must be positive, got %v", c.Images.Timeout)
+	}
+	if c.Images.MaxBytes < 1 {
+		invalid("images.max_bytes", "must be positive, got %d", c.Images.MaxBytes)
+	}
+
+	if _, err := utils.ParseLevel(c.Logging.Level); err != nil {
+		invalid("logging.level", "%v", err)
+	}
+	switch c.Logging.Format {
+	case "text", "json":
+	default:
+		invalid("logging.format", "must be text or json, got %q", c.Logging.Format)
+	}
+	if _, err := utils.ParseComponentLevels(c.Logging.ComponentLevels); err != nil {
+		invalid("logging.component_levels", "%v", err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// validateHTTPURL checks that value is an absolute http or https URL.
+func validateHTTPURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", value, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("must be an absolute http(s) URL, got %q", value)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+// Package fakeserver provides an in-process fake of the Bonpreu online shop
+// for offline end-to-end tests. It serves a sitemap and product JSON fixtures
+// on the same paths as the real shop and can inject faults per product:
+// 404s, 429s with Retry-After, 5xx errors, slow responses, gzip/brotli
+// encodings and malformed JSON.
+package fakeserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Paths served by the fake, matching the real Bonpreu shop.
+const (
+	SitemapPath = "/sitemaps/sitemap-products-part1.xml"
+	ProductPath = "/api/webproductpagews/v5/products/bop"
+)
+
+//go:embed fixtures/*.json
+var fixtureFS embed.FS
+
+// Fault describes an injected failure or response variation for a product.
+// A zero Fault serves the fixture unchanged.
+type Fault struct {
+	// Status, when non-zero, is returned instead of the fixture.
+	Status int
+	// RetryAfter is sent as the Retry-After header together with Status.
+	RetryAfter string
+	// Times limits the fault to the first N requests; zero means every request.
+	Times int
+	// Delay is slept before responding, to simulate slow responses.
+	Delay time.Duration
+	// Encoding compresses the fixture body: "gzip" or "br".
+	Encoding string
+	// Malformed serves a truncated, invalid JSON body with status 200.
+	Malformed bool
+}
+
+// Server is a fake Bonpreu shop backed by httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	products   map[int][]byte
+	faults     map[int]Fault
+	sitemap    []byte
+	sitemapErr int
+	requests   map[int]int
+}
+
+// New starts a fake server with no products. Close it when done.
+func New() *Server {
+	s := &Server{
+		products: make(map[int][]byte),
+		faults:   make(map[int]Fault),
+		requests: make(map[int]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(SitemapPath, s.handleSitemap)
+	mux.HandleFunc(ProductPath, s.handleProduct)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// NewWithFixtures starts a fake server preloaded with the bundled product fixtures.
+func NewWithFixtures() *Server {
+	s := New()
+	for _, id := range FixtureIDs() {
+		s.AddProduct(id, Fixture(id))
+	}
+	return s
+}
+
+// FixtureIDs returns the product IDs of the bundled fixtures in ascending order.
+func FixtureIDs() []int {
+	entries, err := fixtureFS.ReadDir("fixtures")
+	if err != nil {
+		return nil
+	}
+
+	var ids []int
+	for _, entry := range entries {
+		name := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "product_"), ".json")
+		if id, err := strconv.Atoi(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Fixture returns the bundled JSON fixture for a product ID, or nil if there is none.
+func Fixture(productID int) []byte {
+	data, err := fixtureFS.ReadFile(path.Join("fixtures", fmt.Sprintf("product_%d.json", productID)))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// SitemapURL returns the URL of the fake sitemap.
+func (s *Server) SitemapURL() string {
+	return s.URL + SitemapPath
+}
+
+// AddProduct registers the JSON body served for a product ID.
+// Registered products are listed in the generated sitemap.
+func (s *Server) AddProduct(productID int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products[productID] = body
+}
+
+// SetFault injects a fault for a product ID. The product does not need to
+// exist, which allows listing IDs in the sitemap that only ever fail.
+func (s *Server) SetFault(productID int, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[productID] = fault
+}
+
+// SetSitemap overrides the generated sitemap with a raw XML body.
+func (s *Server) SetSitemap(body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sitemap = body
+}
+
+// SetSitemapStatus makes the sitemap endpoint fail with the given status code.
+// Zero restores normal behaviour.
+func (s *Server) SetSitemapStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sitemapErr = status
+}
+
+// Requests returns how many product requests were received for a product ID.
+func (s *Server) Requests(productID int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[productID]
+}
+
+// handleSitemap serves either the configured sitemap or one generated from
+// the registered products and faults.
+func (s *Server) handleSitemap(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := s.sitemapErr
+	body := s.sitemap
+	if body == nil {
+		body = s.generateSitemapLocked()
+	}
+	s.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(body)
+}
+
+// generateSitemapLocked builds a sitemap listing every known product ID.
+// The caller must hold s.mu.
+func (s *Server) generateSitemapLocked() []byte {
+	seen := make(map[int]bool)
+	for id := range s.products {
+		seen[id] = true
+	}
+	for id := range s.faults {
+		seen[id] = true
+	}
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "  <url><loc>%s/products/product-%d/%d</loc></url>\n", s.URL, id, id)
+	}
+	buf.WriteString("</urlset>\n")
+	return buf.Bytes()
+}
+
+// handleProduct serves a product fixture, applying any fault registered for it.
+func (s *Server) handleProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(r.URL.Query().Get("retailerProductId"))
+	if err != nil {
+		http.Error(w, "invalid retailerProductId", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[productID]++
+	attempt := s.requests[productID]
+	body, exists := s.products[productID]
+	fault, hasFault := s.faults[productID]
+	s.mu.Unlock()
+
+	if hasFault && fault.Times > 0 && attempt > fault.Times {
+		fault = Fault{}
+	}
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if fault.Status != 0 {
+		if fault.RetryAfter != "" {
+			w.Header().Set("Retry-After", fault.RetryAfter)
+		}
+		http.Error(w, http.StatusText(fault.Status), fault.Status)
+		return
+	}
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fault.Malformed {
+		body = body[:len(body)/2]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch fault.Encoding {
+	case "gzip":
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(body)
+		gz.Close()
+	case "br":
+		w.Header().Set("Content-Encoding", "br")
+		br := brotli.NewWriter(w)
+		br.Write(body)
+		br.Close()
+	default:
+		w.Write(body)
+	}
+}
@@ -0,0 +1,111 @@
+package metrics
+
+// Default is the registry used by the application. All crawler metrics
+// below are registered on it and exposed by Handler and Push.
+var Default = NewRegistry()
+
+var (
+	// HTTPRequests counts upstream HTTP requests by service and status code.
+	// Transport failures that never produced a response use code "error".
+	HTTPRequests = Default.NewCounterVec(
+		"bonpreu_http_requests_total",
+		"Upstream HTTP requests by service and status code.",
+		"service", "code",
+	)
+
+	// HTTPRequestDuration observes the latency of upstream HTTP requests.
+	HTTPRequestDuration = Default.NewHistogramVec(
+		"bonpreu_http_request_duration_seconds",
+		"Latency of upstream HTTP requests in seconds.",
+		nil,
+		"service",
+	)
+
+	// HTTPRetries counts retried upstream requests by service and reason.
+	HTTPRetries = Default.NewCounterVec(
+		"bonpreu_http_retries_total",
+		"Retried upstream HTTP requests by service and reason.",
+		"service", "reason",
+	)
+
+	// ParseWarnings counts product responses that parsed with missing or suspicious data.
+	ParseWarnings = Default.NewCounterVec(
+		"bonpreu_parse_warnings_total",
+		"Product responses that parsed with missing or suspicious data, by reason.",
+		"reason",
+	)
+
+	// ProductsFetched counts fetched products by outcome: success or the error category
+	// (not_found, rate_limited, http_status, parse, transport).
+	ProductsFetched = Default.NewCounterVec(
+		"bonpreu_products_fetched_total",
+		"Fetched products by outcome.",
+		"result",
+	)
+
+	// RowsSaved counts rows written to the database by table.
+	RowsSaved = Default.NewCounterVec(
+		"bonpreu_db_rows_saved_total",
+		"Rows written to the database by table.",
+		"table",
+	)
+
+	// DBBatchDuration observes the duration of bulk insert batches by table.
+	DBBatchDuration = Default.NewHistogramVec(
+		"bonpreu_db_batch_duration_seconds",
+		"Duration of database bulk insert batches in seconds.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		"table",
+	)
+
+	// WebhookDeliveries counts webhook events by outcome: delivered or dead_letter.
+	WebhookDeliveries = Default.NewCounterVec(
+		"bonpreu_webhook_deliveries_total",
+		"Webhook events by outcome.",
+		"result",
+	)
+
+	// DaemonJobRuns counts daemon job runs by job and result: success, error or skipped.
+	DaemonJobRuns = Default.NewCounterVec(
+		"bonpreu_daemon_job_runs_total",
+		"Daemon job runs by job and result.",
+		"job", "result",
+	)
+
+	// RateLimiterRate reports the configured request rate of the crawler.
+	// It is zero when rate limiting is disabled.
+	RateLimiterRate = Default.NewGaugeVec(
+		"bonpreu_rate_limiter_requests_per_second",
+		"Configured crawler request rate; zero when rate limiting is disabled.",
+	)
+
+	// SitemapURLs reports the number of URLs found in the last sitemap fetch.
+	SitemapURLs = Default.NewGaugeVec(
+		"bonpreu_sitemap_urls",
+		"Number of URLs found in the last sitemap fetch.",
+	)
+
+	// SitemapProductIDs reports the number of product IDs extracted from the last sitemap.
+	SitemapProductIDs = Default.NewGaugeVec(
+		"bonpreu_sitemap_product_ids",
+		"Number of product IDs extracted from the last sitemap fetch.",
+	)
+
+	// SitemapBytes reports the size of the last downloaded sitemap.
+	SitemapBytes = Default.NewGaugeVec(
+		"bonpreu_sitemap_bytes",
+		"Size of the last downloaded sitemap in bytes.",
+	)
+
+	// CrawlDuration reports the wall-clock duration of the last crawl.
+	CrawlDuration = Default.NewGaugeVec(
+		"bonpreu_crawl_duration_seconds",
+		"Wall-clock duration of the last crawl in seconds.",
+	)
+
+	// CrawlLastSuccess reports the Unix time of the last crawl that completed without error.
+	CrawlLastSuccess = Default.NewGaugeVec(
+		"bonpreu_crawl_last_success_timestamp_seconds",
+		"Unix time of the last crawl that completed without error.",
+	)
+)
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests by code.", "service", "code")
+	rate := registry.NewGaugeVec("test_rate", "Configured rate.\nSecond line.")
+	latency := registry.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "service")
+
+	requests.Inc("product", "200")
+	requests.Add(2, "product", "200")
+	requests.Add(-1, "product", "200")
+	requests.Inc("webhook", `say "hi"\`)
+	rate.Set(2.5)
+	latency.Observe(0.05, "product")
+	latency.Observe(0.5, "product")
+	latency.Observe(3, "product")
+
+	var sb strings.Builder
+	if err := registry.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+
+	want := `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{service="product",le="0.1"} 1
+test_latency_seconds_bucket{service="product",le="1"} 2
+test_latency_seconds_bucket{service="product",le="+Inf"} 3
+test_latency_seconds_sum{service="product"} 3.55
+test_latency_seconds_count{service="product"} 3
+# HELP test_rate Configured rate.\nSecond line.
+# TYPE test_rate gauge
+test_rate 2.5
+# HELP test_requests_total Requests by code.
+# TYPE test_requests_total counter
+test_requests_total{service="product",code="200"} 3
+test_requests_total{service="webhook",code="say \"hi\"\\"} 1
+`
+	if got := sb.String(); got != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := requests.Value("product", "200"); got != 3 {
+		t.Errorf("counter value = %v, want 3", got)
+	}
+	if got := latency.Count("product"); got != 3 {
+		t.Errorf("histogram count = %d, want 3", got)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"duplicate name", func(r *Registry) {
+			r.NewCounterVec("test_total", "First.")
+			r.NewGaugeVec("test_total", "Second.")
+		}},
+		{"wrong label count", func(r *Registry) {
+			r.NewCounterVec("test_total", "Counter.", "service").Inc("product", "200")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
+
+func TestDefaultRegistryRenders(t *testing.T) {
+	var sb strings.Builder
+	if err := Default.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	for _, name := range []string{"bonpreu_http_requests_total", "bonpreu_products_fetched_total", "bonpreu_rate_limiter_requests_per_second"} {
+		if !strings.Contains(sb.String(), "# TYPE "+name+" ") {
+			t.Errorf("default registry is missing %s", name)
+		}
+	}
+}
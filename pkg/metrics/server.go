@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// contentType is the content type of the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an http.Handler that serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Serve starts an HTTP server exposing the registry on /metrics at addr.
+// The server runs in the background; the returned server can be shut down by the caller.
+func (r *Registry) Serve(addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	// Surface immediate failures such as the address already being in use
+	select {
+	case err := <-errChan:
+		return nil, fmt.Errorf("failed to start metrics server on %s: %w", addr, err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	return server, nil
+}
+
+// Push sends the current state of the registry to a Pushgateway-compatible
+// endpoint. Metrics are grouped under the given job name, replacing any
+// previously pushed metrics for that job (HTTP PUT semantics).
+func (r *Registry) Push(gatewayURL, job string) error {
+	var body bytes.Buffer
+	if err := r.WriteText(&body); err != nil {
+		return fmt.Errorf("failed to render metrics: %w", err)
+	}
+
+	pushURL := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequest(http.MethodPut, pushURL, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push metrics, status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "Counter.").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := recorder.Header().Get("Content-Type"); got != contentType {
+		t.Errorf("Content-Type = %q, want %q", got, contentType)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "\ntest_total 1\n") {
+		t.Errorf("body = %q, want the test_total sample", body)
+	}
+}
+
+func TestPush(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGaugeVec("test_products", "Products.").Set(42)
+
+	var method, path, gotContentType, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, gotContentType = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type")
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer gateway.Close()
+
+	if err := registry.Push(gateway.URL+"/", "bonpreu crawl"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/bonpreu%20crawl" {
+		t.Errorf("pushed with %s %s, want PUT /metrics/job/bonpreu%%20crawl", method, path)
+	}
+	if gotContentType != contentType {
+		t.Errorf("Content-Type = %q, want %q", gotContentType, contentType)
+	}
+	if !strings.Contains(body, "\ntest_products 42\n") {
+		t.Errorf("body = %q, want the test_products sample", body)
+	}
+}
+
+func TestPushFailure(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+
+	err := NewRegistry().Push(gateway.URL, "bonpreu")
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Push() error = %v, want the 503 status", err)
+	}
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Titles of the bopData fields that are parsed into typed product data.
+const (
+	FieldCookingGuidelines = "cookingGuidelines"
+	FieldNutritionalData   = "nutritionalData"
+)
+
+// ProductAttribute is a bopData field of a product: its title and its
+// content, both as received and as plain text. Every field is kept, so that
+// information the API adds later is captured without a code change.
+type ProductAttribute struct {
+	Title       string `json:"title"`
+	ContentText string `json:"content_text"`
+	ContentHTML string `json:"content_html"`
+}
+
+// Hash identifies the content of the attribute, so that unchanged attributes
+// are not stored again.
+func (a ProductAttribute) Hash() string {
+	sum := sha256.Sum256([]byte(a.ContentHTML))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseProductAttributesFromResponse returns every bopData field of an API
+// response, in order. Contents that are not strings are kept as JSON. Fields
+// without a title are skipped, and only the first of several fields with the
+// same title is kept.
+func ParseProductAttributesFromResponse(responseJSON map[string]interface{}) []ProductAttribute {
+	bopData, ok := responseJSON["bopData"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	fields, ok := bopData["fields"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var attributes []ProductAttribute
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		fieldMap, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		title, _ := fieldMap["title"].(string)
+		if title = strings.TrimSpace(title); title == "" || seen[title] {
+			continue
+		}
+
+		var content string
+		switch value := fieldMap["content"].(type) {
+		case nil:
+		case string:
+			content = value
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			content = string(encoded)
+		}
+
+		seen[title] = true
+		attributes = append(attributes, ProductAttribute{
+			Title:       title,
+			ContentText: cleanText(content),
+			ContentHTML: content,
+		})
+	}
+	return attributes
+}
+
+// FindAttribute returns the attribute with the given title.
+func FindAttribute(attributes []ProductAttribute, title string) (ProductAttribute, bool) {
+	for _, attribute := range attributes {
+		if attribute.Title == title {
+			return attribute, true
+		}
+	}
+	return ProductAttribute{}, false
+}
+
+// AttributeTitleStats describes how widespread a bopData field title is:
+// the products that currently have it, the share of all products that is,
+// the versions of its content recorded, and when it was first and last seen.
+type AttributeTitleStats struct {
+	Title       string    `json:"title"`
+	Products    int       `json:"products"`
+	Share       float64   `json:"share"`
+	Versions    int       `json:"versions"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// AttributeVersion is a recorded version of a product attribute. It was
+// first seen in the observation ObservationID and is Current until the
+// product is observed with another content or without the attribute.
+type AttributeVersion struct {
+	ID              int64     `json:"id"`
+	Retailer        string    `json:"retailer"`
+	ProductID       int       `json:"product_id"`
+	ProductName     string    `json:"product_name"`
+	Title           string    `json:"title"`
+	ContentText     string    `json:"content_text"`
+	ContentHTML     string    `json:"content_html"`
+	ObservationID   *int64    `json:"observation_id,omitempty"`
+	FirstObservedAt time.Time `json:"first_observed_at"`
+	LastObservedAt  time.Time `json:"last_observed_at"`
+	Current         bool      `json:"current"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "testing"
+
+func TestParseProductAttributesFromResponse(t *testing.T) {
+	response := map[string]interface{}{
+		"bopData": map[string]interface{}{
+			"fields": []interface{}{
+				map[string]interface{}{"title": "Conservació", "content": "<p>Conservar en lloc <b>fresc</b></p>"},
+				map[string]interface{}{"title": "", "content": "sense títol"},
+				map[string]interface{}{"title": "Conservació", "content": "duplicat"},
+				map[string]interface{}{"title": FieldNutritionalData, "content": map[string]interface{}{"energy": 120.0}},
+				map[string]interface{}{"title": "Origen"},
+			},
+		},
+	}
+
+	got := ParseProductAttributesFromResponse(response)
+	want := []ProductAttribute{
+		{Title: "Conservació", ContentText: "Conservar en lloc fresc", ContentHTML: "<p>Conservar en lloc <b>fresc</b></p>"},
+		{Title: FieldNutritionalData, ContentText: `{"energy":120}`, ContentHTML: `{"energy":120}`},
+		{Title: "Origen"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseProductAttributesFromResponse() returned %d attributes, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("attribute %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if attribute, ok := FindAttribute(got, "Origen"); !ok || attribute.Title != "Origen" {
+		t.Errorf("FindAttribute(Origen) = %+v, %v", attribute, ok)
+	}
+	if _, ok := FindAttribute(got, "Al·lèrgens"); ok {
+		t.Error("FindAttribute found a missing attribute")
+	}
+
+	if got[0].Hash() == got[2].Hash() {
+		t.Error("attributes with different contents have the same hash")
+	}
+	if changed := (ProductAttribute{ContentHTML: got[0].ContentHTML + " "}); changed.Hash() == got[0].Hash() {
+		t.Error("changed content has the same hash")
+	}
+	if ParseProductAttributesFromResponse(map[string]interface{}{}) != nil {
+		t.Error("response without bopData has attributes")
+	}
+}
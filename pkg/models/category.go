@@ -0,0 +1,300 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Category is a node of the category taxonomy built from the categoryPath of
+// every observed product. Path is the full path from the top-level category
+// down to and including Name; ParentID is zero for top-level categories.
+// IDs are kept when a category is renamed, so they can be used as stable keys.
+type Category struct {
+	ID          int       `json:"id"`
+	ParentID    int       `json:"parent_id,omitempty"`
+	Name        string    `json:"name"`
+	Path        []string  `json:"path"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// CategoryRename is a category whose name changed between crawls. Path is
+// the path before the rename and NewPath the path after it; they differ only
+// in their last element.
+type CategoryRename struct {
+	CategoryID int      `json:"category_id"`
+	Path       []string `json:"path"`
+	NewPath    []string `json:"new_path"`
+}
+
+// CategoryMove records a product that moved to a different leaf category.
+// Renamed categories keep their ID and are not reported as moves.
+type CategoryMove struct {
+	Retailer      string    `json:"retailer"`
+	ProductID     int       `json:"product_id"`
+	ProductName   string    `json:"product_name"`
+	OldCategoryID int       `json:"old_category_id"`
+	NewCategoryID int       `json:"new_category_id"`
+	OldPath       []string  `json:"old_path"`
+	NewPath       []string  `json:"new_path"`
+	MovedAt       time.Time `json:"moved_at"`
+}
+
+// CategoryNode is a category with its children and aggregates over every
+// product in its subtree. Unit price aggregates are computed over the
+// products priced per UnitPriceBase ("kg", "l" or "unit"), the most common
+// base unit in the subtree, since prices per kg and per litre cannot be mixed.
+type CategoryNode struct {
+	Category
+	ProductCount    int             `json:"product_count"`
+	PricedCount     int             `json:"priced_count"`
+	UnitPriceBase   string          `json:"unit_price_base,omitempty"`
+	AvgUnitPrice    float64         `json:"avg_unit_price,omitempty"`
+	MedianUnitPrice float64         `json:"median_unit_price,omitempty"`
+	Children        []*CategoryNode `json:"children,omitempty"`
+}
+
+// CategorizedProduct is the leaf category and shelf unit price of a product,
+// as used for the category aggregates.
+type CategorizedProduct struct {
+	ProductID     int
+	CategoryID    int
+	UnitPrice     float64
+	UnitPriceUnit string
+}
+
+// CategoryPathKey returns a map key identifying a category path.
+func CategoryPathKey(path []string) string {
+	return strings.Join(path, "\x1f")
+}
+
+// DetectCategoryRenames finds categories that were renamed, by comparing the
+// path of the leaf category each product is currently assigned to with the
+// path it was observed under. A category at a given level is considered
+// renamed to a new sibling name when none of the observed products is still
+// under it, most of its observed products are under the new name, and the new
+// path is not already a known category. Renames are returned shallowest first;
+// the paths of deeper renames already take the shallower ones into account.
+//
+// existing lists the known categories, assigned maps product IDs to their
+// current leaf category ID and observed maps product IDs to their observed
+// category path. Products missing from observed are ignored, so partial
+// crawls do not produce renames.
+func DetectCategoryRenames(existing []Category, assigned map[int]int, observed map[int][]string) []CategoryRename {
+	byID := make(map[int]Category, len(existing))
+	known := make(map[string]Category, len(existing))
+	maxDepth := 0
+	for _, category := range existing {
+		byID[category.ID] = category
+		known[CategoryPathKey(category.Path)] = category
+		if len(category.Path) > maxDepth {
+			maxDepth = len(category.Path)
+		}
+	}
+
+	// Every prefix of an observed path is a category that still exists
+	observedPrefixes := make(map[string]bool)
+	for _, path := range observed {
+		for depth := 1; depth <= len(path); depth++ {
+			observedPrefixes[CategoryPathKey(path[:depth])] = true
+		}
+	}
+
+	// Current paths of the assigned products, rewritten as renames are found
+	oldPaths := make(map[int][]string)
+	for productID, categoryID := range assigned {
+		category, ok := byID[categoryID]
+		if _, seen := observed[productID]; ok && seen {
+			oldPaths[productID] = append([]string(nil), category.Path...)
+		}
+	}
+
+	var renames []CategoryRename
+	for depth := 1; depth <= maxDepth; depth++ {
+		totals := make(map[string]int)
+		votes := make(map[string]map[string]int)
+		newPrefixes := make(map[string][]string)
+
+		for productID, oldPath := range oldPaths {
+			newPath := observed[productID]
+			if len(oldPath) < depth || len(newPath) < depth {
+				continue
+			}
+			oldKey := CategoryPathKey(oldPath[:depth])
+			totals[oldKey]++
+
+			if CategoryPathKey(oldPath[:depth-1]) != CategoryPathKey(newPath[:depth-1]) {
+				continue
+			}
+			newKey := CategoryPathKey(newPath[:depth])
+			if newKey == oldKey {
+				continue
+			}
+			if votes[oldKey] == nil {
+				votes[oldKey] = make(map[string]int)
+			}
+			votes[oldKey][newKey]++
+			newPrefixes[newKey] = newPath[:depth]
+		}
+
+		// Visit candidates in a stable order so that results are deterministic
+		oldKeys := make([]string, 0, len(votes))
+		for oldKey := range votes {
+			oldKeys = append(oldKeys, oldKey)
+		}
+		sort.Strings(oldKeys)
+
+		claimed := make(map[string]bool)
+		for _, oldKey := range oldKeys {
+			category, ok := known[oldKey]
+			if !ok || observedPrefixes[oldKey] {
+				continue
+			}
+
+			bestKey, bestVotes := "", 0
+			for newKey, count := range votes[oldKey] {
+				if count > bestVotes || (count == bestVotes && newKey < bestKey) {
+					bestKey, bestVotes = newKey, count
+				}
+			}
+			if bestVotes*2 <= totals[oldKey] || claimed[bestKey] {
+				continue
+			}
+			if _, exists := known[bestKey]; exists {
+				continue
+			}
+			claimed[bestKey] = true
+
+			newPrefix := newPrefixes[bestKey]
+			renames = append(renames, CategoryRename{
+				CategoryID: category.ID,
+				Path:       category.Path,
+				NewPath:    append([]string(nil), newPrefix...),
+			})
+
+			// Apply the rename to the known categories and the assigned paths
+			for key, other := range known {
+				if hasPathPrefix(other.Path, category.Path) {
+					delete(known, key)
+					other.Path = append(append([]string(nil), newPrefix...), other.Path[depth:]...)
+					known[CategoryPathKey(other.Path)] = other
+				}
+			}
+			for productID, oldPath := range oldPaths {
+				if hasPathPrefix(oldPath, category.Path) {
+					oldPaths[productID] = append(append([]string(nil), newPrefix...), oldPath[depth:]...)
+				}
+			}
+		}
+	}
+
+	return renames
+}
+
+// hasPathPrefix reports whether path starts with prefix.
+func hasPathPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildCategoryTree assembles the category taxonomy and computes, for every
+// node, the number of products in its subtree and the average and median
+// unit price per kg, litre or unit. Products whose category is unknown are
+// ignored. Top-level nodes and children are sorted by name.
+func BuildCategoryTree(categories []Category, products []CategorizedProduct) []*CategoryNode {
+	nodes := make(map[int]*CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryNode{Category: category}
+	}
+
+	var roots []*CategoryNode
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if parent, ok := nodes[category.ParentID]; ok && category.ParentID != 0 {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	// Collect the unit prices of every product under each node, per base unit
+	prices := make(map[int]map[string][]float64)
+	for _, product := range products {
+		node, ok := nodes[product.CategoryID]
+		if !ok {
+			continue
+		}
+
+		baseUnit, factor := unitPriceBase(product.UnitPriceUnit)
+		for visited := 0; node != nil && visited <= len(nodes); visited++ {
+			node.ProductCount++
+			if baseUnit != "" && product.UnitPrice > 0 {
+				if prices[node.ID] == nil {
+					prices[node.ID] = make(map[string][]float64)
+				}
+				prices[node.ID][baseUnit] = append(prices[node.ID][baseUnit], product.UnitPrice*factor)
+			}
+			if node.ParentID == 0 {
+				break
+			}
+			node = nodes[node.ParentID]
+		}
+	}
+
+	for id, byUnit := range prices {
+		node := nodes[id]
+		for _, baseUnit := range []string{"kg", "l", "unit"} {
+			if len(byUnit[baseUnit]) > node.PricedCount {
+				node.UnitPriceBase, node.PricedCount = baseUnit, len(byUnit[baseUnit])
+			}
+		}
+		values := byUnit[node.UnitPriceBase]
+		node.AvgUnitPrice = roundCents(mean(values))
+		node.MedianUnitPrice = roundCents(median(values))
+	}
+
+	sortCategoryNodes(roots)
+	return roots
+}
+
+// sortCategoryNodes sorts nodes and their descendants by name.
+func sortCategoryNodes(nodes []*CategoryNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		sortCategoryNodes(node.Children)
+	}
+}
+
+// mean returns the arithmetic mean of values, or zero when there are none.
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// median returns the median of values, or zero when there are none.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectCategoryRenames(t *testing.T) {
+	existing := []Category{
+		{ID: 1, Name: "Frescos", Path: []string{"Frescos"}},
+		{ID: 2, ParentID: 1, Name: "Fruita", Path: []string{"Frescos", "Fruita"}},
+		{ID: 3, ParentID: 1, Name: "Verdura", Path: []string{"Frescos", "Verdura"}},
+		{ID: 4, ParentID: 2, Name: "Pomes", Path: []string{"Frescos", "Fruita", "Pomes"}},
+	}
+	assigned := map[int]int{10: 4, 11: 4, 12: 3, 13: 2}
+
+	tests := []struct {
+		name     string
+		observed map[int][]string
+		want     []CategoryRename
+	}{
+		{
+			name: "unchanged",
+			observed: map[int][]string{
+				10: {"Frescos", "Fruita", "Pomes"},
+				12: {"Frescos", "Verdura"},
+			},
+		},
+		{
+			name: "leaf renamed",
+			observed: map[int][]string{
+				10: {"Frescos", "Fruita", "Pomes i peres"},
+				11: {"Frescos", "Fruita", "Pomes i peres"},
+			},
+			want: []CategoryRename{{CategoryID: 4, Path: []string{"Frescos", "Fruita", "Pomes"}, NewPath: []string{"Frescos", "Fruita", "Pomes i peres"}}},
+		},
+		{
+			name: "parent renamed",
+			observed: map[int][]string{
+				10: {"Frescos", "Fruites", "Pomes"},
+				13: {"Frescos", "Fruites"},
+				12: {"Frescos", "Verdura"},
+			},
+			want: []CategoryRename{{CategoryID: 2, Path: []string{"Frescos", "Fruita"}, NewPath: []string{"Frescos", "Fruites"}}},
+		},
+		{
+			name: "products moved to an existing category",
+			observed: map[int][]string{
+				10: {"Frescos", "Verdura"},
+				11: {"Frescos", "Verdura"},
+			},
+		},
+		{
+			name: "old category still observed",
+			observed: map[int][]string{
+				10: {"Frescos", "Fruita", "Pomes vermelles"},
+				11: {"Frescos", "Fruita", "Pomes"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectCategoryRenames(existing, assigned, tt.observed)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DetectCategoryRenames = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	categories := []Category{
+		{ID: 1, Name: "Begudes", Path: []string{"Begudes"}},
+		{ID: 2, ParentID: 1, Name: "Sucs", Path: []string{"Begudes", "Sucs"}},
+		{ID: 3, ParentID: 1, Name: "Aigua", Path: []string{"Begudes", "Aigua"}},
+	}
+	products := []CategorizedProduct{
+		{ProductID: 1, CategoryID: 2, UnitPrice: 1.20, UnitPriceUnit: "fop.price.per.litre"},
+		{ProductID: 2, CategoryID: 2, UnitPrice: 2.40, UnitPriceUnit: "fop.price.per.litre"},
+		{ProductID: 3, CategoryID: 3, UnitPrice: 0.30, UnitPriceUnit: "fop.price.per.litre"},
+		{ProductID: 4, CategoryID: 3, UnitPrice: 0.50, UnitPriceUnit: "fop.price.per.each"},
+		{ProductID: 5, CategoryID: 99},
+	}
+
+	tree := BuildCategoryTree(categories, products)
+	if len(tree) != 1 || tree[0].Name != "Begudes" || len(tree[0].Children) != 2 {
+		t.Fatalf("tree = %+v", tree)
+	}
+
+	root := tree[0]
+	if root.ProductCount != 4 || root.PricedCount != 3 || root.UnitPriceBase != "l" {
+		t.Errorf("root = %+v", root)
+	}
+	if root.AvgUnitPrice != 1.30 || root.MedianUnitPrice != 1.20 {
+		t.Errorf("root avg/median = %v/%v, want 1.30/1.20", root.AvgUnitPrice, root.MedianUnitPrice)
+	}
+
+	juices := root.Children[1]
+	if juices.Name != "Sucs" || juices.ProductCount != 2 || juices.MedianUnitPrice != 1.80 {
+		t.Errorf("juices = %+v", juices)
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// Statuses of a crawl job in the distributed crawl queue.
+const (
+	CrawlJobPending = "pending"
+	CrawlJobRunning = "running"
+	CrawlJobDone    = "done"
+	CrawlJobFailed  = "failed"
+)
+
+// CrawlJob is a product to fetch in a queued crawl run. A worker holds a
+// running job until LeasedUntil; jobs whose lease expired are reassigned.
+type CrawlJob struct {
+	ID          int64      `json:"id"`
+	RunID       string     `json:"run_id"`
+	Retailer    string     `json:"retailer"`
+	ProductID   int        `json:"product_id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	WorkerID    string     `json:"worker_id,omitempty"`
+	LeasedUntil *time.Time `json:"leased_until,omitempty"`
+}
+
+// CrawlJobResult is the outcome of a claimed job. ErrorCategory is one of the
+// services.Category* values, or empty when the product was fetched, and Status
+// is the status the job moves to.
+type CrawlJobResult struct {
+	JobID         int64
+	Status        string
+	ErrorCategory string
+	ErrorMessage  string
+}
+
+// NextStatus returns the status of a job after an attempt with this result.
+// Fetched and missing products are done, and other failures go back to the
+// queue until the job has been attempted maxAttempts times.
+func (r CrawlJobResult) NextStatus(attempts, maxAttempts int) string {
+	switch {
+	case r.ErrorCategory == "" || r.ErrorCategory == "not_found":
+		return CrawlJobDone
+	case attempts < maxAttempts:
+		return CrawlJobPending
+	default:
+		return CrawlJobFailed
+	}
+}
+
+// CrawlQueueStats counts the jobs of a crawl run by status.
+type CrawlQueueStats struct {
+	RunID      string    `json:"run_id"`
+	Pending    int       `json:"pending"`
+	Running    int       `json:"running"`
+	Done       int       `json:"done"`
+	NotFound   int       `json:"not_found"`
+	Failed     int       `json:"failed"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// Total returns the number of jobs in the run.
+func (s CrawlQueueStats) Total() int {
+	return s.Pending + s.Running + s.Done + s.Failed
+}
+
+// Finished reports whether every job of the run is done or failed.
+func (s CrawlQueueStats) Finished() bool {
+	return s.Pending == 0 && s.Running == 0
+}
+
+// RunHealth returns the product counts of the run as reported in the digest.
+func (s CrawlQueueStats) RunHealth(duration time.Duration) RunHealth {
+	return RunHealth{
+		TotalProducts: int64(s.Total()),
+		SuccessCount:  int64(s.Done - s.NotFound),
+		NotFoundCount: int64(s.NotFound),
+		ErrorCount:    int64(s.Failed),
+		Duration:      duration,
+	}
+}
+
+// CrawlWorker is a worker process of the distributed crawl.
+type CrawlWorker struct {
+	WorkerID    string    `json:"worker_id"`
+	Hostname    string    `json:"hostname"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	RunningJobs int       `json:"running_jobs"`
+	JobsDone    int       `json:"jobs_done"`
+	JobsFailed  int       `json:"jobs_failed"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "testing"
+
+func TestCrawlJobResultNextStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   CrawlJobResult
+		attempts int
+		want     string
+	}{
+		{"fetched", CrawlJobResult{}, 1, CrawlJobDone},
+		{"not found", CrawlJobResult{ErrorCategory: "not_found"}, 1, CrawlJobDone},
+		{"retryable", CrawlJobResult{ErrorCategory: "rate_limited"}, 1, CrawlJobPending},
+		{"out of attempts", CrawlJobResult{ErrorCategory: "transport"}, 3, CrawlJobFailed},
+	}
+
+	for _, tt := range tests {
+		if got := tt.result.NextStatus(tt.attempts, 3); got != tt.want {
+			t.Errorf("%s: NextStatus(%d, 3) = %q, want %q", tt.name, tt.attempts, got, tt.want)
+		}
+	}
+
+	stats := CrawlQueueStats{Done: 90, NotFound: 5, Failed: 10}
+	if health := stats.RunHealth(0); health.TotalProducts != 100 || health.SuccessCount != 85 || health.ErrorCount != 10 {
+		t.Errorf("RunHealth() = %+v", health)
+	}
+}
+
+func TestRunHealthFailureRate(t *testing.T) {
+	// Products that were not found count neither as failed nor as crawled
+	health := RunHealth{TotalProducts: 100, SuccessCount: 75, NotFoundCount: 20, ErrorCount: 5}
+	if got := health.FailureRate(); got != 0.0625 {
+		t.Errorf("FailureRate() = %v, want 0.0625", got)
+	}
+	if got := health.FailurePercent(); got != 6.25 {
+		t.Errorf("FailurePercent() = %v, want 6.25", got)
+	}
+	if got := (RunHealth{TotalProducts: 3, NotFoundCount: 3}).FailureRate(); got != 0 {
+		t.Errorf("FailureRate() of a run with only missing products = %v, want 0", got)
+	}
+}
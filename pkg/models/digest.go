@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Digest summarises the catalogue changes observed between From and To, as
+// sent in the daily email digest.
+type Digest struct {
+	From                 time.Time         `json:"from"`
+	To                   time.Time         `json:"to"`
+	NewProducts          []DigestProduct   `json:"new_products"`
+	DiscontinuedProducts []DigestProduct   `json:"discontinued_products"`
+	PriceIncreases       []PriceChange     `json:"price_increases"`
+	PriceDecreases       []PriceChange     `json:"price_decreases"`
+	NewPromotions        []ActivePromotion `json:"new_promotions"`
+	RunHealth            *RunHealth        `json:"run_health,omitempty"`
+}
+
+// Empty reports whether the digest holds no catalogue changes.
+func (d Digest) Empty() bool {
+	return len(d.NewProducts) == 0 && len(d.DiscontinuedProducts) == 0 &&
+		len(d.PriceIncreases) == 0 && len(d.PriceDecreases) == 0 && len(d.NewPromotions) == 0
+}
+
+// DigestProduct is a product listed in the digest. ObservedAt is the first
+// observation of a new product and the last one of a discontinued product.
+type DigestProduct struct {
+	Retailer           string    `json:"retailer"`
+	ProductID          int       `json:"product_id"`
+	ProductName        string    `json:"product_name"`
+	ProductBrand       string    `json:"product_brand"`
+	ProductPriceAmount float64   `json:"product_price_amount"`
+	ObservedAt         time.Time `json:"observed_at"`
+}
+
+// PriceChange is the change of a product's shelf price between its last
+// observation before the digest period and its latest one.
+type PriceChange struct {
+	Retailer      string    `json:"retailer"`
+	ProductID     int       `json:"product_id"`
+	ProductName   string    `json:"product_name"`
+	ProductBrand  string    `json:"product_brand"`
+	OldPrice      float64   `json:"old_price"`
+	NewPrice      float64   `json:"new_price"`
+	ChangePercent float64   `json:"change_percent"`
+	ObservedAt    time.Time `json:"observed_at"`
+}
+
+// RunHealth holds the product counts of the crawl that preceded the digest.
+type RunHealth struct {
+	TotalProducts int64         `json:"total_products"`
+	SuccessCount  int64         `json:"success_count"`
+	NotFoundCount int64         `json:"not_found_count"`
+	ErrorCount    int64         `json:"error_count"`
+	Duration      time.Duration `json:"duration"`
+}
+
+// Add adds the counts and duration of another run, e.g. of another retailer
+// crawled in the same run.
+func (h *RunHealth) Add(other RunHealth) {
+	h.TotalProducts += other.TotalProducts
+	h.SuccessCount += other.SuccessCount
+	h.NotFoundCount += other.NotFoundCount
+	h.ErrorCount += other.ErrorCount
+	h.Duration += other.Duration
+}
+
+// FailureRate returns the share of products that failed, between 0 and 1.
+// Products that were not found are left out of both counts, since delisted
+// products are expected. It is the rate compared with the configured
+// failure-rate threshold.
+func (h RunHealth) FailureRate() float64 {
+	if h.TotalProducts-h.NotFoundCount <= 0 {
+		return 0
+	}
+	return float64(h.ErrorCount) / float64(h.TotalProducts-h.NotFoundCount)
+}
+
+// FailurePercent returns the failure rate as a percentage rounded to two
+// decimals, for display.
+func (h RunHealth) FailurePercent() float64 {
+	return roundCents(h.FailureRate() * 100)
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ProductFetchFailure records a product that could not be fetched during a crawl.
+// Failures are kept between runs so that they can be retried without a full crawl.
+// ErrorCategory is one of the services.Category* values and HTTPStatus is zero
+// when the request failed before a response was received.
+type ProductFetchFailure struct {
+	Retailer      string    `json:"retailer"`
+	ProductID     int       `json:"product_id"`
+	ErrorCategory string    `json:"error_category"`
+	HTTPStatus    int       `json:"http_status,omitempty"`
+	Attempts      int       `json:"attempts"`
+	ErrorMessage  string    `json:"error_message"`
+	FailedAt      time.Time `json:"failed_at"`
+	FailureCount  int       `json:"failure_count"`
+}
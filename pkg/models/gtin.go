@@ -0,0 +1,162 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gtinKeys are the product attributes, bopData field titles and attribute
+// names under which the API may expose a barcode, compared case-insensitively
+// with spaces, dashes and underscores removed.
+var gtinKeys = map[string]bool{
+	"ean": true, "eans": true, "ean13": true, "eancode": true,
+	"gtin": true, "gtins": true, "gtin13": true, "gtin14": true,
+	"barcode": true, "barcodes": true, "codibarres": true, "codidebarres": true, "codigodebarras": true,
+}
+
+// isGTINKey reports whether an attribute name or field title holds a barcode.
+func isGTINKey(key string) bool {
+	return gtinKeys[fieldKey(key)]
+}
+
+// NormalizeGTIN validates a GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN) or GTIN-14
+// code and returns it in a canonical 13-digit form, so that the same product
+// matches whichever length a source uses: shorter codes are padded with
+// zeros and a GTIN-14 with a zero indicator digit loses it. Spaces and
+// dashes are ignored. Codes with a wrong length or check digit are rejected.
+func NormalizeGTIN(code string) (string, bool) {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return "", false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	if strings.Trim(code, "0") == "" || !validGTINCheckDigit(code) {
+		return "", false
+	}
+
+	if len(code) < 13 {
+		code = strings.Repeat("0", 13-len(code)) + code
+	}
+	if len(code) == 14 && code[0] == '0' {
+		code = code[1:]
+	}
+	return code, true
+}
+
+// validGTINCheckDigit verifies the GS1 check digit: from the right, digits
+// other than the check digit are weighted 3, 1, 3, ... and the check digit
+// brings the sum to a multiple of 10.
+func validGTINCheckDigit(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(code[len(code)-1]-'0')
+}
+
+// ParseGTINsFromResponse extracts the barcodes of a product from the API
+// response. They are looked for in the product attributes (ean, gtin,
+// barcode and their plurals), in an attributes list or map of the product,
+// and in the bopData fields, given as parsed attributes, with such a title.
+// Invalid codes are dropped and the valid ones are returned normalized,
+// without duplicates, in the order found.
+func ParseGTINsFromResponse(responseJSON map[string]interface{}, fields []ProductAttribute) []string {
+	var gtins []string
+	seen := make(map[string]bool)
+	add := func(value interface{}) {
+		for _, code := range gtinCandidates(value) {
+			if gtin, ok := NormalizeGTIN(code); ok && !seen[gtin] {
+				seen[gtin] = true
+				gtins = append(gtins, gtin)
+			}
+		}
+	}
+
+	if productData, ok := responseJSON["product"].(map[string]interface{}); ok {
+		addKeys(productData, add)
+
+		switch attributes := productData["attributes"].(type) {
+		case map[string]interface{}:
+			addKeys(attributes, add)
+		case []interface{}:
+			for _, attribute := range attributes {
+				attributeMap, ok := attribute.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				for _, nameKey := range []string{"name", "key", "title", "id"} {
+					if name, ok := attributeMap[nameKey].(string); ok && isGTINKey(name) {
+						add(attributeMap["value"])
+						add(attributeMap["values"])
+						break
+					}
+				}
+			}
+		}
+	}
+
+	for _, field := range fields {
+		if isGTINKey(field.Title) {
+			add(field.ContentText)
+		}
+	}
+
+	return gtins
+}
+
+// addKeys passes the values of the barcode keys of an object to add, in key
+// order so that the result does not depend on map iteration.
+func addKeys(object map[string]interface{}, add func(value interface{})) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		if isGTINKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(object[key])
+	}
+}
+
+// gtinCandidates returns the codes held by an attribute value: a string,
+// possibly listing several codes, a JSON number or a list of either.
+func gtinCandidates(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.FieldsFunc(cleanText(v), func(r rune) bool {
+			return r == ',' || r == ';' || r == '/' || r == '|' || r == ' '
+		})
+	case float64:
+		if v <= 0 || v != float64(int64(v)) {
+			return nil
+		}
+		// Numbers lose the leading zeros of the code
+		code := strconv.FormatInt(int64(v), 10)
+		switch {
+		case len(code) < 8:
+			code = strings.Repeat("0", 8-len(code)) + code
+		case len(code) > 8 && len(code) < 13:
+			code = strings.Repeat("0", 13-len(code)) + code
+		}
+		return []string{code}
+	case []interface{}:
+		var codes []string
+		for _, item := range v {
+			codes = append(codes, gtinCandidates(item)...)
+		}
+		return codes
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import "testing"
+
+func TestNormalizeGTIN(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+		ok   bool
+	}{
+		{"8410076472885", "8410076472885", true},
+		{"841 0076 472885", "8410076472885", true},
+		{"08410076472885", "8410076472885", true},
+		{"036000291452", "0036000291452", true},
+		{"96385074", "0000096385074", true},
+		{"8410076472886", "", false},
+		{"0000000000000", "", false},
+		{"84100764728", "", false},
+		{"84100764728a5", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizeGTIN(tt.code)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeGTIN(%q) = %q, %v, want %q, %v", tt.code, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseGTINsFromResponse(t *testing.T) {
+	response := map[string]interface{}{
+		"product": map[string]interface{}{
+			"ean":   "8410076472885",
+			"gtins": []interface{}{"08410076472885", 36000291452.0, "1234"},
+			"attributes": []interface{}{
+				map[string]interface{}{"name": "Codi de barres", "value": "96385074"},
+			},
+		},
+		"bopData": map[string]interface{}{
+			"fields": []interface{}{
+				map[string]interface{}{"title": "EAN", "content": "<p>4006381333931, 8410076472885</p>"},
+			},
+		},
+	}
+
+	got := ParseGTINsFromResponse(response, ParseProductAttributesFromResponse(response))
+	want := []string{"8410076472885", "0036000291452", "0000096385074", "4006381333931"}
+	if len(got) != len(want) {
+		t.Fatalf("ParseGTINsFromResponse = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ParseGTINsFromResponse = %v, want %v", got, want)
+			break
+		}
+	}
+}
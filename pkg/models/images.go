@@ -0,0 +1,165 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImageSizeOriginal is the size of an image URL that names no size.
+const ImageSizeOriginal = "original"
+
+// imageFields are the product keys holding images, main image first.
+var imageFields = []string{"image", "images", "imagePaths", "imageUrls"}
+
+// imageURLFields are the keys of an image object holding its URL.
+var imageURLFields = []string{"src", "url", "href", "path"}
+
+// imageSizeFields are the keys of an image object holding its URLs by size.
+var imageSizeFields = []string{"sizes", "variants", "renditions"}
+
+// imageSizePattern matches the dimensions in an image URL, e.g. "/300x300.jpg".
+var imageSizePattern = regexp.MustCompile(`(?i)\b(\d{2,5})x(\d{2,5})\b`)
+
+// ProductImage is a URL of a product image at one size. Position orders the
+// images of a product, 0 being the main image; every size of an image shares
+// its position.
+type ProductImage struct {
+	ID        int64  `json:"id,omitempty"`
+	Retailer  string `json:"retailer,omitempty"`
+	ProductID int    `json:"product_id,omitempty"`
+	Position  int    `json:"position"`
+	Size      string `json:"size"`
+	URL       string `json:"url"`
+}
+
+// ParseProductImagesFromResponse returns the image URLs of an API response,
+// at every size given. Images are read from the image, images, imagePaths
+// and imageUrls fields, as URLs or as objects with a src or url and,
+// optionally, their URLs by size. The size of a URL is its key, or else the
+// dimensions in the URL. A URL found twice is only kept the first time.
+func ParseProductImagesFromResponse(responseJSON map[string]interface{}) []ProductImage {
+	productData, ok := responseJSON["product"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var images []ProductImage
+	seen := make(map[string]bool)
+	add := func(sizes []ProductImage) {
+		position := -1
+		for _, image := range sizes {
+			if seen[image.URL] {
+				continue
+			}
+			seen[image.URL] = true
+			if position < 0 {
+				position = 0
+				if len(images) > 0 {
+					position = images[len(images)-1].Position + 1
+				}
+			}
+			image.Position = position
+			images = append(images, image)
+		}
+	}
+
+	for _, field := range imageFields {
+		switch value := productData[field].(type) {
+		case []interface{}:
+			for _, item := range value {
+				add(parseImageSizes(item))
+			}
+		default:
+			add(parseImageSizes(value))
+		}
+	}
+	return images
+}
+
+// parseImageSizes returns the URLs of a single image, given as a URL or as
+// an object.
+func parseImageSizes(value interface{}) []ProductImage {
+	switch value := value.(type) {
+	case string:
+		if url := strings.TrimSpace(value); url != "" {
+			return []ProductImage{{Size: imageSize("", url), URL: url}}
+		}
+	case map[string]interface{}:
+		var sizes []ProductImage
+		for _, key := range imageURLFields {
+			if url, ok := value[key].(string); ok && strings.TrimSpace(url) != "" {
+				url = strings.TrimSpace(url)
+				label, _ := value["size"].(string)
+				sizes = append(sizes, ProductImage{Size: imageSize(label, url), URL: url})
+				break
+			}
+		}
+		for _, key := range imageSizeFields {
+			bySize, ok := value[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			labels := make([]string, 0, len(bySize))
+			for label := range bySize {
+				labels = append(labels, label)
+			}
+			sort.Strings(labels)
+			for _, label := range labels {
+				for _, size := range parseImageSizes(bySize[label]) {
+					size.Size = imageSize(label, size.URL)
+					sizes = append(sizes, size)
+				}
+			}
+		}
+		return sizes
+	}
+	return nil
+}
+
+// imageSize returns the size of an image URL: its label when given, else
+// the dimensions in the URL, else ImageSizeOriginal.
+func imageSize(label, url string) string {
+	if label = strings.TrimSpace(label); label != "" {
+		return label
+	}
+	if match := imageSizePattern.FindStringSubmatch(url); match != nil {
+		return match[1] + "x" + match[2]
+	}
+	return ImageSizeOriginal
+}
+
+// ImageFile is an image downloaded to the local mirror. Files are named
+// after the SHA-256 of their content, so an image shared by several
+// products or sizes is stored once.
+type ImageFile struct {
+	ContentHash string `json:"content_hash"`
+	ContentType string `json:"content_type"`
+	Bytes       int64  `json:"bytes"`
+	Path        string `json:"path"`
+}
+
+// ImageDownload records that a product image URL was downloaded and which
+// file its content was.
+type ImageDownload struct {
+	ProductImageID int64     `json:"product_image_id"`
+	File           ImageFile `json:"file"`
+	DownloadedAt   time.Time `json:"downloaded_at"`
+}
+
+// ImageChange is a product image whose content differs from the previous
+// download of the same position and size, such as a packaging redesign.
+type ImageChange struct {
+	Retailer     string    `json:"retailer"`
+	ProductID    int       `json:"product_id"`
+	ProductName  string    `json:"product_name"`
+	Position     int       `json:"position"`
+	Size         string    `json:"size"`
+	PreviousURL  string    `json:"previous_url"`
+	PreviousHash string    `json:"previous_hash"`
+	URL          string    `json:"url"`
+	ContentHash  string    `json:"content_hash"`
+	Path         string    `json:"path"`
+	ChangedAt    time.Time `json:"changed_at"`
+}
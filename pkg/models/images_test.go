@@ -0,0 +1,50 @@
+package models
+
+import "testing"
+
+func TestParseProductImagesFromResponse(t *testing.T) {
+	response := map[string]interface{}{
+		"product": map[string]interface{}{
+			"image": map[string]interface{}{
+				"src": "https://cdn.example/p/1/front/640x640.jpg",
+				"sizes": map[string]interface{}{
+					"small": "https://cdn.example/p/1/front/small.jpg",
+					"large": map[string]interface{}{"url": "https://cdn.example/p/1/front/1200x1200.jpg"},
+				},
+			},
+			"images": []interface{}{
+				"https://cdn.example/p/1/front/640x640.jpg",
+				map[string]interface{}{"url": "https://cdn.example/p/1/back.jpg", "size": "zoom"},
+				"",
+				42.0,
+			},
+			"imagePaths": []interface{}{"/p/1/side/300X300.png"},
+		},
+	}
+
+	got := ParseProductImagesFromResponse(response)
+	want := []ProductImage{
+		{Position: 0, Size: "640x640", URL: "https://cdn.example/p/1/front/640x640.jpg"},
+		{Position: 0, Size: "large", URL: "https://cdn.example/p/1/front/1200x1200.jpg"},
+		{Position: 0, Size: "small", URL: "https://cdn.example/p/1/front/small.jpg"},
+		{Position: 1, Size: "zoom", URL: "https://cdn.example/p/1/back.jpg"},
+		{Position: 2, Size: "300x300", URL: "/p/1/side/300X300.png"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseProductImagesFromResponse() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("image %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if images := ParseProductImagesFromResponse(map[string]interface{}{
+		"product": map[string]interface{}{"image": "https://cdn.example/p/2.jpg"},
+	}); len(images) != 1 || images[0].Size != ImageSizeOriginal {
+		t.Errorf("image without a size = %+v", images)
+	}
+	if images := ParseProductImagesFromResponse(map[string]interface{}{}); images != nil {
+		t.Errorf("response without a product has images: %+v", images)
+	}
+}
@@ -0,0 +1,359 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The 14 allergens that EU Regulation 1169/2011 (Annex II) requires food
+// labels to declare.
+const (
+	AllergenGluten      = "gluten"
+	AllergenCrustaceans = "crustaceans"
+	AllergenEggs        = "eggs"
+	AllergenFish        = "fish"
+	AllergenPeanuts     = "peanuts"
+	AllergenSoybeans    = "soybeans"
+	AllergenMilk        = "milk"
+	AllergenNuts        = "nuts"
+	AllergenCelery      = "celery"
+	AllergenMustard     = "mustard"
+	AllergenSesame      = "sesame"
+	AllergenSulphites   = "sulphites"
+	AllergenLupin       = "lupin"
+	AllergenMolluscs    = "molluscs"
+)
+
+// Allergens lists the EU allergens in the order of Annex II.
+var Allergens = []string{
+	AllergenGluten, AllergenCrustaceans, AllergenEggs, AllergenFish, AllergenPeanuts,
+	AllergenSoybeans, AllergenMilk, AllergenNuts, AllergenCelery, AllergenMustard,
+	AllergenSesame, AllergenSulphites, AllergenLupin, AllergenMolluscs,
+}
+
+// IsAllergen reports whether name is one of the EU allergens.
+func IsAllergen(name string) bool {
+	for _, allergen := range Allergens {
+		if name == allergen {
+			return true
+		}
+	}
+	return false
+}
+
+// Presence of an allergen in a product.
+const (
+	AllergenContains   = "contains"
+	AllergenMayContain = "may_contain"
+)
+
+// ProductDetails holds the label information of a product taken from the
+// bopData fields: the ingredient list, as text and split into ingredients,
+// the allergens it contains or may contain, and the storage conditions,
+// origin and manufacturer. Allergens maps an allergen to its presence;
+// allergens that are not declared are absent.
+type ProductDetails struct {
+	IngredientsText   string            `json:"ingredients_text,omitempty"`
+	Ingredients       []string          `json:"ingredients,omitempty"`
+	AllergensText     string            `json:"allergens_text,omitempty"`
+	Allergens         map[string]string `json:"allergens,omitempty"`
+	StorageConditions string            `json:"storage_conditions,omitempty"`
+	Origin            string            `json:"origin,omitempty"`
+	Manufacturer      string            `json:"manufacturer,omitempty"`
+}
+
+// HasAllergenInformation reports whether the product declares ingredients or
+// allergens, without which the absence of an allergen means nothing.
+func (d ProductDetails) HasAllergenInformation() bool {
+	return d.IngredientsText != "" || d.AllergensText != ""
+}
+
+// Attributes of ProductDetails that bopData fields are mapped to.
+const (
+	detailIngredients  = "ingredients"
+	detailAllergens    = "allergens"
+	detailStorage      = "storage"
+	detailOrigin       = "origin"
+	detailManufacturer = "manufacturer"
+)
+
+// detailFieldTitles maps the bopData field titles, compared case-insensitively
+// with spaces, dashes and underscores removed, to the attribute they hold.
+var detailFieldTitles = map[string]string{
+	"ingredients": detailIngredients, "ingredientes": detailIngredients, "ingredientlist": detailIngredients,
+	"allergens": detailAllergens, "alergens": detailAllergens, "alergenos": detailAllergens,
+	"allergeninformation": detailAllergens, "allergenstatement": detailAllergens,
+	"storageconditions": detailStorage, "storageinstructions": detailStorage, "storage": detailStorage,
+	"conservacio": detailStorage, "conservacion": detailStorage,
+	"origin": detailOrigin, "countryoforigin": detailOrigin, "placeoforigin": detailOrigin,
+	"origen": detailOrigin, "paisorigen": detailOrigin,
+	"manufacturer": detailManufacturer, "manufacturername": detailManufacturer,
+	"manufactureraddress": detailManufacturer, "fabricant": detailManufacturer, "fabricante": detailManufacturer,
+}
+
+// fieldKey normalises a field title or attribute name for lookups.
+func fieldKey(title string) string {
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(title))
+}
+
+// emphasisPattern matches the text that labels emphasise, as the EU requires
+// for allergens in ingredient lists.
+var emphasisPattern = regexp.MustCompile(`(?is)<(b|strong|u)\b[^>]*>(.*?)</(?:b|strong|u)>`)
+
+// ParseProductDetails maps the known bopData fields, given as parsed
+// attributes, to the product details, then splits the ingredient list and
+// derives the allergen flags from the allergen statement, the ingredients
+// and the allergens emphasised in them.
+func ParseProductDetails(attributes []ProductAttribute) ProductDetails {
+	var details ProductDetails
+	var emphasised []string
+
+	for _, attribute := range attributes {
+		text := attribute.ContentText
+		if text == "" {
+			continue
+		}
+
+		switch detailFieldTitles[fieldKey(attribute.Title)] {
+		case detailIngredients:
+			details.IngredientsText = joinText(details.IngredientsText, text)
+			for _, match := range emphasisPattern.FindAllStringSubmatch(attribute.ContentHTML, -1) {
+				emphasised = append(emphasised, cleanText(match[2]))
+			}
+		case detailAllergens:
+			details.AllergensText = joinText(details.AllergensText, text)
+		case detailStorage:
+			details.StorageConditions = joinText(details.StorageConditions, text)
+		case detailOrigin:
+			details.Origin = joinText(details.Origin, text)
+		case detailManufacturer:
+			details.Manufacturer = joinText(details.Manufacturer, text)
+		}
+	}
+
+	details.Ingredients = ParseIngredients(details.IngredientsText)
+
+	allergens := ParseAllergens(details.AllergensText)
+	for allergen, presence := range ParseAllergens(details.IngredientsText) {
+		mergeAllergen(allergens, allergen, presence)
+	}
+	for _, text := range emphasised {
+		for allergen := range ParseAllergens(text) {
+			mergeAllergen(allergens, allergen, AllergenContains)
+		}
+	}
+	if len(allergens) > 0 {
+		details.Allergens = allergens
+	}
+	return details
+}
+
+// joinText appends text to value, separated by a space.
+func joinText(value, text string) string {
+	if value == "" {
+		return text
+	}
+	return value + " " + text
+}
+
+// mergeAllergen records an allergen; "contains" wins over "may contain".
+func mergeAllergen(allergens map[string]string, allergen, presence string) {
+	if allergens[allergen] != AllergenContains {
+		allergens[allergen] = presence
+	}
+}
+
+// ingredientsPrefix matches the heading that ingredient lists often start with.
+var ingredientsPrefix = regexp.MustCompile(`(?i)^\s*(ingredients|ingredientes|ingredients list)\s*:\s*`)
+
+// ParseIngredients splits an ingredient list into its ingredients. Commas
+// and semicolons separate ingredients except inside brackets, so that
+// compound ingredients such as "xocolata (sucre, cacau)" stay whole. The
+// list ends at the first full stop outside brackets, which leaves out
+// statements such as "Pot contenir traces de fruits de closca." that follow
+// it; a leading "Ingredients:" heading is dropped.
+func ParseIngredients(text string) []string {
+	runes := []rune(ingredientsPrefix.ReplaceAllString(text, ""))
+
+	var ingredients []string
+	var current strings.Builder
+	depth := 0
+	flush := func() {
+		if ingredient := strings.TrimSpace(current.String()); ingredient != "" {
+			ingredients = append(ingredients, ingredient)
+		}
+		current.Reset()
+	}
+
+	for i, r := range runes {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',', ';':
+			if depth == 0 {
+				flush()
+				continue
+			}
+		case '.':
+			// A full stop, unlike a decimal point, ends the text or is followed by a space
+			if depth == 0 && (i == len(runes)-1 || runes[i+1] == ' ') {
+				flush()
+				return ingredients
+			}
+		}
+		current.WriteRune(r)
+	}
+	flush()
+	return ingredients
+}
+
+// allergenKeywords are the Catalan, Spanish and English words naming each
+// allergen or a common source of it, as accent-free lowercase tokens.
+var allergenKeywords = map[string][]string{
+	AllergenGluten: {"gluten", "blat", "trigo", "wheat", "ordi", "cebada", "barley", "segol", "centeno", "rye",
+		"civada", "avena", "oats", "oat", "espelta", "spelt", "kamut", "cereals amb gluten", "cereales con gluten"},
+	AllergenCrustaceans: {"crustacis", "crustaceos", "crustaceans", "gamba", "gambes", "gambas", "llagosti", "llagostins",
+		"langostino", "langostinos", "prawn", "prawns", "shrimp", "cranc", "cangrejo", "crab", "llagosta", "langosta",
+		"lobster", "escamarla", "cigala"},
+	AllergenEggs: {"ou", "ous", "huevo", "huevos", "egg", "eggs", "ovoproductes", "ovoproductos"},
+	AllergenFish: {"peix", "peixos", "pescado", "pescados", "fish", "tonyina", "atun", "tuna", "salmo", "salmon",
+		"anxova", "anxoves", "anchoa", "anchoas", "bacalla", "bacalao", "cod", "lluc", "merluza", "sardina", "sardines"},
+	AllergenPeanuts:  {"cacauet", "cacauets", "cacahuete", "cacahuetes", "peanut", "peanuts"},
+	AllergenSoybeans: {"soja", "soia", "soy", "soya"},
+	AllergenMilk: {"llet", "leche", "milk", "lactosa", "lactose", "formatge", "formatges", "queso", "quesos", "cheese",
+		"mantega", "mantequilla", "butter", "nata", "cream", "caseina", "casein", "caseinat", "caseinato", "iogurt",
+		"yogur", "yogurt", "lactoserum", "derivats lactis", "derivados lacteos"},
+	AllergenNuts: {"fruits de closca", "frutos de cascara", "frutos secos", "fruits secs", "nuts", "ametlla", "ametlles",
+		"almendra", "almendras", "almond", "almonds", "avellana", "avellanes", "avellanas", "hazelnut", "hazelnuts",
+		"nou", "nous", "nuez", "nueces", "walnut", "walnuts", "anacard", "anacards", "anacardo", "anacardos", "cashew",
+		"cashews", "pistatxo", "pistatxos", "pistacho", "pistachos", "pistachio", "pistachios", "macadamia", "pacana",
+		"pacanes", "pecan", "pecans"},
+	AllergenCelery:  {"api", "apio", "celery"},
+	AllergenMustard: {"mostassa", "mostaza", "mustard"},
+	AllergenSesame:  {"sesam", "sesamo", "sesame"},
+	AllergenSulphites: {"sulfits", "sulfit", "sulfitos", "sulfito", "sulphites", "sulphite", "sulfites", "sulfite",
+		"dioxid de sofre", "dioxido de azufre", "sulphur dioxide", "metabisulfit", "metabisulfito"},
+	AllergenLupin: {"tramus", "tramussos", "altramuz", "altramuces", "lupin", "lupino", "lupins"},
+	AllergenMolluscs: {"mol luscs", "moluscos", "molluscs", "musclo", "musclos", "mejillon", "mejillones", "mussel",
+		"mussels", "calamar", "calamars", "calamares", "squid", "pop", "pulpo", "octopus", "sipia", "sepia", "cloissa",
+		"cloisses", "almeja", "almejas", "clam", "clams", "ostra", "ostres", "ostras", "oyster", "oysters"},
+}
+
+// allergenExceptions are phrases containing an allergen keyword that do not
+// name the allergen, such as coconut milk or cocoa butter.
+var allergenExceptions = []string{
+	"llet de coco", "leche de coco", "coconut milk", "mantega de cacau", "manteca de cacao", "cocoa butter",
+	"mantega de karite", "nou moscada", "nuez moscada", "nutmeg", "ferments lactics", "fermentos lacticos",
+	"nou de coco", "nuez de coco",
+}
+
+// mayContainTriggers introduce the allergens that a product may contain
+// through cross-contamination.
+var mayContainTriggers = []string{
+	"pot contenir", "puede contener", "may contain", "traces", "tracas", "trazas", "elaborat en una planta",
+	"elaborado en una planta", "made in a factory",
+}
+
+// freeFromWords mark a following allergen as absent ("sense gluten").
+var freeFromWords = map[string]bool{"sense": true, "sin": true, "without": true, "lliure": true, "libre": true, "free": true}
+
+// clauseSeparators split statements such as "Conté llet. Pot contenir ou".
+// Colons do not end a clause, so that the allergens listed after
+// "Pot contenir:" or "Trazas:" are still introduced by the trigger.
+var clauseSeparators = regexp.MustCompile(`[.;\n]`)
+
+// ParseAllergens finds the EU allergens named in a label text. Allergens in
+// a clause introduced by "pot contenir", "puede contener", "may contain" or
+// "traces" may be contained; others are contained, unless preceded by
+// "sense", "sin" or a similar word.
+func ParseAllergens(text string) map[string]string {
+	allergens := make(map[string]string)
+	for _, clause := range clauseSeparators.Split(text, -1) {
+		tokens := matchTokens(clause)
+		if len(tokens) == 0 {
+			continue
+		}
+		joined := " " + strings.Join(tokens, " ") + " "
+		for _, exception := range allergenExceptions {
+			joined = strings.ReplaceAll(joined, " "+exception+" ", " ")
+		}
+		tokens = strings.Fields(joined)
+
+		mayContainFrom := len(tokens)
+		for _, trigger := range mayContainTriggers {
+			if index := tokenIndex(tokens, strings.Fields(trigger)); index >= 0 && index < mayContainFrom {
+				mayContainFrom = index
+			}
+		}
+
+		for allergen, keywords := range allergenKeywords {
+			for _, keyword := range keywords {
+				keywordTokens := strings.Fields(keyword)
+				for i := 0; i+len(keywordTokens) <= len(tokens); i++ {
+					if !hasTokensAt(tokens, keywordTokens, i) {
+						continue
+					}
+					if i > 0 && freeFromWords[tokens[i-1]] || i > 1 && freeFromWords[tokens[i-2]] && tokens[i-1] == "de" {
+						continue
+					}
+					if i+len(keywordTokens) < len(tokens) && tokens[i+len(keywordTokens)] == "free" {
+						continue
+					}
+					presence := AllergenContains
+					if i >= mayContainFrom {
+						presence = AllergenMayContain
+					}
+					mergeAllergen(allergens, allergen, presence)
+				}
+			}
+		}
+	}
+	return allergens
+}
+
+// tokenIndex returns the index at which needle starts in tokens, or -1.
+func tokenIndex(tokens, needle []string) int {
+	for i := 0; i+len(needle) <= len(tokens); i++ {
+		if hasTokensAt(tokens, needle, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasTokensAt reports whether needle occurs in tokens at index i.
+func hasTokensAt(tokens, needle []string, i int) bool {
+	for j, token := range needle {
+		if tokens[i+j] != token {
+			return false
+		}
+	}
+	return true
+}
+
+// SortedAllergens returns the allergens of the details with the given
+// presence, in the order of Annex II.
+func (d ProductDetails) SortedAllergens(presence string) []string {
+	var allergens []string
+	for _, allergen := range Allergens {
+		if d.Allergens[allergen] == presence {
+			allergens = append(allergens, allergen)
+		}
+	}
+	return allergens
+}
+
+// AllergenProduct is a product with the allergens it declares, as listed by
+// allergen filters.
+type AllergenProduct struct {
+	Retailer     string   `json:"retailer"`
+	ProductID    int      `json:"product_id"`
+	ProductName  string   `json:"product_name"`
+	ProductBrand string   `json:"product_brand"`
+	Contains     []string `json:"contains"`
+	MayContain   []string `json:"may_contain"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIngredients(t *testing.T) {
+	got := ParseIngredients("Ingredients: farina de <b>blat</b> (65%), aigua, xocolata (sucre, cacau, 2.5% mantega de cacau); sal. Pot contenir traces de sèsam.")
+	want := []string{"farina de <b>blat</b> (65%)", "aigua", "xocolata (sucre, cacau, 2.5% mantega de cacau)", "sal"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseIngredients = %q, want %q", got, want)
+	}
+}
+
+func TestParseAllergens(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]string
+	}{
+		{"Conté llet i ous. Pot contenir traces de fruits de closca i sèsam.",
+			map[string]string{AllergenMilk: AllergenContains, AllergenEggs: AllergenContains,
+				AllergenNuts: AllergenMayContain, AllergenSesame: AllergenMayContain}},
+		{"Contiene: trigo, soja; puede contener trazas de cacahuetes",
+			map[string]string{AllergenGluten: AllergenContains, AllergenSoybeans: AllergenContains,
+				AllergenPeanuts: AllergenMayContain}},
+		{"Beguda de civada sense gluten, llet de coco, mantega de cacau",
+			map[string]string{AllergenGluten: AllergenContains}},
+		{"Mol·luscs i crustacis", map[string]string{AllergenMolluscs: AllergenContains, AllergenCrustaceans: AllergenContains}},
+		{"Sense gluten", map[string]string{}},
+		{"Conté: llet. Pot contenir: fruits de closca", map[string]string{AllergenMilk: AllergenContains,
+			AllergenNuts: AllergenMayContain}},
+		{"Trazas: soja", map[string]string{AllergenSoybeans: AllergenMayContain}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseAllergens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAllergens(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseProductDetails(t *testing.T) {
+	response := map[string]interface{}{
+		"bopData": map[string]interface{}{
+			"fields": []interface{}{
+				map[string]interface{}{"title": "ingredients", "content": "<b>Llet</b> sencera ecològica, ferments làctics."},
+				map[string]interface{}{"title": "allergens", "content": "Pot contenir traces de <b>soja</b> i sèsam."},
+				map[string]interface{}{"title": "storageConditions", "content": "Conservar entre 1 i 8 ºC."},
+				map[string]interface{}{"title": "countryOfOrigin", "content": "Espanya"},
+				map[string]interface{}{"title": "manufacturer", "content": "Cooperativa Lletera, Vic"},
+			},
+		},
+	}
+
+	details := ParseProductDetails(ParseProductAttributesFromResponse(response))
+	want := ProductDetails{
+		IngredientsText: "Llet sencera ecològica, ferments làctics.",
+		Ingredients:     []string{"Llet sencera ecològica", "ferments làctics"},
+		AllergensText:   "Pot contenir traces de soja i sèsam.",
+		Allergens: map[string]string{AllergenMilk: AllergenContains, AllergenSoybeans: AllergenMayContain,
+			AllergenSesame: AllergenMayContain},
+		StorageConditions: "Conservar entre 1 i 8 ºC.",
+		Origin:            "Espanya",
+		Manufacturer:      "Cooperativa Lletera, Vic",
+	}
+	if !reflect.DeepEqual(details, want) {
+		t.Errorf("ParseProductDetails =\n%+v\nwant\n%+v", details, want)
+	}
+	if got := details.SortedAllergens(AllergenContains); !reflect.DeepEqual(got, []string{AllergenMilk}) {
+		t.Errorf("SortedAllergens(contains) = %v", got)
+	}
+}
@@ -0,0 +1,300 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Methods by which two products were matched.
+const (
+	MatchMethodGTIN  = "gtin"  // the products share a barcode
+	MatchMethodFuzzy = "fuzzy" // same brand and pack size, and similar names
+)
+
+// Statuses of a product match. Accepted and pending matches are recomputed
+// by every matching run; approved and rejected ones were reviewed and are kept.
+const (
+	MatchStatusAccepted = "accepted" // confident enough to be used without review
+	MatchStatusPending  = "pending"  // low confidence, waiting in the review queue
+	MatchStatusApproved = "approved" // confirmed by a reviewer
+	MatchStatusRejected = "rejected" // refused by a reviewer, never proposed again
+)
+
+// MatchStatuses lists every match status.
+var MatchStatuses = []string{MatchStatusAccepted, MatchStatusPending, MatchStatusApproved, MatchStatusRejected}
+
+// ProductMatch links a product of one retailer to the same product of
+// another. Left is always the retailer whose name sorts first, so that a
+// pair of products has a single match. Score is 1 for GTIN matches and the
+// name similarity, between 0 and 1, for fuzzy ones.
+type ProductMatch struct {
+	ID               int64      `json:"id,omitempty"`
+	LeftRetailer     string     `json:"left_retailer"`
+	LeftProductID    int        `json:"left_product_id"`
+	RightRetailer    string     `json:"right_retailer"`
+	RightProductID   int        `json:"right_product_id"`
+	Method           string     `json:"method"`
+	Score            float64    `json:"score"`
+	Status           string     `json:"status"`
+	LeftProductName  string     `json:"left_product_name,omitempty"`
+	RightProductName string     `json:"right_product_name,omitempty"`
+	LeftPriceAmount  float64    `json:"left_price_amount,omitempty"`
+	RightPriceAmount float64    `json:"right_price_amount,omitempty"`
+	MatchedAt        time.Time  `json:"matched_at"`
+	ReviewedAt       *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// Left returns the key of the left product.
+func (m ProductMatch) Left() ProductKey {
+	return ProductKey{Retailer: m.LeftRetailer, ProductID: m.LeftProductID}
+}
+
+// Right returns the key of the right product.
+func (m ProductMatch) Right() ProductKey {
+	return ProductKey{Retailer: m.RightRetailer, ProductID: m.RightProductID}
+}
+
+// MatchOptions are the score thresholds of fuzzy matches. Matches scoring at
+// least AcceptScore are accepted; those scoring at least ReviewScore go to
+// the review queue, and lower ones are dropped.
+type MatchOptions struct {
+	AcceptScore float64
+	ReviewScore float64
+}
+
+// DefaultMatchOptions are the thresholds used when none are given.
+var DefaultMatchOptions = MatchOptions{AcceptScore: 0.9, ReviewScore: 0.6}
+
+// unknownPackSizePenalty scales the score of fuzzy matches between products
+// whose pack size is unknown, since the pack size could not confirm them.
+const unknownPackSizePenalty = 0.9
+
+// MatchProducts links the products of two retailers one to one. Products
+// sharing a GTIN are matched first. The others are matched when their
+// normalised brands and pack sizes are equal and their names are similar,
+// unless both have GTINs, which then tell them apart. Reviewed matches
+// constrain the result: products of approved matches are not matched again
+// and rejected pairs are never proposed. Among competing candidates the
+// highest scores win. The returned matches are new; reviewed ones are not
+// included.
+func MatchProducts(left, right []Product, reviewed []ProductMatch, options MatchOptions) []ProductMatch {
+	if len(left) > 0 && len(right) > 0 && left[0].Retailer > right[0].Retailer {
+		left, right = right, left
+	}
+
+	used := make(map[ProductKey]bool)
+	rejected := make(map[[2]ProductKey]bool)
+	for _, match := range reviewed {
+		switch match.Status {
+		case MatchStatusApproved:
+			used[match.Left()] = true
+			used[match.Right()] = true
+		case MatchStatusRejected:
+			rejected[[2]ProductKey{match.Left(), match.Right()}] = true
+		}
+	}
+
+	byGTIN := make(map[string][]int)
+	byBlock := make(map[string][]int)
+	for i, product := range right {
+		for _, gtin := range product.ProductGTINs {
+			byGTIN[gtin] = append(byGTIN[gtin], i)
+		}
+		if key, ok := matchBlockKey(product); ok {
+			byBlock[key] = append(byBlock[key], i)
+		}
+	}
+
+	var candidates []ProductMatch
+	for _, l := range left {
+		seen := make(map[int]bool)
+		for _, gtin := range l.ProductGTINs {
+			for _, i := range byGTIN[gtin] {
+				if !seen[i] {
+					seen[i] = true
+					candidates = append(candidates, newProductMatch(l, right[i], MatchMethodGTIN, 1, MatchStatusAccepted))
+				}
+			}
+		}
+
+		key, ok := matchBlockKey(l)
+		if !ok {
+			continue
+		}
+		for _, i := range byBlock[key] {
+			r := right[i]
+			if seen[i] || (len(l.ProductGTINs) > 0 && len(r.ProductGTINs) > 0) {
+				continue
+			}
+			score := NameSimilarity(l, r)
+			if l.PackSize.NetQuantity <= 0 {
+				score *= unknownPackSizePenalty
+			}
+			score = math.Round(score*10000) / 10000
+			switch {
+			case score >= options.AcceptScore:
+				candidates = append(candidates, newProductMatch(l, r, MatchMethodFuzzy, score, MatchStatusAccepted))
+			case score >= options.ReviewScore:
+				candidates = append(candidates, newProductMatch(l, r, MatchMethodFuzzy, score, MatchStatusPending))
+			}
+		}
+	}
+
+	// GTIN matches first, then by descending score; keys break ties
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.Method == MatchMethodGTIN) != (b.Method == MatchMethodGTIN) {
+			return a.Method == MatchMethodGTIN
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.LeftProductID != b.LeftProductID {
+			return a.LeftProductID < b.LeftProductID
+		}
+		return a.RightProductID < b.RightProductID
+	})
+
+	var matches []ProductMatch
+	for _, match := range candidates {
+		if used[match.Left()] || used[match.Right()] || rejected[[2]ProductKey{match.Left(), match.Right()}] {
+			continue
+		}
+		used[match.Left()] = true
+		used[match.Right()] = true
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// newProductMatch returns a match between two products.
+func newProductMatch(left, right Product, method string, score float64, status string) ProductMatch {
+	return ProductMatch{
+		LeftRetailer:     left.Retailer,
+		LeftProductID:    left.ProductID,
+		RightRetailer:    right.Retailer,
+		RightProductID:   right.ProductID,
+		Method:           method,
+		Score:            score,
+		Status:           status,
+		LeftProductName:  left.ProductName,
+		RightProductName: right.ProductName,
+		LeftPriceAmount:  left.ProductPriceAmount,
+		RightPriceAmount: right.ProductPriceAmount,
+	}
+}
+
+// matchBlockKey groups the products that may fuzzy match: those with the
+// same normalised brand and net quantity. Products without a brand are not
+// fuzzy matched.
+func matchBlockKey(product Product) (string, bool) {
+	brand := strings.Join(matchTokens(product.ProductBrand), " ")
+	if brand == "" {
+		return "", false
+	}
+	if product.PackSize.NetQuantity <= 0 {
+		return brand + "|", true
+	}
+	return brand + "|" + formatQuantity(product.PackSize.NetQuantity) + " " + product.PackSize.BaseUnit, true
+}
+
+// formatQuantity rounds a net quantity to a tenth, so that float noise does
+// not split a block.
+func formatQuantity(quantity float64) string {
+	return strconv.FormatFloat(math.Round(quantity*10)/10, 'f', -1, 64)
+}
+
+// NameSimilarity scores how alike the names of two products are, from 0 to
+// 1, as the Dice coefficient of their normalised name tokens. Brand words
+// and pack size words are left out, since they are compared separately.
+func NameSimilarity(a, b Product) float64 {
+	tokensA := nameTokens(a)
+	tokensB := nameTokens(b)
+	if len(tokensA) == 0 || len(tokensB) == 0 {
+		return 0
+	}
+
+	counts := make(map[string]int, len(tokensA))
+	for _, token := range tokensA {
+		counts[token]++
+	}
+	common := 0
+	for _, token := range tokensB {
+		if counts[token] > 0 {
+			counts[token]--
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(tokensA)+len(tokensB))
+}
+
+// nameTokens returns the words of a product name that identify it.
+func nameTokens(product Product) []string {
+	brand := make(map[string]bool)
+	for _, token := range matchTokens(product.ProductBrand) {
+		brand[token] = true
+	}
+
+	var tokens []string
+	for _, token := range matchTokens(product.ProductName) {
+		if brand[token] || matchStopWords[token] || isPackSizeToken(token) {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// matchStopWords are Catalan and Spanish words that carry no meaning in a
+// product name.
+var matchStopWords = map[string]bool{
+	"a": true, "amb": true, "al": true, "con": true, "d": true, "de": true, "del": true,
+	"el": true, "els": true, "en": true, "i": true, "l": true, "la": true, "las": true,
+	"les": true, "los": true, "per": true, "por": true, "sense": true, "sin": true, "y": true,
+	"pack": true, "x": true,
+}
+
+// isPackSizeToken reports whether a token is a number or a unit of a pack size.
+func isPackSizeToken(token string) bool {
+	if _, ok := measureUnits[token]; ok {
+		return true
+	}
+	for _, unit := range strings.Split(countUnits, "|") {
+		if token == unit {
+			return true
+		}
+	}
+	for _, r := range token {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTokens lowercases text, removes accents and splits it into words.
+func matchTokens(text string) []string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(cleanText(text)) {
+		if folded, ok := accentFolding[r]; ok {
+			r = folded
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune(' ')
+		}
+	}
+	return strings.Fields(builder.String())
+}
+
+// accentFolding maps the accented letters of Catalan and Spanish to their
+// base letter.
+var accentFolding = map[rune]rune{
+	'à': 'a', 'á': 'a', 'è': 'e', 'é': 'e', 'í': 'i', 'ï': 'i', 'ò': 'o',
+	'ó': 'o', 'ú': 'u', 'ü': 'u', 'ç': 'c', 'ñ': 'n',
+}
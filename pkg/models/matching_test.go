@@ -0,0 +1,66 @@
+package models
+
+import "testing"
+
+func TestMatchProducts(t *testing.T) {
+	litre := PackSize{NetQuantity: 1000, BaseUnit: BaseUnitMillilitre}
+	bonpreu := []Product{
+		{Retailer: RetailerBonpreu, ProductID: 1, ProductName: "Llet sencera Pascual 1 l", ProductBrand: "Pascual", PackSize: litre, ProductGTINs: []string{"8410076472885"}},
+		{Retailer: RetailerBonpreu, ProductID: 2, ProductName: "Oli d'oliva verge extra", ProductBrand: "Borges", PackSize: litre},
+		{Retailer: RetailerBonpreu, ProductID: 3, ProductName: "Suc de taronja natural", ProductBrand: "Don Simón", PackSize: litre},
+		{Retailer: RetailerBonpreu, ProductID: 4, ProductName: "Aigua mineral", ProductBrand: "Font Vella", PackSize: litre, ProductGTINs: []string{"8410076472886"}},
+	}
+	esclat := []Product{
+		{Retailer: RetailerEsclat, ProductID: 11, ProductName: "Llet sencera", ProductBrand: "PASCUAL", PackSize: litre, ProductGTINs: []string{"8410076472885"}},
+		{Retailer: RetailerEsclat, ProductID: 12, ProductName: "Oli d'oliva verge extra Borges", ProductBrand: "Borges", PackSize: litre},
+		{Retailer: RetailerEsclat, ProductID: 13, ProductName: "Suc de taronja amb polpa", ProductBrand: "Don Simon", PackSize: litre},
+		{Retailer: RetailerEsclat, ProductID: 14, ProductName: "Aigua mineral", ProductBrand: "Font Vella", PackSize: litre, ProductGTINs: []string{"4006381333931"}},
+		{Retailer: RetailerEsclat, ProductID: 15, ProductName: "Oli d'oliva verge extra", ProductBrand: "Borges", PackSize: PackSize{NetQuantity: 500, BaseUnit: BaseUnitMillilitre}},
+	}
+
+	// Arguments in either order give the same orientation
+	matches := MatchProducts(esclat, bonpreu, nil, DefaultMatchOptions)
+
+	want := []struct {
+		left, right int
+		method      string
+		status      string
+	}{
+		{1, 11, MatchMethodGTIN, MatchStatusAccepted},
+		{2, 12, MatchMethodFuzzy, MatchStatusAccepted},
+		{3, 13, MatchMethodFuzzy, MatchStatusPending},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for i, w := range want {
+		m := matches[i]
+		if m.LeftRetailer != RetailerBonpreu || m.LeftProductID != w.left || m.RightProductID != w.right ||
+			m.Method != w.method || m.Status != w.status {
+			t.Errorf("match %d = %+v, want %d-%d %s %s", i, m, w.left, w.right, w.method, w.status)
+		}
+	}
+
+	// A rejected pair is not proposed again, an approved one is not recomputed
+	reviewed := []ProductMatch{
+		{LeftRetailer: RetailerBonpreu, LeftProductID: 3, RightRetailer: RetailerEsclat, RightProductID: 13, Status: MatchStatusRejected},
+		{LeftRetailer: RetailerBonpreu, LeftProductID: 2, RightRetailer: RetailerEsclat, RightProductID: 12, Status: MatchStatusApproved},
+	}
+	matches = MatchProducts(bonpreu, esclat, reviewed, DefaultMatchOptions)
+	if len(matches) != 1 || matches[0].LeftProductID != 1 {
+		t.Errorf("with reviews got %+v, want only the GTIN match", matches)
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	a := Product{ProductName: "Iogurt natural Danone 4 x 125 g", ProductBrand: "Danone"}
+	b := Product{ProductName: "IOGURT NATURAL pack 4 u.", ProductBrand: "Danone"}
+	if got := NameSimilarity(a, b); got != 1 {
+		t.Errorf("NameSimilarity = %v, want 1", got)
+	}
+
+	c := Product{ProductName: "Iogurt grec", ProductBrand: "Danone"}
+	if got := NameSimilarity(a, c); got != 0.5 {
+		t.Errorf("NameSimilarity = %v, want 0.5", got)
+	}
+}
@@ -0,0 +1,466 @@
+package models
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nutrients used by the Nutri-Score, as named in diagnostics and exports.
+const (
+	NutrientEnergy       = "energy"
+	NutrientFat          = "fat"
+	NutrientSaturatedFat = "saturated_fat"
+	NutrientSugars       = "sugars"
+	NutrientFibre        = "fibre"
+	NutrientProtein      = "protein"
+	NutrientSalt         = "salt"
+)
+
+// Nutri-Score categories, each with its own thresholds.
+const (
+	NutriScoreGeneral  = "general"
+	NutriScoreCheese   = "cheese"
+	NutriScoreFats     = "fats"
+	NutriScoreBeverage = "beverage"
+	NutriScoreWater    = "water"
+	NutriScoreAlcohol  = "alcohol"
+)
+
+// Nutri-Score statuses. Products with missing inputs get no grade, and
+// alcoholic beverages are out of the Nutri-Score's scope.
+const (
+	NutriScoreGraded        = "graded"
+	NutriScoreMissingInputs = "missing_inputs"
+	NutriScoreNotApplicable = "not_applicable"
+)
+
+// NutriScoreGrades lists the Nutri-Score grades, best first.
+var NutriScoreGrades = []string{"A", "B", "C", "D", "E"}
+
+// NutritionFacts holds the nutrients of a product per 100 g or 100 ml,
+// parsed from its nutrition table. Energy is in kJ and the rest in grams;
+// nil means the nutrient is not declared.
+type NutritionFacts struct {
+	EnergyKJ     *float64 `json:"energy_kj,omitempty"`
+	Fat          *float64 `json:"fat,omitempty"`
+	SaturatedFat *float64 `json:"saturated_fat,omitempty"`
+	Sugars       *float64 `json:"sugars,omitempty"`
+	Fibre        *float64 `json:"fibre,omitempty"`
+	Protein      *float64 `json:"protein,omitempty"`
+	Salt         *float64 `json:"salt,omitempty"`
+}
+
+// Value returns the amount of a nutrient, one of the Nutrient* values.
+func (f NutritionFacts) Value(nutrient string) (float64, bool) {
+	var value *float64
+	switch nutrient {
+	case NutrientEnergy:
+		value = f.EnergyKJ
+	case NutrientFat:
+		value = f.Fat
+	case NutrientSaturatedFat:
+		value = f.SaturatedFat
+	case NutrientSugars:
+		value = f.Sugars
+	case NutrientFibre:
+		value = f.Fibre
+	case NutrientProtein:
+		value = f.Protein
+	case NutrientSalt:
+		value = f.Salt
+	}
+	if value == nil {
+		return 0, false
+	}
+	return *value, true
+}
+
+// NutriScore is the Nutri-Score of a product with the 2023 algorithm.
+// Score is the negative points minus the positive points; lower is better.
+// Missing lists the inputs whose absence prevents a grade, and Assumed the
+// optional ones counted as zero. The share of fruits, vegetables and legumes
+// is not published by the shops and is always counted as zero.
+type NutriScore struct {
+	Status         string         `json:"status"`
+	Category       string         `json:"category"`
+	Grade          string         `json:"grade,omitempty"`
+	Score          *int           `json:"score,omitempty"`
+	NegativePoints int            `json:"negative_points"`
+	PositivePoints int            `json:"positive_points"`
+	Missing        []string       `json:"missing,omitempty"`
+	Assumed        []string       `json:"assumed,omitempty"`
+	Facts          NutritionFacts `json:"facts"`
+}
+
+// NutriScoreProduct is a product with its stored Nutri-Score.
+type NutriScoreProduct struct {
+	Retailer     string     `json:"retailer"`
+	ProductID    int        `json:"product_id"`
+	ProductName  string     `json:"product_name"`
+	ProductBrand string     `json:"product_brand,omitempty"`
+	NutriScore   NutriScore `json:"nutri_score"`
+	ComputedAt   time.Time  `json:"computed_at"`
+}
+
+// Points thresholds of the 2023 algorithm: a component scores one point for
+// every threshold its value exceeds.
+var (
+	energyThresholds          = []float64{335, 670, 1005, 1340, 1675, 2010, 2345, 2680, 3015, 3350}
+	sugarsThresholds          = []float64{3.4, 6.8, 10, 14, 17, 20, 24, 27, 31, 34, 37, 41, 44, 48, 51}
+	saturatedFatThresholds    = []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	saltThresholds            = []float64{0.2, 0.4, 0.6, 0.8, 1, 1.2, 1.4, 1.6, 1.8, 2, 2.2, 2.4, 2.6, 2.8, 3, 3.2, 3.4, 3.6, 3.8, 4}
+	proteinThresholds         = []float64{2.4, 4.8, 7.2, 9.6, 12, 14, 17}
+	fibreThresholds           = []float64{3, 4.1, 5.2, 6.3, 7.4}
+	saturatedEnergyThresholds = []float64{120, 240, 360, 480, 600, 720, 840, 960, 1080, 1200}
+	saturatedRatioThresholds  = []float64{10, 16, 22, 28, 34, 40, 46, 52, 58, 64}
+	beverageEnergyThresholds  = []float64{30, 90, 150, 210, 240, 270, 300, 330, 360, 390}
+	beverageSugarsThresholds  = []float64{0.5, 2, 3.5, 5, 6, 7, 8, 9, 10, 11}
+	beverageProteinThresholds = []float64{1.2, 1.5, 1.8, 2.1, 2.4, 2.7, 3}
+)
+
+// sweetenerPoints are the negative points of beverages with non-nutritive sweeteners.
+const sweetenerPoints = 4
+
+// ComputeNutriScore computes the Nutri-Score of a product from its
+// nutrition facts, with the thresholds of its category as inferred by
+// InferNutriScoreCategory.
+func ComputeNutriScore(product Product, facts NutritionFacts) NutriScore {
+	nutriScore := NutriScore{Category: InferNutriScoreCategory(product, facts), Facts: facts}
+
+	switch nutriScore.Category {
+	case NutriScoreAlcohol:
+		nutriScore.Status = NutriScoreNotApplicable
+		return nutriScore
+	case NutriScoreWater:
+		nutriScore.Status = NutriScoreGraded
+		nutriScore.Grade = "A"
+		nutriScore.Score = new(int)
+		return nutriScore
+	}
+
+	required := []string{NutrientEnergy, NutrientSugars, NutrientSaturatedFat, NutrientSalt, NutrientProtein}
+	if nutriScore.Category == NutriScoreFats {
+		required = []string{NutrientFat, NutrientSaturatedFat, NutrientSugars, NutrientSalt, NutrientProtein}
+	}
+	for _, nutrient := range required {
+		if _, ok := facts.Value(nutrient); !ok {
+			nutriScore.Missing = append(nutriScore.Missing, nutrient)
+		}
+	}
+	if _, ok := facts.Value(NutrientFibre); !ok {
+		nutriScore.Assumed = append(nutriScore.Assumed, NutrientFibre)
+	}
+	if len(nutriScore.Missing) > 0 {
+		nutriScore.Status = NutriScoreMissingInputs
+		return nutriScore
+	}
+
+	value := func(nutrient string) float64 {
+		amount, _ := facts.Value(nutrient)
+		return amount
+	}
+	fibre := points(value(NutrientFibre), fibreThresholds)
+	salt := points(value(NutrientSalt), saltThresholds)
+	saturatedFat := points(value(NutrientSaturatedFat), saturatedFatThresholds)
+
+	var negative, protein int
+	proteinCounts := true
+	switch nutriScore.Category {
+	case NutriScoreBeverage:
+		negative = points(value(NutrientEnergy), beverageEnergyThresholds) +
+			points(value(NutrientSugars), beverageSugarsThresholds) + saturatedFat + salt
+		if hasSweeteners(product) {
+			negative += sweetenerPoints
+		}
+		protein = points(value(NutrientProtein), beverageProteinThresholds)
+	case NutriScoreFats:
+		var ratio float64
+		if fat := value(NutrientFat); fat > 0 {
+			ratio = value(NutrientSaturatedFat) / fat * 100
+		}
+		negative = points(value(NutrientSaturatedFat)*37, saturatedEnergyThresholds) +
+			points(value(NutrientSugars), sugarsThresholds) + points(ratio, saturatedRatioThresholds) + salt
+		protein = points(value(NutrientProtein), proteinThresholds)
+		proteinCounts = negative < 7
+	default:
+		negative = points(value(NutrientEnergy), energyThresholds) +
+			points(value(NutrientSugars), sugarsThresholds) + saturatedFat + salt
+		protein = points(value(NutrientProtein), proteinThresholds)
+		proteinCounts = negative < 11 || nutriScore.Category == NutriScoreCheese
+	}
+
+	positive := fibre
+	if proteinCounts {
+		positive += protein
+	}
+	score := negative - positive
+
+	nutriScore.Status = NutriScoreGraded
+	nutriScore.NegativePoints = negative
+	nutriScore.PositivePoints = positive
+	nutriScore.Score = &score
+	nutriScore.Grade = nutriScoreGrade(nutriScore.Category, score)
+	return nutriScore
+}
+
+// points returns the number of thresholds that value exceeds.
+func points(value float64, thresholds []float64) int {
+	count := 0
+	for _, threshold := range thresholds {
+		if value > threshold {
+			count++
+		}
+	}
+	return count
+}
+
+// nutriScoreGrade returns the grade of a score in a category.
+func nutriScoreGrade(category string, score int) string {
+	var limits []int // upper score of grades A to D
+	switch category {
+	case NutriScoreBeverage:
+		limits = []int{math.MinInt, 2, 6, 9} // only water is graded A
+	case NutriScoreFats:
+		limits = []int{-6, 2, 10, 18}
+	default:
+		limits = []int{0, 2, 10, 18}
+	}
+	for i, limit := range limits {
+		if score <= limit {
+			return NutriScoreGrades[i]
+		}
+	}
+	return NutriScoreGrades[len(NutriScoreGrades)-1]
+}
+
+// Category keywords, matched against the accent-folded words of any level of
+// a product's category path.
+var (
+	cheeseCategoryWords   = []string{"formatge", "formatges", "queso", "quesos", "cheese", "cheeses"}
+	fatsCategoryWords     = []string{"oli", "olis", "aceite", "aceites", "mantega", "mantegues", "mantequilla", "margarina", "margarines", "margarinas", "llavors", "semillas", "fruits secs", "frutos secos", "nuts"}
+	beverageCategoryWords = []string{"begudes", "beguda", "bebidas", "bebida", "refrescos", "sucs", "zumos", "llet", "llets", "leche", "leches", "aigua", "aigues", "agua", "aguas", "drinks"}
+	waterCategoryWords    = []string{"aigua", "aigues", "agua", "aguas", "water"}
+)
+
+// InferNutriScoreCategory infers the Nutri-Score category of a product from
+// its category path: cheese, fats, oils, nuts and seeds, beverages (milk
+// included), plain water without energy or sugars, and alcoholic beverages,
+// which have no Nutri-Score. Everything else is a general food.
+func InferNutriScoreCategory(product Product, facts NutritionFacts) string {
+	if product.ProductAlcohol {
+		return NutriScoreAlcohol
+	}
+
+	words := " " + strings.Join(matchTokens(strings.Join(product.ProductCategories, " ")), " ") + " "
+	hasAny := func(keywords []string) bool {
+		for _, keyword := range keywords {
+			if strings.Contains(words, " "+keyword+" ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case hasAny(cheeseCategoryWords):
+		return NutriScoreCheese
+	case hasAny(fatsCategoryWords):
+		return NutriScoreFats
+	case hasAny(beverageCategoryWords):
+		energy, _ := facts.Value(NutrientEnergy)
+		sugars, _ := facts.Value(NutrientSugars)
+		if hasAny(waterCategoryWords) && energy == 0 && sugars == 0 && !hasSweeteners(product) {
+			return NutriScoreWater
+		}
+		return NutriScoreBeverage
+	default:
+		return NutriScoreGeneral
+	}
+}
+
+// sweetenerWords are the non-nutritive sweeteners looked for in ingredient
+// lists, by name prefix or E number.
+var sweetenerWords = []string{
+	"aspartam", "acesulfam", "sucralos", "sacarin", "ciclamat", "esteviol", "stevia", "neotam", "advantam",
+	"e950", "e951", "e952", "e954", "e955", "e960", "e961", "e962", "e969",
+}
+
+// hasSweeteners reports whether the ingredients of a product name a
+// non-nutritive sweetener.
+func hasSweeteners(product Product) bool {
+	for _, word := range matchTokens(product.Details.IngredientsText) {
+		for _, sweetener := range sweetenerWords {
+			if strings.HasPrefix(word, sweetener) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Nutrient names in Catalan, Spanish and English, matched as word prefixes
+// against the accent-folded nutrient names of a nutrition table. Names with
+// an ignored word, such as monounsaturated fat or starch, are skipped.
+var (
+	ignoredNutrientWords = []string{"monoinsat", "poliinsat", "insatur", "monounsat", "polyunsat", "unsatur", "trans", "polial", "polyol", "mido", "almidon", "starch", "colesterol", "cholesterol"}
+	nutrientWords        = []struct {
+		nutrient string
+		words    []string
+	}{
+		{NutrientSaturatedFat, []string{"satura"}},
+		{NutrientSugars, []string{"sucre", "azucar", "sugar"}},
+		{NutrientFibre, []string{"fibra", "fibre", "fiber"}},
+		{NutrientProtein, []string{"prote"}},
+		{NutrientSalt, []string{"sal", "salt"}},
+		{"sodium", []string{"sodi", "sodium"}},
+		{NutrientEnergy, []string{"energ", "kj", "kcal"}},
+		{NutrientFat, []string{"greix", "gras", "fat", "lipid"}},
+	}
+)
+
+// Patterns of the amounts in a nutrition table.
+var (
+	amountPattern = regexp.MustCompile(`(\d+(?:[.,]\d+)?)\s*(mg|g)?\b`)
+	kjPattern     = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*kj`)
+	kcalPattern   = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*kcal`)
+)
+
+// kcalToKJ converts kilocalories to kilojoules, and sodiumToSalt sodium to
+// its equivalent in salt.
+const (
+	kcalToKJ     = 4.184
+	sodiumToSalt = 2.5
+)
+
+// ParseNutritionFacts reads the nutrition facts from the rows of a nutrition
+// table. Energy is read in kJ, or converted from kcal; salt is derived from
+// sodium when only sodium is declared; amounts in mg are converted to grams,
+// and "traces" count as zero. The first row of each nutrient wins.
+func ParseNutritionFacts(data []ProductNutritionalData) NutritionFacts {
+	var facts NutritionFacts
+	var sodium, energyKcal *float64
+
+	for _, row := range data {
+		nutrient := nutrientOf(row.ProductNutritionalValue)
+		quantity := row.ProductNutritionalQuantity
+		switch nutrient {
+		case "":
+			continue
+		case NutrientEnergy:
+			name := strings.ToLower(row.ProductNutritionalValue)
+			if amount, ok := parseUnitAmount(kjPattern, quantity); ok && facts.EnergyKJ == nil {
+				facts.EnergyKJ = &amount
+			} else if strings.Contains(name, "kj") && facts.EnergyKJ == nil {
+				if amount, ok := parseNutrientAmount(quantity); ok {
+					facts.EnergyKJ = &amount
+				}
+			}
+			if amount, ok := parseUnitAmount(kcalPattern, quantity); ok && energyKcal == nil {
+				energyKcal = &amount
+			} else if strings.Contains(name, "kcal") && energyKcal == nil {
+				if amount, ok := parseNutrientAmount(quantity); ok {
+					energyKcal = &amount
+				}
+			}
+			continue
+		}
+
+		amount, ok := parseNutrientAmount(quantity)
+		if !ok {
+			continue
+		}
+		var field **float64
+		switch nutrient {
+		case NutrientFat:
+			field = &facts.Fat
+		case NutrientSaturatedFat:
+			field = &facts.SaturatedFat
+		case NutrientSugars:
+			field = &facts.Sugars
+		case NutrientFibre:
+			field = &facts.Fibre
+		case NutrientProtein:
+			field = &facts.Protein
+		case NutrientSalt:
+			field = &facts.Salt
+		case "sodium":
+			field = &sodium
+		}
+		if *field == nil {
+			*field = &amount
+		}
+	}
+
+	if facts.EnergyKJ == nil && energyKcal != nil {
+		energy := *energyKcal * kcalToKJ
+		facts.EnergyKJ = &energy
+	}
+	if facts.Salt == nil && sodium != nil {
+		salt := *sodium * sodiumToSalt
+		facts.Salt = &salt
+	}
+	return facts
+}
+
+// nutrientOf returns the nutrient a nutrition table row declares, or "" when
+// it is not used by the Nutri-Score.
+func nutrientOf(name string) string {
+	words := matchTokens(name)
+	for _, word := range words {
+		for _, ignored := range ignoredNutrientWords {
+			if strings.HasPrefix(word, ignored) {
+				return ""
+			}
+		}
+	}
+	for _, candidate := range nutrientWords {
+		for _, word := range words {
+			for _, prefix := range candidate.words {
+				// Short names must match whole words, so that "sal" does not match "saludable"
+				if word == prefix || (len(prefix) > 3 && strings.HasPrefix(word, prefix)) {
+					return candidate.nutrient
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// parseNutrientAmount reads an amount in grams such as "3,5 g", "<0,5 g" or
+// "400 mg". Traces count as zero.
+func parseNutrientAmount(text string) (float64, bool) {
+	text = strings.ToLower(cleanText(text))
+	for _, traces := range []string{"traces", "traça", "traza", "trazas"} {
+		if strings.Contains(text, traces) {
+			return 0, true
+		}
+	}
+	match := amountPattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, false
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(match[1], ",", "."), 64)
+	if err != nil {
+		return 0, false
+	}
+	if match[2] == "mg" {
+		amount /= 1000
+	}
+	return amount, true
+}
+
+// parseUnitAmount reads the amount before the unit matched by pattern.
+func parseUnitAmount(pattern *regexp.Regexp, text string) (float64, bool) {
+	match := pattern.FindStringSubmatch(cleanText(text))
+	if match == nil {
+		return 0, false
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(match[1], ",", "."), 64)
+	if err != nil {
+		return 0, false
+	}
+	return amount, true
+}
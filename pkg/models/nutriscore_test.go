@@ -0,0 +1,133 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// nutritionRows builds nutrition table rows from name and quantity pairs.
+func nutritionRows(pairs ...string) []ProductNutritionalData {
+	var rows []ProductNutritionalData
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rows = append(rows, ProductNutritionalData{ProductNutritionalValue: pairs[i], ProductNutritionalQuantity: pairs[i+1]})
+	}
+	return rows
+}
+
+func TestParseNutritionFacts(t *testing.T) {
+	facts := ParseNutritionFacts(nutritionRows(
+		"Valor energètic", "276 kJ / 66 kcal",
+		"Greixos", "3,5 g",
+		"dels quals saturats", "2,3 g",
+		"dels quals monoinsaturats", "0,9 g",
+		"Hidrats de carboni", "4,7 g",
+		"dels quals sucres", "<0,5 g",
+		"Fibra alimentària", "traces",
+		"Proteïnes", "3,9 g",
+		"Sodi", "400 mg",
+	))
+
+	want := map[string]float64{
+		NutrientEnergy:       276,
+		NutrientFat:          3.5,
+		NutrientSaturatedFat: 2.3,
+		NutrientSugars:       0.5,
+		NutrientFibre:        0,
+		NutrientProtein:      3.9,
+		NutrientSalt:         1,
+	}
+	for nutrient, amount := range want {
+		if got, ok := facts.Value(nutrient); !ok || math.Abs(got-amount) > 1e-9 {
+			t.Errorf("%s = %v, %v, want %v", nutrient, got, ok, amount)
+		}
+	}
+
+	facts = ParseNutritionFacts(nutritionRows("Valor energético (kcal)", "100", "Grasas saturadas", "1 g"))
+	if energy, ok := facts.Value(NutrientEnergy); !ok || math.Abs(energy-418.4) > 1e-9 {
+		t.Errorf("energy from kcal = %v, %v, want 418.4", energy, ok)
+	}
+	if _, ok := facts.Value(NutrientFat); ok {
+		t.Error("saturated fat was read as fat")
+	}
+}
+
+func TestComputeNutriScore(t *testing.T) {
+	amount := func(value float64) *float64 { return &value }
+
+	tests := []struct {
+		name         string
+		product      Product
+		facts        NutritionFacts
+		wantCategory string
+		wantStatus   string
+		wantGrade    string
+		wantScore    int
+		wantMissing  []string
+	}{
+		{
+			name:    "yogurt",
+			product: Product{ProductCategories: []string{"Frescos", "Làctics", "Iogurts"}},
+			facts: NutritionFacts{EnergyKJ: amount(276), SaturatedFat: amount(2.3), Sugars: amount(4.7),
+				Protein: amount(3.9), Salt: amount(0.13)},
+			wantCategory: NutriScoreGeneral, wantStatus: NutriScoreGraded, wantGrade: "B", wantScore: 2,
+		},
+		{
+			name: "sweetened soft drink",
+			product: Product{
+				ProductCategories: []string{"Begudes", "Refrescos"},
+				Details:           ProductDetails{IngredientsText: "Aigua carbonatada, edulcorants (aspartam, acesulfam K)"},
+			},
+			facts: NutritionFacts{EnergyKJ: amount(1), SaturatedFat: amount(0), Sugars: amount(0),
+				Protein: amount(0), Salt: amount(0.02)},
+			wantCategory: NutriScoreBeverage, wantStatus: NutriScoreGraded, wantGrade: "C", wantScore: 4,
+		},
+		{
+			name:    "olive oil",
+			product: Product{ProductCategories: []string{"Rebost", "Olis i vinagres"}},
+			facts: NutritionFacts{EnergyKJ: amount(3700), Fat: amount(100), SaturatedFat: amount(14),
+				Sugars: amount(0), Protein: amount(0), Salt: amount(0)},
+			wantCategory: NutriScoreFats, wantStatus: NutriScoreGraded, wantGrade: "C", wantScore: 5,
+		},
+		{
+			name:    "cheese counts protein despite many negative points",
+			product: Product{ProductCategories: []string{"Frescos", "Formatges"}},
+			facts: NutritionFacts{EnergyKJ: amount(1680), SaturatedFat: amount(20), Sugars: amount(0.5),
+				Protein: amount(25), Fibre: amount(0), Salt: amount(1.7)},
+			wantCategory: NutriScoreCheese, wantStatus: NutriScoreGraded, wantGrade: "D", wantScore: 16,
+		},
+		{
+			name:         "plain water",
+			product:      Product{ProductCategories: []string{"Begudes", "Aigües"}},
+			wantCategory: NutriScoreWater, wantStatus: NutriScoreGraded, wantGrade: "A",
+		},
+		{
+			name:         "alcoholic beverage",
+			product:      Product{ProductAlcohol: true, ProductCategories: []string{"Begudes", "Cerveses"}},
+			wantCategory: NutriScoreAlcohol, wantStatus: NutriScoreNotApplicable,
+		},
+		{
+			name:         "missing inputs",
+			product:      Product{ProductCategories: []string{"Rebost", "Galetes"}},
+			facts:        NutritionFacts{EnergyKJ: amount(2000)},
+			wantCategory: NutriScoreGeneral, wantStatus: NutriScoreMissingInputs,
+			wantMissing: []string{NutrientSugars, NutrientSaturatedFat, NutrientSalt, NutrientProtein},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputeNutriScore(tt.product, tt.facts)
+			if got.Category != tt.wantCategory || got.Status != tt.wantStatus || got.Grade != tt.wantGrade {
+				t.Errorf("got category %q, status %q, grade %q, want %q, %q, %q",
+					got.Category, got.Status, got.Grade, tt.wantCategory, tt.wantStatus, tt.wantGrade)
+			}
+			if tt.wantGrade != "" && (got.Score == nil || *got.Score != tt.wantScore) {
+				t.Errorf("score = %v, want %d (N %d, P %d)", got.Score, tt.wantScore, got.NegativePoints, got.PositivePoints)
+			}
+			if !reflect.DeepEqual(got.Missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", got.Missing, tt.wantMissing)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package models
+
+import (
+	"math"
+	"strings"
+)
+
+// Fields that a product needs to be exported to Open Food Facts.
+const (
+	OpenFoodFactsCode        = "code"
+	OpenFoodFactsProductName = "product_name"
+	OpenFoodFactsBrands      = "brands"
+	OpenFoodFactsCategories  = "categories"
+	OpenFoodFactsNutriments  = "nutriments"
+)
+
+// OpenFoodFactsRequiredFields lists the fields a product needs to be
+// exported to Open Food Facts.
+var OpenFoodFactsRequiredFields = []string{
+	OpenFoodFactsCode, OpenFoodFactsProductName, OpenFoodFactsBrands, OpenFoodFactsCategories, OpenFoodFactsNutriments,
+}
+
+// OpenFoodFactsProduct is a product in the Open Food Facts product schema,
+// with the field names of their CSV export and product JSON. Tags are
+// taxonomy entries such as "en:yogurts"; nutriments are per 100 g or 100 ml
+// and keyed with the _100g suffix, e.g. "saturated-fat_100g".
+type OpenFoodFactsProduct struct {
+	Code                string             `json:"code"`
+	ProductName         string             `json:"product_name"`
+	Lang                string             `json:"lang"`
+	Quantity            string             `json:"quantity,omitempty"`
+	ProductQuantity     float64            `json:"product_quantity,omitempty"`
+	ProductQuantityUnit string             `json:"product_quantity_unit,omitempty"`
+	Brands              string             `json:"brands"`
+	BrandsTags          []string           `json:"brands_tags"`
+	Categories          string             `json:"categories"`
+	CategoriesTags      []string           `json:"categories_tags"`
+	Countries           string             `json:"countries"`
+	Stores              string             `json:"stores"`
+	Origins             string             `json:"origins,omitempty"`
+	IngredientsText     string             `json:"ingredients_text,omitempty"`
+	Allergens           string             `json:"allergens,omitempty"`
+	Traces              string             `json:"traces,omitempty"`
+	NutritionDataPer    string             `json:"nutrition_data_per"`
+	Nutriments          map[string]float64 `json:"nutriments"`
+	NutriScoreGrade     string             `json:"nutriscore_grade,omitempty"`
+	ImageURL            string             `json:"image_url,omitempty"`
+}
+
+// OpenFoodFactsIssue is a product that cannot be exported to Open Food
+// Facts, with the required fields it lacks.
+type OpenFoodFactsIssue struct {
+	Retailer     string   `json:"retailer"`
+	ProductID    int      `json:"product_id"`
+	ProductName  string   `json:"product_name"`
+	ProductBrand string   `json:"product_brand"`
+	Missing      []string `json:"missing"`
+}
+
+// OpenFoodFactsNutrimentKeys lists the exported nutriment keys in the order
+// of the Open Food Facts CSV export.
+var OpenFoodFactsNutrimentKeys = []string{
+	"energy-kj_100g", "energy-kcal_100g", "fat_100g", "saturated-fat_100g", "sugars_100g",
+	"fiber_100g", "proteins_100g", "salt_100g", "sodium_100g",
+}
+
+// openFoodFactsAllergens maps the EU allergens to the Open Food Facts
+// allergens taxonomy.
+var openFoodFactsAllergens = map[string]string{
+	AllergenGluten:      "en:gluten",
+	AllergenCrustaceans: "en:crustaceans",
+	AllergenEggs:        "en:eggs",
+	AllergenFish:        "en:fish",
+	AllergenPeanuts:     "en:peanuts",
+	AllergenSoybeans:    "en:soybeans",
+	AllergenMilk:        "en:milk",
+	AllergenNuts:        "en:nuts",
+	AllergenCelery:      "en:celery",
+	AllergenMustard:     "en:mustard",
+	AllergenSesame:      "en:sesame-seeds",
+	AllergenSulphites:   "en:sulphur-dioxide-and-sulphites",
+	AllergenLupin:       "en:lupin",
+	AllergenMolluscs:    "en:molluscs",
+}
+
+// openFoodFactsCategories maps the accent-folded words of a category path
+// level to an entry of the Open Food Facts categories taxonomy. Rules are
+// tried in order, so the more specific ones come first.
+var openFoodFactsCategories = []struct {
+	words []string
+	tag   string
+}{
+	{[]string{"iogurt", "iogurts", "yogur", "yogures", "yogurt", "yogurts"}, "en:yogurts"},
+	{[]string{"formatge", "formatges", "queso", "quesos", "cheese", "cheeses"}, "en:cheeses"},
+	{[]string{"mantega", "mantegues", "mantequilla", "butter"}, "en:butters"},
+	{[]string{"llet", "llets", "leche", "leches", "milk"}, "en:milks"},
+	{[]string{"postres", "dessert", "desserts"}, "en:desserts"},
+	{[]string{"oliva"}, "en:olive-oils"},
+	{[]string{"oli", "olis", "aceite", "aceites"}, "en:vegetable-oils"},
+	{[]string{"vinagre", "vinagres", "vinegar"}, "en:vinegars"},
+	{[]string{"aigua", "aigues", "agua", "aguas", "water"}, "en:waters"},
+	{[]string{"refresc", "refrescos", "refrescs", "sodas"}, "en:sodas"},
+	{[]string{"suc", "sucs", "zumo", "zumos", "juice", "juices"}, "en:fruit-juices"},
+	{[]string{"cervesa", "cerveses", "cerveza", "cervezas", "beer", "beers"}, "en:beers"},
+	{[]string{"vi", "vins", "vino", "vinos", "wine", "wines", "cava", "caves"}, "en:wines"},
+	{[]string{"cafe", "cafes", "coffee"}, "en:coffees"},
+	{[]string{"te", "tes", "infusions", "infusiones", "tea"}, "en:teas"},
+	{[]string{"begudes", "beguda", "bebidas", "bebida", "drinks"}, "en:beverages"},
+	{[]string{"galeta", "galetes", "galleta", "galletas", "biscuits", "cookies"}, "en:biscuits"},
+	{[]string{"xocolata", "xocolates", "chocolate", "chocolates"}, "en:chocolates"},
+	{[]string{"cereals", "cereales", "cereal"}, "en:breakfast-cereals"},
+	{[]string{"pa", "pans", "pan", "panes", "bread", "breads"}, "en:breads"},
+	{[]string{"pasta", "pastes", "pastas"}, "en:pastas"},
+	{[]string{"arros", "arroz", "rice"}, "en:rices"},
+	{[]string{"llegums", "legumbres", "legumes"}, "en:legumes"},
+	{[]string{"conserves", "conservas"}, "en:canned-foods"},
+	{[]string{"embotit", "embotits", "embutido", "embutidos", "pernil", "jamon"}, "en:prepared-meats"},
+	{[]string{"carn", "carns", "carne", "carnes", "meat", "meats"}, "en:meats"},
+	{[]string{"peix", "pescado", "pescados", "marisc", "marisco", "mariscos", "fish", "seafood"}, "en:seafood"},
+	{[]string{"ous", "huevos", "eggs"}, "en:eggs"},
+	{[]string{"fruita", "fruites", "fruta", "frutas", "fruits"}, "en:fruits"},
+	{[]string{"verdura", "verdures", "verduras", "hortalisses", "hortalizas", "vegetables"}, "en:vegetables"},
+	{[]string{"fruits secs", "frutos secos", "nuts"}, "en:nuts"},
+	{[]string{"snacks", "aperitius", "aperitivos", "patates fregides", "patatas fritas"}, "en:salty-snacks"},
+	{[]string{"salses", "salsas", "sauces"}, "en:sauces"},
+	{[]string{"congelats", "congelados", "frozen"}, "en:frozen-foods"},
+	{[]string{"precuinats", "precocinados", "plats preparats", "platos preparados"}, "en:meals"},
+}
+
+// openFoodFactsCategoryParents maps categories taxonomy entries to their
+// parent, so that the exported categories include the whole path.
+var openFoodFactsCategoryParents = map[string]string{
+	"en:yogurts":             "en:dairies",
+	"en:cheeses":             "en:dairies",
+	"en:butters":             "en:dairies",
+	"en:milks":               "en:dairies",
+	"en:olive-oils":          "en:vegetable-oils",
+	"en:vegetable-oils":      "en:fats",
+	"en:waters":              "en:beverages",
+	"en:sodas":               "en:beverages",
+	"en:fruit-juices":        "en:beverages",
+	"en:beers":               "en:alcoholic-beverages",
+	"en:wines":               "en:alcoholic-beverages",
+	"en:alcoholic-beverages": "en:beverages",
+	"en:coffees":             "en:beverages",
+	"en:teas":                "en:beverages",
+	"en:biscuits":            "en:sweet-snacks",
+	"en:chocolates":          "en:sweet-snacks",
+	"en:sweet-snacks":        "en:snacks",
+	"en:salty-snacks":        "en:snacks",
+	"en:breakfast-cereals":   "en:cereals-and-potatoes",
+	"en:breads":              "en:cereals-and-potatoes",
+	"en:pastas":              "en:cereals-and-potatoes",
+	"en:rices":               "en:cereals-and-potatoes",
+	"en:prepared-meats":      "en:meats",
+	"en:nuts":                "en:plant-based-foods",
+	"en:fruits":              "en:plant-based-foods",
+	"en:vegetables":          "en:plant-based-foods",
+	"en:legumes":             "en:plant-based-foods",
+}
+
+// MapOpenFoodFactsCategories maps a category path to Open Food Facts
+// categories taxonomy entries, parents first. Levels of the path that no
+// rule matches are kept in Catalan, as "ca:Name", so that Open Food Facts
+// can map them with its own translations.
+func MapOpenFoodFactsCategories(path []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		var chain []string
+		for ; tag != ""; tag = openFoodFactsCategoryParents[tag] {
+			chain = append([]string{tag}, chain...)
+		}
+		for _, tag := range chain {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	for _, level := range path {
+		words := " " + strings.Join(matchTokens(level), " ") + " "
+		if strings.TrimSpace(words) == "" {
+			continue
+		}
+		tag := ""
+		for _, rule := range openFoodFactsCategories {
+			for _, word := range rule.words {
+				if strings.Contains(words, " "+word+" ") {
+					tag = rule.tag
+					break
+				}
+			}
+			if tag != "" {
+				break
+			}
+		}
+		if tag == "" {
+			tag = "ca:" + cleanText(level)
+		}
+		add(tag)
+	}
+	return tags
+}
+
+// openFoodFactsTag returns the taxonomy tag of a free-text value, as Open
+// Food Facts derives brands_tags from brands: lowercase, without accents
+// and with words joined by dashes.
+func openFoodFactsTag(text string) string {
+	return strings.Join(matchTokens(text), "-")
+}
+
+// openFoodFactsCode returns a GTIN as Open Food Facts stores barcodes:
+// without leading zeros, then padded to 8 digits for EAN-8 codes and to 13
+// digits for UPC-A and other codes of 9 to 12 digits, so that an EAN-8 is
+// not exported as its 13-digit form. It returns false for invalid GTINs.
+func openFoodFactsCode(gtin string) (string, bool) {
+	code, ok := NormalizeGTIN(gtin)
+	if !ok {
+		return "", false
+	}
+	code = strings.TrimLeft(code, "0")
+	switch {
+	case len(code) <= 8:
+		code = strings.Repeat("0", 8-len(code)) + code
+	case len(code) < 13:
+		code = strings.Repeat("0", 13-len(code)) + code
+	}
+	return code, true
+}
+
+// ToOpenFoodFacts maps a product, its nutrition facts (those its Nutri-Score
+// was computed from) and its label details to the Open Food Facts product
+// schema. The code is the product's first valid GTIN in Open Food Facts
+// form, see openFoodFactsCode. It also returns the
+// required fields the product lacks, in which case it cannot be exported:
+// a valid barcode, a name, a brand, a category and the energy per 100 g.
+func ToOpenFoodFacts(product Product) (OpenFoodFactsProduct, []string) {
+	offProduct := OpenFoodFactsProduct{
+		ProductName:      product.ProductName,
+		Lang:             "ca",
+		Quantity:         product.ProductPackSizeDescription,
+		Brands:           product.ProductBrand,
+		Countries:        "en:spain",
+		Stores:           retailerStoreName(product.Retailer),
+		Origins:          product.Details.Origin,
+		IngredientsText:  product.Details.IngredientsText,
+		NutritionDataPer: "100g",
+		Nutriments:       make(map[string]float64),
+		CategoriesTags:   MapOpenFoodFactsCategories(product.ProductCategories),
+	}
+	for _, gtin := range product.ProductGTINs {
+		if code, ok := openFoodFactsCode(gtin); ok {
+			offProduct.Code = code
+			break
+		}
+	}
+	if tag := openFoodFactsTag(product.ProductBrand); tag != "" {
+		offProduct.BrandsTags = []string{tag}
+	}
+	offProduct.Categories = strings.Join(offProduct.CategoriesTags, ", ")
+
+	if packSize := product.PackSize; packSize.NetQuantity > 0 && packSize.BaseUnit != BaseUnitUnits && packSize.BaseUnit != "" {
+		offProduct.ProductQuantity = packSize.NetQuantity
+		offProduct.ProductQuantityUnit = packSize.BaseUnit
+	}
+
+	var allergens, traces []string
+	for _, allergen := range product.Details.SortedAllergens(AllergenContains) {
+		allergens = append(allergens, openFoodFactsAllergens[allergen])
+	}
+	for _, allergen := range product.Details.SortedAllergens(AllergenMayContain) {
+		traces = append(traces, openFoodFactsAllergens[allergen])
+	}
+	offProduct.Allergens = strings.Join(allergens, ",")
+	offProduct.Traces = strings.Join(traces, ",")
+
+	facts := product.NutriScore.Facts
+	nutriments := map[string]*float64{
+		"energy-kj_100g":     facts.EnergyKJ,
+		"fat_100g":           facts.Fat,
+		"saturated-fat_100g": facts.SaturatedFat,
+		"sugars_100g":        facts.Sugars,
+		"fiber_100g":         facts.Fibre,
+		"proteins_100g":      facts.Protein,
+		"salt_100g":          facts.Salt,
+	}
+	for key, value := range nutriments {
+		if value != nil {
+			offProduct.Nutriments[key] = roundNutriment(*value)
+		}
+	}
+	if facts.EnergyKJ != nil {
+		offProduct.Nutriments["energy-kcal_100g"] = roundNutriment(*facts.EnergyKJ / kcalToKJ)
+	}
+	if facts.Salt != nil {
+		offProduct.Nutriments["sodium_100g"] = roundNutriment(*facts.Salt / sodiumToSalt)
+	}
+	if product.NutriScore.Status == NutriScoreGraded {
+		offProduct.NutriScoreGrade = strings.ToLower(product.NutriScore.Grade)
+	}
+
+	for _, image := range product.Images {
+		if image.Position != 0 {
+			continue
+		}
+		if offProduct.ImageURL == "" || image.Size == ImageSizeOriginal {
+			offProduct.ImageURL = image.URL
+		}
+	}
+
+	var missing []string
+	if offProduct.Code == "" {
+		missing = append(missing, OpenFoodFactsCode)
+	}
+	if strings.TrimSpace(offProduct.ProductName) == "" {
+		missing = append(missing, OpenFoodFactsProductName)
+	}
+	if len(offProduct.BrandsTags) == 0 {
+		missing = append(missing, OpenFoodFactsBrands)
+	}
+	if len(offProduct.CategoriesTags) == 0 {
+		missing = append(missing, OpenFoodFactsCategories)
+	}
+	if facts.EnergyKJ == nil {
+		missing = append(missing, OpenFoodFactsNutriments)
+	}
+	return offProduct, missing
+}
+
+// ExportOpenFoodFacts maps products to the Open Food Facts product schema.
+// Products lacking required fields are not exported but returned as issues.
+// Open Food Facts has one product per barcode, so a barcode sold by several
+// retailers is exported once, from the first of them, with every retailer
+// in its stores.
+func ExportOpenFoodFacts(products []Product) ([]OpenFoodFactsProduct, []OpenFoodFactsIssue) {
+	var exported []OpenFoodFactsProduct
+	var issues []OpenFoodFactsIssue
+	index := make(map[string]int)
+	for _, product := range products {
+		offProduct, missing := ToOpenFoodFacts(product)
+		if len(missing) > 0 {
+			issues = append(issues, OpenFoodFactsIssue{
+				Retailer:     product.Retailer,
+				ProductID:    product.ProductID,
+				ProductName:  product.ProductName,
+				ProductBrand: product.ProductBrand,
+				Missing:      missing,
+			})
+			continue
+		}
+
+		if i, ok := index[offProduct.Code]; ok {
+			stores := strings.Split(exported[i].Stores, ",")
+			if !containsString(stores, offProduct.Stores) {
+				exported[i].Stores += "," + offProduct.Stores
+			}
+			continue
+		}
+		index[offProduct.Code] = len(exported)
+		exported = append(exported, offProduct)
+	}
+	return exported, issues
+}
+
+// retailerStoreName returns the store name of a retailer as Open Food Facts
+// lists it, e.g. "Bonpreu".
+func retailerStoreName(retailer string) string {
+	if retailer == "" {
+		return ""
+	}
+	return strings.ToUpper(retailer[:1]) + retailer[1:]
+}
+
+// roundNutriment rounds a nutriment amount to 3 decimals, dropping the
+// noise of unit conversions.
+func roundNutriment(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, known := range values {
+		if known == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapOpenFoodFactsCategories(t *testing.T) {
+	tests := []struct {
+		path []string
+		want []string
+	}{
+		{[]string{"Frescos", "Làctics", "Iogurts"}, []string{"ca:Frescos", "ca:Làctics", "en:dairies", "en:yogurts"}},
+		{[]string{"Rebost", "Oli d'oliva"}, []string{"ca:Rebost", "en:fats", "en:vegetable-oils", "en:olive-oils"}},
+		{[]string{"Begudes", "Cerveses"}, []string{"en:beverages", "en:alcoholic-beverages", "en:beers"}},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := MapOpenFoodFactsCategories(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MapOpenFoodFactsCategories(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpenFoodFactsCode(t *testing.T) {
+	tests := []struct {
+		gtin string
+		want string
+		ok   bool
+	}{
+		{"8412345678905", "8412345678905", true},
+		{"0000096385074", "96385074", true},
+		{"96385074", "96385074", true},
+		{"0000001234565", "01234565", true},
+		{"036000291452", "0036000291452", true},
+		{"00036000291452", "0036000291452", true},
+		{"96385075", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := openFoodFactsCode(tt.gtin); got != tt.want || ok != tt.ok {
+			t.Errorf("openFoodFactsCode(%q) = %q, %v, want %q, %v", tt.gtin, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExportOpenFoodFacts(t *testing.T) {
+	amount := func(value float64) *float64 { return &value }
+	yogurt := Product{
+		Retailer:                   RetailerBonpreu,
+		ProductID:                  90001,
+		ProductName:                "Iogurt natural ecològic",
+		ProductBrand:               "La Fageda",
+		ProductPackSizeDescription: "4 x 125 g",
+		ProductGTINs:               []string{"8412345678905"},
+		ProductCategories:          []string{"Frescos", "Làctics", "Iogurts"},
+		PackSize:                   PackSize{NetQuantity: 500, BaseUnit: BaseUnitGrams},
+		Details: ProductDetails{
+			IngredientsText: "Llet sencera ecològica, ferments làctics.",
+			Allergens:       map[string]string{AllergenMilk: AllergenContains, AllergenNuts: AllergenMayContain},
+		},
+		NutriScore: NutriScore{
+			Status: NutriScoreGraded,
+			Grade:  "B",
+			Facts: NutritionFacts{EnergyKJ: amount(276), Fat: amount(3.5), SaturatedFat: amount(2.3),
+				Sugars: amount(4.7), Protein: amount(3.9), Salt: amount(0.13)},
+		},
+		Images: []ProductImage{
+			{Position: 1, Size: ImageSizeOriginal, URL: "https://cdn.example/back.jpg"},
+			{Position: 0, Size: "500x500", URL: "https://cdn.example/front.jpg"},
+		},
+	}
+	sameAtEsclat := yogurt
+	sameAtEsclat.Retailer, sameAtEsclat.ProductID = RetailerEsclat, 7
+	withoutBarcode := Product{Retailer: RetailerBonpreu, ProductID: 90002, ProductName: "Pa de pagès"}
+	ean8 := yogurt
+	ean8.ProductID, ean8.ProductGTINs = 90003, []string{"0000096385074"}
+
+	exported, issues := ExportOpenFoodFacts([]Product{yogurt, sameAtEsclat, withoutBarcode, ean8})
+	if len(exported) != 2 {
+		t.Fatalf("exported %d products, want 2: %+v", len(exported), exported)
+	}
+	if exported[1].Code != "96385074" {
+		t.Errorf("EAN-8 code = %q, want 96385074", exported[1].Code)
+	}
+	got := exported[0]
+	checks := []struct {
+		field     string
+		got, want interface{}
+	}{
+		{"Code", got.Code, "8412345678905"},
+		{"ProductQuantity", got.ProductQuantity, 500.0},
+		{"ProductQuantityUnit", got.ProductQuantityUnit, "g"},
+		{"Brands", got.Brands, "La Fageda"},
+		{"Stores", got.Stores, "Bonpreu,Esclat"},
+		{"Allergens", got.Allergens, "en:milk"},
+		{"Traces", got.Traces, "en:nuts"},
+		{"NutriScoreGrade", got.NutriScoreGrade, "b"},
+		{"ImageURL", got.ImageURL, "https://cdn.example/front.jpg"},
+		{"energy-kcal_100g", got.Nutriments["energy-kcal_100g"], 65.966},
+		{"sodium_100g", got.Nutriments["sodium_100g"], 0.052},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.field, check.got, check.want)
+		}
+	}
+	if !reflect.DeepEqual(got.BrandsTags, []string{"la-fageda"}) {
+		t.Errorf("brands tags = %q", got.BrandsTags)
+	}
+	if _, ok := got.Nutriments["fiber_100g"]; ok {
+		t.Error("missing fibre was exported")
+	}
+
+	wantIssues := []OpenFoodFactsIssue{{
+		Retailer:    RetailerBonpreu,
+		ProductID:   90002,
+		ProductName: "Pa de pagès",
+		Missing:     []string{OpenFoodFactsCode, OpenFoodFactsBrands, OpenFoodFactsCategories, OpenFoodFactsNutriments},
+	}}
+	if !reflect.DeepEqual(issues, wantIssues) {
+		t.Errorf("issues = %+v, want %+v", issues, wantIssues)
+	}
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pack size parsing confidence levels.
+const (
+	PackSizeConfidenceHigh     = "high"     // one unambiguous quantity, consistent with the unit price
+	PackSizeConfidenceLow      = "low"      // parsed, but approximate, ambiguous or inconsistent with the unit price
+	PackSizeConfidenceInferred = "inferred" // not parsed; derived from the price and the unit price
+	PackSizeConfidenceNone     = "none"     // nothing could be determined
+)
+
+// Canonical base units of NetQuantity.
+const (
+	BaseUnitGrams      = "g"
+	BaseUnitMillilitre = "ml"
+	BaseUnitUnits      = "units"
+)
+
+// Results of cross-checking a pack size against the product's unit price.
+const (
+	UnitPriceCheckMatch     = "match"
+	UnitPriceCheckMismatch  = "mismatch"
+	UnitPriceCheckUnchecked = "unchecked"
+)
+
+// PackSize is the structured form of a free-text pack size description such
+// as "6 x 1 L", "500 g" or "pack 4 u. x 125 g". Units is the number of items
+// in the pack, each holding UnitQuantity of UnitOfMeasure; NetQuantity is the
+// total in BaseUnit (g, ml or units).
+type PackSize struct {
+	Units          int     `json:"units"`
+	UnitQuantity   float64 `json:"unit_quantity"`
+	UnitOfMeasure  string  `json:"unit_of_measure"`
+	NetQuantity    float64 `json:"net_quantity"`
+	BaseUnit       string  `json:"base_unit"`
+	Confidence     string  `json:"confidence"`
+	UnitPriceCheck string  `json:"unit_price_check"`
+}
+
+// measureUnits maps the spellings of a unit of measure to its canonical symbol,
+// base unit and factor to the base unit.
+var measureUnits = map[string]struct {
+	symbol   string
+	baseUnit string
+	factor   float64
+}{
+	"mg": {"mg", BaseUnitGrams, 0.001}, "g": {"g", BaseUnitGrams, 1}, "gr": {"g", BaseUnitGrams, 1},
+	"grs": {"g", BaseUnitGrams, 1}, "grams": {"g", BaseUnitGrams, 1}, "gramos": {"g", BaseUnitGrams, 1},
+	"kg": {"kg", BaseUnitGrams, 1000}, "kgs": {"kg", BaseUnitGrams, 1000}, "kilo": {"kg", BaseUnitGrams, 1000},
+	"ml": {"ml", BaseUnitMillilitre, 1}, "cl": {"cl", BaseUnitMillilitre, 10}, "dl": {"dl", BaseUnitMillilitre, 100},
+	"l": {"l", BaseUnitMillilitre, 1000}, "lt": {"l", BaseUnitMillilitre, 1000}, "litre": {"l", BaseUnitMillilitre, 1000},
+	"litres": {"l", BaseUnitMillilitre, 1000}, "litro": {"l", BaseUnitMillilitre, 1000}, "litros": {"l", BaseUnitMillilitre, 1000},
+}
+
+// countUnits are the spellings of "units" in pack size descriptions.
+const countUnits = `u|ut|uts|ud|uds|un|unitat|unitats|unidad|unidades`
+
+const (
+	numberPattern  = `(\d+(?:\.\d+)?)`
+	measurePattern = `(mg|grs|gr|gramos|grams|g|kgs|kg|kilo|ml|cl|dl|litres|litre|litros|litro|lt|l)\b\.?`
+)
+
+var (
+	// "6 x 1 l", "pack 4 u. x 125 g"
+	multipackPattern = regexp.MustCompile(`(\d+)\s*(?:(?:` + countUnits + `)\b\.?)?\s*[x×]\s*` + numberPattern + `\s*` + measurePattern)
+	// "125 g x 4"
+	reverseMultipackPattern = regexp.MustCompile(numberPattern + `\s*` + measurePattern + `\s*[x×]\s*(\d+)\b`)
+	// "500 g"
+	quantityPattern = regexp.MustCompile(numberPattern + `\s*` + measurePattern)
+	// "12 u."
+	countPattern = regexp.MustCompile(`(\d+)\s*(?:` + countUnits + `)\b\.?`)
+	// A further multiplier in front of a multipack: "2 x 4 x 100 g"
+	leadingMultiplierPattern = regexp.MustCompile(`(\d+)\s*[x×]\s*$`)
+	// Approximate weights: "aprox. 1 kg", "~ 500 g"
+	approximatePattern = regexp.MustCompile(`aprox|approx|~|c\.a\.|peso variable|pes variable`)
+)
+
+// ParsePackSize parses a pack size description into a structured quantity.
+// Descriptions with several quantities (e.g. drained weight), nested
+// multipliers or approximate weights are parsed with low confidence.
+func ParsePackSize(description string) PackSize {
+	text := strings.ToLower(strings.TrimSpace(description))
+	text = decimalCommaPattern.ReplaceAllString(text, "$1.$2")
+
+	packSize := PackSize{Confidence: PackSizeConfidenceNone, UnitPriceCheck: UnitPriceCheckUnchecked}
+	ambiguous := approximatePattern.MatchString(text)
+
+	if match := multipackPattern.FindStringSubmatchIndex(text); match != nil {
+		units, _ := strconv.Atoi(text[match[2]:match[3]])
+		quantity, _ := strconv.ParseFloat(text[match[4]:match[5]], 64)
+		packSize = newPackSize(units, quantity, text[match[6]:match[7]])
+
+		if leading := leadingMultiplierPattern.FindStringSubmatch(text[:match[0]]); leading != nil {
+			multiplier, _ := strconv.Atoi(leading[1])
+			packSize.Units *= multiplier
+			packSize.NetQuantity *= float64(multiplier)
+			ambiguous = true
+		}
+		if len(quantityPattern.FindAllString(text, -1)) > 1 {
+			ambiguous = true
+		}
+	} else if match := reverseMultipackPattern.FindStringSubmatch(text); match != nil {
+		quantity, _ := strconv.ParseFloat(match[1], 64)
+		units, _ := strconv.Atoi(match[3])
+		packSize = newPackSize(units, quantity, match[2])
+	} else if matches := quantityPattern.FindAllStringSubmatch(text, -1); matches != nil {
+		quantity, _ := strconv.ParseFloat(matches[0][1], 64)
+		packSize = newPackSize(1, quantity, matches[0][2])
+		if len(matches) > 1 {
+			ambiguous = true
+		}
+	} else if match := countPattern.FindStringSubmatch(text); match != nil {
+		units, _ := strconv.Atoi(match[1])
+		if units > 0 {
+			packSize = PackSize{
+				Units:         units,
+				UnitQuantity:  1,
+				UnitOfMeasure: "u",
+				NetQuantity:   float64(units),
+				BaseUnit:      BaseUnitUnits,
+			}
+		}
+	}
+
+	if packSize.NetQuantity <= 0 || math.IsInf(packSize.NetQuantity, 0) {
+		return PackSize{Confidence: PackSizeConfidenceNone, UnitPriceCheck: UnitPriceCheckUnchecked}
+	}
+
+	packSize.NetQuantity = roundQuantity(packSize.NetQuantity)
+	packSize.UnitPriceCheck = UnitPriceCheckUnchecked
+	packSize.Confidence = PackSizeConfidenceHigh
+	if ambiguous {
+		packSize.Confidence = PackSizeConfidenceLow
+	}
+	return packSize
+}
+
+// decimalCommaPattern matches decimal commas such as "1,5".
+var decimalCommaPattern = regexp.MustCompile(`(\d),(\d)`)
+
+// newPackSize builds a pack size of units items of quantity in the given unit of measure.
+func newPackSize(units int, quantity float64, unit string) PackSize {
+	measure, ok := measureUnits[strings.TrimSuffix(unit, ".")]
+	if !ok || units <= 0 || quantity <= 0 {
+		return PackSize{}
+	}
+	return PackSize{
+		Units:         units,
+		UnitQuantity:  quantity,
+		UnitOfMeasure: measure.symbol,
+		NetQuantity:   float64(units) * quantity * measure.factor,
+		BaseUnit:      measure.baseUnit,
+	}
+}
+
+// ParseProductPackSize parses the pack size description of a product and
+// cross-checks the result against its price and unit price. A pack size that
+// disagrees with the unit price by more than 5% is downgraded to low
+// confidence; when the description cannot be parsed, the net quantity is
+// inferred from the price and the unit price where possible.
+func ParseProductPackSize(product Product) PackSize {
+	packSize := ParsePackSize(product.ProductPackSizeDescription)
+
+	priceBase, factor := unitPriceBase(product.ProductUnitPriceUnit)
+	unitPrice := product.ProductUnitPriceAmount * factor
+	if priceBase == "" || unitPrice <= 0 || product.ProductPriceAmount <= 0 {
+		return packSize
+	}
+
+	// Quantity implied by the prices, in the pack size's base unit
+	impliedBase, impliedQuantity := packBaseUnit(priceBase), product.ProductPriceAmount/unitPrice
+	if impliedBase != BaseUnitUnits {
+		impliedQuantity *= 1000
+	}
+
+	if packSize.Confidence == PackSizeConfidenceNone {
+		return PackSize{
+			Units:          1,
+			UnitQuantity:   roundQuantity(impliedQuantity),
+			UnitOfMeasure:  impliedBase,
+			NetQuantity:    roundQuantity(impliedQuantity),
+			BaseUnit:       impliedBase,
+			Confidence:     PackSizeConfidenceInferred,
+			UnitPriceCheck: UnitPriceCheckUnchecked,
+		}
+	}
+	if packSize.BaseUnit != impliedBase {
+		return packSize
+	}
+
+	if math.Abs(packSize.NetQuantity-impliedQuantity)/packSize.NetQuantity <= 0.05 {
+		packSize.UnitPriceCheck = UnitPriceCheckMatch
+	} else {
+		packSize.UnitPriceCheck = UnitPriceCheckMismatch
+		packSize.Confidence = PackSizeConfidenceLow
+	}
+	return packSize
+}
+
+// packBaseUnit maps a unit price base unit ("kg", "l" or "unit") to the
+// corresponding pack size base unit.
+func packBaseUnit(priceBase string) string {
+	switch priceBase {
+	case "kg":
+		return BaseUnitGrams
+	case "l":
+		return BaseUnitMillilitre
+	default:
+		return BaseUnitUnits
+	}
+}
+
+// roundQuantity rounds a quantity to three decimals to hide floating point noise.
+func roundQuantity(quantity float64) float64 {
+	return math.Round(quantity*1000) / 1000
+}
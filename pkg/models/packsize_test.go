@@ -0,0 +1,63 @@
+package models
+
+import "testing"
+
+func TestParsePackSize(t *testing.T) {
+	tests := []struct {
+		description  string
+		units        int
+		unitQuantity float64
+		unit         string
+		net          float64
+		baseUnit     string
+		confidence   string
+	}{
+		{"500 g", 1, 500, "g", 500, BaseUnitGrams, PackSizeConfidenceHigh},
+		{"1 kg", 1, 1, "kg", 1000, BaseUnitGrams, PackSizeConfidenceHigh},
+		{"6 x 1 L", 6, 1, "l", 6000, BaseUnitMillilitre, PackSizeConfidenceHigh},
+		{"pack 4 u. x 125 g", 4, 125, "g", 500, BaseUnitGrams, PackSizeConfidenceHigh},
+		{"4 x 125 g", 4, 125, "g", 500, BaseUnitGrams, PackSizeConfidenceHigh},
+		{"125 g x 4", 4, 125, "g", 500, BaseUnitGrams, PackSizeConfidenceHigh},
+		{"75 cl", 1, 75, "cl", 750, BaseUnitMillilitre, PackSizeConfidenceHigh},
+		{"1,5 L", 1, 1.5, "l", 1500, BaseUnitMillilitre, PackSizeConfidenceHigh},
+		{"12 u.", 12, 1, "u", 12, BaseUnitUnits, PackSizeConfidenceHigh},
+		{"6 x 33cl", 6, 33, "cl", 1980, BaseUnitMillilitre, PackSizeConfidenceHigh},
+		{"aprox. 1 kg", 1, 1, "kg", 1000, BaseUnitGrams, PackSizeConfidenceLow},
+		{"2 x 4 x 100 g", 8, 100, "g", 800, BaseUnitGrams, PackSizeConfidenceLow},
+		{"420 g (escorregut 250 g)", 1, 420, "g", 420, BaseUnitGrams, PackSizeConfidenceLow},
+		{"pack", 0, 0, "", 0, "", PackSizeConfidenceNone},
+		{"", 0, 0, "", 0, "", PackSizeConfidenceNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got := ParsePackSize(tt.description)
+			if got.Units != tt.units || got.UnitQuantity != tt.unitQuantity || got.UnitOfMeasure != tt.unit ||
+				got.NetQuantity != tt.net || got.BaseUnit != tt.baseUnit || got.Confidence != tt.confidence {
+				t.Errorf("ParsePackSize(%q) = %+v", tt.description, got)
+			}
+		})
+	}
+}
+
+func TestParseProductPackSizeCrossCheck(t *testing.T) {
+	product := Product{
+		ProductPackSizeDescription: "4 x 125 g",
+		ProductPriceAmount:         2.35,
+		ProductUnitPriceAmount:     4.70,
+		ProductUnitPriceUnit:       "fop.price.per.kg",
+	}
+	if got := ParseProductPackSize(product); got.UnitPriceCheck != UnitPriceCheckMatch || got.Confidence != PackSizeConfidenceHigh {
+		t.Errorf("consistent pack size = %+v", got)
+	}
+
+	product.ProductPackSizeDescription = "4 x 250 g"
+	if got := ParseProductPackSize(product); got.UnitPriceCheck != UnitPriceCheckMismatch || got.Confidence != PackSizeConfidenceLow {
+		t.Errorf("inconsistent pack size = %+v", got)
+	}
+
+	product.ProductPackSizeDescription = "safata"
+	if got := ParseProductPackSize(product); got.Confidence != PackSizeConfidenceInferred || got.NetQuantity != 500 || got.BaseUnit != BaseUnitGrams {
+		t.Errorf("inferred pack size = %+v", got)
+	}
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Promotion mechanics recognised in promotion descriptions.
+const (
+	MechanicNone            = ""
+	MechanicMultiBuy        = "multi_buy"         // "3x2": take N, pay M
+	MechanicNthUnitDiscount = "nth_unit_discount" // "2a unitat -50%": the Nth unit is X% off
+	MechanicPercentDiscount = "percent_discount"  // "-20%": every unit is X% off
+	MechanicFixedDiscount   = "fixed_discount"    // "-1,50 €": every unit is X € off
+	MechanicBundlePrice     = "bundle_price"      // "2 per 5 €": N units for a fixed price
+	MechanicPromoPrice      = "promo_price"       // the API exposes the promotional unit price
+)
+
+// PromotionMechanic describes how a promotion changes what a shopper pays.
+// Quantity is the number of units that must be bought; the other fields are
+// only meaningful for the matching Kind.
+type PromotionMechanic struct {
+	Kind            string
+	Quantity        int
+	PaidUnits       int     // MechanicMultiBuy
+	DiscountPercent float64 // MechanicNthUnitDiscount, MechanicPercentDiscount
+	DiscountAmount  float64 // MechanicFixedDiscount
+	BundlePrice     float64 // MechanicBundlePrice
+}
+
+// Patterns for the promotion mechanics, tried in order. Descriptions are
+// lower-cased before matching; decimal commas are accepted.
+var (
+	bundlePricePattern     = regexp.MustCompile(`^\s*(\d+)\s*(?:x|×|per|por|unitats per|uds\.? per)\s*(\d+(?:[.,]\d{1,2})?)\s*(?:€|eur)`)
+	multiBuyPattern        = regexp.MustCompile(`^\s*(\d+)\s*[x×]\s*(\d+)\b`)
+	nthUnitPattern         = regexp.MustCompile(`(\d+)\s*(?:a|ª|º|na|ena|era)?\s*(?:unitat|unidad|ud)\w*\.?\s*(?:a|al)?\s*-?\s*(\d+(?:[.,]\d+)?)\s*%`)
+	percentDiscountPattern = regexp.MustCompile(`(?:-\s*(\d+(?:[.,]\d+)?)\s*%|(\d+(?:[.,]\d+)?)\s*%\s*(?:de\s*)?(?:dte|descompte|descuento))`)
+	fixedDiscountPattern   = regexp.MustCompile(`(?:-\s*(\d+(?:[.,]\d{1,2})?)\s*(?:€|eur)|(\d+(?:[.,]\d{1,2})?)\s*(?:€|eur)\s*(?:de\s*)?(?:dte|descompte|descuento))`)
+)
+
+// ParsePromotionMechanic recognises the mechanic of a promotion description such
+// as "3x2", "2a unitat -50%", "-20%", "-1,50 €" or "2 per 5 €". Unrecognised
+// descriptions return a mechanic with Kind MechanicNone and Quantity 1.
+func ParsePromotionMechanic(description string) PromotionMechanic {
+	text := strings.ToLower(description)
+
+	if match := bundlePricePattern.FindStringSubmatch(text); match != nil {
+		quantity, _ := strconv.Atoi(match[1])
+		price := parseDecimal(match[2])
+		if quantity >= 1 && price > 0 {
+			return PromotionMechanic{Kind: MechanicBundlePrice, Quantity: quantity, BundlePrice: price}
+		}
+	}
+	if match := multiBuyPattern.FindStringSubmatch(text); match != nil {
+		quantity, _ := strconv.Atoi(match[1])
+		paid, _ := strconv.Atoi(match[2])
+		if paid >= 1 && quantity > paid {
+			return PromotionMechanic{Kind: MechanicMultiBuy, Quantity: quantity, PaidUnits: paid}
+		}
+	}
+	if match := nthUnitPattern.FindStringSubmatch(text); match != nil {
+		quantity, _ := strconv.Atoi(match[1])
+		percent := parseDecimal(match[2])
+		if quantity >= 2 && percent > 0 && percent <= 100 {
+			return PromotionMechanic{Kind: MechanicNthUnitDiscount, Quantity: quantity, DiscountPercent: percent}
+		}
+	}
+	if match := percentDiscountPattern.FindStringSubmatch(text); match != nil {
+		percent := parseDecimal(match[1] + match[2])
+		if percent > 0 && percent < 100 {
+			return PromotionMechanic{Kind: MechanicPercentDiscount, Quantity: 1, DiscountPercent: percent}
+		}
+	}
+	if match := fixedDiscountPattern.FindStringSubmatch(text); match != nil {
+		amount := parseDecimal(match[1] + match[2])
+		if amount > 0 {
+			return PromotionMechanic{Kind: MechanicFixedDiscount, Quantity: 1, DiscountAmount: amount}
+		}
+	}
+
+	return PromotionMechanic{Kind: MechanicNone, Quantity: 1}
+}
+
+// UnitPrice returns the average price paid per unit when buying Quantity units
+// at the given shelf price. It returns false when the mechanic is unknown or
+// would not lower the price.
+func (m PromotionMechanic) UnitPrice(shelfPrice float64) (float64, bool) {
+	if shelfPrice <= 0 {
+		return 0, false
+	}
+
+	var price float64
+	switch m.Kind {
+	case MechanicMultiBuy:
+		price = shelfPrice * float64(m.PaidUnits) / float64(m.Quantity)
+	case MechanicNthUnitDiscount:
+		price = shelfPrice * (float64(m.Quantity) - m.DiscountPercent/100) / float64(m.Quantity)
+	case MechanicPercentDiscount:
+		price = shelfPrice * (1 - m.DiscountPercent/100)
+	case MechanicFixedDiscount:
+		price = shelfPrice - m.DiscountAmount
+	case MechanicBundlePrice:
+		price = m.BundlePrice / float64(m.Quantity)
+	default:
+		return 0, false
+	}
+
+	if price <= 0 || price >= shelfPrice {
+		return 0, false
+	}
+	return roundCents(price), true
+}
+
+// EffectivePrice is what a product really costs at the time it was observed,
+// with the cheapest active promotion applied. PricePerUnit is the effective
+// price per BaseUnit ("kg", "l" or "unit"), derived from the shelf unit price.
+// PromotionPosition is the position of the applied promotion, or -1 when no
+// promotion lowers the price.
+type EffectivePrice struct {
+	UnitPrice         float64 `json:"unit_price"`
+	PricePerUnit      float64 `json:"price_per_unit,omitempty"`
+	BaseUnit          string  `json:"base_unit,omitempty"`
+	Mechanic          string  `json:"mechanic,omitempty"`
+	RequiredQuantity  int     `json:"required_quantity"`
+	PromotionPosition int     `json:"promotion_position"`
+}
+
+// CalculateEffectivePrice computes the effective price of a product from its
+// shelf price and the promotions active when it was observed. A promotional
+// price exposed by the API is preferred over one derived from the description.
+func CalculateEffectivePrice(product Product) EffectivePrice {
+	effective := EffectivePrice{
+		UnitPrice:         product.ProductPriceAmount,
+		RequiredQuantity:  1,
+		PromotionPosition: -1,
+	}
+
+	for _, promotion := range product.Promotions {
+		if !promotion.IsActive(product.CreatedAt) {
+			continue
+		}
+
+		mechanic := ParsePromotionMechanic(promotion.Description)
+		price, ok := mechanic.UnitPrice(product.ProductPriceAmount)
+		if promotion.DiscountedPrice > 0 && promotion.DiscountedPrice < product.ProductPriceAmount {
+			price, ok = promotion.DiscountedPrice, true
+			if mechanic.Kind == MechanicNone {
+				mechanic = PromotionMechanic{Kind: MechanicPromoPrice, Quantity: promotion.RequiredQuantity}
+			}
+		}
+
+		if ok && price < effective.UnitPrice {
+			effective.UnitPrice = price
+			effective.Mechanic = mechanic.Kind
+			effective.RequiredQuantity = mechanic.Quantity
+			effective.PromotionPosition = promotion.Position
+		}
+	}
+
+	// Scale the shelf price per kg/litre by the promotional discount
+	baseUnit, factor := unitPriceBase(product.ProductUnitPriceUnit)
+	if baseUnit != "" && product.ProductUnitPriceAmount > 0 && product.ProductPriceAmount > 0 {
+		effective.BaseUnit = baseUnit
+		effective.PricePerUnit = roundCents(product.ProductUnitPriceAmount * factor * effective.UnitPrice / product.ProductPriceAmount)
+	}
+
+	return effective
+}
+
+// unitPriceBase maps a unit price unit such as "fop.price.per.kg" to a base
+// unit and the factor converting the unit price to a price per base unit.
+func unitPriceBase(unit string) (string, float64) {
+	unit = strings.ToLower(unit)
+	unit = unit[strings.LastIndex(unit, ".")+1:]
+
+	switch unit {
+	case "kg", "kilo", "kilogram":
+		return "kg", 1
+	case "100g":
+		return "kg", 10
+	case "g":
+		return "kg", 1000
+	case "l", "litre", "liter", "litro":
+		return "l", 1
+	case "100ml":
+		return "l", 10
+	case "ml":
+		return "l", 1000
+	case "each", "unit", "unitat", "u":
+		return "unit", 1
+	default:
+		return "", 0
+	}
+}
+
+// parseDecimal parses a number that may use a decimal comma.
+func parseDecimal(value string) float64 {
+	number, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+// roundCents rounds a price to whole cents.
+func roundCents(price float64) float64 {
+	return math.Round(price*100) / 100
+}
+
+// RankedProduct is a product with the effective price of its latest
+// observation, as returned by the effective price ranking.
+type RankedProduct struct {
+	Retailer           string    `json:"retailer"`
+	ProductID          int       `json:"product_id"`
+	ProductName        string    `json:"product_name"`
+	ProductBrand       string    `json:"product_brand"`
+	ProductPriceAmount float64   `json:"product_price_amount"`
+	EffectiveUnitPrice float64   `json:"effective_unit_price"`
+	PricePerUnit       float64   `json:"price_per_unit"`
+	BaseUnit           string    `json:"base_unit"`
+	Mechanic           string    `json:"mechanic,omitempty"`
+	RequiredQuantity   int       `json:"required_quantity"`
+	ObservedAt         time.Time `json:"observed_at"`
+}
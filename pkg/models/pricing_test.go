@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePromotionMechanic(t *testing.T) {
+	tests := []struct {
+		description string
+		kind        string
+		quantity    int
+		unitPrice   float64 // at a shelf price of 10
+	}{
+		{"3x2", MechanicMultiBuy, 3, 6.67},
+		{"2x1", MechanicMultiBuy, 2, 5},
+		{"2a unitat -50%", MechanicNthUnitDiscount, 2, 7.5},
+		{"3a unitat -70%", MechanicNthUnitDiscount, 3, 7.67},
+		{"2ª unidad al 70%", MechanicNthUnitDiscount, 2, 6.5},
+		{"-20%", MechanicPercentDiscount, 1, 8},
+		{"15% de descompte", MechanicPercentDiscount, 1, 8.5},
+		{"-1,50 €", MechanicFixedDiscount, 1, 8.5},
+		{"2 per 15 €", MechanicBundlePrice, 2, 7.5},
+		{"3x25€", MechanicBundlePrice, 3, 8.33},
+		{"Preu especial", MechanicNone, 1, 0},
+		{"1x2", MechanicNone, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			mechanic := ParsePromotionMechanic(tt.description)
+			if mechanic.Kind != tt.kind || mechanic.Quantity != tt.quantity {
+				t.Fatalf("mechanic = %+v, want kind %q quantity %d", mechanic, tt.kind, tt.quantity)
+			}
+			price, ok := mechanic.UnitPrice(10)
+			if ok != (tt.unitPrice > 0) || price != tt.unitPrice {
+				t.Errorf("UnitPrice(10) = %v, %v, want %v", price, ok, tt.unitPrice)
+			}
+		})
+	}
+}
+
+func TestCalculateEffectivePrice(t *testing.T) {
+	observedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	expired := observedAt.AddDate(0, 0, -1)
+
+	product := Product{
+		ProductPriceAmount:     4.00,
+		ProductUnitPriceAmount: 8.00,
+		ProductUnitPriceUnit:   "fop.price.per.kg",
+		CreatedAt:              observedAt,
+		Promotions: []Promotion{
+			{Position: 0, Description: "-10%"},
+			{Position: 1, Description: "2x1", EndDate: &expired},
+			{Position: 2, Description: "3x2"},
+		},
+	}
+
+	effective := CalculateEffectivePrice(product)
+	if effective.UnitPrice != 2.67 || effective.Mechanic != MechanicMultiBuy || effective.PromotionPosition != 2 || effective.RequiredQuantity != 3 {
+		t.Errorf("effective price = %+v, want the 3x2 at 2.67", effective)
+	}
+	if effective.BaseUnit != "kg" || effective.PricePerUnit != 5.34 {
+		t.Errorf("price per unit = %v/%s, want 5.34/kg", effective.PricePerUnit, effective.BaseUnit)
+	}
+
+	// An exposed promotional price is used even when the mechanics are unknown
+	product.Promotions = []Promotion{{Position: 0, Description: "Oferta", DiscountedPrice: 3.50, RequiredQuantity: 1}}
+	effective = CalculateEffectivePrice(product)
+	if effective.UnitPrice != 3.50 || effective.Mechanic != MechanicPromoPrice {
+		t.Errorf("effective price = %+v, want the exposed 3.50", effective)
+	}
+
+	// Without promotions the shelf price applies
+	product.Promotions = nil
+	product.ProductUnitPriceUnit = "fop.price.per.100g"
+	effective = CalculateEffectivePrice(product)
+	if effective.UnitPrice != 4.00 || effective.PromotionPosition != -1 || effective.PricePerUnit != 80 {
+		t.Errorf("effective price = %+v, want the shelf price", effective)
+	}
+}
@@ -1,7 +1,6 @@
 package models
 
 import (
-	"strconv"
 	"strings"
 	"time"
 )
@@ -10,29 +9,38 @@ import (
 // It contains all the essential product information including pricing,
 // availability, categories, and metadata.
 type Product struct {
-	ProductID                  int       `json:"product_id"`
-	ProductType                string    `json:"product_type"`
-	ProductName                string    `json:"product_name"`
-	ProductDescription         string    `json:"product_description"`
-	ProductBrand               string    `json:"product_brand"`
-	ProductPackSizeDescription string    `json:"product_pack_size_description"`
-	ProductPriceAmount         float64   `json:"product_price_amount"`
-	ProductCurrency            string    `json:"product_currency"`
-	ProductUnitPriceAmount     float64   `json:"product_unit_price_amount"`
-	ProductUnitPriceCurrency   string    `json:"product_unit_price_currency"`
-	ProductUnitPriceUnit       string    `json:"product_unit_price_unit"`
-	ProductAvailable           bool      `json:"product_available"`
-	ProductAlcohol             bool      `json:"product_alcohol"`
-	ProductCookingGuidelines   string    `json:"product_cooking_guidelines"`
-	ProductCategories          []string  `json:"product_categories"`
-	PromotionType              string    `json:"promotion_type"`
-	CreatedAt                  time.Time `json:"created_at"`
+	Retailer                   string             `json:"retailer"`
+	ProductID                  int                `json:"product_id"`
+	ProductType                string             `json:"product_type"`
+	ProductName                string             `json:"product_name"`
+	ProductDescription         string             `json:"product_description"`
+	ProductBrand               string             `json:"product_brand"`
+	ProductPackSizeDescription string             `json:"product_pack_size_description"`
+	ProductPriceAmount         float64            `json:"product_price_amount"`
+	ProductCurrency            string             `json:"product_currency"`
+	ProductUnitPriceAmount     float64            `json:"product_unit_price_amount"`
+	ProductUnitPriceCurrency   string             `json:"product_unit_price_currency"`
+	ProductUnitPriceUnit       string             `json:"product_unit_price_unit"`
+	ProductAvailable           bool               `json:"product_available"`
+	ProductAlcohol             bool               `json:"product_alcohol"`
+	ProductCookingGuidelines   string             `json:"product_cooking_guidelines"`
+	ProductCategories          []string           `json:"product_categories"`
+	ProductGTINs               []string           `json:"product_gtins,omitempty"`
+	Details                    ProductDetails     `json:"details"`
+	Attributes                 []ProductAttribute `json:"attributes,omitempty"`
+	Images                     []ProductImage     `json:"images,omitempty"`
+	NutriScore                 NutriScore         `json:"nutri_score"`
+	PromotionType              string             `json:"promotion_type"`
+	Promotions                 []Promotion        `json:"promotions,omitempty"`
+	PackSize                   PackSize           `json:"pack_size"`
+	CreatedAt                  time.Time          `json:"created_at"`
 }
 
 // ProductNutritionalData represents nutritional information for a product.
 // It contains the nutritional value name and quantity for a specific product.
 type ProductNutritionalData struct {
 	ID                         *int      `json:"id,omitempty"`
+	Retailer                   string    `json:"retailer"`
 	ProductID                  int       `json:"product_id"`
 	ProductNutritionalValue    string    `json:"product_nutritional_value"`
 	ProductNutritionalQuantity string    `json:"product_nutritional_quantity"`
@@ -102,19 +110,19 @@ func ParseProductFromResponse(responseJSON map[string]interface{}, productID int
 	if productData, ok := responseJSON["product"].(map[string]interface{}); ok {
 		// Basic product information
 		if productType, ok := productData["type"].(string); ok {
-			product.ProductType = productType
+			product.ProductType = cleanText(productType)
 		}
 		if productName, ok := productData["name"].(string); ok {
-			product.ProductName = strings.ReplaceAll(productName, "<br />", "")
+			product.ProductName = cleanText(productName)
 		}
 		if productDescription, ok := productData["description"].(string); ok {
-			product.ProductDescription = strings.ReplaceAll(productDescription, "<br />", "")
+			product.ProductDescription = cleanText(productDescription)
 		}
 		if productBrand, ok := productData["brand"].(string); ok {
-			product.ProductBrand = productBrand
+			product.ProductBrand = cleanText(productBrand)
 		}
 		if packSizeDescription, ok := productData["packSizeDescription"].(string); ok {
-			product.ProductPackSizeDescription = packSizeDescription
+			product.ProductPackSizeDescription = cleanText(packSizeDescription)
 		}
 		if available, ok := productData["available"].(bool); ok {
 			product.ProductAvailable = available
@@ -125,34 +133,22 @@ func ParseProductFromResponse(responseJSON map[string]interface{}, productID int
 
 		// Price information
 		if priceData, ok := productData["price"].(map[string]interface{}); ok {
-			if amount, ok := priceData["amount"].(float64); ok {
-				product.ProductPriceAmount = amount
-			} else if amountStr, ok := priceData["amount"].(string); ok {
-				if amount, err := strconv.ParseFloat(amountStr, 64); err == nil {
-					product.ProductPriceAmount = amount
-				}
-			}
+			product.ProductPriceAmount = parseAmount(priceData["amount"])
 			if currency, ok := priceData["currency"].(string); ok {
-				product.ProductCurrency = currency
+				product.ProductCurrency = cleanText(currency)
 			}
 		}
 
 		// Unit price information
 		if unitPriceData, ok := productData["unitPrice"].(map[string]interface{}); ok {
 			if unitPricePrice, ok := unitPriceData["price"].(map[string]interface{}); ok {
-				if amount, ok := unitPricePrice["amount"].(float64); ok {
-					product.ProductUnitPriceAmount = amount
-				} else if amountStr, ok := unitPricePrice["amount"].(string); ok {
-					if amount, err := strconv.ParseFloat(amountStr, 64); err == nil {
-						product.ProductUnitPriceAmount = amount
-					}
-				}
+				product.ProductUnitPriceAmount = parseAmount(unitPricePrice["amount"])
 				if currency, ok := unitPricePrice["currency"].(string); ok {
-					product.ProductUnitPriceCurrency = currency
+					product.ProductUnitPriceCurrency = cleanText(currency)
 				}
 			}
 			if unit, ok := unitPriceData["unit"].(string); ok {
-				product.ProductUnitPriceUnit = unit
+				product.ProductUnitPriceUnit = cleanText(unit)
 			}
 		}
 
@@ -160,38 +156,38 @@ func ParseProductFromResponse(responseJSON map[string]interface{}, productID int
 		if categoryPath, ok := productData["categoryPath"].([]interface{}); ok {
 			for _, cat := range categoryPath {
 				if catStr, ok := cat.(string); ok {
-					product.ProductCategories = append(product.ProductCategories, catStr)
+					product.ProductCategories = append(product.ProductCategories, cleanText(catStr))
 				}
 			}
 		}
 
-		// Extract description and cooking guidelines from bopData
+		// Extract description from bopData, and keep every bopData field as an attribute
 		if bopData, ok := responseJSON["bopData"].(map[string]interface{}); ok {
 			if detailedDesc, ok := bopData["detailedDescription"].(string); ok {
-				product.ProductDescription = strings.ReplaceAll(detailedDesc, "<br />", "")
+				product.ProductDescription = cleanText(detailedDesc)
 			}
+		}
+		product.Attributes = ParseProductAttributesFromResponse(responseJSON)
+		if attribute, ok := FindAttribute(product.Attributes, FieldCookingGuidelines); ok {
+			product.ProductCookingGuidelines = attribute.ContentText
+		}
 
-			// Extract cooking guidelines
-			if fields, ok := bopData["fields"].([]interface{}); ok {
-				for _, field := range fields {
-					if fieldMap, ok := field.(map[string]interface{}); ok {
-						if title, ok := fieldMap["title"].(string); ok && title == "cookingGuidelines" {
-							if content, ok := fieldMap["content"].(string); ok {
-								product.ProductCookingGuidelines = strings.ReplaceAll(content, "<br />", "")
-							}
-							break
-						}
-					}
-				}
-			}
+		product.Images = ParseProductImagesFromResponse(responseJSON)
+		product.ProductGTINs = ParseGTINsFromResponse(responseJSON, product.Attributes)
+		product.Details = ParseProductDetails(product.Attributes)
+		product.NutriScore = ComputeNutriScore(product, ParseNutritionFacts(ParseNutritionalDataFromResponse(responseJSON, productID)))
+
+		// Extract every promotion from bopPromotions; PromotionType keeps the first one's type
+		product.Promotions = ParsePromotionsFromResponse(responseJSON, productID, product.CreatedAt)
+		if len(product.Promotions) > 0 {
+			product.PromotionType = product.Promotions[0].Type
 		}
+		product.PackSize = ParseProductPackSize(product)
 
-		// Extract promotion type from bopPromotions
-		if bopPromotions, ok := responseJSON["bopPromotions"].([]interface{}); ok && len(bopPromotions) > 0 {
-			// Get the first promotion's type
-			if firstPromotion, ok := bopPromotions[0].(map[string]interface{}); ok {
-				if promoType, ok := firstPromotion["type"].(string); ok {
-					product.PromotionType = promoType
+		for i, promotion := range product.Promotions {
+			if promotion.DiscountedPrice == 0 {
+				if price, ok := ParsePromotionMechanic(promotion.Description).UnitPrice(product.ProductPriceAmount); ok {
+					product.Promotions[i].DiscountedPrice = price
 				}
 			}
 		}
@@ -204,24 +200,11 @@ func ParseProductFromResponse(responseJSON map[string]interface{}, productID int
 // It looks for the "nutritionalData" field in the BOP data and extracts
 // nutritional information from the HTML table content.
 func ParseNutritionalDataFromResponse(responseJSON map[string]interface{}, productID int) []ProductNutritionalData {
-	var nutritionalData []ProductNutritionalData
-
-	if bopData, ok := responseJSON["bopData"].(map[string]interface{}); ok {
-		if fields, ok := bopData["fields"].([]interface{}); ok {
-			for _, field := range fields {
-				if fieldMap, ok := field.(map[string]interface{}); ok {
-					if title, ok := fieldMap["title"].(string); ok && title == "nutritionalData" {
-						if content, ok := fieldMap["content"].(string); ok {
-							nutritionalData = parseNutritionalDataTable(content, productID)
-						}
-						break
-					}
-				}
-			}
-		}
+	attribute, ok := FindAttribute(ParseProductAttributesFromResponse(responseJSON), FieldNutritionalData)
+	if !ok {
+		return nil
 	}
-
-	return nutritionalData
+	return parseNutritionalDataTable(attribute.ContentHTML, productID)
 }
 
 // parseNutritionalDataTable parses the HTML table containing nutritional data.
@@ -245,13 +228,11 @@ func parseNutritionalDataTable(html string, productID int) []ProductNutritionalD
 		if len(cells) >= 3 { // At least 2 data cells + empty first element
 			// Extract nutritional value (first cell)
 			valueCell := cells[1]
-			value := strings.TrimSpace(strings.ReplaceAll(valueCell, "</td>", ""))
-			value = strings.ReplaceAll(value, "<br />", "")
+			value := cleanText(valueCell)
 
 			// Extract quantity (second cell)
 			quantityCell := cells[2]
-			quantity := strings.TrimSpace(strings.ReplaceAll(quantityCell, "</td>", ""))
-			quantity = strings.ReplaceAll(quantity, "<br />", "")
+			quantity := cleanText(quantityCell)
 
 			// Only add if we have both value and quantity
 			if value != "" && quantity != "" {
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"bonpreu-go/pkg/fakeserver"
+)
+
+// leftoverBreakPattern matches what remains of a <br /> tag, complete or not.
+var leftoverBreakPattern = regexp.MustCompile(`(?i)<br\b`)
+
+// checkTextField fails the test if a text field still contains HTML.
+func checkTextField(t *testing.T, field, value string) {
+	t.Helper()
+	if leftoverBreakPattern.MatchString(value) || htmlTagPattern.MatchString(value) {
+		t.Errorf("%s contains HTML: %q", field, value)
+	}
+}
+
+// checkProductInvariants verifies the properties every parsed product must hold.
+func checkProductInvariants(t *testing.T, product Product, productID int) {
+	t.Helper()
+
+	if product.ProductID != productID {
+		t.Errorf("ProductID = %d, want %d", product.ProductID, productID)
+	}
+	for _, price := range []float64{product.ProductPriceAmount, product.ProductUnitPriceAmount} {
+		if price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+			t.Errorf("invalid price %v", price)
+		}
+	}
+
+	checkTextField(t, "ProductType", product.ProductType)
+	checkTextField(t, "ProductName", product.ProductName)
+	checkTextField(t, "ProductDescription", product.ProductDescription)
+	checkTextField(t, "ProductBrand", product.ProductBrand)
+	checkTextField(t, "ProductPackSizeDescription", product.ProductPackSizeDescription)
+	checkTextField(t, "ProductCurrency", product.ProductCurrency)
+	checkTextField(t, "ProductUnitPriceCurrency", product.ProductUnitPriceCurrency)
+	checkTextField(t, "ProductUnitPriceUnit", product.ProductUnitPriceUnit)
+	checkTextField(t, "ProductCookingGuidelines", product.ProductCookingGuidelines)
+	checkTextField(t, "PromotionType", product.PromotionType)
+	for _, category := range product.ProductCategories {
+		checkTextField(t, "ProductCategories", category)
+	}
+	if product.PackSize.NetQuantity < 0 || math.IsNaN(product.PackSize.NetQuantity) || math.IsInf(product.PackSize.NetQuantity, 0) {
+		t.Errorf("invalid net quantity %v", product.PackSize.NetQuantity)
+	}
+	for _, promotion := range product.Promotions {
+		checkTextField(t, "Promotion.Type", promotion.Type)
+		checkTextField(t, "Promotion.Description", promotion.Description)
+		if promotion.ProductID != productID || promotion.RequiredQuantity < 1 || promotion.DiscountedPrice < 0 {
+			t.Errorf("invalid promotion %+v", promotion)
+		}
+	}
+}
+
+// checkNutritionalInvariants verifies the properties every parsed nutrition row must hold.
+func checkNutritionalInvariants(t *testing.T, data []ProductNutritionalData, productID int) {
+	t.Helper()
+
+	for _, entry := range data {
+		if entry.ProductID != productID {
+			t.Errorf("nutritional ProductID = %d, want %d", entry.ProductID, productID)
+		}
+		if entry.ProductNutritionalValue == "" || entry.ProductNutritionalQuantity == "" {
+			t.Errorf("empty nutritional entry: %+v", entry)
+		}
+		checkTextField(t, "ProductNutritionalValue", entry.ProductNutritionalValue)
+		checkTextField(t, "ProductNutritionalQuantity", entry.ProductNutritionalQuantity)
+	}
+}
+
+// addFixtureSeeds seeds a fuzz target with the fake server's real response fixtures.
+func addFixtureSeeds(f *testing.F) {
+	for _, id := range fakeserver.FixtureIDs() {
+		f.Add(fakeserver.Fixture(id), id)
+	}
+}
+
+func FuzzParseProductFromResponse(f *testing.F) {
+	addFixtureSeeds(f)
+	f.Add([]byte(`{"product":{"name":"<b>Pa</b>","price":{"amount":"-1"}}}`), 1)
+	f.Add([]byte(`{"product":{"price":{"amount":"NaN"},"unitPrice":{"price":{"amount":"+Inf"}}}}`), 2)
+
+	f.Fuzz(func(t *testing.T, data []byte, productID int) {
+		var responseJSON map[string]interface{}
+		if err := json.Unmarshal(data, &responseJSON); err != nil {
+			return
+		}
+
+		product := ParseProductFromResponse(responseJSON, productID)
+		checkProductInvariants(t, product, productID)
+	})
+}
+
+func FuzzParseNutritionalDataFromResponse(f *testing.F) {
+	addFixtureSeeds(f)
+	f.Add([]byte(`{"bopData":{"fields":[{"title":"nutritionalData","content":"<tr><td>Sal<td>"}]}}`), 3)
+
+	f.Fuzz(func(t *testing.T, data []byte, productID int) {
+		var responseJSON map[string]interface{}
+		if err := json.Unmarshal(data, &responseJSON); err != nil {
+			return
+		}
+
+		nutritionalData := ParseNutritionalDataFromResponse(responseJSON, productID)
+		checkNutritionalInvariants(t, nutritionalData, productID)
+	})
+}
+
+func FuzzParseNutritionalDataTable(f *testing.F) {
+	for _, id := range fakeserver.FixtureIDs() {
+		var response struct {
+			BopData BopData `json:"bopData"`
+		}
+		if err := json.Unmarshal(fakeserver.Fixture(id), &response); err != nil {
+			f.Fatalf("fixture %d: %v", id, err)
+		}
+		for _, field := range response.BopData.Fields {
+			if field.Title == "nutritionalData" {
+				f.Add(field.Content, id)
+			}
+		}
+	}
+	f.Add("<tr><td>Greixos</td><td>3,5 g</td></tr></table>", 4)
+	f.Add("<tr><td><b>Sal</b></td><td>&lt;0,01 g</td>", 5)
+
+	f.Fuzz(func(t *testing.T, html string, productID int) {
+		nutritionalData := parseNutritionalDataTable(html, productID)
+		checkNutritionalInvariants(t, nutritionalData, productID)
+	})
+}
+
+// randomResponse is a quick.Generator producing API responses with random
+// shapes: every known key may be missing, of the wrong type, or hold text
+// mixed with HTML fragments.
+type randomResponse map[string]interface{}
+
+// htmlFragments are mixed into generated strings to exercise text cleaning.
+var htmlFragments = []string{"<br />", "<br>", "<BR/>", "<b>", "</td>", "</tr></table>", "&lt;i&gt;", "<<b>b>", "<!-- x -->", "&amp;", " ", "\n"}
+
+func randomText(r *rand.Rand) string {
+	var sb strings.Builder
+	for i := r.Intn(6); i > 0; i-- {
+		if r.Intn(2) == 0 {
+			sb.WriteString(htmlFragments[r.Intn(len(htmlFragments))])
+		} else {
+			sb.WriteString([]string{"Llet", "Oli", "3,5 g", "Sal", "<", ">"}[r.Intn(6)])
+		}
+	}
+	return sb.String()
+}
+
+// randomValue returns either a value of the expected kind or a random other kind.
+func randomValue(r *rand.Rand, expected func() interface{}) interface{} {
+	switch r.Intn(8) {
+	case 0:
+		return nil
+	case 1:
+		return r.NormFloat64() * 100
+	case 2:
+		return randomText(r)
+	case 3:
+		return []interface{}{randomText(r), r.Float64()}
+	default:
+		return expected()
+	}
+}
+
+func (randomResponse) Generate(r *rand.Rand, size int) reflect.Value {
+	text := func() interface{} { return randomText(r) }
+	amount := func() interface{} {
+		if r.Intn(2) == 0 {
+			return r.NormFloat64() * 10
+		}
+		return []string{"1.25", "-3", "NaN", "Inf", "abc", ""}[r.Intn(6)]
+	}
+	price := func() interface{} {
+		return map[string]interface{}{"amount": randomValue(r, amount), "currency": randomValue(r, text)}
+	}
+	fields := func() interface{} {
+		var list []interface{}
+		for i := r.Intn(4); i > 0; i-- {
+			title := []string{"nutritionalData", "cookingGuidelines", "ingredients"}[r.Intn(3)]
+			content := randomText(r)
+			if title == "nutritionalData" {
+				content = "<table><tr><th>x</th></tr><tr><td>" + randomText(r) + "</td><td>" + randomText(r) + "</td></tr></table>"
+			}
+			list = append(list, map[string]interface{}{"title": title, "content": randomValue(r, func() interface{} { return content })})
+		}
+		return list
+	}
+
+	response := randomResponse{
+		"product": randomValue(r, func() interface{} {
+			return map[string]interface{}{
+				"type":                randomValue(r, text),
+				"name":                randomValue(r, text),
+				"description":         randomValue(r, text),
+				"brand":               randomValue(r, text),
+				"packSizeDescription": randomValue(r, text),
+				"price":               randomValue(r, price),
+				"unitPrice":           randomValue(r, func() interface{} { return map[string]interface{}{"price": price(), "unit": text()} }),
+				"available":           randomValue(r, func() interface{} { return r.Intn(2) == 0 }),
+				"categoryPath":        randomValue(r, func() interface{} { return []interface{}{text(), text()} }),
+			}
+		}),
+		"bopData": randomValue(r, func() interface{} {
+			return map[string]interface{}{"detailedDescription": randomValue(r, text), "fields": randomValue(r, fields)}
+		}),
+		"bopPromotions": randomValue(r, func() interface{} {
+			return []interface{}{map[string]interface{}{"type": randomValue(r, text)}}
+		}),
+	}
+	return reflect.ValueOf(response)
+}
+
+func TestParseProductFromResponseProperties(t *testing.T) {
+	property := func(response randomResponse, productID int) bool {
+		product := ParseProductFromResponse(response, productID)
+		checkProductInvariants(t, product, productID)
+		return !t.Failed()
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseNutritionalDataFromResponseProperties(t *testing.T) {
+	property := func(response randomResponse, productID int) bool {
+		nutritionalData := ParseNutritionalDataFromResponse(response, productID)
+		checkNutritionalInvariants(t, nutritionalData, productID)
+		return !t.Failed()
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseProductFromFixtures(t *testing.T) {
+	for _, id := range fakeserver.FixtureIDs() {
+		var responseJSON map[string]interface{}
+		if err := json.Unmarshal(fakeserver.Fixture(id), &responseJSON); err != nil {
+			t.Fatalf("fixture %d: %v", id, err)
+		}
+
+		product := ParseProductFromResponse(responseJSON, id)
+		checkProductInvariants(t, product, id)
+		if product.ProductName == "" || product.ProductPriceAmount <= 0 {
+			t.Errorf("fixture %d parsed without name or price: %+v", id, product)
+		}
+	}
+}
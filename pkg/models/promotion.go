@@ -0,0 +1,153 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Promotion represents one entry of the bopPromotions array of a product,
+// as seen in a single observation of the product.
+// DiscountedPrice is the average price of a single unit with the promotion,
+// as exposed by the API or derived from the mechanics, and zero when unknown.
+// RequiredQuantity is the number of units that must be bought to get the
+// promotion. StartDate and EndDate are nil when the promotion has no known
+// validity period.
+type Promotion struct {
+	Retailer         string     `json:"retailer"`
+	ProductID        int        `json:"product_id"`
+	ObservationID    int64      `json:"observation_id,omitempty"`
+	Position         int        `json:"position"`
+	Type             string     `json:"type"`
+	Description      string     `json:"description"`
+	StartDate        *time.Time `json:"start_date,omitempty"`
+	EndDate          *time.Time `json:"end_date,omitempty"`
+	DiscountedPrice  float64    `json:"discounted_price,omitempty"`
+	RequiredQuantity int        `json:"required_quantity"`
+	ObservedAt       time.Time  `json:"observed_at"`
+}
+
+// ActivePromotion is a promotion together with the product it applies to,
+// as returned by the active promotions query.
+type ActivePromotion struct {
+	Promotion
+	ProductName        string  `json:"product_name"`
+	ProductBrand       string  `json:"product_brand"`
+	ProductPriceAmount float64 `json:"product_price_amount"`
+}
+
+// IsActive reports whether the promotion is valid at the given time.
+// Missing start or end dates are treated as open-ended, and an end date
+// without a time of day, parsed as midnight UTC, includes the whole day.
+func (p Promotion) IsActive(at time.Time) bool {
+	if p.StartDate != nil && at.Before(*p.StartDate) {
+		return false
+	}
+	if p.EndDate != nil {
+		end := p.EndDate.UTC()
+		if end.Equal(end.Truncate(24 * time.Hour)) {
+			return at.Before(end.AddDate(0, 0, 1))
+		}
+		if at.After(end) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePromotionsFromResponse parses every entry of the bopPromotions array
+// of a raw API response. Entries that are not objects are skipped.
+// ParseProductFromResponse additionally derives the discounted price from the
+// promotion mechanics when the API does not expose it.
+func ParsePromotionsFromResponse(responseJSON map[string]interface{}, productID int, observedAt time.Time) []Promotion {
+	var promotions []Promotion
+
+	bopPromotions, ok := responseJSON["bopPromotions"].([]interface{})
+	if !ok {
+		return promotions
+	}
+
+	for _, entry := range bopPromotions {
+		promotionData, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		promotion := Promotion{
+			ProductID:        productID,
+			Position:         len(promotions),
+			RequiredQuantity: 1,
+			ObservedAt:       observedAt,
+		}
+		if promoType, ok := promotionData["type"].(string); ok {
+			promotion.Type = cleanText(promoType)
+		}
+		if description, ok := promotionData["description"].(string); ok {
+			promotion.Description = cleanText(description)
+		}
+		promotion.StartDate = parsePromotionDate(promotionData["startDate"])
+		promotion.EndDate = parsePromotionDate(promotionData["endDate"])
+
+		// The promotional price is given either as a price object or a bare amount
+		for _, key := range []string{"promotionPrice", "promoPrice", "price"} {
+			if priceData, ok := promotionData[key].(map[string]interface{}); ok {
+				promotion.DiscountedPrice = parseAmount(priceData["amount"])
+			} else if promotionData[key] != nil {
+				promotion.DiscountedPrice = parseAmount(promotionData[key])
+			}
+			if promotion.DiscountedPrice > 0 {
+				break
+			}
+		}
+
+		if quantity := parseQuantity(promotionData["requiredQuantity"]); quantity > 0 {
+			promotion.RequiredQuantity = quantity
+		} else if quantity := parseQuantity(promotionData["quantity"]); quantity > 0 {
+			promotion.RequiredQuantity = quantity
+		} else {
+			// Infer it from the mechanics: "3x2" requires 3 units and "2a unitat -50%" requires 2
+			promotion.RequiredQuantity = ParsePromotionMechanic(promotion.Description).Quantity
+		}
+
+		promotions = append(promotions, promotion)
+	}
+
+	return promotions
+}
+
+// parsePromotionDate reads a promotion date given as an RFC 3339 timestamp,
+// a plain date or milliseconds since the epoch. It returns nil when the date
+// is missing or malformed.
+func parsePromotionDate(value interface{}) *time.Time {
+	switch v := value.(type) {
+	case string:
+		v = strings.TrimSpace(v)
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if date, err := time.Parse(layout, v); err == nil {
+				return &date
+			}
+		}
+	case float64:
+		if v > 0 {
+			date := time.UnixMilli(int64(v)).UTC()
+			return &date
+		}
+	}
+	return nil
+}
+
+// parseQuantity reads a positive whole quantity given as a JSON number or a string.
+// It returns zero when the quantity is missing or malformed.
+func parseQuantity(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		if v >= 1 && v <= 1000 {
+			return int(v)
+		}
+	case string:
+		if quantity, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && quantity >= 1 && quantity <= 1000 {
+			return quantity
+		}
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/fakeserver"
+)
+
+func TestParsePromotionsFromResponse(t *testing.T) {
+	response := `{"bopPromotions": [
+		{"type": "OFFER", "description": "2a unitat -50%", "startDate": "2026-10-01T00:00:00Z", "endDate": "2026-10-31"},
+		{"type": "MULTI_BUY", "description": "3x2"},
+		"not a promotion",
+		{"type": "PRICE", "description": "Preu rebaixat", "promotionPrice": {"amount": "1.99"}, "requiredQuantity": 1, "endDate": 1793491199000},
+		{"type": "BUNDLE", "description": "Pack &lt;b&gt;estalvi&lt;/b&gt;", "quantity": "4", "startDate": "someday"}
+	]}`
+	var responseJSON map[string]interface{}
+	if err := json.Unmarshal([]byte(response), &responseJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	observedAt := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	promotions := ParsePromotionsFromResponse(responseJSON, 42, observedAt)
+	if len(promotions) != 4 {
+		t.Fatalf("got %d promotions, want 4: %+v", len(promotions), promotions)
+	}
+
+	want := []struct {
+		promoType   string
+		description string
+		quantity    int
+		price       float64
+		hasStart    bool
+		hasEnd      bool
+	}{
+		{"OFFER", "2a unitat -50%", 2, 0, true, true},
+		{"MULTI_BUY", "3x2", 3, 0, false, false},
+		{"PRICE", "Preu rebaixat", 1, 1.99, false, true},
+		{"BUNDLE", "Pack estalvi", 4, 0, false, false},
+	}
+	for i, w := range want {
+		got := promotions[i]
+		if got.ProductID != 42 || got.Position != i || !got.ObservedAt.Equal(observedAt) {
+			t.Errorf("promotion %d: wrong identity %+v", i, got)
+		}
+		if got.Type != w.promoType || got.Description != w.description {
+			t.Errorf("promotion %d: type/description = %q/%q, want %q/%q", i, got.Type, got.Description, w.promoType, w.description)
+		}
+		if got.RequiredQuantity != w.quantity || got.DiscountedPrice != w.price {
+			t.Errorf("promotion %d: quantity/price = %d/%v, want %d/%v", i, got.RequiredQuantity, got.DiscountedPrice, w.quantity, w.price)
+		}
+		if (got.StartDate != nil) != w.hasStart || (got.EndDate != nil) != w.hasEnd {
+			t.Errorf("promotion %d: start/end = %v/%v", i, got.StartDate, got.EndDate)
+		}
+	}
+
+	if !promotions[0].IsActive(observedAt) || promotions[0].IsActive(observedAt.AddDate(0, 1, 0)) {
+		t.Error("promotion 0 should be active during October only")
+	}
+	if lastDay := time.Date(2026, 10, 31, 18, 30, 0, 0, time.UTC); !promotions[0].IsActive(lastDay) {
+		t.Error("promotion 0 should be active until the end of its date-only end date")
+	}
+	if !promotions[1].IsActive(observedAt) {
+		t.Error("promotion without dates should always be active")
+	}
+}
+
+func TestParseProductKeepsAllPromotions(t *testing.T) {
+	var responseJSON map[string]interface{}
+	if err := json.Unmarshal(fakeserver.Fixture(90002), &responseJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	product := ParseProductFromResponse(responseJSON, 90002)
+	if len(product.Promotions) != 1 || product.PromotionType != "OFFER" {
+		t.Fatalf("promotions = %+v, type = %q", product.Promotions, product.PromotionType)
+	}
+	if !product.Promotions[0].ObservedAt.Equal(product.CreatedAt) {
+		t.Error("promotion should be observed at the product's creation time")
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+// Names of the supported retailers. Products are identified by their
+// retailer together with the retailer's own product ID, since IDs of
+// different retailers may collide.
+const (
+	RetailerBonpreu = "bonpreu"
+	RetailerEsclat  = "esclat"
+)
+
+// DefaultRetailer is the retailer of products stored before retailers were
+// introduced, and the one crawled when none is configured.
+const DefaultRetailer = RetailerBonpreu
+
+// RetailerNames lists every supported retailer.
+var RetailerNames = []string{RetailerBonpreu, RetailerEsclat}
+
+// IsRetailer reports whether name is a supported retailer.
+func IsRetailer(name string) bool {
+	for _, retailer := range RetailerNames {
+		if retailer == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductKey identifies a product across retailers.
+type ProductKey struct {
+	Retailer  string
+	ProductID int
+}
+
+// Key returns the key identifying the product.
+func (p Product) Key() ProductKey {
+	return ProductKey{Retailer: p.Retailer, ProductID: p.ProductID}
+}
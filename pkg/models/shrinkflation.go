@@ -0,0 +1,85 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// PackSizeChange is a pair of successive observations of a product whose pack
+// size description changed between them. OldPackSize and NewPackSize are the
+// pack sizes stored with the observations; only their NetQuantity, BaseUnit
+// and Confidence are set.
+type PackSizeChange struct {
+	Retailer       string    `json:"retailer"`
+	ProductID      int       `json:"product_id"`
+	ProductName    string    `json:"product_name"`
+	ProductBrand   string    `json:"product_brand"`
+	OldObservedAt  time.Time `json:"old_observed_at"`
+	NewObservedAt  time.Time `json:"new_observed_at"`
+	OldDescription string    `json:"old_pack_size_description"`
+	NewDescription string    `json:"new_pack_size_description"`
+	OldPrice       float64   `json:"old_price"`
+	NewPrice       float64   `json:"new_price"`
+	OldPackSize    PackSize  `json:"-"`
+	NewPackSize    PackSize  `json:"-"`
+}
+
+// ShrinkflationEvent is a product whose net quantity decreased while its shelf
+// price stayed the same or rose. Net quantities are in BaseUnit (g, ml or
+// units); unit prices are per UnitPriceUnit (kg, l or unit) and
+// UnitPriceIncrease is their relative increase in percent. LowConfidence is
+// set when either pack size was parsed with low confidence or inferred from
+// the unit price.
+type ShrinkflationEvent struct {
+	PackSizeChange
+	OldNetQuantity    float64   `json:"old_net_quantity"`
+	NewNetQuantity    float64   `json:"new_net_quantity"`
+	BaseUnit          string    `json:"base_unit"`
+	OldUnitPrice      float64   `json:"old_unit_price"`
+	NewUnitPrice      float64   `json:"new_unit_price"`
+	UnitPriceUnit     string    `json:"unit_price_unit"`
+	UnitPriceIncrease float64   `json:"unit_price_increase_percent"`
+	LowConfidence     bool      `json:"low_confidence"`
+	DetectedAt        time.Time `json:"detected_at"`
+}
+
+// DetectShrinkflation reports whether a pack size change is shrinkflation: both
+// stored pack sizes are known and in the same base unit, the net quantity
+// decreased and the shelf price did not.
+func DetectShrinkflation(change PackSizeChange) (ShrinkflationEvent, bool) {
+	oldSize, newSize := change.OldPackSize, change.NewPackSize
+
+	if oldSize.NetQuantity <= 0 || newSize.NetQuantity <= 0 {
+		return ShrinkflationEvent{}, false
+	}
+	if oldSize.BaseUnit != newSize.BaseUnit || newSize.NetQuantity >= oldSize.NetQuantity {
+		return ShrinkflationEvent{}, false
+	}
+	if change.OldPrice <= 0 || change.NewPrice < change.OldPrice {
+		return ShrinkflationEvent{}, false
+	}
+
+	// Express unit prices per kg or litre rather than per gram or millilitre
+	unitPriceUnit, factor := "unit", 1.0
+	switch oldSize.BaseUnit {
+	case BaseUnitGrams:
+		unitPriceUnit, factor = "kg", 1000
+	case BaseUnitMillilitre:
+		unitPriceUnit, factor = "l", 1000
+	}
+
+	oldUnitPrice := change.OldPrice / oldSize.NetQuantity * factor
+	newUnitPrice := change.NewPrice / newSize.NetQuantity * factor
+
+	return ShrinkflationEvent{
+		PackSizeChange:    change,
+		OldNetQuantity:    oldSize.NetQuantity,
+		NewNetQuantity:    newSize.NetQuantity,
+		BaseUnit:          oldSize.BaseUnit,
+		OldUnitPrice:      roundCents(oldUnitPrice),
+		NewUnitPrice:      roundCents(newUnitPrice),
+		UnitPriceUnit:     unitPriceUnit,
+		UnitPriceIncrease: math.Round((newUnitPrice/oldUnitPrice-1)*10000) / 100,
+		LowConfidence:     oldSize.Confidence != PackSizeConfidenceHigh || newSize.Confidence != PackSizeConfidenceHigh,
+	}, true
+}
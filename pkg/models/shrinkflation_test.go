@@ -0,0 +1,59 @@
+package models
+
+import "testing"
+
+// packSizeChange returns a change whose stored pack sizes are those parsed
+// from the descriptions, as SaveObservations stores them.
+func packSizeChange(oldDescription, newDescription string, oldPrice, newPrice float64) PackSizeChange {
+	return PackSizeChange{
+		OldDescription: oldDescription,
+		NewDescription: newDescription,
+		OldPrice:       oldPrice,
+		NewPrice:       newPrice,
+		OldPackSize:    ParsePackSize(oldDescription),
+		NewPackSize:    ParsePackSize(newDescription),
+	}
+}
+
+func TestDetectShrinkflation(t *testing.T) {
+	// A pack size inferred from the unit price when the description was stored
+	inferred := packSizeChange("safata", "450 g", 2.00, 2.00)
+	inferred.OldPackSize = PackSize{NetQuantity: 500, BaseUnit: BaseUnitGrams, Confidence: PackSizeConfidenceInferred}
+
+	tests := []struct {
+		name     string
+		change   PackSizeChange
+		want     bool
+		increase float64
+	}{
+		{"smaller pack, same price", packSizeChange("500 g", "450 g", 2.00, 2.00), true, 11.11},
+		{"smaller pack, higher price", packSizeChange("1 L", "900 ml", 1.80, 1.89), true, 16.67},
+		{"fewer units", packSizeChange("4 x 125 g", "3 x 125 g", 2.35, 2.35), true, 33.33},
+		{"smaller pack, lower price", packSizeChange("500 g", "450 g", 2.00, 1.80), false, 0},
+		{"bigger pack", packSizeChange("450 g", "500 g", 2.00, 2.00), false, 0},
+		{"different base unit", packSizeChange("1 kg", "900 ml", 2.00, 2.00), false, 0},
+		{"unparsable", packSizeChange("safata", "450 g", 2.00, 2.00), false, 0},
+		{"not stored", PackSizeChange{OldDescription: "500 g", NewDescription: "450 g", OldPrice: 2.00, NewPrice: 2.00}, false, 0},
+		{"stored pack size inferred", inferred, true, 11.11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := DetectShrinkflation(tt.change)
+			if ok != tt.want {
+				t.Fatalf("DetectShrinkflation = %v, want %v (%+v)", ok, tt.want, event)
+			}
+			if ok && event.UnitPriceIncrease != tt.increase {
+				t.Errorf("unit price increase = %v%%, want %v%%", event.UnitPriceIncrease, tt.increase)
+			}
+		})
+	}
+
+	event, _ := DetectShrinkflation(packSizeChange("500 g", "450 g", 2.00, 2.00))
+	if event.OldUnitPrice != 4.00 || event.NewUnitPrice != 4.44 || event.UnitPriceUnit != "kg" || event.LowConfidence {
+		t.Errorf("event = %+v", event)
+	}
+	if event, _ := DetectShrinkflation(inferred); !event.LowConfidence {
+		t.Errorf("event with an inferred pack size = %+v, want low confidence", event)
+	}
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The API returns text fields as HTML fragments and prices as numbers or
+// strings. The helpers below normalise them for every parser in this package,
+// so that stored text fields are plain text and stored amounts are finite and
+// non-negative whatever the upstream content.
+
+// htmlTagPattern matches HTML tags and comments.
+var htmlTagPattern = regexp.MustCompile(`<[^<>]*>`)
+
+// lineBreakPattern matches <br>, <br/> and <br /> in any case, including
+// truncated forms such as "<br /" that lack the closing bracket.
+var lineBreakPattern = regexp.MustCompile(`(?i)<br\b\s*/?>?`)
+
+// cleanText turns an HTML fragment from the API into plain text. Entities are
+// decoded, line breaks become spaces, remaining tags are stripped and runs
+// of whitespace are collapsed.
+func cleanText(value string) string {
+	value = html.UnescapeString(value)
+	value = lineBreakPattern.ReplaceAllString(value, " ")
+
+	// Strip until stable, since removing a tag can expose a new one ("<<b>b>")
+	for {
+		stripped := htmlTagPattern.ReplaceAllString(value, " ")
+		if stripped == value {
+			break
+		}
+		value = stripped
+	}
+
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// parseAmount reads a price amount given either as a JSON number or a string.
+// Missing, malformed, negative and non-finite amounts are returned as zero.
+func parseAmount(value interface{}) float64 {
+	var amount float64
+	switch v := value.(type) {
+	case float64:
+		amount = v
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0
+		}
+		amount = parsed
+	default:
+		return 0
+	}
+
+	if amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0
+	}
+	return amount
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCleanText(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Iogurt natural", "Iogurt natural"},
+		{"Iogurt natural<br />ecològic", "Iogurt natural ecològic"},
+		{"Línia 1<BR>Línia 2<br/>Línia 3", "Línia 1 Línia 2 Línia 3"},
+		{"Iogurt natural<br /", "Iogurt natural"},
+		{"<b>Llet</b> sencera", "Llet sencera"},
+		{"Oli d&#39;oliva &amp; sal", "Oli d'oliva & sal"},
+		{"&lt;b&gt;Llet&lt;/b&gt;", "Llet"},
+		{"<<b>b>Llet", "Llet"},
+		{"  4 x\n125 g\t", "4 x 125 g"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := cleanText(tt.input); got != tt.want {
+			t.Errorf("cleanText(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input interface{}
+		want  float64
+	}{
+		{2.35, 2.35},
+		{"2.35", 2.35},
+		{" 4.70 ", 4.70},
+		{"2,35", 0},
+		{"", 0},
+		{nil, 0},
+		{true, 0},
+		{-1.5, 0},
+		{"-1.5", 0},
+		{"NaN", 0},
+		{"Inf", 0},
+		{math.Inf(1), 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseAmount(tt.input); got != tt.want {
+			t.Errorf("parseAmount(%#v) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
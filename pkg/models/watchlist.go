@@ -0,0 +1,186 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Types of product events sent to webhooks.
+const (
+	EventPriceChanged     = "price_changed"
+	EventPromotionStarted = "promotion_started"
+	EventBackInStock      = "back_in_stock"
+)
+
+// EventTypes lists every product event type.
+var EventTypes = []string{EventPriceChanged, EventPromotionStarted, EventBackInStock}
+
+// WatchlistEntry selects products to notify about. An entry matches a single
+// product by ProductID, or every product whose name or brand contains
+// SearchQuery and/or whose category path contains Category; all the filters
+// that are set must match. Retailer restricts the entry to one retailer; an
+// empty Retailer matches every retailer. EventTypes restricts the events
+// sent; an empty list means every event type.
+type WatchlistEntry struct {
+	ID          int       `json:"id"`
+	Retailer    string    `json:"retailer,omitempty"`
+	ProductID   int       `json:"product_id,omitempty"`
+	SearchQuery string    `json:"search_query,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	EventTypes  []string  `json:"event_types,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Matches reports whether the entry selects the given product. Search and
+// category filters are case-insensitive.
+func (w WatchlistEntry) Matches(product Product) bool {
+	if w.ProductID == 0 && w.SearchQuery == "" && w.Category == "" {
+		return false
+	}
+	if w.Retailer != "" && w.Retailer != product.Retailer {
+		return false
+	}
+	if w.ProductID != 0 && w.ProductID != product.ProductID {
+		return false
+	}
+	if w.SearchQuery != "" {
+		query := strings.ToLower(w.SearchQuery)
+		if !strings.Contains(strings.ToLower(product.ProductName), query) &&
+			!strings.Contains(strings.ToLower(product.ProductBrand), query) {
+			return false
+		}
+	}
+	if w.Category != "" {
+		found := false
+		for _, category := range product.ProductCategories {
+			if strings.EqualFold(category, w.Category) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Wants reports whether the entry subscribes to the given event type.
+func (w WatchlistEntry) Wants(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, wanted := range w.EventTypes {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductState holds the fields of a product that are compared between two
+// observations. JSON names match those of Product.
+type ProductState struct {
+	ProductPriceAmount     float64   `json:"product_price_amount"`
+	ProductCurrency        string    `json:"product_currency"`
+	ProductUnitPriceAmount float64   `json:"product_unit_price_amount"`
+	ProductUnitPriceUnit   string    `json:"product_unit_price_unit"`
+	ProductAvailable       bool      `json:"product_available"`
+	PromotionType          string    `json:"promotion_type"`
+	ObservedAt             time.Time `json:"observed_at"`
+}
+
+// NewProductState returns the compared fields of a product as observed now.
+func NewProductState(product Product) ProductState {
+	return ProductState{
+		ProductPriceAmount:     product.ProductPriceAmount,
+		ProductCurrency:        product.ProductCurrency,
+		ProductUnitPriceAmount: product.ProductUnitPriceAmount,
+		ProductUnitPriceUnit:   product.ProductUnitPriceUnit,
+		ProductAvailable:       product.ProductAvailable,
+		PromotionType:          product.PromotionType,
+		ObservedAt:             product.CreatedAt,
+	}
+}
+
+// FieldChange is the old and new value of a Product field, by JSON name.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ProductEvent is a change of a watched product between two observations, as
+// sent to webhooks. Previous and Current hold the full compared state; Changes
+// lists the fields that triggered the event. WatchlistIDs are the entries that
+// selected the product.
+type ProductEvent struct {
+	Type         string        `json:"type"`
+	Retailer     string        `json:"retailer"`
+	ProductID    int           `json:"product_id"`
+	ProductName  string        `json:"product_name"`
+	ProductBrand string        `json:"product_brand"`
+	Previous     ProductState  `json:"previous"`
+	Current      ProductState  `json:"current"`
+	Changes      []FieldChange `json:"changes"`
+	WatchlistIDs []int         `json:"watchlist_ids"`
+	OccurredAt   time.Time     `json:"occurred_at"`
+}
+
+// DiffProduct compares a product with its previous observation and returns
+// an event for each price change, promotion start and return to stock.
+// Prices that are missing in either observation are not compared.
+func DiffProduct(previous ProductState, product Product) []ProductEvent {
+	current := NewProductState(product)
+
+	newEvent := func(eventType string, changes ...FieldChange) ProductEvent {
+		return ProductEvent{
+			Type:         eventType,
+			Retailer:     product.Retailer,
+			ProductID:    product.ProductID,
+			ProductName:  product.ProductName,
+			ProductBrand: product.ProductBrand,
+			Previous:     previous,
+			Current:      current,
+			Changes:      changes,
+			OccurredAt:   product.CreatedAt,
+		}
+	}
+
+	var events []ProductEvent
+	if previous.ProductPriceAmount > 0 && current.ProductPriceAmount > 0 &&
+		roundCents(previous.ProductPriceAmount) != roundCents(current.ProductPriceAmount) {
+		changes := []FieldChange{{"product_price_amount", previous.ProductPriceAmount, current.ProductPriceAmount}}
+		if previous.ProductUnitPriceAmount != current.ProductUnitPriceAmount {
+			changes = append(changes, FieldChange{"product_unit_price_amount", previous.ProductUnitPriceAmount, current.ProductUnitPriceAmount})
+		}
+		events = append(events, newEvent(EventPriceChanged, changes...))
+	}
+	if previous.PromotionType == "" && current.PromotionType != "" {
+		events = append(events, newEvent(EventPromotionStarted,
+			FieldChange{"promotion_type", previous.PromotionType, current.PromotionType}))
+	}
+	if !previous.ProductAvailable && current.ProductAvailable {
+		events = append(events, newEvent(EventBackInStock,
+			FieldChange{"product_available", previous.ProductAvailable, current.ProductAvailable}))
+	}
+
+	return events
+}
+
+// WebhookDeadLetter is a product event that could not be delivered to the
+// webhook after all retries. LastStatus is zero when no response was received.
+type WebhookDeadLetter struct {
+	ID           int64           `json:"id"`
+	EventType    string          `json:"event_type"`
+	Retailer     string          `json:"retailer"`
+	ProductID    int             `json:"product_id"`
+	URL          string          `json:"url"`
+	Payload      json.RawMessage `json:"payload"`
+	Attempts     int             `json:"attempts"`
+	LastStatus   int             `json:"last_status,omitempty"`
+	ErrorMessage string          `json:"error_message"`
+	FailedAt     time.Time       `json:"failed_at"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import "testing"
+
+func TestWatchlistEntryMatches(t *testing.T) {
+	product := Product{
+		Retailer:          RetailerBonpreu,
+		ProductID:         42,
+		ProductName:       "Oli d'oliva verge extra",
+		ProductBrand:      "Bonpreu",
+		ProductCategories: []string{"Alimentació", "Olis"},
+	}
+
+	tests := []struct {
+		name  string
+		entry WatchlistEntry
+		want  bool
+	}{
+		{"product ID", WatchlistEntry{ProductID: 42}, true},
+		{"other product ID", WatchlistEntry{ProductID: 7}, false},
+		{"search in name", WatchlistEntry{SearchQuery: "OLIVA"}, true},
+		{"search in brand", WatchlistEntry{SearchQuery: "bonpreu"}, true},
+		{"search mismatch", WatchlistEntry{SearchQuery: "gira-sol"}, false},
+		{"category", WatchlistEntry{Category: "olis"}, true},
+		{"search and category", WatchlistEntry{SearchQuery: "oliva", Category: "Begudes"}, false},
+		{"same retailer", WatchlistEntry{Retailer: RetailerBonpreu, ProductID: 42}, true},
+		{"other retailer", WatchlistEntry{Retailer: RetailerEsclat, ProductID: 42}, false},
+		{"no filter", WatchlistEntry{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Matches(product); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffProduct(t *testing.T) {
+	previous := ProductState{ProductPriceAmount: 4.99, ProductUnitPriceAmount: 4.99, ProductAvailable: false}
+	product := Product{
+		ProductID:              42,
+		ProductPriceAmount:     4.49,
+		ProductUnitPriceAmount: 4.49,
+		ProductAvailable:       true,
+		PromotionType:          "OFFER",
+	}
+
+	events := DiffProduct(previous, product)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+
+	wantTypes := []string{EventPriceChanged, EventPromotionStarted, EventBackInStock}
+	for i, event := range events {
+		if event.Type != wantTypes[i] {
+			t.Errorf("event %d type = %s, want %s", i, event.Type, wantTypes[i])
+		}
+		if event.Previous.ProductPriceAmount != 4.99 || event.Current.ProductPriceAmount != 4.49 {
+			t.Errorf("event %d states = %+v -> %+v", i, event.Previous, event.Current)
+		}
+	}
+	if change := events[0].Changes[0]; change.Field != "product_price_amount" || change.Old != 4.99 || change.New != 4.49 {
+		t.Errorf("price change = %+v", change)
+	}
+
+	unchanged := ProductState{ProductPriceAmount: 4.49, ProductUnitPriceAmount: 4.49, ProductAvailable: true, PromotionType: "OFFER"}
+	if events := DiffProduct(unchanged, product); len(events) != 0 {
+		t.Errorf("unchanged product produced events: %+v", events)
+	}
+}
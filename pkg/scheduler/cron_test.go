@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"@daily", time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC), time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC), time.Date(2024, 3, 5, 10, 45, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2024, 3, 8, 3, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 2, 30, 0, 0, time.UTC)},
+		{"0 6-22/4 * * *", time.Date(2024, 3, 5, 18, 1, 0, 0, time.UTC), time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 12 1 * 0", time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		// 02:30 does not exist on the spring-forward day
+		{"30 2 * * *", time.Date(2024, 3, 30, 12, 0, 0, 0, madrid), time.Date(2024, 4, 1, 2, 30, 0, 0, madrid)},
+		{"0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		schedule, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+		}
+		if got := schedule.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.spec, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "@sometimes"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestRunJobSkipsWhenLocked(t *testing.T) {
+	lock := NewLocalLock()
+	s := New(time.UTC, 0, lock)
+
+	schedule, _ := ParseCron("@hourly")
+	s.Add(Job{Name: "full", Schedule: schedule, LockKey: "crawl", Run: func(ctx context.Context) error { return nil }})
+	s.Add(Job{Name: "incremental", Schedule: schedule, LockKey: "crawl", Run: func(ctx context.Context) error { return errors.New("boom") }})
+
+	release, _, _ := lock(context.Background(), "crawl")
+	s.runJob(context.Background(), s.jobs[0])
+	release()
+	s.runJob(context.Background(), s.jobs[1])
+
+	status := s.Status()
+	if full := status.Jobs[0]; full.LastResult != ResultSkipped || full.Skipped != 1 || full.Runs != 0 {
+		t.Errorf("full = %+v, want a skipped run", full)
+	}
+	if incremental := status.Jobs[1]; incremental.LastResult != ResultError || incremental.LastError != "boom" || incremental.Failures != 1 {
+		t.Errorf("incremental = %+v, want a failed run", incremental)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	s := New(time.UTC, 0, nil)
+	schedule, _ := ParseCron("@yearly")
+	s.Add(Job{Name: "reports", Schedule: schedule, Run: func(ctx context.Context) error { return nil }})
+
+	var dbErr error
+	s.HealthCheck = func(ctx context.Context) error { return dbErr }
+	healthz := func() int {
+		recorder := httptest.NewRecorder()
+		s.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return recorder.Code
+	}
+
+	if code := healthz(); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz before Run = %d, want 503", code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+	for deadline := time.Now().Add(time.Second); s.Healthy(ctx) != nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	if code := healthz(); code != http.StatusOK {
+		t.Errorf("healthz while running = %d, want 200", code)
+	}
+	dbErr = errors.New("connection refused")
+	if code := healthz(); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz with a failing health check = %d, want 503", code)
+	}
+
+	cancel()
+	<-stopped
+	dbErr = nil
+	if code := healthz(); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz after Run = %d, want 503", code)
+	}
+}
@@ -0,0 +1,325 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"bonpreu-go/pkg/utils"
+)
+
+// LockFunc takes the lock named key without waiting. It returns ok=false when
+// the lock is held elsewhere, and otherwise a function releasing the lock.
+type LockFunc func(ctx context.Context, key string) (release func() error, ok bool, err error)
+
+// Job is a task run on a schedule.
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	// LockKey names the lock held while the job runs, so that jobs sharing a
+	// key never overlap. It defaults to the job name.
+	LockKey string
+	Run     func(ctx context.Context) error
+}
+
+// healthCheckTimeout bounds the HealthCheck call of /healthz.
+const healthCheckTimeout = 5 * time.Second
+
+// Results of a job run.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultSkipped = "skipped"
+)
+
+// JobStatus is the state of a job as reported by the status endpoint.
+type JobStatus struct {
+	Name                string     `json:"name"`
+	Schedule            string     `json:"schedule"`
+	Running             bool       `json:"running"`
+	NextRun             *time.Time `json:"next_run,omitempty"`
+	LastStart           *time.Time `json:"last_start,omitempty"`
+	LastEnd             *time.Time `json:"last_end,omitempty"`
+	LastDurationSeconds float64    `json:"last_duration_seconds,omitempty"`
+	LastResult          string     `json:"last_result,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	Runs                int        `json:"runs"`
+	Failures            int        `json:"failures"`
+	Skipped             int        `json:"skipped"`
+}
+
+// Status is the state of the scheduler as served on /status.
+type Status struct {
+	StartedAt time.Time   `json:"started_at"`
+	Timezone  string      `json:"timezone"`
+	Jobs      []JobStatus `json:"jobs"`
+}
+
+// Scheduler runs jobs on their schedules. Each run starts at a random delay
+// of up to the jitter after its scheduled time, and is skipped when the job's
+// lock is held, e.g. by a run of another job or of another process.
+type Scheduler struct {
+	location *time.Location
+	jitter   time.Duration
+	lock     LockFunc
+	logger   *utils.Logger
+
+	// OnResult, when set, is called after every run with the job name and result
+	OnResult func(job, result string)
+	// HealthCheck, when set, is called by /healthz to check the dependencies
+	// of the jobs, such as the database
+	HealthCheck func(ctx context.Context) error
+
+	mu        sync.Mutex
+	jobs      []*scheduledJob
+	startedAt time.Time
+	running   bool
+}
+
+// scheduledJob is a job with its status.
+type scheduledJob struct {
+	Job
+	status JobStatus
+}
+
+// New creates a scheduler evaluating schedules in location. A nil lock uses
+// locks local to the process.
+func New(location *time.Location, jitter time.Duration, lock LockFunc) *Scheduler {
+	if lock == nil {
+		lock = NewLocalLock()
+	}
+	return &Scheduler{
+		location: location,
+		jitter:   jitter,
+		lock:     lock,
+		logger:   utils.NewLogger("Scheduler"),
+	}
+}
+
+// Add registers a job. Jobs must be added before Run is called.
+func (s *Scheduler) Add(job Job) {
+	if job.LockKey == "" {
+		job.LockKey = job.Name
+	}
+	s.jobs = append(s.jobs, &scheduledJob{
+		Job:    job,
+		status: JobStatus{Name: job.Name, Schedule: job.Schedule.String()},
+	})
+}
+
+// Run runs the jobs until ctx is cancelled, then waits for running jobs to
+// return. Running jobs receive ctx and should stop early when it is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.startedAt = time.Now()
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job *scheduledJob) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+// loop waits for each scheduled time of a job and runs it.
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob) {
+	for {
+		next := job.Schedule.Next(time.Now().In(s.location))
+		if next.IsZero() {
+			s.logger.Error("Job %s has no upcoming run for schedule %q", job.Name, job.Schedule)
+			return
+		}
+		if s.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+		}
+
+		s.mu.Lock()
+		job.status.NextRun = &next
+		s.mu.Unlock()
+		s.logger.Info("Next %s run at %s", job.Name, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runJob(ctx, job)
+	}
+}
+
+// runJob runs a job once while holding its lock, and records the result.
+func (s *Scheduler) runJob(ctx context.Context, job *scheduledJob) {
+	release, ok, err := s.lock(ctx, job.LockKey)
+	if err != nil {
+		s.logger.Error("Error taking lock %s for job %s: %v", job.LockKey, job.Name, err)
+		s.record(job, time.Now(), ResultError, fmt.Errorf("failed to take lock %s: %w", job.LockKey, err))
+		return
+	}
+	if !ok {
+		s.logger.Warn("Skipping job %s: lock %s is held by another run", job.Name, job.LockKey)
+		s.record(job, time.Time{}, ResultSkipped, nil)
+		return
+	}
+	defer func() {
+		if err := release(); err != nil {
+			s.logger.Error("Error releasing lock %s: %v", job.LockKey, err)
+		}
+	}()
+
+	start := time.Now()
+	s.mu.Lock()
+	job.status.Running = true
+	job.status.LastStart = &start
+	s.mu.Unlock()
+
+	s.logger.Info("Starting job %s", job.Name)
+	err = s.safeRun(ctx, job)
+	if err != nil {
+		s.logger.Error("Job %s failed after %v: %v", job.Name, time.Since(start).Round(time.Second), err)
+		s.record(job, start, ResultError, err)
+		return
+	}
+	s.logger.Info("Job %s finished in %v", job.Name, time.Since(start).Round(time.Second))
+	s.record(job, start, ResultSuccess, nil)
+}
+
+// safeRun runs the job, turning a panic into an error so that one broken run
+// does not take the daemon down.
+func (s *Scheduler) safeRun(ctx context.Context, job *scheduledJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// record stores the result of a run. start is zero for skipped runs.
+func (s *Scheduler) record(job *scheduledJob, start time.Time, result string, err error) {
+	end := time.Now()
+
+	s.mu.Lock()
+	status := &job.status
+	status.Running = false
+	status.LastResult = result
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	switch result {
+	case ResultSkipped:
+		status.Skipped++
+	case ResultError:
+		status.Runs++
+		status.Failures++
+	default:
+		status.Runs++
+	}
+	if !start.IsZero() {
+		status.LastEnd = &end
+		status.LastDurationSeconds = end.Sub(start).Seconds()
+	}
+	s.mu.Unlock()
+
+	if s.OnResult != nil {
+		s.OnResult(job.Name, result)
+	}
+}
+
+// Status returns the current state of the scheduler and its jobs.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{StartedAt: s.startedAt, Timezone: s.location.String()}
+	for _, job := range s.jobs {
+		status.Jobs = append(status.Jobs, job.status)
+	}
+	return status
+}
+
+// Healthy reports whether the scheduler is running and HealthCheck, when
+// set, succeeds.
+func (s *Scheduler) Healthy(ctx context.Context) error {
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if !running {
+		return errors.New("scheduler is not running")
+	}
+	if s.HealthCheck != nil {
+		return s.HealthCheck(ctx)
+	}
+	return nil
+}
+
+// Handler serves /healthz, answering 200 while the scheduler is healthy and
+// 503 otherwise, and /status with the last and next runs of every job as JSON.
+func (s *Scheduler) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		health := struct {
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+		}{Status: "ok"}
+		code := http.StatusOK
+		if err := s.Healthy(ctx); err != nil {
+			health.Status, health.Error = "unavailable", err.Error()
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(health)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(s.Status()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return mux
+}
+
+// NewLocalLock returns a LockFunc whose locks only exclude runs within the
+// current process.
+func NewLocalLock() LockFunc {
+	var mu sync.Mutex
+	held := make(map[string]bool)
+	return func(ctx context.Context, key string) (func() error, bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if held[key] {
+			return nil, false, nil
+		}
+		held[key] = true
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(held, key)
+			return nil
+		}, true, nil
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// saveProductAttributes records the bopData fields of the observed products
+// in the product_attributes table. A new version of an attribute is stored,
+// linked to the observation, only when its content changed; unchanged
+// attributes just get their last_observed_at extended. Current attributes
+// that a product no longer has, or whose content changed, stop being current.
+func saveProductAttributes(tx *sql.Tx, products []models.Product, observationIDs map[models.ProductKey]int64) (int64, error) {
+	if len(products) == 0 {
+		return 0, nil
+	}
+
+	var productRetailers, retailers, titles, texts, htmls, hashes, productObservedAt, observedAt []string
+	var productIDs, attributeProductIDs []int
+	var observations []int64
+	for _, product := range products {
+		at := product.CreatedAt.Format(time.RFC3339Nano)
+		productRetailers = append(productRetailers, product.Retailer)
+		productIDs = append(productIDs, product.ProductID)
+		productObservedAt = append(productObservedAt, at)
+
+		for _, attribute := range product.Attributes {
+			retailers = append(retailers, product.Retailer)
+			attributeProductIDs = append(attributeProductIDs, product.ProductID)
+			titles = append(titles, attribute.Title)
+			texts = append(texts, attribute.ContentText)
+			htmls = append(htmls, attribute.ContentHTML)
+			hashes = append(hashes, attribute.Hash())
+			observations = append(observations, observationIDs[product.Key()])
+			observedAt = append(observedAt, at)
+		}
+	}
+
+	start := time.Now()
+	if _, err := tx.Exec(`
+		UPDATE product_attributes a SET is_current = FALSE
+		FROM unnest($1::text[], $2::integer[]) AS p(retailer, product_id)
+		WHERE a.is_current AND a.retailer = p.retailer AND a.product_id = p.product_id
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($3::text[], $4::integer[], $5::text[], $6::text[])
+					AS i(retailer, product_id, title, content_hash)
+				WHERE i.retailer = a.retailer AND i.product_id = a.product_id
+					AND i.title = a.title AND i.content_hash = a.content_hash
+			)
+	`, pq.Array(productRetailers), pq.Array(productIDs),
+		pq.Array(retailers), pq.Array(attributeProductIDs), pq.Array(titles), pq.Array(hashes)); err != nil {
+		return 0, fmt.Errorf("failed to retire product attributes: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE product_attributes a SET last_observed_at = GREATEST(a.last_observed_at, i.observed_at)
+		FROM unnest($1::text[], $2::integer[], $3::text[], $4::timestamptz[]) AS i(retailer, product_id, title, observed_at)
+		WHERE a.is_current AND a.retailer = i.retailer AND a.product_id = i.product_id AND a.title = i.title
+	`, pq.Array(retailers), pq.Array(attributeProductIDs), pq.Array(titles), pq.Array(observedAt)); err != nil {
+		return 0, fmt.Errorf("failed to update product attributes: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO product_attributes (
+			retailer, product_id, title, content_text, content_html, content_hash,
+			observation_id, first_observed_at, last_observed_at
+		)
+		SELECT i.retailer, i.product_id, i.title, i.content_text, i.content_html, i.content_hash,
+			NULLIF(i.observation_id, 0), i.observed_at, i.observed_at
+		FROM unnest($1::text[], $2::integer[], $3::text[], $4::text[], $5::text[], $6::text[], $7::bigint[], $8::timestamptz[])
+			AS i(retailer, product_id, title, content_text, content_html, content_hash, observation_id, observed_at)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM product_attributes a
+			WHERE a.is_current AND a.retailer = i.retailer AND a.product_id = i.product_id AND a.title = i.title
+		)
+	`, pq.Array(retailers), pq.Array(attributeProductIDs), pq.Array(titles), pq.Array(texts), pq.Array(htmls),
+		pq.Array(hashes), pq.Array(observations), pq.Array(observedAt))
+	metrics.DBBatchDuration.Observe(time.Since(start).Seconds(), "product_attributes")
+	if err != nil {
+		return 0, fmt.Errorf("failed to save product attributes: %w", err)
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count saved product attributes: %w", err)
+	}
+	metrics.RowsSaved.Add(float64(added), "product_attributes")
+	return added, nil
+}
+
+// GetAttributeTitleStats reports every bopData field title ever seen, with
+// the number and share of products that currently have it, most widespread
+// first, so that fields the API adds stand out.
+func (d *DatabaseService) GetAttributeTitleStats() ([]models.AttributeTitleStats, error) {
+	rows, err := d.db.Query(`
+		SELECT title,
+			COUNT(*) FILTER (WHERE is_current),
+			COALESCE(COUNT(*) FILTER (WHERE is_current)::float / NULLIF((SELECT COUNT(*) FROM products), 0), 0),
+			COUNT(*), MIN(first_observed_at), MAX(last_observed_at)
+		FROM product_attributes
+		GROUP BY title
+		ORDER BY 2 DESC, title
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attribute titles: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []models.AttributeTitleStats
+	for rows.Next() {
+		var stat models.AttributeTitleStats
+		if err := rows.Scan(
+			&stat.Title,
+			&stat.Products,
+			&stat.Share,
+			&stat.Versions,
+			&stat.FirstSeenAt,
+			&stat.LastSeenAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan attribute title: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read attribute titles: %w", err)
+	}
+	return stats, nil
+}
+
+// AttributeFilter selects product attributes. Title is matched exactly and
+// Search against the plain text content, case-insensitively. Retailer and
+// ProductID select a single retailer or product. History includes the
+// versions that are no longer current. Limit caps the number of results when
+// positive.
+type AttributeFilter struct {
+	Retailer  string
+	ProductID int
+	Title     string
+	Search    string
+	History   bool
+	Limit     int
+}
+
+// GetProductAttributes returns the attribute versions selected by the
+// filter, by product and title, newest version first.
+func (d *DatabaseService) GetProductAttributes(filter AttributeFilter) ([]models.AttributeVersion, error) {
+	query := `
+		SELECT a.id, a.retailer, a.product_id, p.product_name, a.title, a.content_text, a.content_html,
+			a.observation_id, a.first_observed_at, a.last_observed_at, a.is_current
+		FROM product_attributes a
+		JOIN products p ON p.retailer = a.retailer AND p.product_id = a.product_id
+		WHERE TRUE
+	`
+	var args []interface{}
+	if !filter.History {
+		query += " AND a.is_current"
+	}
+	if filter.Retailer != "" {
+		args = append(args, filter.Retailer)
+		query += fmt.Sprintf(" AND a.retailer = $%d", len(args))
+	}
+	if filter.ProductID != 0 {
+		args = append(args, filter.ProductID)
+		query += fmt.Sprintf(" AND a.product_id = $%d", len(args))
+	}
+	if filter.Title != "" {
+		args = append(args, filter.Title)
+		query += fmt.Sprintf(" AND a.title = $%d", len(args))
+	}
+	if filter.Search != "" {
+		args = append(args, "%"+filter.Search+"%")
+		query += fmt.Sprintf(" AND a.content_text ILIKE $%d", len(args))
+	}
+	query += " ORDER BY a.retailer, a.product_id, a.title, a.first_observed_at DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product attributes: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []models.AttributeVersion
+	for rows.Next() {
+		var version models.AttributeVersion
+		if err := rows.Scan(
+			&version.ID,
+			&version.Retailer,
+			&version.ProductID,
+			&version.ProductName,
+			&version.Title,
+			&version.ContentText,
+			&version.ContentHTML,
+			&version.ObservationID,
+			&version.FirstObservedAt,
+			&version.LastObservedAt,
+			&version.Current,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan product attribute: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read product attributes: %w", err)
+	}
+	return versions, nil
+}
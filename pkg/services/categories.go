@@ -0,0 +1,498 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// SyncCategories updates the category taxonomy with the category paths of the
+// given products. Renamed categories keep their ID, every prefix of an
+// observed path is added as a category, each product is assigned its leaf
+// category, and products whose leaf category changed are recorded in the
+// product_category_moves table. Retailers share the taxonomy, but renames are
+// detected among the products of each retailer. The operation is performed
+// within a transaction.
+func (d *DatabaseService) SyncCategories(products []models.Product) error {
+	observed := make(map[string]map[int][]string)
+	productCount := 0
+	for _, product := range products {
+		if len(product.ProductCategories) > 0 {
+			if observed[product.Retailer] == nil {
+				observed[product.Retailer] = make(map[int][]string)
+			}
+			observed[product.Retailer][product.ProductID] = product.ProductCategories
+			productCount++
+		}
+	}
+	if len(observed) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	retailers := make([]string, 0, len(observed))
+	for retailer := range observed {
+		retailers = append(retailers, retailer)
+	}
+	sort.Strings(retailers)
+
+	var categoryCount, renameCount, moveCount int
+	for _, retailer := range retailers {
+		categories, renames, moves, err := d.syncRetailerCategories(tx, retailer, observed[retailer])
+		if err != nil {
+			return err
+		}
+		categoryCount += categories
+		renameCount += renames
+		moveCount += moves
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	metrics.RowsSaved.Add(float64(moveCount), "product_category_moves")
+	d.logger.Info("Synced %d categories for %d products (%d renamed, %d products moved) in %v",
+		categoryCount, productCount, renameCount, moveCount, time.Since(start))
+	return nil
+}
+
+// syncRetailerCategories syncs the taxonomy with the observed category paths
+// of a retailer's products, keyed by product ID. It returns the number of
+// categories observed, renamed and products moved.
+func (d *DatabaseService) syncRetailerCategories(tx *sql.Tx, retailer string, observed map[int][]string) (int, int, int, error) {
+	existing, err := queryCategories(tx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	productIDs := make([]int, 0, len(observed))
+	for productID := range observed {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Ints(productIDs)
+
+	assigned, err := queryProductCategories(tx, retailer, productIDs)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	// Rename categories in place so that their IDs survive
+	renames := models.DetectCategoryRenames(existing, assigned, observed)
+	for _, rename := range renames {
+		depth := len(rename.Path)
+		if _, err := tx.Exec(`
+			UPDATE categories
+			SET path = $1::text[] || path[($2::integer + 1):],
+				name = CASE WHEN id = $3 THEN $4 ELSE name END,
+				last_seen_at = CURRENT_TIMESTAMP
+			WHERE path[1:$2] = $5::text[]
+		`, pq.Array(rename.NewPath), depth, rename.CategoryID, rename.NewPath[depth-1], pq.Array(rename.Path)); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to rename category %d: %w", rename.CategoryID, err)
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO category_renames (category_id, old_path, new_path) VALUES ($1, $2, $3)",
+			rename.CategoryID, pq.Array(rename.Path), pq.Array(rename.NewPath),
+		); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to record rename of category %d: %w", rename.CategoryID, err)
+		}
+		d.logger.Info("Category %d renamed from %q to %q", rename.CategoryID,
+			strings.Join(rename.Path, " > "), strings.Join(rename.NewPath, " > "))
+	}
+
+	categoryIDs, err := upsertCategoryPaths(tx, observed)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	// Resolve the paths of the previous categories after the renames
+	categories, err := queryCategories(tx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	paths := make(map[int][]string, len(categories))
+	for _, category := range categories {
+		paths[category.ID] = category.Path
+	}
+
+	leafIDs := make(map[int]int, len(observed))
+	var moves []models.CategoryMove
+	for _, productID := range productIDs {
+		newPath := observed[productID]
+		leafIDs[productID] = categoryIDs[models.CategoryPathKey(newPath)]
+
+		if oldID, ok := assigned[productID]; ok && oldID != leafIDs[productID] {
+			moves = append(moves, models.CategoryMove{
+				Retailer:      retailer,
+				ProductID:     productID,
+				OldCategoryID: oldID,
+				NewCategoryID: leafIDs[productID],
+				OldPath:       paths[oldID],
+				NewPath:       newPath,
+			})
+		}
+	}
+
+	if err := assignProductCategories(tx, retailer, productIDs, leafIDs); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := saveCategoryMoves(tx, moves); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return len(categoryIDs), len(renames), len(moves), nil
+}
+
+// RebuildCategories builds the category taxonomy from the category paths of
+// every product in the database, for instance after the categories table was
+// created on an existing database.
+func (d *DatabaseService) RebuildCategories() error {
+	rows, err := d.db.Query("SELECT retailer, product_id, product_categories FROM products WHERE cardinality(product_categories) > 0")
+	if err != nil {
+		return fmt.Errorf("failed to query product categories: %w", err)
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		if err := rows.Scan(&product.Retailer, &product.ProductID, pq.Array(&product.ProductCategories)); err != nil {
+			return fmt.Errorf("failed to scan product categories: %w", err)
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read product categories: %w", err)
+	}
+
+	return d.SyncCategories(products)
+}
+
+// queryCategories returns every known category.
+func queryCategories(tx *sql.Tx) ([]models.Category, error) {
+	rows, err := tx.Query("SELECT id, COALESCE(parent_id, 0), name, path, first_seen_at, last_seen_at FROM categories")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []models.Category
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(
+			&category.ID,
+			&category.ParentID,
+			&category.Name,
+			pq.Array(&category.Path),
+			&category.FirstSeenAt,
+			&category.LastSeenAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read categories: %w", err)
+	}
+
+	return categories, nil
+}
+
+// queryProductCategories returns the current leaf category of the given products
+// of a retailer, keyed by product ID. Products without a category are left out.
+func queryProductCategories(tx *sql.Tx, retailer string, productIDs []int) (map[int]int, error) {
+	rows, err := tx.Query(
+		"SELECT product_id, category_id FROM products WHERE category_id IS NOT NULL AND retailer = $1 AND product_id = ANY($2)",
+		retailer, pq.Array(productIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product categories: %w", err)
+	}
+	defer rows.Close()
+
+	assigned := make(map[int]int)
+	for rows.Next() {
+		var productID, categoryID int
+		if err := rows.Scan(&productID, &categoryID); err != nil {
+			return nil, fmt.Errorf("failed to scan product category: %w", err)
+		}
+		assigned[productID] = categoryID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read product categories: %w", err)
+	}
+
+	return assigned, nil
+}
+
+// upsertCategoryPaths inserts every prefix of the observed paths as a category,
+// one level at a time so that parents exist before their children, and marks
+// existing ones as seen. It returns the category IDs keyed by path.
+func upsertCategoryPaths(tx *sql.Tx, observed map[int][]string) (map[string]int, error) {
+	levels := make(map[int]map[string][]string)
+	maxDepth := 0
+	for _, path := range observed {
+		for depth := 1; depth <= len(path); depth++ {
+			if levels[depth] == nil {
+				levels[depth] = make(map[string][]string)
+			}
+			levels[depth][models.CategoryPathKey(path[:depth])] = path[:depth]
+		}
+		if len(path) > maxDepth {
+			maxDepth = len(path)
+		}
+	}
+
+	categoryIDs := make(map[string]int)
+	for depth := 1; depth <= maxDepth; depth++ {
+		keys := make([]string, 0, len(levels[depth]))
+		for key := range levels[depth] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		// Categories have 4 parameters per record
+		maxCategoriesPerBatch := 60000 / 4
+
+		for i := 0; i < len(keys); i += maxCategoriesPerBatch {
+			end := i + maxCategoriesPerBatch
+			if end > len(keys) {
+				end = len(keys)
+			}
+
+			batch := keys[i:end]
+			values := make([]string, 0, len(batch))
+			args := make([]interface{}, 0, len(batch)*4)
+			argIndex := 1
+
+			for _, key := range batch {
+				path := levels[depth][key]
+				values = append(values, fmt.Sprintf("(NULLIF($%d, 0), $%d, $%d, $%d)",
+					argIndex, argIndex+1, argIndex+2, argIndex+3))
+				args = append(args,
+					categoryIDs[models.CategoryPathKey(path[:depth-1])],
+					path[depth-1],
+					pq.Array(path),
+					depth,
+				)
+				argIndex += 4
+			}
+
+			query := fmt.Sprintf(`
+				INSERT INTO categories (parent_id, name, path, depth)
+				VALUES %s
+				ON CONFLICT (path) DO UPDATE SET
+					last_seen_at = CURRENT_TIMESTAMP
+				RETURNING id, path
+			`, strings.Join(values, ","))
+
+			batchStart := time.Now()
+			rows, err := tx.Query(query, args...)
+			if err != nil {
+				metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "categories")
+				return nil, fmt.Errorf("failed to save categories at depth %d: %w", depth, err)
+			}
+			for rows.Next() {
+				var id int
+				var path []string
+				if err := rows.Scan(&id, pq.Array(&path)); err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("failed to scan category ID: %w", err)
+				}
+				categoryIDs[models.CategoryPathKey(path)] = id
+			}
+			err = rows.Err()
+			rows.Close()
+			metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "categories")
+			if err != nil {
+				return nil, fmt.Errorf("failed to read category IDs: %w", err)
+			}
+		}
+	}
+
+	return categoryIDs, nil
+}
+
+// assignProductCategories sets the leaf category of the given products of a retailer.
+func assignProductCategories(tx *sql.Tx, retailer string, productIDs []int, leafIDs map[int]int) error {
+	// Assignments have 2 parameters per record, plus the retailer
+	maxProductsPerBatch := 60000 / 2
+
+	for i := 0; i < len(productIDs); i += maxProductsPerBatch {
+		end := i + maxProductsPerBatch
+		if end > len(productIDs) {
+			end = len(productIDs)
+		}
+
+		batch := productIDs[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*2+1)
+		args = append(args, retailer)
+		for j, productID := range batch {
+			values = append(values, fmt.Sprintf("($%d::integer, $%d::integer)", 2*j+2, 2*j+3))
+			args = append(args, productID, leafIDs[productID])
+		}
+
+		query := fmt.Sprintf(`
+			UPDATE products AS p
+			SET category_id = v.category_id
+			FROM (VALUES %s) AS v(product_id, category_id)
+			WHERE p.retailer = $1 AND p.product_id = v.product_id
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "products")
+		if err != nil {
+			return fmt.Errorf("failed to assign categories batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	return nil
+}
+
+// saveCategoryMoves records products that moved to a different leaf category.
+func saveCategoryMoves(tx *sql.Tx, moves []models.CategoryMove) error {
+	// Category moves have 6 parameters per record
+	maxMovesPerBatch := 60000 / 6
+
+	for i := 0; i < len(moves); i += maxMovesPerBatch {
+		end := i + maxMovesPerBatch
+		if end > len(moves) {
+			end = len(moves)
+		}
+
+		batch := moves[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*6)
+		argIndex := 1
+
+		for _, move := range batch {
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5))
+			args = append(args,
+				move.Retailer,
+				move.ProductID,
+				move.OldCategoryID,
+				move.NewCategoryID,
+				pq.Array(move.OldPath),
+				pq.Array(move.NewPath),
+			)
+			argIndex += 6
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_category_moves (retailer, product_id, old_category_id, new_category_id, old_path, new_path)
+			VALUES %s
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_category_moves")
+		if err != nil {
+			return fmt.Errorf("failed to save category moves batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	return nil
+}
+
+// GetCategoryTree returns the category taxonomy with, for every node, the
+// number of products in its subtree and their average and median unit price.
+func (d *DatabaseService) GetCategoryTree() ([]*models.CategoryNode, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	categories, err := queryCategories(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT product_id, category_id,
+			COALESCE(product_unit_price_amount, 0), COALESCE(product_unit_price_unit, '')
+		FROM products
+		WHERE category_id IS NOT NULL
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query categorized products: %w", err)
+	}
+	defer rows.Close()
+
+	var products []models.CategorizedProduct
+	for rows.Next() {
+		var product models.CategorizedProduct
+		if err := rows.Scan(
+			&product.ProductID,
+			&product.CategoryID,
+			&product.UnitPrice,
+			&product.UnitPriceUnit,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan categorized product: %w", err)
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read categorized products: %w", err)
+	}
+
+	return models.BuildCategoryTree(categories, products), nil
+}
+
+// GetCategoryMoves returns the products that moved to a different leaf
+// category since the given time, most recent first.
+func (d *DatabaseService) GetCategoryMoves(since time.Time) ([]models.CategoryMove, error) {
+	rows, err := d.db.Query(`
+		SELECT m.retailer, m.product_id, p.product_name,
+			COALESCE(m.old_category_id, 0), COALESCE(m.new_category_id, 0),
+			COALESCE(m.old_path, '{}'), COALESCE(m.new_path, '{}'), m.moved_at
+		FROM product_category_moves m
+		JOIN products p ON p.retailer = m.retailer AND p.product_id = m.product_id
+		WHERE m.moved_at >= $1
+		ORDER BY m.moved_at DESC, m.retailer, m.product_id
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category moves: %w", err)
+	}
+	defer rows.Close()
+
+	var moves []models.CategoryMove
+	for rows.Next() {
+		var move models.CategoryMove
+		if err := rows.Scan(
+			&move.Retailer,
+			&move.ProductID,
+			&move.ProductName,
+			&move.OldCategoryID,
+			&move.NewCategoryID,
+			pq.Array(&move.OldPath),
+			pq.Array(&move.NewPath),
+			&move.MovedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan category move: %w", err)
+		}
+		moves = append(moves, move)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read category moves: %w", err)
+	}
+
+	return moves, nil
+}
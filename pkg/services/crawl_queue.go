@@ -0,0 +1,308 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// ErrNoCrawlRuns is returned by GetCrawlQueueStats when no run was ever queued.
+var ErrNoCrawlRuns = errors.New("no crawl runs queued")
+
+// EnqueueCrawlJobs queues the products of a retailer in a crawl run and returns
+// the number of jobs added. Products that already have a pending or running job,
+// e.g. from an unfinished earlier run, are not queued again.
+func (d *DatabaseService) EnqueueCrawlJobs(runID, retailer string, productIDs []int) (int64, error) {
+	result, err := d.db.Exec(`
+		INSERT INTO crawl_jobs (run_id, retailer, product_id)
+		SELECT $1, $2, ids.product_id
+		FROM unnest($3::integer[]) AS ids(product_id)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM crawl_jobs j
+			WHERE j.retailer = $2 AND j.product_id = ids.product_id AND j.status IN ('pending', 'running')
+		)
+		ON CONFLICT (run_id, retailer, product_id) DO NOTHING
+	`, runID, retailer, pq.Array(productIDs))
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue crawl jobs: %w", err)
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count enqueued crawl jobs: %w", err)
+	}
+	metrics.RowsSaved.Add(float64(added), "crawl_jobs")
+	d.logger.Info("Enqueued %d of %d %s products for run %s", added, len(productIDs), retailer, runID)
+	return added, nil
+}
+
+// ClaimCrawlJobs leases up to limit jobs to the worker for the lease
+// duration. Pending jobs are claimed in queue order, together with running
+// jobs whose lease expired because their worker died. Concurrent workers
+// skip each other's rows instead of waiting for them. Expired jobs that have
+// already been attempted maxAttempts times are marked failed instead.
+func (d *DatabaseService) ClaimCrawlJobs(workerID string, limit int, lease time.Duration, maxAttempts int) ([]models.CrawlJob, error) {
+	expired, err := d.db.Exec(`
+		UPDATE crawl_jobs
+		SET status = 'failed', leased_until = NULL, finished_at = NOW(),
+			error_message = 'lease of worker ' || worker_id || ' expired'
+		WHERE status = 'running' AND leased_until < NOW() AND attempts >= $1
+	`, maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire crawl jobs: %w", err)
+	}
+	if count, err := expired.RowsAffected(); err == nil && count > 0 {
+		d.logger.Warn("Marked %d crawl jobs failed after their last lease expired", count)
+	}
+
+	rows, err := d.db.Query(`
+		UPDATE crawl_jobs j
+		SET status = 'running', worker_id = $1, attempts = j.attempts + 1,
+			leased_until = NOW() + make_interval(secs => $2), started_at = NOW()
+		WHERE j.id IN (
+			SELECT id FROM crawl_jobs
+			WHERE (status = 'pending' OR (status = 'running' AND leased_until < NOW()))
+				AND attempts < $3
+			ORDER BY id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING j.id, j.run_id, j.retailer, j.product_id, j.status, j.attempts, j.worker_id, j.leased_until
+	`, workerID, lease.Seconds(), maxAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim crawl jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []models.CrawlJob
+	for rows.Next() {
+		var job models.CrawlJob
+		var leasedUntil time.Time
+		if err := rows.Scan(&job.ID, &job.RunID, &job.Retailer, &job.ProductID, &job.Status, &job.Attempts, &job.WorkerID, &leasedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan crawl job: %w", err)
+		}
+		job.LeasedUntil = &leasedUntil
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read claimed crawl jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// CompleteCrawlJobs records the results of jobs claimed by the worker. Jobs
+// whose lease was lost to another worker in the meantime are left alone; the
+// number of jobs actually updated is returned.
+func (d *DatabaseService) CompleteCrawlJobs(workerID string, results []models.CrawlJobResult) (int64, error) {
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(results))
+	statuses := make([]string, 0, len(results))
+	categories := make([]string, 0, len(results))
+	messages := make([]string, 0, len(results))
+	var done, failed int
+	for _, result := range results {
+		ids = append(ids, result.JobID)
+		statuses = append(statuses, result.Status)
+		categories = append(categories, result.ErrorCategory)
+		messages = append(messages, result.ErrorMessage)
+		switch result.Status {
+		case models.CrawlJobDone:
+			done++
+		case models.CrawlJobFailed:
+			failed++
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE crawl_jobs j
+		SET status = r.status,
+			error_category = NULLIF(r.error_category, ''),
+			error_message = NULLIF(r.error_message, ''),
+			worker_id = CASE WHEN r.status = 'pending' THEN NULL ELSE j.worker_id END,
+			leased_until = NULL,
+			finished_at = CASE WHEN r.status = 'pending' THEN NULL ELSE NOW() END
+		FROM unnest($2::bigint[], $3::text[], $4::text[], $5::text[])
+			AS r(id, status, error_category, error_message)
+		WHERE j.id = r.id AND j.worker_id = $1 AND j.status = 'running'
+	`, workerID, pq.Array(ids), pq.Array(statuses), pq.Array(categories), pq.Array(messages))
+	if err != nil {
+		return 0, fmt.Errorf("failed to complete crawl jobs: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count completed crawl jobs: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE crawl_workers SET jobs_done = jobs_done + $2, jobs_failed = jobs_failed + $3, heartbeat_at = NOW()
+		WHERE worker_id = $1
+	`, workerID, done, failed); err != nil {
+		return 0, fmt.Errorf("failed to update worker %s: %w", workerID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if lost := int64(len(results)) - updated; lost > 0 {
+		d.logger.Warn("Worker %s lost the lease of %d crawl jobs before completing them", workerID, lost)
+	}
+	return updated, nil
+}
+
+// RegisterCrawlWorker records a worker process, or refreshes its heartbeat
+// when it is already known.
+func (d *DatabaseService) RegisterCrawlWorker(workerID, hostname string) error {
+	_, err := d.db.Exec(`
+		INSERT INTO crawl_workers (worker_id, hostname)
+		VALUES ($1, $2)
+		ON CONFLICT (worker_id) DO UPDATE SET heartbeat_at = NOW()
+	`, workerID, hostname)
+	if err != nil {
+		return fmt.Errorf("failed to register worker %s: %w", workerID, err)
+	}
+	return nil
+}
+
+// ReleaseCrawlJobs gives up jobs claimed by the worker that it could not
+// process, e.g. because saving the batch failed, so that they do not wait for
+// a lease to expire. They go back to the queue, or are marked failed once
+// they have been attempted maxAttempts times. It returns the number of jobs
+// released.
+func (d *DatabaseService) ReleaseCrawlJobs(workerID string, jobIDs []int64, maxAttempts int, message string) (int64, error) {
+	if len(jobIDs) == 0 {
+		return 0, nil
+	}
+
+	result, err := d.db.Exec(`
+		UPDATE crawl_jobs
+		SET status = CASE WHEN attempts >= $3 THEN 'failed' ELSE 'pending' END,
+			worker_id = CASE WHEN attempts >= $3 THEN worker_id END,
+			leased_until = NULL,
+			error_message = NULLIF($4, ''),
+			finished_at = CASE WHEN attempts >= $3 THEN NOW() END
+		WHERE id = ANY($2) AND worker_id = $1 AND status = 'running'
+	`, workerID, pq.Array(jobIDs), maxAttempts, message)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release crawl jobs of worker %s: %w", workerID, err)
+	}
+	return result.RowsAffected()
+}
+
+// HeartbeatCrawlWorker records that the worker is alive and extends the
+// lease of the given jobs, those it is processing. Other jobs of the worker
+// keep their lease, so that jobs it gave up on are reassigned once it
+// expires. It returns the number of leases extended.
+func (d *DatabaseService) HeartbeatCrawlWorker(workerID string, jobIDs []int64, lease time.Duration) (int64, error) {
+	if _, err := d.db.Exec("UPDATE crawl_workers SET heartbeat_at = NOW() WHERE worker_id = $1", workerID); err != nil {
+		return 0, fmt.Errorf("failed to record heartbeat of worker %s: %w", workerID, err)
+	}
+	if len(jobIDs) == 0 {
+		return 0, nil
+	}
+
+	result, err := d.db.Exec(`
+		UPDATE crawl_jobs SET leased_until = NOW() + make_interval(secs => $2)
+		WHERE worker_id = $1 AND status = 'running' AND id = ANY($3)
+	`, workerID, lease.Seconds(), pq.Array(jobIDs))
+	if err != nil {
+		return 0, fmt.Errorf("failed to extend leases of worker %s: %w", workerID, err)
+	}
+	return result.RowsAffected()
+}
+
+// HasOpenCrawlJobs reports whether any job is pending or running.
+func (d *DatabaseService) HasOpenCrawlJobs() (bool, error) {
+	var open bool
+	err := d.db.QueryRow("SELECT EXISTS (SELECT 1 FROM crawl_jobs WHERE status IN ('pending', 'running'))").Scan(&open)
+	if err != nil {
+		return false, fmt.Errorf("failed to check open crawl jobs: %w", err)
+	}
+	return open, nil
+}
+
+// GetCrawlQueueStats counts the jobs of a crawl run by status. An empty
+// runID selects the most recently queued run.
+func (d *DatabaseService) GetCrawlQueueStats(runID string) (models.CrawlQueueStats, error) {
+	var stats models.CrawlQueueStats
+	err := d.db.QueryRow(`
+		SELECT run_id,
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'running'),
+			COUNT(*) FILTER (WHERE status = 'done'),
+			COUNT(*) FILTER (WHERE status = 'done' AND error_category = 'not_found'),
+			COUNT(*) FILTER (WHERE status = 'failed'),
+			MIN(enqueued_at)
+		FROM crawl_jobs
+		WHERE run_id = COALESCE(NULLIF($1, ''), (SELECT run_id FROM crawl_jobs ORDER BY id DESC LIMIT 1))
+		GROUP BY run_id
+	`, runID).Scan(
+		&stats.RunID,
+		&stats.Pending,
+		&stats.Running,
+		&stats.Done,
+		&stats.NotFound,
+		&stats.Failed,
+		&stats.EnqueuedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		if runID != "" {
+			return stats, fmt.Errorf("crawl run %s not found", runID)
+		}
+		return stats, ErrNoCrawlRuns
+	}
+	if err != nil {
+		return stats, fmt.Errorf("failed to query crawl queue: %w", err)
+	}
+	return stats, nil
+}
+
+// GetCrawlWorkers returns the workers that sent a heartbeat since the given
+// time, with the number of jobs they hold.
+func (d *DatabaseService) GetCrawlWorkers(since time.Time) ([]models.CrawlWorker, error) {
+	rows, err := d.db.Query(`
+		SELECT w.worker_id, w.hostname, w.started_at, w.heartbeat_at, COUNT(j.id), w.jobs_done, w.jobs_failed
+		FROM crawl_workers w
+		LEFT JOIN crawl_jobs j ON j.worker_id = w.worker_id AND j.status = 'running'
+		WHERE w.heartbeat_at >= $1
+		GROUP BY w.worker_id, w.hostname, w.started_at, w.heartbeat_at, w.jobs_done, w.jobs_failed
+		ORDER BY w.started_at
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crawl workers: %w", err)
+	}
+	defer rows.Close()
+
+	var workers []models.CrawlWorker
+	for rows.Next() {
+		var worker models.CrawlWorker
+		if err := rows.Scan(
+			&worker.WorkerID,
+			&worker.Hostname,
+			&worker.StartedAt,
+			&worker.HeartbeatAt,
+			&worker.RunningJobs,
+			&worker.JobsDone,
+			&worker.JobsFailed,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan crawl worker: %w", err)
+		}
+		workers = append(workers, worker)
+	}
+	return workers, rows.Err()
+}
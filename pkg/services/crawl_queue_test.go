@@ -0,0 +1,128 @@
+package services_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/models"
+)
+
+func TestCrawlQueueWorkers(t *testing.T) {
+	dbService := newTestDatabase(t)
+
+	productIDs := make([]int, 200)
+	for i := range productIDs {
+		productIDs[i] = 1000 + i
+	}
+	if added, err := dbService.EnqueueCrawlJobs("run-1", models.RetailerBonpreu, productIDs); err != nil || added != 200 {
+		t.Fatalf("EnqueueCrawlJobs = %d, %v", added, err)
+	}
+
+	// Concurrent workers claim disjoint batches until the queue is empty
+	var mu sync.Mutex
+	claims := make(map[int64]string)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		workerID := fmt.Sprintf("worker-%d", w)
+		if err := dbService.RegisterCrawlWorker(workerID, "test"); err != nil {
+			t.Fatalf("RegisterCrawlWorker: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				jobs, err := dbService.ClaimCrawlJobs(workerID, 7, time.Minute, 3)
+				if err != nil {
+					t.Errorf("%s: ClaimCrawlJobs: %v", workerID, err)
+					return
+				}
+				if len(jobs) == 0 {
+					return
+				}
+
+				results := make([]models.CrawlJobResult, 0, len(jobs))
+				mu.Lock()
+				for _, job := range jobs {
+					if other, ok := claims[job.ID]; ok {
+						t.Errorf("job %d claimed by %s and %s", job.ID, other, workerID)
+					}
+					claims[job.ID] = workerID
+					results = append(results, models.CrawlJobResult{JobID: job.ID, Status: models.CrawlJobDone})
+				}
+				mu.Unlock()
+
+				if completed, err := dbService.CompleteCrawlJobs(workerID, results); err != nil || completed != int64(len(results)) {
+					t.Errorf("%s: CompleteCrawlJobs = %d, %v", workerID, completed, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != 200 {
+		t.Errorf("%d jobs claimed, want 200", len(claims))
+	}
+	stats, err := dbService.GetCrawlQueueStats("run-1")
+	if err != nil {
+		t.Fatalf("GetCrawlQueueStats: %v", err)
+	}
+	if stats.Done != 200 || !stats.Finished() {
+		t.Errorf("stats = %+v, want 200 done", stats)
+	}
+}
+
+func TestCrawlQueueReleaseAndLeases(t *testing.T) {
+	dbService := newTestDatabase(t)
+
+	if _, err := dbService.EnqueueCrawlJobs("run-1", models.RetailerBonpreu, []int{1, 2, 3, 4}); err != nil {
+		t.Fatalf("EnqueueCrawlJobs: %v", err)
+	}
+	for _, workerID := range []string{"a", "b"} {
+		if err := dbService.RegisterCrawlWorker(workerID, "test"); err != nil {
+			t.Fatalf("RegisterCrawlWorker: %v", err)
+		}
+	}
+
+	// A batch that failed to process goes straight back to the queue
+	jobs, err := dbService.ClaimCrawlJobs("a", 2, time.Hour, 3)
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("ClaimCrawlJobs = %v, %v", jobs, err)
+	}
+	if released, err := dbService.ReleaseCrawlJobs("a", []int64{jobs[0].ID, jobs[1].ID}, 3, "save failed"); err != nil || released != 2 {
+		t.Fatalf("ReleaseCrawlJobs = %d, %v", released, err)
+	}
+	reclaimed, err := dbService.ClaimCrawlJobs("b", 2, time.Hour, 3)
+	if err != nil || len(reclaimed) != 2 || reclaimed[0].ID != jobs[0].ID || reclaimed[0].Attempts != 2 {
+		t.Fatalf("released jobs were not reclaimed: %+v, %v", reclaimed, err)
+	}
+
+	// The heartbeat only extends the leases of the jobs being processed
+	jobs, err = dbService.ClaimCrawlJobs("a", 2, 10*time.Millisecond, 3)
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("ClaimCrawlJobs = %v, %v", jobs, err)
+	}
+	if extended, err := dbService.HeartbeatCrawlWorker("a", []int64{jobs[0].ID}, time.Hour); err != nil || extended != 1 {
+		t.Fatalf("HeartbeatCrawlWorker = %d, %v", extended, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	expired, err := dbService.ClaimCrawlJobs("b", 10, time.Hour, 3)
+	if err != nil || len(expired) != 1 || expired[0].ID != jobs[1].ID {
+		t.Fatalf("ClaimCrawlJobs after expiry = %+v, %v, want job %d", expired, err, jobs[1].ID)
+	}
+
+	// A job out of attempts is failed rather than queued again
+	if released, err := dbService.ReleaseCrawlJobs("b", []int64{reclaimed[0].ID}, 2, "save failed"); err != nil || released != 1 {
+		t.Fatalf("ReleaseCrawlJobs = %d, %v", released, err)
+	}
+	stats, err := dbService.GetCrawlQueueStats("run-1")
+	if err != nil {
+		t.Fatalf("GetCrawlQueueStats: %v", err)
+	}
+	if stats.Failed != 1 {
+		t.Errorf("stats = %+v, want 1 failed", stats)
+	}
+}
@@ -1,12 +1,17 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/metrics"
 	"bonpreu-go/pkg/models"
 	"bonpreu-go/pkg/utils"
 
@@ -22,19 +27,22 @@ type DatabaseService struct {
 	logger *utils.Logger
 }
 
+// maxConnectRetryDelay caps the backoff between database connection attempts.
+const maxConnectRetryDelay = 30 * time.Second
+
 // NewDatabaseService creates a new DatabaseService instance with the provided configuration.
-// It establishes a connection to the PostgreSQL database using the connection details
-// from the configuration. The connection is tested with a ping before returning.
+// It establishes a connection to the PostgreSQL database using either the connection URL
+// or the individual connection details from the configuration, and applies the connection
+// pool limits. The connection is tested with a ping before returning; failed pings are
+// retried with exponential backoff so that cold-starting serverless databases can wake up.
 func NewDatabaseService(cfg *config.Configuration) (*DatabaseService, error) {
+	logger := utils.NewLogger("DatabaseService")
+
 	// Build connection string
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.DBName,
-		cfg.Database.SSLMode,
-	)
+	connStr, err := connectionString(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
 
 	// Connect to database
 	db, err := sql.Open("postgres", connStr)
@@ -42,17 +50,87 @@ func NewDatabaseService(cfg *config.Configuration) (*DatabaseService, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+
 	// Test the connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	delay := cfg.Database.ConnectRetryDelay
+	for attempt := 0; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			break
+		}
+		if attempt >= cfg.Database.ConnectRetries {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database after %d attempts: %w", attempt+1, err)
+		}
+
+		logger.Warn("Database ping failed (attempt %d of %d), retrying in %v: %v",
+			attempt+1, cfg.Database.ConnectRetries+1, delay, err)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
 	}
 
 	return &DatabaseService{
 		db:     db,
-		logger: utils.NewLogger("DatabaseService"),
+		logger: logger,
 	}, nil
 }
 
+// connectionString builds a postgres:// URL from the database configuration.
+// Building a URL rather than a key=value string means that passwords with
+// spaces, quotes or other special characters are escaped correctly.
+// A configured statement timeout is passed as a run-time parameter.
+func connectionString(cfg config.DatabaseConfig) (string, error) {
+	var connURL *url.URL
+	if cfg.URL != "" {
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil {
+			return "", fmt.Errorf("invalid database URL: %w", err)
+		}
+		connURL = parsed
+
+		// A password from DB_PASSWORD or DB_PASSWORD_FILE fills in a URL without one
+		if _, hasPassword := connURL.User.Password(); !hasPassword && cfg.Password != "" && connURL.User != nil {
+			connURL.User = url.UserPassword(connURL.User.Username(), cfg.Password)
+		}
+	} else {
+		connURL = &url.URL{
+			Scheme: "postgres",
+			Host:   net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			Path:   "/" + cfg.DBName,
+		}
+		if cfg.User != "" || cfg.Password != "" {
+			connURL.User = url.UserPassword(cfg.User, cfg.Password)
+		}
+
+		query := connURL.Query()
+		query.Set("sslmode", cfg.SSLMode)
+		connURL.RawQuery = query.Encode()
+	}
+
+	if cfg.StatementTimeout > 0 {
+		query := connURL.Query()
+		query.Set("statement_timeout", strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10))
+		connURL.RawQuery = query.Encode()
+	}
+
+	return connURL.String(), nil
+}
+
+// Ping checks that the database can be reached.
+func (d *DatabaseService) Ping(ctx context.Context) error {
+	if err := d.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to reach database: %w", err)
+	}
+	return nil
+}
+
 // Close closes the database connection and releases associated resources.
 // This method should be called when the DatabaseService is no longer needed.
 func (d *DatabaseService) Close() error {
@@ -79,9 +157,9 @@ func (d *DatabaseService) SaveProducts(products []models.Product) error {
 	defer tx.Rollback()
 
 	// Use bulk insert with batching to respect PostgreSQL parameter limits
-	// PostgreSQL supports max 65535 parameters, so max ~3500 products per batch (17 params each)
+	// PostgreSQL supports max 65535 parameters, so max ~2300 products per batch (26 params each)
 	maxParamsPerBatch := 60000
-	maxProductsPerBatch := maxParamsPerBatch / 17
+	maxProductsPerBatch := maxParamsPerBatch / 26
 
 	for i := 0; i < len(products); i += maxProductsPerBatch {
 		end := i + maxProductsPerBatch
@@ -91,15 +169,18 @@ func (d *DatabaseService) SaveProducts(products []models.Product) error {
 
 		batch := products[i:end]
 		values := make([]string, 0, len(batch))
-		args := make([]interface{}, 0, len(batch)*16)
+		args := make([]interface{}, 0, len(batch)*26)
 		argIndex := 1
 
 		for _, product := range batch {
-			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, "+
+				"NULLIF($%d, 0), NULLIF($%d::numeric, 0), NULLIF($%d, ''), NULLIF($%d::numeric, 0), NULLIF($%d, ''), NULLIF($%d, ''), NULLIF($%d, ''), COALESCE($%d::text[], '{}'))",
 				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6, argIndex+7,
-				argIndex+8, argIndex+9, argIndex+10, argIndex+11, argIndex+12, argIndex+13, argIndex+14, argIndex+15, argIndex+16))
+				argIndex+8, argIndex+9, argIndex+10, argIndex+11, argIndex+12, argIndex+13, argIndex+14, argIndex+15, argIndex+16,
+				argIndex+17, argIndex+18, argIndex+19, argIndex+20, argIndex+21, argIndex+22, argIndex+23, argIndex+24, argIndex+25))
 
 			args = append(args,
+				product.Retailer,
 				product.ProductID,
 				product.ProductType,
 				product.ProductName,
@@ -117,19 +198,29 @@ func (d *DatabaseService) SaveProducts(products []models.Product) error {
 				pq.Array(product.ProductCategories),
 				product.PromotionType,
 				product.CreatedAt,
+				product.PackSize.Units,
+				product.PackSize.UnitQuantity,
+				product.PackSize.UnitOfMeasure,
+				product.PackSize.NetQuantity,
+				product.PackSize.BaseUnit,
+				product.PackSize.Confidence,
+				product.PackSize.UnitPriceCheck,
+				pq.Array(product.ProductGTINs),
 			)
-			argIndex += 17
+			argIndex += 26
 		}
 
 		query := fmt.Sprintf(`
 			INSERT INTO products (
-				product_id, product_type, product_name, product_description, 
+				retailer, product_id, product_type, product_name, product_description, 
 				product_brand, product_pack_size_description, product_price_amount, 
 				product_currency, product_unit_price_amount, product_unit_price_currency, 
 				product_unit_price_unit, product_available, product_alcohol, 
-				product_cooking_guidelines, product_categories, promotion_type, created_at
+				product_cooking_guidelines, product_categories, promotion_type, created_at,
+				pack_units, pack_unit_quantity, pack_unit_of_measure, pack_net_quantity,
+				pack_base_unit, pack_size_confidence, pack_unit_price_check, product_gtins
 			) VALUES %s
-			ON CONFLICT (product_id) DO UPDATE SET
+			ON CONFLICT (retailer, product_id) DO UPDATE SET
 				product_type = EXCLUDED.product_type,
 				product_name = EXCLUDED.product_name,
 				product_description = EXCLUDED.product_description,
@@ -145,10 +236,20 @@ func (d *DatabaseService) SaveProducts(products []models.Product) error {
 				product_cooking_guidelines = EXCLUDED.product_cooking_guidelines,
 				product_categories = EXCLUDED.product_categories,
 				promotion_type = EXCLUDED.promotion_type,
+				pack_units = EXCLUDED.pack_units,
+				pack_unit_quantity = EXCLUDED.pack_unit_quantity,
+				pack_unit_of_measure = EXCLUDED.pack_unit_of_measure,
+				pack_net_quantity = EXCLUDED.pack_net_quantity,
+				pack_base_unit = EXCLUDED.pack_base_unit,
+				pack_size_confidence = EXCLUDED.pack_size_confidence,
+				pack_unit_price_check = EXCLUDED.pack_unit_price_check,
+				product_gtins = EXCLUDED.product_gtins,
 				updated_at = CURRENT_TIMESTAMP
 		`, strings.Join(values, ","))
 
+		batchStart := time.Now()
 		_, err = tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "products")
 		if err != nil {
 			return fmt.Errorf("failed to bulk insert products batch %d-%d: %w", i+1, end, err)
 		}
@@ -164,6 +265,7 @@ func (d *DatabaseService) SaveProducts(products []models.Product) error {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	metrics.RowsSaved.Add(float64(len(products)), "products")
 	d.logger.Info("Successfully saved %d products in %v", len(products), time.Since(start))
 	return nil
 }
@@ -188,9 +290,9 @@ func (d *DatabaseService) SaveNutritionalData(nutritionalData []models.ProductNu
 	defer tx.Rollback()
 
 	// Use bulk insert for nutritional data with batching
-	// Nutritional data has 4 parameters per record, so max ~15000 records per batch
+	// Nutritional data has 5 parameters per record, so max ~12000 records per batch
 	maxParamsPerBatch := 60000
-	maxNutritionalPerBatch := maxParamsPerBatch / 4
+	maxNutritionalPerBatch := maxParamsPerBatch / 5
 
 	for i := 0; i < len(nutritionalData); i += maxNutritionalPerBatch {
 		end := i + maxNutritionalPerBatch
@@ -200,30 +302,33 @@ func (d *DatabaseService) SaveNutritionalData(nutritionalData []models.ProductNu
 
 		batch := nutritionalData[i:end]
 		values := make([]string, 0, len(batch))
-		args := make([]interface{}, 0, len(batch)*4)
+		args := make([]interface{}, 0, len(batch)*5)
 		argIndex := 1
 
 		for _, data := range batch {
-			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)",
-				argIndex, argIndex+1, argIndex+2, argIndex+3))
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4))
 
 			args = append(args,
+				data.Retailer,
 				data.ProductID,
 				data.ProductNutritionalValue,
 				data.ProductNutritionalQuantity,
 				data.CreatedAt,
 			)
-			argIndex += 4
+			argIndex += 5
 		}
 
 		query := fmt.Sprintf(`
 			INSERT INTO product_nutritional_data (
-				product_id, product_nutritional_value, product_nutritional_quantity, created_at
+				retailer, product_id, product_nutritional_value, product_nutritional_quantity, created_at
 			) VALUES %s
 			ON CONFLICT DO NOTHING
 		`, strings.Join(values, ","))
 
+		batchStart := time.Now()
 		_, err = tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_nutritional_data")
 		if err != nil {
 			return fmt.Errorf("failed to bulk insert nutritional data batch %d-%d: %w", i+1, end, err)
 		}
@@ -239,6 +344,7 @@ func (d *DatabaseService) SaveNutritionalData(nutritionalData []models.ProductNu
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	metrics.RowsSaved.Add(float64(len(nutritionalData)), "product_nutritional_data")
 	d.logger.Info("Successfully saved %d nutritional data entries in %v", len(nutritionalData), time.Since(start))
 	return nil
 }
@@ -256,11 +362,31 @@ func (d *DatabaseService) SaveAllData(products []models.Product, nutritionalData
 		return fmt.Errorf("failed to save products: %w", err)
 	}
 
+	// Add any new category paths to the taxonomy and assign the leaf categories
+	if err := d.SyncCategories(products); err != nil {
+		return fmt.Errorf("failed to sync categories: %w", err)
+	}
+
+	// Record the observation history and promotions
+	if err := d.SaveObservations(products); err != nil {
+		return fmt.Errorf("failed to save observations: %w", err)
+	}
+
 	// Save nutritional data
 	if err := d.SaveNutritionalData(nutritionalData); err != nil {
 		return fmt.Errorf("failed to save nutritional data: %w", err)
 	}
 
+	// Replace the ingredients, allergens and other label details
+	if err := d.SaveProductDetails(products); err != nil {
+		return fmt.Errorf("failed to save product details: %w", err)
+	}
+
+	// Store the Nutri-Score computed from the nutrition table
+	if err := d.SaveNutriScores(products); err != nil {
+		return fmt.Errorf("failed to save Nutri-Scores: %w", err)
+	}
+
 	d.logger.Info("Successfully saved all data in %v", time.Since(start))
 	return nil
 }
@@ -286,3 +412,22 @@ func (d *DatabaseService) GetNutritionalDataCount() (int, error) {
 	}
 	return count, nil
 }
+
+// GetProductIDs returns the IDs of every product of the retailer in the database.
+func (d *DatabaseService) GetProductIDs(retailer string) ([]int, error) {
+	rows, err := d.db.Query("SELECT product_id FROM products WHERE retailer = $1 ORDER BY product_id", retailer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product IDs: %w", err)
+	}
+	defer rows.Close()
+
+	var productIDs []int
+	for rows.Next() {
+		var productID int
+		if err := rows.Scan(&productID); err != nil {
+			return nil, fmt.Errorf("failed to scan product ID: %w", err)
+		}
+		productIDs = append(productIDs, productID)
+	}
+	return productIDs, rows.Err()
+}
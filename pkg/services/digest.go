@@ -0,0 +1,244 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"bonpreu-go/pkg/models"
+)
+
+// digestWindows selects the latest observation of each product within the
+// digest period ($1 to $2) and the latest one before it.
+const digestWindows = `
+	WITH cur AS (
+		SELECT DISTINCT ON (retailer, product_id) id, retailer, product_id, product_price_amount, observed_at
+		FROM product_observations
+		WHERE observed_at >= $1 AND observed_at < $2
+		ORDER BY retailer, product_id, observed_at DESC
+	), prev AS (
+		SELECT DISTINCT ON (retailer, product_id) id, retailer, product_id, product_price_amount, observed_at
+		FROM product_observations
+		WHERE observed_at < $1
+		ORDER BY retailer, product_id, observed_at DESC
+	)
+`
+
+// BuildDigest collects the catalogue changes between from and to: products
+// first observed in the period, products last observed in the preceding
+// period of the same length (excluding products that merely failed to fetch
+// and products of retailers not observed at all in the period, whose crawl
+// failed),
+// the limit largest price increases and decreases against the last
+// observation before the period, and promotions that were not present in it.
+func (d *DatabaseService) BuildDigest(from, to time.Time, limit int) (models.Digest, error) {
+	digest := models.Digest{From: from, To: to}
+
+	var err error
+	digest.NewProducts, err = d.queryDigestProducts(`
+		SELECT o.retailer, o.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			COALESCE(p.product_price_amount, 0), MIN(o.observed_at) AS first_observed_at
+		FROM product_observations o
+		JOIN products p ON p.retailer = o.retailer AND p.product_id = o.product_id
+		GROUP BY o.retailer, o.product_id, p.product_name, p.product_brand, p.product_price_amount
+		HAVING MIN(o.observed_at) >= $1 AND MIN(o.observed_at) < $2
+		ORDER BY first_observed_at, o.retailer, o.product_id
+	`, from, to)
+	if err != nil {
+		return digest, fmt.Errorf("failed to query new products: %w", err)
+	}
+
+	digest.DiscontinuedProducts, err = d.queryDigestProducts(`
+		SELECT o.retailer, o.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			COALESCE(p.product_price_amount, 0), MAX(o.observed_at) AS last_observed_at
+		FROM product_observations o
+		JOIN products p ON p.retailer = o.retailer AND p.product_id = o.product_id
+		WHERE NOT EXISTS (
+				SELECT 1 FROM product_fetch_failures f
+				WHERE f.retailer = o.retailer AND f.product_id = o.product_id
+			)
+			AND o.retailer IN (
+				SELECT DISTINCT retailer FROM product_observations
+				WHERE observed_at >= $2 AND observed_at < $3
+			)
+		GROUP BY o.retailer, o.product_id, p.product_name, p.product_brand, p.product_price_amount
+		HAVING MAX(o.observed_at) >= $1 AND MAX(o.observed_at) < $2
+		ORDER BY last_observed_at, o.retailer, o.product_id
+	`, from.Add(-to.Sub(from)), from, to)
+	if err != nil {
+		return digest, fmt.Errorf("failed to query discontinued products: %w", err)
+	}
+
+	priceChanges := digestWindows + `
+		SELECT cur.retailer, cur.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			prev.product_price_amount, cur.product_price_amount,
+			ROUND((cur.product_price_amount / prev.product_price_amount - 1) * 100, 2) AS change_percent,
+			cur.observed_at
+		FROM cur
+		JOIN prev ON prev.retailer = cur.retailer AND prev.product_id = cur.product_id
+		JOIN products p ON p.retailer = cur.retailer AND p.product_id = cur.product_id
+		WHERE prev.product_price_amount > 0 AND cur.product_price_amount > 0
+	`
+	digest.PriceIncreases, err = d.queryPriceChanges(priceChanges+`
+			AND cur.product_price_amount > prev.product_price_amount
+		ORDER BY change_percent DESC, cur.retailer, cur.product_id
+		LIMIT $3
+	`, from, to, limit)
+	if err != nil {
+		return digest, fmt.Errorf("failed to query price increases: %w", err)
+	}
+
+	digest.PriceDecreases, err = d.queryPriceChanges(priceChanges+`
+			AND cur.product_price_amount < prev.product_price_amount
+		ORDER BY change_percent, cur.retailer, cur.product_id
+		LIMIT $3
+	`, from, to, limit)
+	if err != nil {
+		return digest, fmt.Errorf("failed to query price decreases: %w", err)
+	}
+
+	digest.NewPromotions, err = d.queryNewPromotions(from, to)
+	if err != nil {
+		return digest, fmt.Errorf("failed to query new promotions: %w", err)
+	}
+
+	return digest, nil
+}
+
+// queryDigestProducts runs a query returning digest products.
+func (d *DatabaseService) queryDigestProducts(query string, args ...interface{}) ([]models.DigestProduct, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.DigestProduct
+	for rows.Next() {
+		var product models.DigestProduct
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductName,
+			&product.ProductBrand,
+			&product.ProductPriceAmount,
+			&product.ObservedAt,
+		); err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// queryPriceChanges runs a query returning price changes.
+func (d *DatabaseService) queryPriceChanges(query string, args ...interface{}) ([]models.PriceChange, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.PriceChange
+	for rows.Next() {
+		var change models.PriceChange
+		if err := rows.Scan(
+			&change.Retailer,
+			&change.ProductID,
+			&change.ProductName,
+			&change.ProductBrand,
+			&change.OldPrice,
+			&change.NewPrice,
+			&change.ChangePercent,
+			&change.ObservedAt,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// queryNewPromotions returns the promotions of the latest observation in the
+// period whose description was not among the promotions of the observation
+// before it. Products observed for the first time are left out.
+func (d *DatabaseService) queryNewPromotions(from, to time.Time) ([]models.ActivePromotion, error) {
+	rows, err := d.db.Query(digestWindows+`
+		SELECT pp.observation_id, pp.retailer, pp.product_id, pp.position,
+			COALESCE(pp.promotion_type, ''), COALESCE(pp.description, ''),
+			pp.start_date, pp.end_date, COALESCE(pp.discounted_price, 0),
+			pp.required_quantity, pp.observed_at,
+			p.product_name, COALESCE(p.product_brand, ''), COALESCE(cur.product_price_amount, 0)
+		FROM cur
+		JOIN prev ON prev.retailer = cur.retailer AND prev.product_id = cur.product_id
+		JOIN product_promotions pp ON pp.observation_id = cur.id
+		JOIN products p ON p.retailer = cur.retailer AND p.product_id = cur.product_id
+		WHERE NOT EXISTS (
+			SELECT 1 FROM product_promotions old
+			WHERE old.observation_id = prev.id
+				AND old.description IS NOT DISTINCT FROM pp.description
+		)
+		ORDER BY pp.retailer, pp.product_id, pp.position
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promotions []models.ActivePromotion
+	for rows.Next() {
+		var promotion models.ActivePromotion
+		var startDate, endDate sql.NullTime
+		if err := rows.Scan(
+			&promotion.ObservationID,
+			&promotion.Retailer,
+			&promotion.ProductID,
+			&promotion.Position,
+			&promotion.Type,
+			&promotion.Description,
+			&startDate,
+			&endDate,
+			&promotion.DiscountedPrice,
+			&promotion.RequiredQuantity,
+			&promotion.ObservedAt,
+			&promotion.ProductName,
+			&promotion.ProductBrand,
+			&promotion.ProductPriceAmount,
+		); err != nil {
+			return nil, err
+		}
+		if startDate.Valid {
+			promotion.StartDate = &startDate.Time
+		}
+		if endDate.Valid {
+			promotion.EndDate = &endDate.Time
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, rows.Err()
+}
+
+// DigestSent reports whether the digest for the given day was already sent.
+func (d *DatabaseService) DigestSent(day time.Time) (bool, error) {
+	var sent bool
+	err := d.db.QueryRow("SELECT EXISTS (SELECT 1 FROM email_digests WHERE digest_date = $1::date)",
+		day.Format("2006-01-02")).Scan(&sent)
+	if err != nil {
+		return false, fmt.Errorf("failed to check digest for %s: %w", day.Format("2006-01-02"), err)
+	}
+	return sent, nil
+}
+
+// RecordDigestSent records that the digest for the given day was sent, so that
+// later crawls on the same day do not send it again.
+func (d *DatabaseService) RecordDigestSent(day time.Time, recipients int) error {
+	_, err := d.db.Exec(`
+		INSERT INTO email_digests (digest_date, recipients)
+		VALUES ($1::date, $2)
+		ON CONFLICT (digest_date) DO NOTHING
+	`, day.Format("2006-01-02"), recipients)
+	if err != nil {
+		return fmt.Errorf("failed to record digest for %s: %w", day.Format("2006-01-02"), err)
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/fakeserver"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+// newTestProductService returns a ProductService pointed at the fake server.
+func newTestProductService(server *fakeserver.Server) *services.ProductService {
+	productService := services.NewProductService(4)
+	productService.SetBaseURL(server.URL)
+	return productService
+}
+
+func TestCrawlPipeline(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+
+	// Products listed in the sitemap that fail in different ways
+	server.SetFault(90010, fakeserver.Fault{Status: http.StatusNotFound})
+	server.SetFault(90011, fakeserver.Fault{Status: http.StatusInternalServerError})
+	server.AddProduct(90012, fakeserver.Fixture(90001))
+	server.SetFault(90012, fakeserver.Fault{Malformed: true})
+
+	items, err := services.NewSitemapService().FetchProductIds(server.SitemapURL())
+	if err != nil {
+		t.Fatalf("FetchProductIds: %v", err)
+	}
+
+	var productIDs []int
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	wantIDs := []int{90001, 90002, 90003, 90010, 90011, 90012}
+	if len(productIDs) != len(wantIDs) {
+		t.Fatalf("sitemap returned %v, want %v", productIDs, wantIDs)
+	}
+
+	// Two of the six products fail, which is tolerated below a 50% threshold
+	productService := newTestProductService(server)
+	productService.SetFailureRateThreshold(0.5)
+	products, nutritionalData, report, err := productService.FetchAllProductsData(context.Background(), productIDs, 0)
+	if err != nil {
+		t.Fatalf("FetchAllProductsData: %v", err)
+	}
+
+	var gotIDs []int
+	for _, product := range products {
+		gotIDs = append(gotIDs, product.ProductID)
+	}
+	sort.Ints(gotIDs)
+	if len(gotIDs) != 3 || gotIDs[0] != 90001 || gotIDs[1] != 90002 || gotIDs[2] != 90003 {
+		t.Fatalf("fetched products %v, want [90001 90002 90003]", gotIDs)
+	}
+
+	// Two fixtures carry a seven-row nutrition table
+	if len(nutritionalData) != 14 {
+		t.Errorf("got %d nutritional data entries, want 14", len(nutritionalData))
+	}
+
+	health := report.Health
+	if health.TotalProducts != 6 || health.SuccessCount != 3 || health.NotFoundCount != 1 || health.ErrorCount != 2 {
+		t.Errorf("run health = %+v, want 3 fetched, 1 not found and 2 failed of 6", health)
+	}
+	if len(report.Failures) != 3 {
+		t.Errorf("got %d failures, want 3", len(report.Failures))
+	}
+
+	// Failing products are requested once, and left to retry-failures
+	for _, productID := range []int{90010, 90011} {
+		if got := server.Requests(productID); got != 1 {
+			t.Errorf("product %d requested %d times, want 1", productID, got)
+		}
+	}
+}
+
+func TestFetchStopsWhenCancelled(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	productService := newTestProductService(server)
+	products, _, _, err := productService.FetchAllProductsData(ctx, []int{90001, 90002, 90003}, time.Minute)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(products) != 0 || server.Requests(90001) != 0 {
+		t.Errorf("got %d products after cancellation, want none requested", len(products))
+	}
+}
+
+func TestFetchAbandonsRequestsWhenCancelled(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+	server.SetFault(90002, fakeserver.Fault{Delay: 2 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	productService := newTestProductService(server)
+	start := time.Now()
+	products, _, report, err := productService.FetchAllProductsData(ctx, []int{90001, 90002}, 0)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch took %v, want the slow request abandoned", elapsed)
+	}
+	if len(products) != 1 || len(report.Failures) != 0 || report.Health.ErrorCount != 0 {
+		t.Errorf("got %d products and failures %+v, want product 90001 and no failures", len(products), report.Failures)
+	}
+}
+
+func TestFailureRateThreshold(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+	server.SetFault(90002, fakeserver.Fault{Status: http.StatusInternalServerError})
+	server.SetFault(90003, fakeserver.Fault{Status: http.StatusTooManyRequests, RetryAfter: "0"})
+	server.SetFault(90010, fakeserver.Fault{Status: http.StatusNotFound})
+
+	productService := newTestProductService(server)
+	productService.SetFailureRateThreshold(0.25)
+	products, _, report, err := productService.FetchAllProductsData(context.Background(), []int{90001, 90002, 90003, 90010}, 0)
+
+	var summary *services.FetchSummaryError
+	if !errors.As(err, &summary) {
+		t.Fatalf("expected a *FetchSummaryError, got %v", err)
+	}
+	if len(products) != 1 {
+		t.Errorf("got %d products, want the 1 that succeeded", len(products))
+	}
+	if summary.Failed != 2 || summary.NotFound != 1 || summary.Succeeded != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	// The product that was not found counts neither as failed nor as requested
+	if rate := summary.FailureRate(); rate < 0.66 || rate > 0.67 {
+		t.Errorf("FailureRate = %v, want 2 of 3", rate)
+	}
+	for category, want := range map[string]int{
+		services.CategoryHTTPStatus:  1,
+		services.CategoryRateLimited: 1,
+		services.CategoryNotFound:    1,
+	} {
+		if got := summary.ByCategory[category]; got != want {
+			t.Errorf("ByCategory[%s] = %d, want %d", category, got, want)
+		}
+	}
+
+	var rateLimited *services.RateLimitedError
+	if !errors.As(err, &rateLimited) || rateLimited.ProductID != 90003 {
+		t.Errorf("expected a RateLimitedError for product 90003, got %v", rateLimited)
+	}
+
+	// Every failure is recorded for retrying, with its status and attempt count
+	failures := make(map[int]models.ProductFetchFailure)
+	for _, failure := range report.Failures {
+		failures[failure.ProductID] = failure
+	}
+	wantFailures := map[int]models.ProductFetchFailure{
+		90002: {ErrorCategory: services.CategoryHTTPStatus, HTTPStatus: 500, Attempts: 1},
+		90003: {ErrorCategory: services.CategoryRateLimited, HTTPStatus: 429, Attempts: 1},
+		90010: {ErrorCategory: services.CategoryNotFound, HTTPStatus: 404, Attempts: 1},
+	}
+	if len(failures) != len(wantFailures) {
+		t.Errorf("got %d failures, want %d", len(failures), len(wantFailures))
+	}
+	for productID, want := range wantFailures {
+		got := failures[productID]
+		if got.ErrorCategory != want.ErrorCategory || got.HTTPStatus != want.HTTPStatus || got.Attempts != want.Attempts {
+			t.Errorf("failure for %d = %+v, want %+v", productID, got, want)
+		}
+		if got.FailedAt.IsZero() || got.ErrorMessage == "" {
+			t.Errorf("failure for %d is missing its timestamp or message: %+v", productID, got)
+		}
+	}
+
+	// The same failures stay below a more tolerant threshold
+	productService.SetFailureRateThreshold(0.7)
+	if _, _, _, err := productService.FetchAllProductsData(context.Background(), []int{90001, 90002, 90003, 90010}, 0); err != nil {
+		t.Errorf("expected no error below the threshold, got %v", err)
+	}
+}
+
+func TestFetchSingleProductParsesFixture(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+
+	product, nutritionalData, err := newTestProductService(server).FetchSingleProductData(90001)
+	if err != nil {
+		t.Fatalf("FetchSingleProductData: %v", err)
+	}
+
+	checks := []struct {
+		field     string
+		got, want interface{}
+	}{
+		{"ProductType", product.ProductType, "SINGLE"},
+		{"ProductName", product.ProductName, "Iogurt natural ecològic"},
+		{"ProductBrand", product.ProductBrand, "La Fageda"},
+		{"ProductPackSizeDescription", product.ProductPackSizeDescription, "4 x 125 g"},
+		{"ProductPriceAmount", product.ProductPriceAmount, 2.35},
+		{"ProductCurrency", product.ProductCurrency, "EUR"},
+		{"ProductUnitPriceAmount", product.ProductUnitPriceAmount, 4.70},
+		{"ProductUnitPriceUnit", product.ProductUnitPriceUnit, "fop.price.per.kg"},
+		{"ProductAvailable", product.ProductAvailable, true},
+		{"StorageConditions", product.Details.StorageConditions, "Conservar entre 1 i 8 ºC."},
+		{"Allergens[milk]", product.Details.Allergens[models.AllergenMilk], models.AllergenContains},
+		{"NutriScore.Category", product.NutriScore.Category, models.NutriScoreGeneral},
+		{"NutriScore.Grade", product.NutriScore.Grade, "B"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.field, check.got, check.want)
+		}
+	}
+	if len(product.ProductCategories) != 3 || product.ProductCategories[2] != "Iogurts" {
+		t.Errorf("categories = %v", product.ProductCategories)
+	}
+	if len(nutritionalData) != 7 || nutritionalData[0].ProductNutritionalValue != "Valor energètic" {
+		t.Errorf("nutritional data = %+v", nutritionalData)
+	}
+	wantImages := []models.ProductImage{
+		{Position: 0, Size: "500x500", URL: server.URL + "/images-v3/90001/main/500x500.jpg"},
+		{Position: 0, Size: "thumbnail", URL: server.URL + "/images-v3/90001/main/100x100.jpg"},
+		{Position: 1, Size: "500x500", URL: server.URL + "/images-v3/90001/back/500x500.jpg"},
+	}
+	if len(product.Images) != len(wantImages) {
+		t.Fatalf("images = %+v, want %+v", product.Images, wantImages)
+	}
+	for i, want := range wantImages {
+		if product.Images[i] != want {
+			t.Errorf("image %d = %+v, want %+v", i, product.Images[i], want)
+		}
+	}
+}
+
+func TestFetchSingleProductFaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		fault        fakeserver.Fault
+		wantCategory string
+		wantRequests int
+	}{
+		{name: "gzip", fault: fakeserver.Fault{Encoding: "gzip"}, wantRequests: 1},
+		{name: "brotli", fault: fakeserver.Fault{Encoding: "br"}, wantRequests: 1},
+		{name: "not found", fault: fakeserver.Fault{Status: http.StatusNotFound}, wantCategory: services.CategoryNotFound, wantRequests: 1},
+		{name: "rate limited", fault: fakeserver.Fault{Status: http.StatusTooManyRequests, RetryAfter: "0"}, wantCategory: services.CategoryRateLimited, wantRequests: 1},
+		{name: "server error", fault: fakeserver.Fault{Status: http.StatusServiceUnavailable}, wantCategory: services.CategoryHTTPStatus, wantRequests: 1},
+		{name: "malformed json", fault: fakeserver.Fault{Malformed: true}, wantCategory: services.CategoryParse, wantRequests: 1},
+		{name: "slow response", fault: fakeserver.Fault{Delay: 50 * time.Millisecond, Times: 1}, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakeserver.NewWithFixtures()
+			defer server.Close()
+			server.SetFault(90002, tt.fault)
+
+			product, _, err := newTestProductService(server).FetchSingleProductData(90002)
+			if tt.wantCategory != "" {
+				if err == nil {
+					t.Fatalf("expected an error, got product %+v", product)
+				}
+				if got := services.ErrorCategory(err); got != tt.wantCategory {
+					t.Errorf("error %v has category %s, want %s", err, got, tt.wantCategory)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("FetchSingleProductData: %v", err)
+				}
+				if product.ProductBrand != "Borges" {
+					t.Errorf("brand = %q, want Borges", product.ProductBrand)
+				}
+			}
+			if got := server.Requests(90002); got != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestFetchSingleProductTimeout(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+	server.SetFault(90003, fakeserver.Fault{Delay: time.Second})
+
+	productService := newTestProductService(server)
+	productService.SetHTTPClient(&http.Client{Timeout: 50 * time.Millisecond})
+
+	_, _, err := productService.FetchSingleProductData(90003)
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if got := services.ErrorCategory(err); got != services.CategoryTransport {
+		t.Errorf("timeout has category %s, want %s", got, services.CategoryTransport)
+	}
+}
+
+func TestRateLimitedErrorCarriesRetryAfter(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+	server.SetFault(90001, fakeserver.Fault{Status: http.StatusTooManyRequests, RetryAfter: "120"})
+
+	_, _, err := newTestProductService(server).FetchSingleProductData(90001)
+	var rateLimited *services.RateLimitedError
+	if !errors.As(err, &rateLimited) || rateLimited.RetryAfter != 2*time.Minute {
+		t.Errorf("expected a RateLimitedError asking to retry after 2m, got %v", err)
+	}
+	if got := server.Requests(90001); got != 1 {
+		t.Errorf("server received %d requests, want 1", got)
+	}
+}
+
+func TestSitemapServerError(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+	server.SetSitemapStatus(http.StatusServiceUnavailable)
+
+	if _, err := services.NewSitemapService().FetchProductIds(server.SitemapURL()); err == nil {
+		t.Fatal("expected an error for a failing sitemap")
+	}
+}
+
+func TestEsclatRetailer(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+
+	if _, err := services.NewRetailer("mercadona", services.RetailerOptions{}); err == nil {
+		t.Fatal("expected an error for an unknown retailer")
+	}
+
+	retailer, err := services.NewRetailer(models.RetailerEsclat, services.RetailerOptions{
+		SitemapURL: server.SitemapURL(),
+		APIBaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewRetailer: %v", err)
+	}
+
+	productIDs, err := retailer.DiscoverProductIDs()
+	if err != nil {
+		t.Fatalf("DiscoverProductIDs: %v", err)
+	}
+	if len(productIDs) != len(fakeserver.FixtureIDs()) {
+		t.Fatalf("discovered %v, want %v", productIDs, fakeserver.FixtureIDs())
+	}
+
+	productService := newTestProductService(server)
+	productService.SetRetailer(retailer)
+	server.SetFault(90002, fakeserver.Fault{Status: http.StatusNotFound})
+	products, nutritionalData, report, err := productService.FetchAllProductsData(context.Background(), []int{90001, 90002}, 0)
+	if err != nil {
+		t.Fatalf("FetchAllProductsData: %v", err)
+	}
+	if len(products) != 1 || products[0].Retailer != models.RetailerEsclat {
+		t.Fatalf("fetched %+v, want product 90001 of esclat", products)
+	}
+	for _, data := range nutritionalData {
+		if data.Retailer != models.RetailerEsclat {
+			t.Errorf("nutritional data of retailer %q, want esclat", data.Retailer)
+		}
+	}
+
+	if len(report.Failures) != 1 || report.Failures[0].Retailer != models.RetailerEsclat {
+		t.Errorf("failures = %+v, want product 90002 of esclat", report.Failures)
+	}
+}
+
+func TestSetBaseURLKeepsRetailerOptions(t *testing.T) {
+	server := fakeserver.NewWithFixtures()
+	defer server.Close()
+
+	// Count the sitemap requests going through the retailer's transport
+	var requests int
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	productService := services.NewProductService(4)
+	productService.SetRetailer(services.NewCompraOnlineRetailer(models.RetailerBonpreu, services.RetailerOptions{
+		SitemapURL: server.SitemapURL(),
+		Transport:  transport,
+	}))
+	productService.SetBaseURL(server.URL)
+
+	productIDs, err := productService.Retailer().DiscoverProductIDs()
+	if err != nil {
+		t.Fatalf("DiscoverProductIDs: %v", err)
+	}
+	if len(productIDs) != len(fakeserver.FixtureIDs()) || requests != 1 {
+		t.Errorf("discovered %v with %d requests through the transport, want %v with 1", productIDs, requests, fakeserver.FixtureIDs())
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestEsclatRetailerParsesItsOwnResponses(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/esclat_product_70001.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bonpreu := fakeserver.NewWithFixtures()
+	defer bonpreu.Close()
+	esclat := fakeserver.New()
+	defer esclat.Close()
+	esclat.AddProduct(70001, fixture)
+
+	if _, err := services.NewRetailer(models.RetailerEsclat, services.RetailerOptions{SitemapURL: esclat.SitemapURL()}); err == nil {
+		t.Fatal("expected an error for esclat without an API base URL")
+	}
+	retailer, err := services.NewRetailer(models.RetailerEsclat, services.RetailerOptions{
+		SitemapURL: esclat.SitemapURL(),
+		APIBaseURL: esclat.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewRetailer: %v", err)
+	}
+
+	productService := newTestProductService(bonpreu)
+	productService.SetRetailer(retailer)
+	products, nutritionalData, _, err := productService.FetchAllProductsData(context.Background(), []int{70001}, 0)
+	if err != nil {
+		t.Fatalf("FetchAllProductsData: %v", err)
+	}
+	if len(products) != 1 {
+		t.Fatalf("fetched %d products, want 1", len(products))
+	}
+
+	product := products[0]
+	if product.Retailer != models.RetailerEsclat || product.ProductName != "Llet sencera Esclat" ||
+		product.ProductPriceAmount != 5.34 || product.PromotionType != "MULTI_BUY" {
+		t.Errorf("product = %+v", product)
+	}
+	if len(product.Images) == 0 || !strings.HasPrefix(product.Images[0].URL, esclat.URL+"/images-v3/70001/") {
+		t.Errorf("images = %+v, want URLs on the esclat shop", product.Images)
+	}
+	if len(nutritionalData) == 0 || nutritionalData[0].Retailer != models.RetailerEsclat {
+		t.Errorf("nutritional data = %+v", nutritionalData)
+	}
+	if esclat.Requests(70001) != 1 || bonpreu.Requests(70001) != 0 {
+		t.Errorf("requests: esclat %d, bonpreu %d; want the esclat API only", esclat.Requests(70001), bonpreu.Requests(70001))
+	}
+}
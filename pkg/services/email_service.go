@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/utils"
+)
+
+//go:embed templates/digest.txt.tmpl templates/digest.html.tmpl
+var digestTemplateFS embed.FS
+
+// digestFuncs are the helpers available to the digest templates.
+var digestFuncs = map[string]interface{}{
+	"date":     func(t time.Time) string { return t.Format("2006-01-02") },
+	"price":    func(amount float64) string { return fmt.Sprintf("%.2f €", amount) },
+	"percent":  func(percent float64) string { return fmt.Sprintf("%+.1f%%", percent) },
+	"duration": func(d time.Duration) string { return d.Round(time.Second).String() },
+}
+
+var (
+	digestTextTemplate = texttemplate.Must(texttemplate.New("digest.txt.tmpl").
+				Funcs(digestFuncs).ParseFS(digestTemplateFS, "templates/digest.txt.tmpl"))
+	digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest.html.tmpl").
+				Funcs(digestFuncs).ParseFS(digestTemplateFS, "templates/digest.html.tmpl"))
+)
+
+// RenderDigest renders the digest as plain text and as HTML.
+func RenderDigest(digest models.Digest) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, digest); err != nil {
+		return "", "", fmt.Errorf("failed to render text digest: %w", err)
+	}
+	if err := digestHTMLTemplate.Execute(&html, digest); err != nil {
+		return "", "", fmt.Errorf("failed to render HTML digest: %w", err)
+	}
+	return text.String(), html.String(), nil
+}
+
+// EmailService sends emails through an SMTP server.
+type EmailService struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+	logger   *utils.Logger
+}
+
+// NewEmailService creates a new EmailService from the email configuration.
+func NewEmailService(cfg config.EmailConfig) *EmailService {
+	return &EmailService{
+		addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:     cfg.SMTPHost,
+		username: cfg.Username,
+		password: cfg.Password,
+		from:     cfg.From,
+		to:       cfg.To,
+		logger:   utils.NewLogger("EmailService"),
+	}
+}
+
+// SendDigest renders the digest and sends it to the configured recipients.
+func (e *EmailService) SendDigest(digest models.Digest) error {
+	text, html, err := RenderDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Bonpreu digest %s: %d new, %d discontinued, %d promotions",
+		digest.To.Format("2006-01-02"), len(digest.NewProducts), len(digest.DiscontinuedProducts), len(digest.NewPromotions))
+	return e.Send(subject, text, html)
+}
+
+// Send sends a multipart/alternative email with a plain text and an HTML body.
+// STARTTLS is used when the server offers it, and credentials are sent only
+// when a username is configured.
+func (e *EmailService) Send(subject, text, html string) error {
+	message, err := e.buildMessage(subject, text, html)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if e.username != "" {
+		auth = smtp.PlainAuth("", e.username, e.password, e.host)
+	}
+
+	start := time.Now()
+	if err := smtp.SendMail(e.addr, auth, e.from, e.to, message); err != nil {
+		return fmt.Errorf("failed to send email via %s: %w", e.addr, err)
+	}
+
+	e.logger.Info("Sent %q to %d recipients in %v", subject, len(e.to), time.Since(start))
+	return nil
+}
+
+// buildMessage assembles the MIME message.
+func (e *EmailService) buildMessage(subject, text, html string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create email part: %w", err)
+		}
+		encoder := quotedprintable.NewWriter(partWriter)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to encode email part: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode email part: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish email: %w", err)
+	}
+
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", e.from},
+		{"To", strings.Join(e.to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
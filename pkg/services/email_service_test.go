@@ -0,0 +1,93 @@
+package services_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/fakesmtp"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+func TestSendDigest(t *testing.T) {
+	server, err := fakesmtp.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	emailService := services.NewEmailService(config.EmailConfig{
+		SMTPHost: server.Host(),
+		SMTPPort: server.Port(),
+		Username: "crawler",
+		Password: "s3cret",
+		From:     "crawler@example.com",
+		To:       []string{"team@example.com", "ops@example.com"},
+	})
+
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	digest := models.Digest{
+		From:           day,
+		To:             day.AddDate(0, 0, 1),
+		NewProducts:    []models.DigestProduct{{ProductID: 1, ProductName: "Cafè <molt> bo", ProductPriceAmount: 3.5}},
+		PriceIncreases: []models.PriceChange{{ProductID: 2, ProductName: "Llet", OldPrice: 1, NewPrice: 1.1, ChangePercent: 10}},
+		RunHealth:      &models.RunHealth{TotalProducts: 100, SuccessCount: 95, NotFoundCount: 3, ErrorCount: 2},
+	}
+	if err := emailService.SendDigest(digest); err != nil {
+		t.Fatalf("SendDigest: %v", err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	received := messages[0]
+	if received.From != "crawler@example.com" || len(received.To) != 2 || received.Username != "crawler" {
+		t.Errorf("envelope = %+v", received)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(received.Data))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if subject := message.Header.Get("Subject"); !strings.Contains(subject, "2024-03-06") {
+		t.Errorf("subject = %q", subject)
+	}
+
+	_, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("invalid content type: %v", err)
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+
+	bodies := make(map[string]string)
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid part: %v", err)
+		}
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		content, _ := io.ReadAll(part)
+		bodies[mediaType] = string(content)
+	}
+
+	for _, want := range []string{"Cafè <molt> bo", "+10.0%", "Errors:           2 (2.06%)"} {
+		if !strings.Contains(bodies["text/plain"], want) {
+			t.Errorf("text body does not contain %q:\n%s", want, bodies["text/plain"])
+		}
+	}
+	for _, want := range []string{"Cafè &lt;molt&gt; bo", "1.10 €"} {
+		if !strings.Contains(bodies["text/html"], want) {
+			t.Errorf("HTML body does not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/models"
+)
+
+// ErrProductNotFound is matched by errors for products the API reports as missing (404).
+var ErrProductNotFound = errors.New("product not found")
+
+// Error categories used to aggregate fetch failures.
+const (
+	CategoryNotFound    = "not_found"
+	CategoryRateLimited = "rate_limited"
+	CategoryHTTPStatus  = "http_status"
+	CategoryParse       = "parse"
+	CategoryTransport   = "transport"
+)
+
+// HTTPStatusError is returned when the product API answers with an unexpected status code.
+// A 404 matches ErrProductNotFound with errors.Is.
+type HTTPStatusError struct {
+	ProductID  int
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.StatusCode == 404 {
+		return fmt.Sprintf("product %d not found", e.ProductID)
+	}
+	return fmt.Sprintf("failed to fetch product %d, status code: %d", e.ProductID, e.StatusCode)
+}
+
+// Is reports whether the error is a 404, so that errors.Is(err, ErrProductNotFound) works.
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrProductNotFound && e.StatusCode == 404
+}
+
+// RateLimitedError is returned when the product API answers 429.
+// RetryAfter holds the delay requested by the response, if any.
+type RateLimitedError struct {
+	ProductID  int
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("product %d rate limited (retry after %v)", e.ProductID, e.RetryAfter)
+	}
+	return fmt.Sprintf("product %d rate limited", e.ProductID)
+}
+
+// ParseError is returned when a product response cannot be decoded or parsed.
+type ParseError struct {
+	ProductID int
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse response for product %d: %v", e.ProductID, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCategory classifies a fetch error into one of the Category constants.
+// Errors that are not one of the typed errors are treated as transport failures.
+func ErrorCategory(err error) string {
+	var rateLimited *RateLimitedError
+	var statusErr *HTTPStatusError
+	var parseErr *ParseError
+
+	switch {
+	case errors.Is(err, ErrProductNotFound):
+		return CategoryNotFound
+	case errors.As(err, &rateLimited):
+		return CategoryRateLimited
+	case errors.As(err, &statusErr):
+		return CategoryHTTPStatus
+	case errors.As(err, &parseErr):
+		return CategoryParse
+	default:
+		return CategoryTransport
+	}
+}
+
+// NewProductFetchFailure builds the failure record persisted for a product that
+// could not be fetched. attempts is the number of requests made for the product.
+func NewProductFetchFailure(productID, attempts int, err error, failedAt time.Time) models.ProductFetchFailure {
+	failure := models.ProductFetchFailure{
+		ProductID:     productID,
+		ErrorCategory: ErrorCategory(err),
+		Attempts:      attempts,
+		ErrorMessage:  err.Error(),
+		FailedAt:      failedAt,
+		FailureCount:  1,
+	}
+
+	var statusErr *HTTPStatusError
+	var rateLimited *RateLimitedError
+	switch {
+	case errors.As(err, &statusErr):
+		failure.HTTPStatus = statusErr.StatusCode
+	case errors.As(err, &rateLimited):
+		failure.HTTPStatus = 429
+	}
+	return failure
+}
+
+// FetchSummaryError aggregates the failures of a FetchAllProductsData run.
+// It is returned when the failure rate exceeds the configured threshold.
+// Products that were not found (404) are counted separately and do not
+// contribute to the failure rate, since delisted products are expected.
+type FetchSummaryError struct {
+	Total      int
+	Succeeded  int
+	NotFound   int
+	Failed     int
+	Threshold  float64
+	ByCategory map[string]int
+	Errors     []error
+}
+
+// FailureRate returns the share of requested products that failed, between 0
+// and 1. Products that were not found are left out of both counts.
+func (e *FetchSummaryError) FailureRate() float64 {
+	return models.RunHealth{
+		TotalProducts: int64(e.Total),
+		NotFoundCount: int64(e.NotFound),
+		ErrorCount:    int64(e.Failed),
+	}.FailureRate()
+}
+
+func (e *FetchSummaryError) Error() string {
+	categories := make([]string, 0, len(e.ByCategory))
+	for category, count := range e.ByCategory {
+		categories = append(categories, fmt.Sprintf("%s=%d", category, count))
+	}
+	sort.Strings(categories)
+
+	return fmt.Sprintf("failed to fetch %d of %d existing products (%.1f%%, threshold %.1f%%): %s",
+		e.Failed, e.Total-e.NotFound, e.FailureRate()*100, e.Threshold*100, strings.Join(categories, ", "))
+}
+
+// Unwrap exposes the individual failures to errors.Is and errors.As.
+func (e *FetchSummaryError) Unwrap() []error {
+	return e.Errors
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// RecordFetchFailures updates the product_fetch_failures table after a crawl of a
+// retailer. Products in fetchedIDs were fetched successfully and are removed from the
+// table, as are products that were not found, since retrying them is pointless. Every
+// other failure is inserted, or updated with its latest details if it failed before,
+// in which case its failure count is incremented. The operation runs in a transaction.
+func (d *DatabaseService) RecordFetchFailures(retailer string, fetchedIDs []int, failures []models.ProductFetchFailure) error {
+	start := time.Now()
+
+	resolvedIDs := append([]int(nil), fetchedIDs...)
+	var pending []models.ProductFetchFailure
+	for _, failure := range failures {
+		if failure.ErrorCategory == CategoryNotFound {
+			resolvedIDs = append(resolvedIDs, failure.ProductID)
+		} else {
+			pending = append(pending, failure)
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if len(resolvedIDs) > 0 {
+		if _, err := tx.Exec(`DELETE FROM product_fetch_failures WHERE retailer = $1 AND product_id = ANY($2)`, retailer, pq.Array(resolvedIDs)); err != nil {
+			return fmt.Errorf("failed to clear resolved fetch failures: %w", err)
+		}
+	}
+
+	// Fetch failures have 7 parameters per record
+	maxFailuresPerBatch := 60000 / 7
+
+	for i := 0; i < len(pending); i += maxFailuresPerBatch {
+		end := i + maxFailuresPerBatch
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		batch := pending[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*7)
+		argIndex := 1
+
+		for _, failure := range batch {
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, NULLIF($%d, 0), $%d, $%d, $%d, $%d)",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6, argIndex+6))
+
+			args = append(args,
+				retailer,
+				failure.ProductID,
+				failure.ErrorCategory,
+				failure.HTTPStatus,
+				failure.Attempts,
+				failure.ErrorMessage,
+				failure.FailedAt,
+			)
+			argIndex += 7
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_fetch_failures (
+				retailer, product_id, error_category, http_status, attempts, error_message,
+				first_failed_at, last_failed_at
+			) VALUES %s
+			ON CONFLICT (retailer, product_id) DO UPDATE SET
+				error_category = EXCLUDED.error_category,
+				http_status = EXCLUDED.http_status,
+				attempts = EXCLUDED.attempts,
+				error_message = EXCLUDED.error_message,
+				last_failed_at = EXCLUDED.last_failed_at,
+				failure_count = product_fetch_failures.failure_count + 1
+		`, strings.Join(values, ","))
+
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to save fetch failures batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	d.logger.Info("Recorded %d fetch failures and cleared %d resolved products in %v", len(pending), len(resolvedIDs), time.Since(start))
+	return nil
+}
+
+// GetFetchFailures returns the products that failed in previous runs, oldest
+// failure first. When categories is not empty, only failures in those error
+// categories are returned; limit caps the number of results when positive.
+func (d *DatabaseService) GetFetchFailures(categories []string, limit int) ([]models.ProductFetchFailure, error) {
+	query := `
+		SELECT retailer, product_id, error_category, COALESCE(http_status, 0), attempts,
+			COALESCE(error_message, ''), last_failed_at, failure_count
+		FROM product_fetch_failures
+	`
+	var args []interface{}
+	if len(categories) > 0 {
+		args = append(args, pq.Array(categories))
+		query += fmt.Sprintf(" WHERE error_category = ANY($%d)", len(args))
+	}
+	query += " ORDER BY first_failed_at, retailer, product_id"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fetch failures: %w", err)
+	}
+	defer rows.Close()
+
+	var failures []models.ProductFetchFailure
+	for rows.Next() {
+		var failure models.ProductFetchFailure
+		if err := rows.Scan(
+			&failure.Retailer,
+			&failure.ProductID,
+			&failure.ErrorCategory,
+			&failure.HTTPStatus,
+			&failure.Attempts,
+			&failure.ErrorMessage,
+			&failure.FailedAt,
+			&failure.FailureCount,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan fetch failure: %w", err)
+		}
+		failures = append(failures, failure)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read fetch failures: %w", err)
+	}
+
+	return failures, nil
+}
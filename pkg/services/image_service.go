@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/utils"
+)
+
+// imageExtensions maps the content types of images to file extensions.
+var imageExtensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/webp":    ".webp",
+	"image/gif":     ".gif",
+	"image/avif":    ".avif",
+	"image/svg+xml": ".svg",
+}
+
+// ImageService mirrors product images to a local directory. Each file is
+// named after the SHA-256 of its content, e.g. ab/cd/abcd….jpg, so an image
+// shared by several products, sizes or URLs is stored once. Downloads are
+// rate limited independently of the crawl.
+type ImageService struct {
+	client            *http.Client
+	dir               string
+	maxBytes          int64
+	requestsPerSecond float64
+	logger            *utils.Logger
+}
+
+// NewImageService creates a new ImageService from the image configuration.
+func NewImageService(cfg config.ImagesConfig) *ImageService {
+	return &ImageService{
+		client:            &http.Client{Timeout: cfg.Timeout},
+		dir:               cfg.Dir,
+		maxBytes:          int64(cfg.MaxBytes),
+		requestsPerSecond: cfg.RequestsPerSecond,
+		logger:            utils.NewLogger("ImageService"),
+	}
+}
+
+// SetTransport replaces the transport of the HTTP client, e.g. to record or replay cassettes.
+func (s *ImageService) SetTransport(transport http.RoundTripper) {
+	s.client.Transport = transport
+}
+
+// DownloadAll downloads the given product images in turn, at the configured
+// rate, and passes the downloads that succeeded to save in batches of up to
+// batchSize as they complete, so that a long run keeps its progress and
+// holds one batch in memory. Images that fail are logged and counted, so
+// that one broken URL does not stop the mirror; an error from save stops the
+// downloads. It returns the number of images downloaded and failed. When ctx
+// is cancelled, no further images are requested, the downloads so far are
+// saved and an error wrapping ctx.Err() is returned.
+func (s *ImageService) DownloadAll(ctx context.Context, images []models.ProductImage, batchSize int, save func([]models.ImageDownload) error) (int, int, error) {
+	var rateLimiter *time.Ticker
+	if s.requestsPerSecond > 0 {
+		rateLimiter = time.NewTicker(time.Duration(float64(time.Second) / s.requestsPerSecond))
+		defer rateLimiter.Stop()
+	}
+
+	var downloads []models.ImageDownload
+	downloaded, failed := 0, 0
+	for i, image := range images {
+		if rateLimiter != nil && i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-rateLimiter.C:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		file, err := s.Download(ctx, image.URL)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The request was cut short, the image is not broken
+				break
+			}
+			failed++
+			s.logger.With(utils.ProductIDKey, image.ProductID).Warn("Failed to download image %s of %s product %d: %v",
+				image.URL, image.Retailer, image.ProductID, err)
+			continue
+		}
+		downloads = append(downloads, models.ImageDownload{
+			ProductImageID: image.ID,
+			File:           file,
+			DownloadedAt:   time.Now(),
+		})
+
+		if len(downloads) >= batchSize {
+			if err := save(downloads); err != nil {
+				return downloaded, failed, err
+			}
+			downloaded += len(downloads)
+			downloads = nil
+		}
+	}
+	if len(downloads) > 0 {
+		if err := save(downloads); err != nil {
+			return downloaded, failed, err
+		}
+		downloaded += len(downloads)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return downloaded, failed, fmt.Errorf("downloads stopped after %d of %d images: %w", downloaded+failed, len(images), err)
+	}
+	s.logger.Info("Downloaded %d images, %d failed", downloaded, failed)
+	return downloaded, failed, nil
+}
+
+// Download fetches an image and stores it in the mirror unless a file with
+// the same content is already there. Responses that are not images, or are
+// larger than the configured maximum, are rejected. The request is
+// abandoned when ctx is cancelled.
+func (s *ImageService) Download(ctx context.Context, url string) (models.ImageFile, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return models.ImageFile{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "image/avif,image/webp,image/*,*/*;q=0.8")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15")
+
+	requestStart := time.Now()
+	resp, err := s.client.Do(req)
+	metrics.HTTPRequestDuration.Observe(time.Since(requestStart).Seconds(), "image")
+	if err != nil {
+		metrics.HTTPRequests.Inc("image", "error")
+		return models.ImageFile{}, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+	metrics.HTTPRequests.Inc("image", strconv.Itoa(resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		return models.ImageFile{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, s.maxBytes+1))
+	if err != nil {
+		return models.ImageFile{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(body)) > s.maxBytes {
+		return models.ImageFile{}, fmt.Errorf("image is larger than %d bytes", s.maxBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if !strings.HasPrefix(contentType, "image/") {
+		return models.ImageFile{}, fmt.Errorf("unexpected content type %q", contentType)
+	}
+
+	sum := sha256.Sum256(body)
+	file := models.ImageFile{
+		ContentHash: hex.EncodeToString(sum[:]),
+		ContentType: contentType,
+		Bytes:       int64(len(body)),
+	}
+	file.Path = imageFilePath(file.ContentHash, contentType, req.URL.Path)
+
+	if err := s.store(file.Path, body); err != nil {
+		return models.ImageFile{}, err
+	}
+	return file, nil
+}
+
+// store writes an image to its path in the mirror, unless it is already
+// there. The file is written under a temporary name and then renamed, so
+// that an interrupted download never leaves a partial image behind.
+func (s *ImageService) store(relativePath string, body []byte) error {
+	fullPath := filepath.Join(s.dir, filepath.FromSlash(relativePath))
+	if _, err := os.Stat(fullPath); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create image directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("failed to store image file: %w", err)
+	}
+	return nil
+}
+
+// imageFilePath returns the path of an image in the mirror, relative to its
+// directory, fanned out by the first bytes of the hash. The extension comes
+// from the content type, or else from the URL.
+func imageFilePath(hash, contentType, urlPath string) string {
+	extension, ok := imageExtensions[contentType]
+	if !ok {
+		extension = strings.ToLower(path.Ext(urlPath))
+	}
+	return path.Join(hash[:2], hash[2:4], hash+extension)
+}
@@ -0,0 +1,113 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+func TestImageDownloads(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n fake image content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/front.png", "/front-copy.png":
+			w.Write(png)
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/huge.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(make([]byte, 2048))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	imageService := services.NewImageService(config.ImagesConfig{Dir: dir, Timeout: time.Second, MaxBytes: 1024})
+
+	var downloads []models.ImageDownload
+	var batches [][]models.ImageDownload
+	downloaded, failed, err := imageService.DownloadAll(context.Background(), []models.ProductImage{
+		{ID: 1, URL: server.URL + "/front.png"},
+		{ID: 2, URL: server.URL + "/front-copy.png"},
+		{ID: 3, URL: server.URL + "/page.html"},
+		{ID: 4, URL: server.URL + "/huge.jpg"},
+		{ID: 5, URL: server.URL + "/missing.jpg"},
+	}, 1, func(batch []models.ImageDownload) error {
+		batches = append(batches, batch)
+		downloads = append(downloads, batch...)
+		return nil
+	})
+	if err != nil || failed != 3 || downloaded != 2 || len(downloads) != 2 {
+		t.Fatalf("got %d downloads and %d failures, want 2 and 3: %+v, %v", downloaded, failed, downloads, err)
+	}
+	// Each batch keeps its own downloads after the next one is saved
+	if len(batches) != 2 || batches[0][0].ProductImageID != 1 || batches[1][0].ProductImageID != 2 {
+		t.Errorf("downloads were saved in batches %+v, want images 1 and 2 apart", batches)
+	}
+
+	first, second := downloads[0].File, downloads[1].File
+	if first.ContentHash != second.ContentHash || first.Path != second.Path {
+		t.Errorf("identical images stored apart: %+v, %+v", first, second)
+	}
+	if first.ContentType != "image/png" || first.Bytes != int64(len(png)) {
+		t.Errorf("file = %+v", first)
+	}
+	if want := first.ContentHash[:2] + "/" + first.ContentHash[2:4] + "/" + first.ContentHash + ".png"; first.Path != want {
+		t.Errorf("path = %q, want %q", first.Path, want)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(first.Path)))
+	if err != nil || string(stored) != string(png) {
+		t.Errorf("stored image = %q, %v", stored, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*"))
+	if len(files) != 1 {
+		t.Errorf("mirror holds %v, want a single file", files)
+	}
+}
+
+func TestImageDownloadsStopWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	// At one image per second, a download after the cancellation would be noticed
+	imageService := services.NewImageService(config.ImagesConfig{Dir: t.TempDir(), Timeout: time.Second, MaxBytes: 1024, RequestsPerSecond: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var saved int
+	start := time.Now()
+	downloaded, failed, err := imageService.DownloadAll(ctx, []models.ProductImage{
+		{ID: 1, URL: server.URL + "/1.png"},
+		{ID: 2, URL: server.URL + "/2.png"},
+		{ID: 3, URL: server.URL + "/3.png"},
+	}, 1, func(batch []models.ImageDownload) error {
+		saved += len(batch)
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a context.Canceled error, got %v", err)
+	}
+	if downloaded != 1 || failed != 0 || saved != 1 {
+		t.Errorf("got %d downloads (%d saved) and %d failures, want the 1 saved before cancelling", downloaded, saved, failed)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("downloads took %v to stop, want no wait for the rate limiter", elapsed)
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// saveProductImages records the image URLs of the observed products in the
+// product_images table. As with attributes, a new version is stored only
+// when the URL of a position and size changes; unchanged URLs just get their
+// last_observed_at extended, and URLs a product no longer has stop being
+// current.
+func saveProductImages(tx *sql.Tx, products []models.Product, observationIDs map[models.ProductKey]int64) (int64, error) {
+	if len(products) == 0 {
+		return 0, nil
+	}
+
+	var productRetailers, retailers, sizes, urls, productObservedAt, observedAt []string
+	var productIDs, imageProductIDs, positions []int
+	var observations []int64
+	for _, product := range products {
+		at := product.CreatedAt.Format(time.RFC3339Nano)
+		productRetailers = append(productRetailers, product.Retailer)
+		productIDs = append(productIDs, product.ProductID)
+		productObservedAt = append(productObservedAt, at)
+
+		for _, image := range product.Images {
+			retailers = append(retailers, product.Retailer)
+			imageProductIDs = append(imageProductIDs, product.ProductID)
+			positions = append(positions, image.Position)
+			sizes = append(sizes, image.Size)
+			urls = append(urls, image.URL)
+			observations = append(observations, observationIDs[product.Key()])
+			observedAt = append(observedAt, at)
+		}
+	}
+
+	start := time.Now()
+	if _, err := tx.Exec(`
+		UPDATE product_images a SET is_current = FALSE
+		FROM unnest($1::text[], $2::integer[]) AS p(retailer, product_id)
+		WHERE a.is_current AND a.retailer = p.retailer AND a.product_id = p.product_id
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($3::text[], $4::integer[], $5::integer[], $6::text[], $7::text[])
+					AS i(retailer, product_id, position, size, url)
+				WHERE i.retailer = a.retailer AND i.product_id = a.product_id
+					AND i.position = a.position AND i.size = a.size AND i.url = a.url
+			)
+	`, pq.Array(productRetailers), pq.Array(productIDs),
+		pq.Array(retailers), pq.Array(imageProductIDs), pq.Array(positions), pq.Array(sizes), pq.Array(urls)); err != nil {
+		return 0, fmt.Errorf("failed to retire product images: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE product_images a SET last_observed_at = GREATEST(a.last_observed_at, i.observed_at)
+		FROM unnest($1::text[], $2::integer[], $3::integer[], $4::text[], $5::timestamptz[])
+			AS i(retailer, product_id, position, size, observed_at)
+		WHERE a.is_current AND a.retailer = i.retailer AND a.product_id = i.product_id
+			AND a.position = i.position AND a.size = i.size
+	`, pq.Array(retailers), pq.Array(imageProductIDs), pq.Array(positions), pq.Array(sizes), pq.Array(observedAt)); err != nil {
+		return 0, fmt.Errorf("failed to update product images: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO product_images (
+			retailer, product_id, position, size, url, observation_id, first_observed_at, last_observed_at
+		)
+		SELECT i.retailer, i.product_id, i.position, i.size, i.url, NULLIF(i.observation_id, 0), i.observed_at, i.observed_at
+		FROM unnest($1::text[], $2::integer[], $3::integer[], $4::text[], $5::text[], $6::bigint[], $7::timestamptz[])
+			AS i(retailer, product_id, position, size, url, observation_id, observed_at)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM product_images a
+			WHERE a.is_current AND a.retailer = i.retailer AND a.product_id = i.product_id
+				AND a.position = i.position AND a.size = i.size
+		)
+	`, pq.Array(retailers), pq.Array(imageProductIDs), pq.Array(positions), pq.Array(sizes), pq.Array(urls),
+		pq.Array(observations), pq.Array(observedAt))
+	metrics.DBBatchDuration.Observe(time.Since(start).Seconds(), "product_images")
+	if err != nil {
+		return 0, fmt.Errorf("failed to save product images: %w", err)
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count saved product images: %w", err)
+	}
+	metrics.RowsSaved.Add(float64(added), "product_images")
+	return added, nil
+}
+
+// ImageDownloadFilter selects the current product images to download.
+// Retailer and Size select a single retailer or size. Refresh includes the
+// images downloaded before, to notice contents replaced behind an unchanged
+// URL. Limit caps the number of images when positive.
+type ImageDownloadFilter struct {
+	Retailer string
+	Size     string
+	Refresh  bool
+	Limit    int
+}
+
+// GetImagesToDownload returns the current product images selected by the
+// filter, by product and position.
+func (d *DatabaseService) GetImagesToDownload(filter ImageDownloadFilter) ([]models.ProductImage, error) {
+	query := `
+		SELECT i.id, i.retailer, i.product_id, i.position, i.size, i.url
+		FROM product_images i
+		WHERE i.is_current
+	`
+	var args []interface{}
+	if !filter.Refresh {
+		query += " AND NOT EXISTS (SELECT 1 FROM product_image_downloads d WHERE d.product_image_id = i.id)"
+	}
+	if filter.Retailer != "" {
+		args = append(args, filter.Retailer)
+		query += fmt.Sprintf(" AND i.retailer = $%d", len(args))
+	}
+	if filter.Size != "" {
+		args = append(args, filter.Size)
+		query += fmt.Sprintf(" AND i.size = $%d", len(args))
+	}
+	query += " ORDER BY i.retailer, i.product_id, i.position, i.size"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []models.ProductImage
+	for rows.Next() {
+		var image models.ProductImage
+		if err := rows.Scan(
+			&image.ID,
+			&image.Retailer,
+			&image.ProductID,
+			&image.Position,
+			&image.Size,
+			&image.URL,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan product image: %w", err)
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read product images: %w", err)
+	}
+	return images, nil
+}
+
+// SaveImageDownloads records downloaded images: their files in image_files
+// and, in product_image_downloads, the content of each product image. A
+// download is only recorded when its content differs from the last one
+// recorded for the same product image, so the table logs content changes.
+func (d *DatabaseService) SaveImageDownloads(downloads []models.ImageDownload) (int64, error) {
+	if len(downloads) == 0 {
+		return 0, nil
+	}
+
+	var imageIDs []int64
+	var hashes, contentTypes, paths, downloadedAt []string
+	var sizes []int64
+	for _, download := range downloads {
+		imageIDs = append(imageIDs, download.ProductImageID)
+		hashes = append(hashes, download.File.ContentHash)
+		contentTypes = append(contentTypes, download.File.ContentType)
+		sizes = append(sizes, download.File.Bytes)
+		paths = append(paths, download.File.Path)
+		downloadedAt = append(downloadedAt, download.DownloadedAt.Format(time.RFC3339Nano))
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	if _, err := tx.Exec(`
+		INSERT INTO image_files (content_hash, content_type, bytes, path, first_downloaded_at)
+		SELECT DISTINCT ON (content_hash) content_hash, content_type, bytes, path, downloaded_at
+		FROM unnest($1::text[], $2::text[], $3::bigint[], $4::text[], $5::timestamptz[])
+			AS f(content_hash, content_type, bytes, path, downloaded_at)
+		ORDER BY content_hash, downloaded_at
+		ON CONFLICT (content_hash) DO NOTHING
+	`, pq.Array(hashes), pq.Array(contentTypes), pq.Array(sizes), pq.Array(paths), pq.Array(downloadedAt)); err != nil {
+		return 0, fmt.Errorf("failed to save image files: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO product_image_downloads (product_image_id, content_hash, downloaded_at)
+		SELECT i.product_image_id, i.content_hash, i.downloaded_at
+		FROM unnest($1::bigint[], $2::text[], $3::timestamptz[]) AS i(product_image_id, content_hash, downloaded_at)
+		WHERE i.content_hash IS DISTINCT FROM (
+			SELECT d.content_hash FROM product_image_downloads d
+			WHERE d.product_image_id = i.product_image_id
+			ORDER BY d.downloaded_at DESC
+			LIMIT 1
+		)
+	`, pq.Array(imageIDs), pq.Array(hashes), pq.Array(downloadedAt))
+	metrics.DBBatchDuration.Observe(time.Since(start).Seconds(), "product_image_downloads")
+	if err != nil {
+		return 0, fmt.Errorf("failed to save image downloads: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	saved, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count saved image downloads: %w", err)
+	}
+	metrics.RowsSaved.Add(float64(saved), "product_image_downloads")
+	d.logger.Info("Saved %d image downloads with new content", saved)
+	return saved, nil
+}
+
+// GetImageChanges returns the product images whose downloaded content
+// changed since the given time, compared with the previous download of the
+// same position and size, newest first. Retailer selects a single retailer
+// and Limit caps the number of changes when positive.
+func (d *DatabaseService) GetImageChanges(since time.Time, retailer string, limit int) ([]models.ImageChange, error) {
+	query := `
+		WITH downloads AS (
+			SELECT i.retailer, i.product_id, i.position, i.size, i.url, d.content_hash, d.downloaded_at,
+				LAG(i.url) OVER w AS previous_url,
+				LAG(d.content_hash) OVER w AS previous_hash
+			FROM product_image_downloads d
+			JOIN product_images i ON i.id = d.product_image_id
+			WINDOW w AS (PARTITION BY i.retailer, i.product_id, i.position, i.size ORDER BY d.downloaded_at)
+		)
+		SELECT c.retailer, c.product_id, p.product_name, c.position, c.size,
+			c.previous_url, c.previous_hash, c.url, c.content_hash, f.path, c.downloaded_at
+		FROM downloads c
+		JOIN products p ON p.retailer = c.retailer AND p.product_id = c.product_id
+		JOIN image_files f ON f.content_hash = c.content_hash
+		WHERE c.previous_hash IS NOT NULL AND c.previous_hash <> c.content_hash AND c.downloaded_at >= $1
+	`
+	args := []interface{}{since}
+	if retailer != "" {
+		args = append(args, retailer)
+		query += fmt.Sprintf(" AND c.retailer = $%d", len(args))
+	}
+	query += " ORDER BY c.downloaded_at DESC, c.retailer, c.product_id, c.position, c.size"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query image changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []models.ImageChange
+	for rows.Next() {
+		var change models.ImageChange
+		if err := rows.Scan(
+			&change.Retailer,
+			&change.ProductID,
+			&change.ProductName,
+			&change.Position,
+			&change.Size,
+			&change.PreviousURL,
+			&change.PreviousHash,
+			&change.URL,
+			&change.ContentHash,
+			&change.Path,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan image change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read image changes: %w", err)
+	}
+	return changes, nil
+}
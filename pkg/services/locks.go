@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// advisoryLockPrefix namespaces the advisory locks taken by the application.
+const advisoryLockPrefix = "bonpreu:"
+
+// TryAdvisoryLock takes a session-level Postgres advisory lock named key
+// without waiting. The lock is held on a dedicated connection until release
+// is called, so it excludes every other session, including other processes
+// sharing the database. ok is false when the lock is already held.
+func (d *DatabaseService) TryAdvisoryLock(ctx context.Context, key string) (func() error, bool, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get connection for lock %s: %w", key, err)
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", advisoryLockPrefix+key).Scan(&locked)
+	if err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to take lock %s: %w", key, err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	d.logger.Debug("Took advisory lock %s", key)
+	release := func() error {
+		defer conn.Close()
+		// The lock must be released even when the job's context was cancelled
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", advisoryLockPrefix+key); err != nil {
+			return fmt.Errorf("failed to release lock %s: %w", key, err)
+		}
+		return nil
+	}
+	return release, true, nil
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// MatchRetailerProducts links the products of every pair of the given
+// retailers, by GTIN and then by brand, pack size and name, and records the
+// matches in the product_matches table. Accepted and pending matches of a
+// pair are replaced by the new ones; reviewed matches are kept and constrain
+// the matching. It returns the new matches.
+func (d *DatabaseService) MatchRetailerProducts(retailers []string, options models.MatchOptions) ([]models.ProductMatch, error) {
+	start := time.Now()
+
+	retailers = append([]string(nil), retailers...)
+	sort.Strings(retailers)
+
+	products := make(map[string][]models.Product, len(retailers))
+	for _, retailer := range retailers {
+		retailerProducts, err := d.GetMatchableProducts(retailer)
+		if err != nil {
+			return nil, err
+		}
+		products[retailer] = retailerProducts
+	}
+
+	var matches []models.ProductMatch
+	for i, left := range retailers {
+		for _, right := range retailers[i+1:] {
+			reviewed, err := d.GetProductMatches(ProductMatchFilter{
+				LeftRetailer:  left,
+				RightRetailer: right,
+				Statuses:      []string{models.MatchStatusApproved, models.MatchStatusRejected},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			pairMatches := models.MatchProducts(products[left], products[right], reviewed, options)
+			if err := d.replaceProductMatches(left, right, pairMatches); err != nil {
+				return nil, err
+			}
+
+			pending := 0
+			for _, match := range pairMatches {
+				if match.Status == models.MatchStatusPending {
+					pending++
+				}
+			}
+			d.logger.Info("Matched %d %s products with %d %s products: %d matches, %d to review",
+				len(products[left]), left, len(products[right]), right, len(pairMatches), pending)
+			matches = append(matches, pairMatches...)
+		}
+	}
+
+	d.logger.Info("Matched products of %d retailers in %v", len(retailers), time.Since(start))
+	return matches, nil
+}
+
+// GetMatchableProducts returns the fields of a retailer's products that
+// matching uses: name, brand, price, net quantity and GTINs.
+func (d *DatabaseService) GetMatchableProducts(retailer string) ([]models.Product, error) {
+	rows, err := d.db.Query(`
+		SELECT retailer, product_id, product_name, COALESCE(product_brand, ''),
+			COALESCE(product_price_amount, 0), COALESCE(pack_net_quantity, 0),
+			COALESCE(pack_base_unit, ''), product_gtins
+		FROM products
+		WHERE retailer = $1
+		ORDER BY product_id
+	`, retailer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s products: %w", retailer, err)
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductName,
+			&product.ProductBrand,
+			&product.ProductPriceAmount,
+			&product.PackSize.NetQuantity,
+			&product.PackSize.BaseUnit,
+			pq.Array(&product.ProductGTINs),
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s products: %w", retailer, err)
+	}
+	return products, nil
+}
+
+// replaceProductMatches replaces the unreviewed matches between two
+// retailers with the given ones, within a transaction.
+func (d *DatabaseService) replaceProductMatches(left, right string, matches []models.ProductMatch) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM product_matches
+		WHERE left_retailer = $1 AND right_retailer = $2 AND status IN ('accepted', 'pending')
+	`, left, right); err != nil {
+		return fmt.Errorf("failed to delete product matches: %w", err)
+	}
+
+	// Product matches have 7 parameters per record
+	maxMatchesPerBatch := 60000 / 7
+
+	for i := 0; i < len(matches); i += maxMatchesPerBatch {
+		end := i + maxMatchesPerBatch
+		if end > len(matches) {
+			end = len(matches)
+		}
+
+		batch := matches[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*7)
+		argIndex := 1
+
+		for _, match := range batch {
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6))
+			args = append(args,
+				match.LeftRetailer,
+				match.LeftProductID,
+				match.RightRetailer,
+				match.RightProductID,
+				match.Method,
+				match.Score,
+				match.Status,
+			)
+			argIndex += 7
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_matches (
+				left_retailer, left_product_id, right_retailer, right_product_id, method, score, status
+			) VALUES %s
+			ON CONFLICT (left_retailer, left_product_id, right_retailer, right_product_id) DO NOTHING
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_matches")
+		if err != nil {
+			return fmt.Errorf("failed to save product matches batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	metrics.RowsSaved.Add(float64(len(matches)), "product_matches")
+	return nil
+}
+
+// ProductMatchFilter selects product matches. Empty fields match everything.
+type ProductMatchFilter struct {
+	LeftRetailer  string
+	RightRetailer string
+	Statuses      []string
+	Limit         int
+}
+
+// GetProductMatches returns the product matches selected by the filter, with
+// the names and current prices of both products, best score first.
+func (d *DatabaseService) GetProductMatches(filter ProductMatchFilter) ([]models.ProductMatch, error) {
+	limit := sql.NullInt64{Int64: int64(filter.Limit), Valid: filter.Limit > 0}
+	rows, err := d.db.Query(`
+		SELECT m.id, m.left_retailer, m.left_product_id, m.right_retailer, m.right_product_id,
+			m.method, m.score, m.status, l.product_name, r.product_name,
+			COALESCE(l.product_price_amount, 0), COALESCE(r.product_price_amount, 0),
+			m.matched_at, m.reviewed_at
+		FROM product_matches m
+		JOIN products l ON l.retailer = m.left_retailer AND l.product_id = m.left_product_id
+		JOIN products r ON r.retailer = m.right_retailer AND r.product_id = m.right_product_id
+		WHERE ($1 = '' OR m.left_retailer = $1)
+			AND ($2 = '' OR m.right_retailer = $2)
+			AND (cardinality($3::text[]) = 0 OR m.status = ANY($3))
+		ORDER BY m.score DESC, m.id
+		LIMIT $4
+	`, filter.LeftRetailer, filter.RightRetailer, pq.Array(filter.Statuses), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product matches: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []models.ProductMatch
+	for rows.Next() {
+		var match models.ProductMatch
+		if err := rows.Scan(
+			&match.ID,
+			&match.LeftRetailer,
+			&match.LeftProductID,
+			&match.RightRetailer,
+			&match.RightProductID,
+			&match.Method,
+			&match.Score,
+			&match.Status,
+			&match.LeftProductName,
+			&match.RightProductName,
+			&match.LeftPriceAmount,
+			&match.RightPriceAmount,
+			&match.MatchedAt,
+			&match.ReviewedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan product match: %w", err)
+		}
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read product matches: %w", err)
+	}
+	return matches, nil
+}
+
+// ReviewProductMatch approves or rejects a match. Rejected matches are kept
+// so that later matching runs do not propose them again.
+func (d *DatabaseService) ReviewProductMatch(id int64, approve bool) error {
+	status := models.MatchStatusRejected
+	if approve {
+		status = models.MatchStatusApproved
+	}
+
+	result, err := d.db.Exec(`
+		UPDATE product_matches SET status = $2, reviewed_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to review product match %d: %w", id, err)
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("product match %d not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// SaveNutriScores stores the Nutri-Score of the given products, with the
+// nutrition facts it was computed from and the missing inputs, in the
+// product_nutriscores table, replacing the previous one.
+func (d *DatabaseService) SaveNutriScores(products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Nutri-Scores have 18 parameters per record
+	maxProductsPerBatch := 60000 / 18
+
+	for i := 0; i < len(products); i += maxProductsPerBatch {
+		end := i + maxProductsPerBatch
+		if end > len(products) {
+			end = len(products)
+		}
+
+		batch := products[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*18)
+		argIndex := 1
+
+		for _, product := range batch {
+			placeholders := make([]string, 18)
+			for j := range placeholders {
+				placeholders[j] = fmt.Sprintf("$%d", argIndex+j)
+			}
+			placeholders[4] = fmt.Sprintf("NULLIF($%d, '')", argIndex+4)
+			values = append(values, "("+strings.Join(placeholders, ", ")+")")
+
+			nutriScore := product.NutriScore
+			facts := nutriScore.Facts
+			args = append(args,
+				product.Retailer,
+				product.ProductID,
+				nutriScore.Status,
+				nutriScore.Category,
+				nutriScore.Grade,
+				nutriScore.Score,
+				nutriScore.NegativePoints,
+				nutriScore.PositivePoints,
+				pq.Array(nutriScore.Missing),
+				pq.Array(nutriScore.Assumed),
+				facts.EnergyKJ,
+				facts.Fat,
+				facts.SaturatedFat,
+				facts.Sugars,
+				facts.Fibre,
+				facts.Protein,
+				facts.Salt,
+				product.CreatedAt,
+			)
+			argIndex += 18
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_nutriscores (
+				retailer, product_id, status, category, grade, score, negative_points, positive_points,
+				missing, assumed, energy_kj, fat, saturated_fat, sugars, fibre, protein, salt, computed_at
+			) VALUES %s
+			ON CONFLICT (retailer, product_id) DO UPDATE SET
+				status = EXCLUDED.status,
+				category = EXCLUDED.category,
+				grade = EXCLUDED.grade,
+				score = EXCLUDED.score,
+				negative_points = EXCLUDED.negative_points,
+				positive_points = EXCLUDED.positive_points,
+				missing = EXCLUDED.missing,
+				assumed = EXCLUDED.assumed,
+				energy_kj = EXCLUDED.energy_kj,
+				fat = EXCLUDED.fat,
+				saturated_fat = EXCLUDED.saturated_fat,
+				sugars = EXCLUDED.sugars,
+				fibre = EXCLUDED.fibre,
+				protein = EXCLUDED.protein,
+				salt = EXCLUDED.salt,
+				computed_at = EXCLUDED.computed_at
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_nutriscores")
+		if err != nil {
+			return fmt.Errorf("failed to save Nutri-Score batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	metrics.RowsSaved.Add(float64(len(products)), "product_nutriscores")
+	d.logger.Info("Saved the Nutri-Score of %d products", len(products))
+	return nil
+}
+
+// RecomputeNutriScores computes the Nutri-Score of the stored products again
+// from their latest nutrition table, categories and ingredients, e.g. after
+// the algorithm changed, without crawling. Retailer selects a single
+// retailer when not empty. It returns the number of products scored.
+func (d *DatabaseService) RecomputeNutriScores(retailer string) (int, error) {
+	rows, err := d.db.Query(`
+		SELECT p.retailer, p.product_id, COALESCE(p.product_alcohol, FALSE), COALESCE(p.product_categories, '{}'),
+			COALESCE(pd.ingredients_text, '')
+		FROM products p
+		LEFT JOIN product_details pd ON pd.retailer = p.retailer AND pd.product_id = p.product_id
+		WHERE $1 = '' OR p.retailer = $1
+		ORDER BY p.retailer, p.product_id
+	`, retailer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query products: %w", err)
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	index := make(map[models.ProductKey]int)
+	for rows.Next() {
+		var product models.Product
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductAlcohol,
+			pq.Array(&product.ProductCategories),
+			&product.Details.IngredientsText,
+		); err != nil {
+			return 0, fmt.Errorf("failed to scan product: %w", err)
+		}
+		index[product.Key()] = len(products)
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read products: %w", err)
+	}
+
+	// Only the nutrition table of the latest crawl of each product counts
+	nutritionRows, err := d.db.Query(`
+		SELECT n.retailer, n.product_id, n.product_nutritional_value, n.product_nutritional_quantity
+		FROM product_nutritional_data n
+		WHERE ($1 = '' OR n.retailer = $1)
+			AND n.created_at = (
+				SELECT MAX(latest.created_at) FROM product_nutritional_data latest
+				WHERE latest.retailer = n.retailer AND latest.product_id = n.product_id
+			)
+		ORDER BY n.id
+	`, retailer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query nutritional data: %w", err)
+	}
+	defer nutritionRows.Close()
+
+	nutritionalData := make(map[models.ProductKey][]models.ProductNutritionalData)
+	for nutritionRows.Next() {
+		var data models.ProductNutritionalData
+		if err := nutritionRows.Scan(
+			&data.Retailer,
+			&data.ProductID,
+			&data.ProductNutritionalValue,
+			&data.ProductNutritionalQuantity,
+		); err != nil {
+			return 0, fmt.Errorf("failed to scan nutritional data: %w", err)
+		}
+		key := models.ProductKey{Retailer: data.Retailer, ProductID: data.ProductID}
+		nutritionalData[key] = append(nutritionalData[key], data)
+	}
+	if err := nutritionRows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read nutritional data: %w", err)
+	}
+
+	now := time.Now()
+	for key, i := range index {
+		products[i].CreatedAt = now
+		products[i].NutriScore = models.ComputeNutriScore(products[i], models.ParseNutritionFacts(nutritionalData[key]))
+	}
+
+	if err := d.SaveNutriScores(products); err != nil {
+		return 0, err
+	}
+	return len(products), nil
+}
+
+// NutriScoreFilter selects stored Nutri-Scores. Retailer and ProductID
+// select a single retailer or product, Category matches any level of the
+// category path, and Grades and Statuses keep the given grades and statuses
+// when not empty. Limit caps the number of results when positive.
+type NutriScoreFilter struct {
+	Retailer  string
+	ProductID int
+	Category  string
+	Grades    []string
+	Statuses  []string
+	Limit     int
+}
+
+// GetNutriScores returns the stored Nutri-Scores selected by the filter,
+// best score first and ungraded products last.
+func (d *DatabaseService) GetNutriScores(filter NutriScoreFilter) ([]models.NutriScoreProduct, error) {
+	query := `
+		SELECT n.retailer, n.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			n.status, n.category, COALESCE(n.grade, ''), n.score, n.negative_points, n.positive_points,
+			n.missing, n.assumed, n.energy_kj, n.fat, n.saturated_fat, n.sugars, n.fibre, n.protein, n.salt,
+			n.computed_at
+		FROM product_nutriscores n
+		JOIN products p ON p.retailer = n.retailer AND p.product_id = n.product_id
+		WHERE TRUE
+	`
+	var args []interface{}
+	if filter.Retailer != "" {
+		args = append(args, filter.Retailer)
+		query += fmt.Sprintf(" AND n.retailer = $%d", len(args))
+	}
+	if filter.ProductID != 0 {
+		args = append(args, filter.ProductID)
+		query += fmt.Sprintf(" AND n.product_id = $%d", len(args))
+	}
+	if filter.Category != "" {
+		args = append(args, filter.Category)
+		query += fmt.Sprintf(" AND $%d = ANY(p.product_categories)", len(args))
+	}
+	if len(filter.Grades) > 0 {
+		args = append(args, pq.Array(filter.Grades))
+		query += fmt.Sprintf(" AND n.grade = ANY($%d)", len(args))
+	}
+	if len(filter.Statuses) > 0 {
+		args = append(args, pq.Array(filter.Statuses))
+		query += fmt.Sprintf(" AND n.status = ANY($%d)", len(args))
+	}
+	query += " ORDER BY n.score NULLS LAST, n.retailer, n.product_id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Nutri-Scores: %w", err)
+	}
+	defer rows.Close()
+
+	var products []models.NutriScoreProduct
+	for rows.Next() {
+		var product models.NutriScoreProduct
+		nutriScore := &product.NutriScore
+		facts := &nutriScore.Facts
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductName,
+			&product.ProductBrand,
+			&nutriScore.Status,
+			&nutriScore.Category,
+			&nutriScore.Grade,
+			&nutriScore.Score,
+			&nutriScore.NegativePoints,
+			&nutriScore.PositivePoints,
+			pq.Array(&nutriScore.Missing),
+			pq.Array(&nutriScore.Assumed),
+			&facts.EnergyKJ,
+			&facts.Fat,
+			&facts.SaturatedFat,
+			&facts.Sugars,
+			&facts.Fibre,
+			&facts.Protein,
+			&facts.Salt,
+			&product.ComputedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan Nutri-Score: %w", err)
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Nutri-Scores: %w", err)
+	}
+	return products, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+)
+
+// SaveObservations records a snapshot of every product in the product_observations
+// table, together with all of its promotions in product_promotions and the
+// versions of its bopData fields and image URLs that changed in product_attributes
+// and product_images. The effective price with the cheapest active promotion
+// applied is stored with each observation. Unlike the products table, which only
+// holds the latest state, observations are kept over time so that changes can be
+// tracked; an observation saved again for the same time replaces every column of
+// its snapshot. The operation is performed within a transaction for data consistency.
+func (d *DatabaseService) SaveObservations(products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	d.logger.Info("Saving %d product observations to database...", len(products))
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Observations have 18 parameters per record
+	maxObservationsPerBatch := 60000 / 18
+	observationIDs := make(map[models.ProductKey]int64, len(products))
+
+	for i := 0; i < len(products); i += maxObservationsPerBatch {
+		end := i + maxObservationsPerBatch
+		if end > len(products) {
+			end = len(products)
+		}
+
+		batch := products[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*18)
+		argIndex := 1
+
+		for _, product := range batch {
+			effective := models.CalculateEffectivePrice(product)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, NULLIF($%d::numeric, 0), NULLIF($%d, ''), NULLIF($%d, ''), $%d, "+
+				"NULLIF($%d::numeric, 0), NULLIF($%d, ''), NULLIF($%d, ''))",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6, argIndex+7, argIndex+8,
+				argIndex+9, argIndex+10, argIndex+11, argIndex+12, argIndex+13, argIndex+14, argIndex+15, argIndex+16, argIndex+17))
+
+			args = append(args,
+				product.Retailer,
+				product.ProductID,
+				product.CreatedAt,
+				product.ProductPriceAmount,
+				product.ProductCurrency,
+				product.ProductUnitPriceAmount,
+				product.ProductUnitPriceUnit,
+				product.ProductPackSizeDescription,
+				product.ProductAvailable,
+				product.PromotionType,
+				effective.UnitPrice,
+				effective.PricePerUnit,
+				effective.BaseUnit,
+				effective.Mechanic,
+				effective.RequiredQuantity,
+				product.PackSize.NetQuantity,
+				product.PackSize.BaseUnit,
+				product.PackSize.Confidence,
+			)
+			argIndex += 18
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_observations (
+				retailer, product_id, observed_at, product_price_amount, product_currency,
+				product_unit_price_amount, product_unit_price_unit,
+				product_pack_size_description, product_available, promotion_type,
+				effective_unit_price, effective_price_per_unit, effective_price_base_unit,
+				effective_mechanic, effective_required_quantity,
+				pack_net_quantity, pack_base_unit, pack_size_confidence
+			) VALUES %s
+			ON CONFLICT (retailer, product_id, observed_at) DO UPDATE SET
+				product_price_amount = EXCLUDED.product_price_amount,
+				product_currency = EXCLUDED.product_currency,
+				product_unit_price_amount = EXCLUDED.product_unit_price_amount,
+				product_unit_price_unit = EXCLUDED.product_unit_price_unit,
+				product_pack_size_description = EXCLUDED.product_pack_size_description,
+				product_available = EXCLUDED.product_available,
+				promotion_type = EXCLUDED.promotion_type,
+				effective_unit_price = EXCLUDED.effective_unit_price,
+				effective_price_per_unit = EXCLUDED.effective_price_per_unit,
+				effective_price_base_unit = EXCLUDED.effective_price_base_unit,
+				effective_mechanic = EXCLUDED.effective_mechanic,
+				effective_required_quantity = EXCLUDED.effective_required_quantity,
+				pack_net_quantity = EXCLUDED.pack_net_quantity,
+				pack_base_unit = EXCLUDED.pack_base_unit,
+				pack_size_confidence = EXCLUDED.pack_size_confidence
+			RETURNING id, retailer, product_id
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to bulk insert observations batch %d-%d: %w", i+1, end, err)
+		}
+		for rows.Next() {
+			var observationID int64
+			var key models.ProductKey
+			if err := rows.Scan(&observationID, &key.Retailer, &key.ProductID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan observation ID: %w", err)
+			}
+			observationIDs[key] = observationID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read observation IDs: %w", err)
+		}
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_observations")
+	}
+
+	var promotions []models.Promotion
+	for _, product := range products {
+		for _, promotion := range product.Promotions {
+			promotion.Retailer = product.Retailer
+			promotion.ObservationID = observationIDs[product.Key()]
+			promotions = append(promotions, promotion)
+		}
+	}
+
+	if err := savePromotions(tx, promotions); err != nil {
+		return err
+	}
+
+	attributeVersions, err := saveProductAttributes(tx, products, observationIDs)
+	if err != nil {
+		return err
+	}
+
+	imageVersions, err := saveProductImages(tx, products, observationIDs)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	metrics.RowsSaved.Add(float64(len(products)), "product_observations")
+	metrics.RowsSaved.Add(float64(len(promotions)), "product_promotions")
+	d.logger.Info("Successfully saved %d observations, %d promotions, %d new attribute versions and %d new image URLs in %v",
+		len(products), len(promotions), attributeVersions, imageVersions, time.Since(start))
+	return nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// GetOpenFoodFactsProducts returns the stored products with the fields that
+// the Open Food Facts export uses: name, brand, pack size, GTINs,
+// categories, label details and allergens, the nutrition facts of their
+// Nutri-Score and their current main image. Retailer selects a single
+// retailer when not empty.
+func (d *DatabaseService) GetOpenFoodFactsProducts(retailer string) ([]models.Product, error) {
+	rows, err := d.db.Query(`
+		SELECT p.retailer, p.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			COALESCE(p.product_pack_size_description, ''), COALESCE(p.pack_net_quantity, 0),
+			COALESCE(p.pack_base_unit, ''), p.product_gtins, COALESCE(p.product_categories, '{}'),
+			COALESCE(pd.ingredients_text, ''), COALESCE(pd.origin, ''),
+			COALESCE((
+				SELECT array_agg(a.allergen ORDER BY a.allergen) FROM product_allergens a
+				WHERE a.retailer = p.retailer AND a.product_id = p.product_id AND a.presence = 'contains'
+			), '{}'),
+			COALESCE((
+				SELECT array_agg(a.allergen ORDER BY a.allergen) FROM product_allergens a
+				WHERE a.retailer = p.retailer AND a.product_id = p.product_id AND a.presence = 'may_contain'
+			), '{}'),
+			COALESCE(n.status, ''), COALESCE(n.grade, ''), n.energy_kj, n.fat, n.saturated_fat, n.sugars,
+			n.fibre, n.protein, n.salt, COALESCE(i.size, ''), COALESCE(i.url, '')
+		FROM products p
+		LEFT JOIN product_details pd ON pd.retailer = p.retailer AND pd.product_id = p.product_id
+		LEFT JOIN product_nutriscores n ON n.retailer = p.retailer AND n.product_id = p.product_id
+		LEFT JOIN LATERAL (
+			SELECT size, url FROM product_images
+			WHERE retailer = p.retailer AND product_id = p.product_id AND is_current AND position = 0
+			ORDER BY size = 'original' DESC, id
+			LIMIT 1
+		) i ON TRUE
+		WHERE $1 = '' OR p.retailer = $1
+		ORDER BY p.retailer, p.product_id
+	`, retailer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query products: %w", err)
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		var contains, mayContain []string
+		var image models.ProductImage
+		facts := &product.NutriScore.Facts
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductName,
+			&product.ProductBrand,
+			&product.ProductPackSizeDescription,
+			&product.PackSize.NetQuantity,
+			&product.PackSize.BaseUnit,
+			pq.Array(&product.ProductGTINs),
+			pq.Array(&product.ProductCategories),
+			&product.Details.IngredientsText,
+			&product.Details.Origin,
+			pq.Array(&contains),
+			pq.Array(&mayContain),
+			&product.NutriScore.Status,
+			&product.NutriScore.Grade,
+			&facts.EnergyKJ,
+			&facts.Fat,
+			&facts.SaturatedFat,
+			&facts.Sugars,
+			&facts.Fibre,
+			&facts.Protein,
+			&facts.Salt,
+			&image.Size,
+			&image.URL,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+
+		if len(contains)+len(mayContain) > 0 {
+			product.Details.Allergens = make(map[string]string)
+			for _, allergen := range contains {
+				product.Details.Allergens[allergen] = models.AllergenContains
+			}
+			for _, allergen := range mayContain {
+				product.Details.Allergens[allergen] = models.AllergenMayContain
+			}
+		}
+		if image.URL != "" {
+			image.Retailer, image.ProductID = product.Retailer, product.ProductID
+			product.Images = []models.ProductImage{image}
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read products: %w", err)
+	}
+	return products, nil
+}
@@ -0,0 +1,28 @@
+package services_test
+
+import (
+	"testing"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/services"
+	"bonpreu-go/pkg/testdb"
+)
+
+// newTestDatabase returns a DatabaseService on a fresh schema of the test
+// database; see testdb.URL.
+func newTestDatabase(t *testing.T) *services.DatabaseService {
+	t.Helper()
+	databaseURL := testdb.URL(t)
+
+	cfg, err := config.ProfileConfig(config.ProfileTesting)
+	if err != nil {
+		t.Fatalf("ProfileConfig: %v", err)
+	}
+	cfg.Database.URL = databaseURL
+	dbService, err := services.NewDatabaseService(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabaseService: %v", err)
+	}
+	t.Cleanup(func() { dbService.Close() })
+	return dbService
+}
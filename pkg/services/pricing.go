@@ -0,0 +1,91 @@
+package services
+
+import (
+	"fmt"
+
+	"bonpreu-go/pkg/models"
+)
+
+// RankingOptions filters the effective price ranking. Category matches any
+// level of the product's category path, BaseUnit is "kg", "l" or "unit" and
+// Retailer is one of the models.Retailer* values; empty values match
+// everything. Limit caps the number of results when positive.
+type RankingOptions struct {
+	Retailer string
+	Category string
+	BaseUnit string
+	Limit    int
+}
+
+// RankProductsByEffectivePrice returns available products ordered by the
+// effective price per kg, litre or unit of their latest observation, so that
+// promotions such as "3x2" are taken into account. Products are grouped by
+// base unit, since prices per kg and per litre cannot be compared.
+func (d *DatabaseService) RankProductsByEffectivePrice(opts RankingOptions) ([]models.RankedProduct, error) {
+	query := `
+		WITH latest AS (
+			SELECT DISTINCT ON (retailer, product_id) *
+			FROM product_observations
+			ORDER BY retailer, product_id, observed_at DESC
+		)
+		SELECT latest.retailer, latest.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			COALESCE(latest.product_price_amount, 0), latest.effective_unit_price,
+			latest.effective_price_per_unit, latest.effective_price_base_unit,
+			COALESCE(latest.effective_mechanic, ''), COALESCE(latest.effective_required_quantity, 1),
+			latest.observed_at
+		FROM latest
+		JOIN products p ON p.retailer = latest.retailer AND p.product_id = latest.product_id
+		WHERE latest.product_available
+			AND latest.effective_price_per_unit IS NOT NULL
+	`
+	var args []interface{}
+	if opts.Retailer != "" {
+		args = append(args, opts.Retailer)
+		query += fmt.Sprintf(" AND latest.retailer = $%d", len(args))
+	}
+	if opts.Category != "" {
+		args = append(args, opts.Category)
+		query += fmt.Sprintf(" AND $%d = ANY(p.product_categories)", len(args))
+	}
+	if opts.BaseUnit != "" {
+		args = append(args, opts.BaseUnit)
+		query += fmt.Sprintf(" AND latest.effective_price_base_unit = $%d", len(args))
+	}
+	query += " ORDER BY latest.effective_price_base_unit, latest.effective_price_per_unit, latest.effective_unit_price"
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query effective price ranking: %w", err)
+	}
+	defer rows.Close()
+
+	var ranking []models.RankedProduct
+	for rows.Next() {
+		var product models.RankedProduct
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductName,
+			&product.ProductBrand,
+			&product.ProductPriceAmount,
+			&product.EffectiveUnitPrice,
+			&product.PricePerUnit,
+			&product.BaseUnit,
+			&product.Mechanic,
+			&product.RequiredQuantity,
+			&product.ObservedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan ranked product: %w", err)
+		}
+		ranking = append(ranking, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read effective price ranking: %w", err)
+	}
+
+	return ranking, nil
+}
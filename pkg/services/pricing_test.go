@@ -0,0 +1,88 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+func TestRankProductsByEffectivePrice(t *testing.T) {
+	dbService := newTestDatabase(t)
+
+	observedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	product := func(retailer string, id int, price float64, unit string, categories ...string) models.Product {
+		return models.Product{
+			Retailer:               retailer,
+			ProductID:              id,
+			ProductName:            "Product",
+			ProductPriceAmount:     price,
+			ProductCurrency:        "EUR",
+			ProductUnitPriceAmount: price,
+			ProductUnitPriceUnit:   unit,
+			ProductAvailable:       true,
+			ProductCategories:      categories,
+			CreatedAt:              observedAt,
+		}
+	}
+	milk := product(models.RetailerBonpreu, 1, 1.00, "fop.price.per.litre", "Làctics")
+	juice := product(models.RetailerBonpreu, 2, 1.20, "fop.price.per.litre", "Begudes")
+	water := product(models.RetailerEsclat, 3, 0.30, "fop.price.per.litre", "Begudes")
+	water.ProductAvailable = false
+	apples := product(models.RetailerEsclat, 4, 2.00, "fop.price.per.kg", "Fruita")
+
+	products := []models.Product{milk, juice, water, apples}
+	if err := dbService.SaveProducts(products); err != nil {
+		t.Fatalf("SaveProducts: %v", err)
+	}
+	if err := dbService.SaveObservations(products); err != nil {
+		t.Fatalf("SaveObservations: %v", err)
+	}
+
+	// Saving the same observation again with a promotion updates its effective price
+	juice.Promotions = []models.Promotion{{ProductID: 2, Type: "MULTI_BUY", Description: "3x2", RequiredQuantity: 3}}
+	if err := dbService.SaveObservations([]models.Product{juice}); err != nil {
+		t.Fatalf("SaveObservations: %v", err)
+	}
+
+	ranking, err := dbService.RankProductsByEffectivePrice(services.RankingOptions{})
+	if err != nil {
+		t.Fatalf("RankProductsByEffectivePrice: %v", err)
+	}
+	want := []struct {
+		productID    int
+		baseUnit     string
+		pricePerUnit float64
+		mechanic     string
+	}{
+		{4, "kg", 2.00, ""},
+		{2, "l", 0.80, models.MechanicMultiBuy},
+		{1, "l", 1.00, ""},
+	}
+	if len(ranking) != len(want) {
+		t.Fatalf("got %d ranked products, want %d: %+v", len(ranking), len(want), ranking)
+	}
+	for i, w := range want {
+		got := ranking[i]
+		if got.ProductID != w.productID || got.BaseUnit != w.baseUnit || got.PricePerUnit != w.pricePerUnit || got.Mechanic != w.mechanic {
+			t.Errorf("rank %d = %+v, want product %d at %.2f/%s (%q)", i, got, w.productID, w.pricePerUnit, w.baseUnit, w.mechanic)
+		}
+	}
+	if ranking[1].RequiredQuantity != 3 || ranking[1].EffectiveUnitPrice != 0.80 {
+		t.Errorf("3x2 promotion = %+v, want 3 units at 0.80", ranking[1])
+	}
+
+	filtered, err := dbService.RankProductsByEffectivePrice(services.RankingOptions{
+		Retailer: models.RetailerBonpreu,
+		Category: "Begudes",
+		BaseUnit: "l",
+		Limit:    5,
+	})
+	if err != nil {
+		t.Fatalf("RankProductsByEffectivePrice: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].ProductID != 2 {
+		t.Errorf("filtered ranking = %+v, want product 2 only", filtered)
+	}
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// SaveProductDetails replaces the ingredients, allergens, storage conditions,
+// origin and manufacturer of the given products in the product_details and
+// product_allergens tables. Products whose details disappeared from the API
+// lose their stored details. The operation is performed within a transaction.
+func (d *DatabaseService) SaveProductDetails(products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	retailers := make([]string, 0, len(products))
+	productIDs := make([]int, 0, len(products))
+	var withDetails []models.Product
+	var allergens int
+	for _, product := range products {
+		retailers = append(retailers, product.Retailer)
+		productIDs = append(productIDs, product.ProductID)
+		if hasDetails(product.Details) {
+			withDetails = append(withDetails, product)
+			allergens += len(product.Details.Allergens)
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Allergens go with their details through ON DELETE CASCADE
+	if _, err := tx.Exec(`
+		DELETE FROM product_details
+		WHERE (retailer, product_id) IN (SELECT * FROM unnest($1::text[], $2::integer[]))
+	`, pq.Array(retailers), pq.Array(productIDs)); err != nil {
+		return fmt.Errorf("failed to delete product details: %w", err)
+	}
+
+	// Product details have 8 parameters per record
+	maxProductsPerBatch := 60000 / 8
+
+	for i := 0; i < len(withDetails); i += maxProductsPerBatch {
+		end := i + maxProductsPerBatch
+		if end > len(withDetails) {
+			end = len(withDetails)
+		}
+
+		batch := withDetails[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*8)
+		argIndex := 1
+
+		for _, product := range batch {
+			values = append(values, fmt.Sprintf("($%d, $%d, NULLIF($%d, ''), $%d, NULLIF($%d, ''), NULLIF($%d, ''), NULLIF($%d, ''), NULLIF($%d, ''))",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6, argIndex+7))
+			args = append(args,
+				product.Retailer,
+				product.ProductID,
+				product.Details.IngredientsText,
+				pq.Array(product.Details.Ingredients),
+				product.Details.AllergensText,
+				product.Details.StorageConditions,
+				product.Details.Origin,
+				product.Details.Manufacturer,
+			)
+			argIndex += 8
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_details (
+				retailer, product_id, ingredients_text, ingredients, allergens_text,
+				storage_conditions, origin, manufacturer
+			) VALUES %s
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_details")
+		if err != nil {
+			return fmt.Errorf("failed to save product details batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	if err := saveProductAllergens(tx, withDetails); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	metrics.RowsSaved.Add(float64(len(withDetails)), "product_details")
+	metrics.RowsSaved.Add(float64(allergens), "product_allergens")
+	d.logger.Info("Saved details of %d products with %d allergen declarations", len(withDetails), allergens)
+	return nil
+}
+
+// hasDetails reports whether any detail of a product is known.
+func hasDetails(details models.ProductDetails) bool {
+	return details.IngredientsText != "" || details.AllergensText != "" || details.StorageConditions != "" ||
+		details.Origin != "" || details.Manufacturer != ""
+}
+
+// saveProductAllergens inserts the allergen flags of the products, in the
+// order of models.Allergens.
+func saveProductAllergens(tx *sql.Tx, products []models.Product) error {
+	var retailers, allergens, presences []string
+	var productIDs []int
+	for _, product := range products {
+		for _, allergen := range models.Allergens {
+			if presence, ok := product.Details.Allergens[allergen]; ok {
+				retailers = append(retailers, product.Retailer)
+				productIDs = append(productIDs, product.ProductID)
+				allergens = append(allergens, allergen)
+				presences = append(presences, presence)
+			}
+		}
+	}
+	if len(allergens) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO product_allergens (retailer, product_id, allergen, presence)
+		SELECT * FROM unnest($1::text[], $2::integer[], $3::text[], $4::text[])
+	`, pq.Array(retailers), pq.Array(productIDs), pq.Array(allergens), pq.Array(presences))
+	if err != nil {
+		return fmt.Errorf("failed to save product allergens: %w", err)
+	}
+	return nil
+}
+
+// AllergenFilter selects products by the allergens they declare. FreeFrom
+// keeps products that neither contain nor may contain any of the listed
+// allergens, Contains those that contain all of them. Only products with an
+// ingredient list or allergen statement are considered, since the absence
+// of an allergen means nothing otherwise. Retailer and Category, which
+// matches any level of the category path, are optional; Limit caps the
+// number of results when positive.
+type AllergenFilter struct {
+	Retailer string
+	Category string
+	FreeFrom []string
+	Contains []string
+	Limit    int
+}
+
+// GetProductsByAllergens returns the products selected by the filter with
+// their declared allergens, by name.
+func (d *DatabaseService) GetProductsByAllergens(filter AllergenFilter) ([]models.AllergenProduct, error) {
+	query := `
+		SELECT p.retailer, p.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			COALESCE(array_agg(a.allergen ORDER BY a.allergen) FILTER (WHERE a.presence = 'contains'), '{}'),
+			COALESCE(array_agg(a.allergen ORDER BY a.allergen) FILTER (WHERE a.presence = 'may_contain'), '{}')
+		FROM product_details pd
+		JOIN products p ON p.retailer = pd.retailer AND p.product_id = pd.product_id
+		LEFT JOIN product_allergens a ON a.retailer = pd.retailer AND a.product_id = pd.product_id
+		WHERE (pd.ingredients_text IS NOT NULL OR pd.allergens_text IS NOT NULL)
+	`
+	var args []interface{}
+	if filter.Retailer != "" {
+		args = append(args, filter.Retailer)
+		query += fmt.Sprintf(" AND p.retailer = $%d", len(args))
+	}
+	if filter.Category != "" {
+		args = append(args, filter.Category)
+		query += fmt.Sprintf(" AND $%d = ANY(p.product_categories)", len(args))
+	}
+	if len(filter.FreeFrom) > 0 {
+		args = append(args, pq.Array(filter.FreeFrom))
+		query += fmt.Sprintf(` AND NOT EXISTS (
+			SELECT 1 FROM product_allergens f
+			WHERE f.retailer = pd.retailer AND f.product_id = pd.product_id AND f.allergen = ANY($%d)
+		)`, len(args))
+	}
+	query += " GROUP BY p.retailer, p.product_id, p.product_name, p.product_brand"
+	if len(filter.Contains) > 0 {
+		args = append(args, pq.Array(filter.Contains))
+		query += fmt.Sprintf(" HAVING COUNT(DISTINCT a.allergen) FILTER (WHERE a.presence = 'contains' AND a.allergen = ANY($%d)) = %d",
+			len(args), len(filter.Contains))
+	}
+	query += " ORDER BY p.product_name, p.retailer, p.product_id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query products by allergens: %w", err)
+	}
+	defer rows.Close()
+
+	var products []models.AllergenProduct
+	for rows.Next() {
+		var product models.AllergenProduct
+		if err := rows.Scan(
+			&product.Retailer,
+			&product.ProductID,
+			&product.ProductName,
+			&product.ProductBrand,
+			pq.Array(&product.Contains),
+			pq.Array(&product.MayContain),
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read products by allergens: %w", err)
+	}
+	return products, nil
+}
@@ -2,26 +2,44 @@ package services
 
 import (
 	"compress/gzip"
-	"encoding/json"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"bonpreu-go/pkg/metrics"
 	"bonpreu-go/pkg/models"
 	"bonpreu-go/pkg/utils"
+
+	"github.com/andybalholm/brotli"
 )
 
-// ProductService handles asynchronous fetching of product data from the Bonpreu API.
-// It manages concurrent requests with rate limiting and provides progress tracking.
+// DefaultProductAPIBaseURL is the base URL of the Bonpreu product API.
+const DefaultProductAPIBaseURL = "https://www.compraonline.bonpreuesclat.cat"
+
+// DefaultFailureRateThreshold is the share of failed products above which
+// FetchAllProductsData returns an error.
+const DefaultFailureRateThreshold = 0.1
+
+// ProductService handles asynchronous fetching of product data from a retailer's API,
+// Bonpreu by default. It manages concurrent requests with rate limiting and provides
+// progress tracking.
 type ProductService struct {
 	client      *http.Client
+	retailer    Retailer
 	logger      *utils.Logger
 	semaphore   chan struct{}
 	maxWorkers  int
 	rateLimiter *time.Ticker
+
+	failureRateThreshold float64
 }
 
 // ProductResult represents the result of a single product fetch operation.
@@ -32,6 +50,15 @@ type ProductResult struct {
 	NutritionalData []models.ProductNutritionalData
 	Error           error
 	ProductID       int
+	Attempts        int
+}
+
+// FetchReport describes a FetchAllProductsData run. Failures holds the products
+// that could not be fetched, including products that were not found, and Health
+// the product counts of the run.
+type FetchReport struct {
+	Failures []models.ProductFetchFailure
+	Health   models.RunHealth
 }
 
 // ProgressStats tracks the progress of the product fetching operation.
@@ -45,6 +72,17 @@ type ProgressStats struct {
 	StartTime      time.Time
 }
 
+// RunHealth returns the product counts and elapsed time of the fetch.
+func (s *ProgressStats) RunHealth() models.RunHealth {
+	return models.RunHealth{
+		TotalProducts: atomic.LoadInt64(&s.TotalProducts),
+		SuccessCount:  atomic.LoadInt64(&s.SuccessCount),
+		NotFoundCount: atomic.LoadInt64(&s.NotFoundCount),
+		ErrorCount:    atomic.LoadInt64(&s.ErrorCount),
+		Duration:      time.Since(s.StartTime),
+	}
+}
+
 // NewProductService creates a new ProductService instance with the specified number of workers.
 // The service uses a worker pool pattern to manage concurrent HTTP requests efficiently.
 // maxWorkers determines the maximum number of concurrent requests that can be processed.
@@ -57,17 +95,63 @@ func NewProductService(maxWorkers int) *ProductService {
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		retailer:   NewCompraOnlineRetailer(models.RetailerBonpreu, RetailerOptions{}),
 		logger:     utils.NewLogger("ProductService"),
 		semaphore:  make(chan struct{}, maxWorkers),
 		maxWorkers: maxWorkers,
+
+		failureRateThreshold: DefaultFailureRateThreshold,
 	}
 }
 
+// SetRetailer selects the retailer whose products are fetched.
+func (p *ProductService) SetRetailer(retailer Retailer) {
+	p.retailer = retailer
+}
+
+// Retailer returns the retailer whose products are fetched.
+func (p *ProductService) Retailer() Retailer {
+	return p.retailer
+}
+
+// SetBaseURL points the service at the online shop API at baseURL, keeping
+// the current retailer's name and its other options, e.g. to use a local
+// fake server.
+func (p *ProductService) SetBaseURL(baseURL string) {
+	var options RetailerOptions
+	if current, ok := p.retailer.(*CompraOnlineRetailer); ok {
+		options = current.Options()
+	}
+	options.APIBaseURL = baseURL
+	p.retailer = NewCompraOnlineRetailer(p.retailer.Name(), options)
+}
+
+// SetHTTPClient replaces the HTTP client used for product requests.
+func (p *ProductService) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// SetTransport replaces the transport of the HTTP client, e.g. to record or replay cassettes.
+func (p *ProductService) SetTransport(transport http.RoundTripper) {
+	p.client.Transport = transport
+}
+
+// SetFailureRateThreshold sets the share of failed products, between 0 and 1,
+// above which FetchAllProductsData returns a *FetchSummaryError.
+func (p *ProductService) SetFailureRateThreshold(threshold float64) {
+	p.failureRateThreshold = threshold
+}
+
 // FetchAllProductsData asynchronously fetches product data for all provided product IDs.
 // It implements rate limiting when duration > 0, spreading requests over the specified duration.
 // The function returns slices of successfully fetched products and nutritional data,
-// along with any errors that occurred during the process.
-func (p *ProductService) FetchAllProductsData(productIDs []int, duration time.Duration) ([]models.Product, []models.ProductNutritionalData, error) {
+// and a report of the products that failed and the product counts of the run.
+// When the share of failed products exceeds the failure-rate threshold, it also returns
+// a *FetchSummaryError; the successfully fetched data is returned in that case too.
+// When ctx is cancelled, no further products are requested, requests in flight are
+// abandoned without counting as failures and the data fetched so far is returned with
+// an error wrapping ctx.Err().
+func (p *ProductService) FetchAllProductsData(ctx context.Context, productIDs []int, duration time.Duration) ([]models.Product, []models.ProductNutritionalData, FetchReport, error) {
 	start := time.Now()
 
 	// Calculate rate limiting parameters
@@ -86,6 +170,7 @@ func (p *ProductService) FetchAllProductsData(productIDs []int, duration time.Du
 		delayBetweenRequests = 0
 		p.logger.Info("Starting to fetch data for %d products with max %d concurrent workers (no rate limiting)", len(productIDs), p.maxWorkers)
 	}
+	metrics.RateLimiterRate.Set(requestsPerSecond)
 
 	// Initialize progress tracking
 	stats := &ProgressStats{
@@ -118,14 +203,22 @@ func (p *ProductService) FetchAllProductsData(productIDs []int, duration time.Du
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
+			workerLogger := p.logger.With(utils.WorkerIDKey, workerID)
 
 			for productID := range jobChan {
 				// Wait for rate limiter tick (only if rate limiting is enabled)
 				if duration > 0 {
-					<-rateLimiter.C
+					select {
+					case <-ctx.Done():
+					case <-rateLimiter.C:
+					}
+				}
+				if ctx.Err() != nil {
+					return
 				}
 
-				p.fetchSingleProductData(productID, resultChan, stats)
+				workerLogger.With(utils.ProductIDKey, productID).Debug("Fetching product %d", productID)
+				p.fetchSingleProductData(ctx, productID, resultChan, stats)
 			}
 		}(i)
 	}
@@ -148,20 +241,40 @@ func (p *ProductService) FetchAllProductsData(productIDs []int, duration time.Du
 	// Collect results
 	var products []models.Product
 	var nutritionalData []models.ProductNutritionalData
-	var errors []error
+	summary := &FetchSummaryError{
+		Total:      len(productIDs),
+		Threshold:  p.failureRateThreshold,
+		ByCategory: make(map[string]int),
+	}
+	var report FetchReport
 
 	for result := range resultChan {
+		// A request cut short by the cancellation says nothing about the product
+		if result.Error != nil && ctx.Err() != nil && errors.Is(result.Error, ctx.Err()) {
+			continue
+		}
 		atomic.AddInt64(&stats.ProcessedCount, 1)
 
 		if result.Error != nil {
-			if result.Error.Error() == fmt.Sprintf("product %d not found", result.ProductID) {
+			category := ErrorCategory(result.Error)
+			summary.ByCategory[category]++
+			metrics.ProductsFetched.Inc(category)
+			failure := NewProductFetchFailure(result.ProductID, result.Attempts, result.Error, time.Now())
+			failure.Retailer = p.retailer.Name()
+			report.Failures = append(report.Failures, failure)
+
+			if errors.Is(result.Error, ErrProductNotFound) {
 				atomic.AddInt64(&stats.NotFoundCount, 1)
+				summary.NotFound++
 			} else {
 				atomic.AddInt64(&stats.ErrorCount, 1)
-				errors = append(errors, result.Error)
+				summary.Failed++
+				summary.Errors = append(summary.Errors, result.Error)
 			}
 		} else {
 			atomic.AddInt64(&stats.SuccessCount, 1)
+			metrics.ProductsFetched.Inc("success")
+			summary.Succeeded++
 			products = append(products, result.Product)
 			nutritionalData = append(nutritionalData, result.NutritionalData...)
 		}
@@ -169,6 +282,7 @@ func (p *ProductService) FetchAllProductsData(productIDs []int, duration time.Du
 
 	// Wait for progress monitoring to finish
 	<-progressDone
+	report.Health = stats.RunHealth()
 
 	// Print final statistics
 	p.logger.Info("Completed fetching products:")
@@ -176,9 +290,21 @@ func (p *ProductService) FetchAllProductsData(productIDs []int, duration time.Du
 	p.logger.Info("  - Successful: %d", stats.SuccessCount)
 	p.logger.Info("  - Not found (404): %d", stats.NotFoundCount)
 	p.logger.Info("  - Errors: %d", stats.ErrorCount)
+	for category, count := range summary.ByCategory {
+		if category != CategoryNotFound {
+			p.logger.Info("  - %s: %d", category, count)
+		}
+	}
 	p.logger.LogDuration("FetchAllProductsData", start)
 
-	return products, nutritionalData, nil
+	if err := ctx.Err(); err != nil {
+		return products, nutritionalData, report, fmt.Errorf("fetching stopped after %d of %d products: %w",
+			stats.ProcessedCount, len(productIDs), err)
+	}
+	if summary.Failed > 0 && summary.FailureRate() > summary.Threshold {
+		return products, nutritionalData, report, summary
+	}
+	return products, nutritionalData, report, nil
 }
 
 // monitorProgress displays periodic status updates during the fetching process.
@@ -235,64 +361,60 @@ func (p *ProductService) monitorProgress(stats *ProgressStats, done chan bool, p
 }
 
 // fetchSingleProductData fetches detailed product information for a single product ID.
-// It handles HTTP requests, gzip decompression, JSON parsing, and error handling.
-// The result is sent through the resultChan for collection by the main process.
-func (p *ProductService) fetchSingleProductData(productID int, resultChan chan<- ProductResult, stats *ProgressStats) {
+// It handles HTTP requests, response decompression, parsing by the retailer, and error
+// handling. The result is sent through the resultChan for collection by the main process.
+// The request is abandoned when ctx is cancelled.
+func (p *ProductService) fetchSingleProductData(ctx context.Context, productID int, resultChan chan<- ProductResult, stats *ProgressStats) {
 	result := ProductResult{
 		ProductID: productID,
 	}
 
-	// Create request with headers
-	url := fmt.Sprintf("https://www.compraonline.bonpreuesclat.cat/api/webproductpagews/v5/products/bop?retailerProductId=%d", productID)
-
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := p.retailer.NewProductRequest(ctx, productID)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to create request for product %d: %w", productID, err)
 		resultChan <- result
 		return
 	}
 
-	// Set headers
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("Accept-Language", "ca-ES,ca;q=0.9")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15")
-
 	// Make the request
+	result.Attempts = 1
+	requestStart := time.Now()
 	resp, err := p.client.Do(req)
+	metrics.HTTPRequestDuration.Observe(time.Since(requestStart).Seconds(), "product")
 	if err != nil {
+		metrics.HTTPRequests.Inc("product", "error")
 		result.Error = fmt.Errorf("failed to fetch product %d: %w", productID, err)
 		resultChan <- result
 		return
 	}
 	defer resp.Body.Close()
+	metrics.HTTPRequests.Inc("product", strconv.Itoa(resp.StatusCode))
 
 	// Check status code
-	if resp.StatusCode == 404 {
-		result.Error = fmt.Errorf("product %d not found", productID)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		result.Error = &RateLimitedError{
+			ProductID:  productID,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
 		resultChan <- result
 		return
 	} else if resp.StatusCode != 200 {
-		result.Error = fmt.Errorf("failed to fetch product %d, status code: %d", productID, resp.StatusCode)
+		result.Error = &HTTPStatusError{ProductID: productID, StatusCode: resp.StatusCode}
 		resultChan <- result
 		return
 	}
 
 	// Read and decompress response body
-	var reader io.Reader = resp.Body
-
-	// Handle gzip compression
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzipReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			result.Error = fmt.Errorf("failed to create gzip reader for product %d: %w", productID, err)
-			resultChan <- result
-			return
+	reader, err := decodeBody(resp)
+	if err != nil {
+		result.Error = &ParseError{
+			ProductID: productID,
+			Err:       fmt.Errorf("failed to create %s reader: %w", resp.Header.Get("Content-Encoding"), err),
 		}
-		defer gzipReader.Close()
-		reader = gzipReader
+		resultChan <- result
+		return
 	}
+	defer reader.Close()
 
 	body, err := io.ReadAll(reader)
 	if err != nil {
@@ -301,19 +423,59 @@ func (p *ProductService) fetchSingleProductData(productID int, resultChan chan<-
 		return
 	}
 
-	// Parse JSON response
-	var responseJSON map[string]interface{}
-	if err := json.Unmarshal(body, &responseJSON); err != nil {
-		result.Error = fmt.Errorf("failed to parse JSON for product %d: %w", productID, err)
-		resultChan <- result
-		return
+	// Parse the response into the canonical product
+	result.Product, result.NutritionalData, result.Error = p.retailer.ParseProduct(productID, body)
+
+	resultChan <- result
+}
+
+// decodeBody wraps the response body in a decompressing reader matching its
+// Content-Encoding. gzip, deflate and brotli are supported, since those are the
+// encodings advertised in the Accept-Encoding request header.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		return zlib.NewReader(resp.Body)
+	case "br":
+		return io.NopCloser(brotli.NewReader(resp.Body)), nil
+	default:
+		return io.NopCloser(resp.Body), nil
 	}
+}
 
-	// Parse product data using the model structure
-	result.Product = models.ParseProductFromResponse(responseJSON, productID)
-	result.NutritionalData = models.ParseNutritionalDataFromResponse(responseJSON, productID)
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+// It returns zero when the header is absent or malformed.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
 
-	resultChan <- result
+// recordParseWarnings counts responses that parsed into a product with
+// missing or suspicious data, so that upstream format changes show up in metrics.
+func recordParseWarnings(responseJSON map[string]interface{}, product models.Product) {
+	if _, ok := responseJSON["product"].(map[string]interface{}); !ok {
+		metrics.ParseWarnings.Inc("missing_product")
+		return
+	}
+	if product.ProductName == "" {
+		metrics.ParseWarnings.Inc("missing_name")
+	}
+	if product.ProductPriceAmount <= 0 {
+		metrics.ParseWarnings.Inc("missing_price")
+	}
+	if len(product.ProductCategories) == 0 {
+		metrics.ParseWarnings.Inc("missing_categories")
+	}
 }
 
 // FetchSingleProductData fetches data for a single product synchronously.
@@ -321,7 +483,7 @@ func (p *ProductService) fetchSingleProductData(productID int, resultChan chan<-
 func (p *ProductService) FetchSingleProductData(productID int) (models.Product, []models.ProductNutritionalData, error) {
 	resultChan := make(chan ProductResult, 1)
 
-	go p.fetchSingleProductData(productID, resultChan, nil)
+	go p.fetchSingleProductData(context.Background(), productID, resultChan, nil)
 
 	result := <-resultChan
 	return result.Product, result.NutritionalData, result.Error
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+)
+
+// savePromotions bulk inserts promotions within the given transaction.
+// Each promotion must carry the ID of the observation it belongs to.
+func savePromotions(tx *sql.Tx, promotions []models.Promotion) error {
+	// Promotions have 11 parameters per record
+	maxPromotionsPerBatch := 60000 / 11
+
+	for i := 0; i < len(promotions); i += maxPromotionsPerBatch {
+		end := i + maxPromotionsPerBatch
+		if end > len(promotions) {
+			end = len(promotions)
+		}
+
+		batch := promotions[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*11)
+		argIndex := 1
+
+		for _, promotion := range batch {
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, NULLIF($%d::numeric, 0), $%d, $%d)",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6, argIndex+7, argIndex+8, argIndex+9, argIndex+10))
+
+			args = append(args,
+				promotion.ObservationID,
+				promotion.Retailer,
+				promotion.ProductID,
+				promotion.Position,
+				promotion.Type,
+				promotion.Description,
+				promotion.StartDate,
+				promotion.EndDate,
+				promotion.DiscountedPrice,
+				promotion.RequiredQuantity,
+				promotion.ObservedAt,
+			)
+			argIndex += 11
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO product_promotions (
+				observation_id, retailer, product_id, position, promotion_type, description,
+				start_date, end_date, discounted_price, required_quantity, observed_at
+			) VALUES %s
+			ON CONFLICT (observation_id, position) DO NOTHING
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "product_promotions")
+		if err != nil {
+			return fmt.Errorf("failed to bulk insert promotions batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	return nil
+}
+
+// GetActivePromotions returns the promotions that are valid at the given time,
+// taken from the latest observation of each product. Promotions without a start
+// or end date are treated as open-ended, and date-only end dates include the
+// whole day, as in models.Promotion.IsActive.
+func (d *DatabaseService) GetActivePromotions(at time.Time) ([]models.ActivePromotion, error) {
+	rows, err := d.db.Query(`
+		WITH latest AS (
+			SELECT DISTINCT ON (retailer, product_id) id
+			FROM product_observations
+			ORDER BY retailer, product_id, observed_at DESC
+		)
+		SELECT pp.observation_id, pp.retailer, pp.product_id, pp.position,
+			COALESCE(pp.promotion_type, ''), COALESCE(pp.description, ''),
+			pp.start_date, pp.end_date, COALESCE(pp.discounted_price, 0),
+			pp.required_quantity, pp.observed_at,
+			p.product_name, COALESCE(p.product_brand, ''), COALESCE(p.product_price_amount, 0)
+		FROM product_promotions pp
+		JOIN latest ON latest.id = pp.observation_id
+		JOIN products p ON p.retailer = pp.retailer AND p.product_id = pp.product_id
+		WHERE (pp.start_date IS NULL OR pp.start_date <= $1)
+			AND (pp.end_date IS NULL OR pp.end_date >= $1
+				OR ((pp.end_date AT TIME ZONE 'UTC')::time = '00:00' AND pp.end_date + INTERVAL '1 day' > $1))
+		ORDER BY pp.retailer, pp.product_id, pp.position
+	`, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active promotions: %w", err)
+	}
+	defer rows.Close()
+
+	var promotions []models.ActivePromotion
+	for rows.Next() {
+		var promotion models.ActivePromotion
+		var startDate, endDate sql.NullTime
+		if err := rows.Scan(
+			&promotion.ObservationID,
+			&promotion.Retailer,
+			&promotion.ProductID,
+			&promotion.Position,
+			&promotion.Type,
+			&promotion.Description,
+			&startDate,
+			&endDate,
+			&promotion.DiscountedPrice,
+			&promotion.RequiredQuantity,
+			&promotion.ObservedAt,
+			&promotion.ProductName,
+			&promotion.ProductBrand,
+			&promotion.ProductPriceAmount,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan promotion: %w", err)
+		}
+		if startDate.Valid {
+			promotion.StartDate = &startDate.Time
+		}
+		if endDate.Valid {
+			promotion.EndDate = &endDate.Time
+		}
+		promotions = append(promotions, promotion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read promotions: %w", err)
+	}
+
+	return promotions, nil
+}
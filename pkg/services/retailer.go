@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bonpreu-go/pkg/models"
+)
+
+// Retailer is a supermarket whose catalogue can be crawled. It discovers the
+// IDs of its products, builds the request fetching a single product and
+// parses the response into the canonical models.Product. Fetching itself,
+// with rate limiting, is left to ProductService.
+type Retailer interface {
+	// Name returns the retailer's name, one of the models.Retailer* values.
+	Name() string
+
+	// DiscoverProductIDs returns the IDs of every product currently sold.
+	DiscoverProductIDs() ([]int, error)
+
+	// NewProductRequest returns the request fetching a single product,
+	// abandoned when ctx is cancelled.
+	NewProductRequest(ctx context.Context, productID int) (*http.Request, error)
+
+	// ParseProduct parses a product response body, already decompressed.
+	// The returned product and nutritional data carry the retailer's name.
+	ParseProduct(productID int, body []byte) (models.Product, []models.ProductNutritionalData, error)
+}
+
+// RetailerOptions configures a Retailer. SitemapURL lists the retailer's
+// products and APIBaseURL serves them. Bonpreu defaults to its public site
+// when APIBaseURL is empty; Esclat has no default and requires it.
+// Transport, when set, replaces the transport of the retailer's own HTTP
+// client, e.g. to record or replay cassettes.
+type RetailerOptions struct {
+	SitemapURL string
+	APIBaseURL string
+	Transport  http.RoundTripper
+}
+
+// retailerFactories holds the constructor of every supported retailer, keyed
+// by name.
+var retailerFactories = map[string]func(options RetailerOptions) (Retailer, error){
+	models.RetailerBonpreu: func(options RetailerOptions) (Retailer, error) {
+		return NewCompraOnlineRetailer(models.RetailerBonpreu, options), nil
+	},
+	models.RetailerEsclat: func(options RetailerOptions) (Retailer, error) {
+		// Esclat's products must not be requested from the Bonpreu API
+		if options.APIBaseURL == "" {
+			return nil, fmt.Errorf("no API base URL configured for retailer %s", models.RetailerEsclat)
+		}
+		return NewCompraOnlineRetailer(models.RetailerEsclat, options), nil
+	},
+}
+
+// NewRetailer returns the named retailer.
+func NewRetailer(name string, options RetailerOptions) (Retailer, error) {
+	factory, ok := retailerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown retailer %q: must be one of %v", name, models.RetailerNames)
+	}
+	return factory(options)
+}
+
+// CompraOnlineRetailer is a retailer sold through the Bonpreu group's online
+// shop platform. Bonpreu and Esclat both use it: the product IDs are listed
+// in a sitemap and each product is served as JSON by the bop product API.
+type CompraOnlineRetailer struct {
+	name       string
+	sitemapURL string
+	baseURL    string
+	sitemap    *SitemapService
+	options    RetailerOptions
+}
+
+// NewCompraOnlineRetailer returns a retailer of the online shop platform
+// with the given name. An empty APIBaseURL selects DefaultProductAPIBaseURL.
+func NewCompraOnlineRetailer(name string, options RetailerOptions) *CompraOnlineRetailer {
+	baseURL := options.APIBaseURL
+	if baseURL == "" {
+		baseURL = DefaultProductAPIBaseURL
+	}
+
+	sitemap := NewSitemapService()
+	if options.Transport != nil {
+		sitemap.SetTransport(options.Transport)
+	}
+
+	return &CompraOnlineRetailer{
+		name:       name,
+		sitemapURL: options.SitemapURL,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		sitemap:    sitemap,
+		options:    options,
+	}
+}
+
+// Name returns the retailer's name.
+func (r *CompraOnlineRetailer) Name() string {
+	return r.name
+}
+
+// Options returns the options the retailer was created with.
+func (r *CompraOnlineRetailer) Options() RetailerOptions {
+	return r.options
+}
+
+// DiscoverProductIDs returns the product IDs listed in the retailer's sitemap.
+func (r *CompraOnlineRetailer) DiscoverProductIDs() ([]int, error) {
+	if r.sitemapURL == "" {
+		return nil, fmt.Errorf("no sitemap URL configured for retailer %s", r.name)
+	}
+
+	items, err := r.sitemap.FetchProductIds(r.sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	return productIDs, nil
+}
+
+// NewProductRequest returns the bop product API request of a product, with
+// the headers of a regular browser.
+func (r *CompraOnlineRetailer) NewProductRequest(ctx context.Context, productID int) (*http.Request, error) {
+	url := fmt.Sprintf("%s/api/webproductpagews/v5/products/bop?retailerProductId=%d", r.baseURL, productID)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	req.Header.Set("Accept-Language", "ca-ES,ca;q=0.9")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15")
+	return req, nil
+}
+
+// resolveURL turns a URL relative to the online shop, such as an image
+// path, into an absolute URL.
+func (r *CompraOnlineRetailer) resolveURL(ref string) string {
+	base, err := url.Parse(r.baseURL + "/")
+	if err != nil {
+		return ref
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return resolved.String()
+}
+
+// ParseProduct parses a bop product API response.
+func (r *CompraOnlineRetailer) ParseProduct(productID int, body []byte) (models.Product, []models.ProductNutritionalData, error) {
+	var responseJSON map[string]interface{}
+	if err := json.Unmarshal(body, &responseJSON); err != nil {
+		return models.Product{}, nil, &ParseError{ProductID: productID, Err: err}
+	}
+
+	product := models.ParseProductFromResponse(responseJSON, productID)
+	nutritionalData := models.ParseNutritionalDataFromResponse(responseJSON, productID)
+	recordParseWarnings(responseJSON, product)
+
+	product.Retailer = r.name
+	for i := range product.Promotions {
+		product.Promotions[i].Retailer = r.name
+	}
+	for i := range product.Images {
+		product.Images[i].URL = r.resolveURL(product.Images[i].URL)
+	}
+	for i := range nutritionalData {
+		nutritionalData[i].Retailer = r.name
+	}
+	return product, nutritionalData, nil
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+)
+
+// DetectShrinkflation compares successive observations of every product whose
+// pack size description changed since the given time, and records the changes
+// where the net quantity decreased while the shelf price stayed the same or rose
+// in the shrinkflation_reports table. Pack sizes are those stored with the
+// observations. It returns the detected events.
+func (d *DatabaseService) DetectShrinkflation(since time.Time) ([]models.ShrinkflationEvent, error) {
+	start := time.Now()
+
+	changes, err := d.GetPackSizeChanges(since)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []models.ShrinkflationEvent
+	for _, change := range changes {
+		if event, ok := models.DetectShrinkflation(change); ok {
+			event.DetectedAt = start
+			events = append(events, event)
+		}
+	}
+
+	if err := d.SaveShrinkflationEvents(events); err != nil {
+		return nil, err
+	}
+
+	d.logger.Info("Detected %d shrinkflation events among %d pack size changes in %v", len(events), len(changes), time.Since(start))
+	return events, nil
+}
+
+// GetPackSizeChanges returns the pairs of successive observations, the newer one
+// observed at or after since, in which a product's pack size description changed.
+func (d *DatabaseService) GetPackSizeChanges(since time.Time) ([]models.PackSizeChange, error) {
+	rows, err := d.db.Query(`
+		SELECT changes.retailer, changes.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			changes.previous_observed_at, changes.observed_at,
+			changes.previous_description, changes.description,
+			COALESCE(changes.previous_price, 0), COALESCE(changes.price, 0),
+			COALESCE(changes.previous_net_quantity, 0), COALESCE(changes.previous_base_unit, ''),
+			COALESCE(changes.previous_confidence, 'none'),
+			COALESCE(changes.net_quantity, 0), COALESCE(changes.base_unit, ''),
+			COALESCE(changes.confidence, 'none')
+		FROM (
+			SELECT retailer, product_id, observed_at,
+				product_pack_size_description AS description,
+				product_price_amount AS price,
+				pack_net_quantity AS net_quantity,
+				pack_base_unit AS base_unit,
+				pack_size_confidence AS confidence,
+				LAG(observed_at) OVER history AS previous_observed_at,
+				LAG(product_pack_size_description) OVER history AS previous_description,
+				LAG(product_price_amount) OVER history AS previous_price,
+				LAG(pack_net_quantity) OVER history AS previous_net_quantity,
+				LAG(pack_base_unit) OVER history AS previous_base_unit,
+				LAG(pack_size_confidence) OVER history AS previous_confidence
+			FROM product_observations
+			WINDOW history AS (PARTITION BY retailer, product_id ORDER BY observed_at)
+		) changes
+		JOIN products p ON p.retailer = changes.retailer AND p.product_id = changes.product_id
+		WHERE changes.observed_at >= $1
+			AND changes.previous_description IS NOT NULL
+			AND changes.description IS NOT NULL
+			AND changes.description <> changes.previous_description
+		ORDER BY changes.observed_at, changes.retailer, changes.product_id
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pack size changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []models.PackSizeChange
+	for rows.Next() {
+		var change models.PackSizeChange
+		if err := rows.Scan(
+			&change.Retailer,
+			&change.ProductID,
+			&change.ProductName,
+			&change.ProductBrand,
+			&change.OldObservedAt,
+			&change.NewObservedAt,
+			&change.OldDescription,
+			&change.NewDescription,
+			&change.OldPrice,
+			&change.NewPrice,
+			&change.OldPackSize.NetQuantity,
+			&change.OldPackSize.BaseUnit,
+			&change.OldPackSize.Confidence,
+			&change.NewPackSize.NetQuantity,
+			&change.NewPackSize.BaseUnit,
+			&change.NewPackSize.Confidence,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan pack size change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pack size changes: %w", err)
+	}
+
+	return changes, nil
+}
+
+// SaveShrinkflationEvents saves shrinkflation events to the shrinkflation_reports
+// table. Events that were detected before are updated, so detection can be rerun
+// over the same period. The operation is performed within a transaction.
+func (d *DatabaseService) SaveShrinkflationEvents(events []models.ShrinkflationEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Shrinkflation events have 17 parameters per record
+	maxEventsPerBatch := 60000 / 17
+
+	for i := 0; i < len(events); i += maxEventsPerBatch {
+		end := i + maxEventsPerBatch
+		if end > len(events) {
+			end = len(events)
+		}
+
+		batch := events[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*17)
+		argIndex := 1
+
+		for _, event := range batch {
+			placeholders := make([]string, 17)
+			for j := range placeholders {
+				placeholders[j] = fmt.Sprintf("$%d", argIndex+j)
+			}
+			values = append(values, "("+strings.Join(placeholders, ", ")+")")
+
+			args = append(args,
+				event.Retailer,
+				event.ProductID,
+				event.OldObservedAt,
+				event.NewObservedAt,
+				event.OldDescription,
+				event.NewDescription,
+				event.OldNetQuantity,
+				event.NewNetQuantity,
+				event.BaseUnit,
+				event.OldPrice,
+				event.NewPrice,
+				event.OldUnitPrice,
+				event.NewUnitPrice,
+				event.UnitPriceUnit,
+				event.UnitPriceIncrease,
+				event.LowConfidence,
+				event.DetectedAt,
+			)
+			argIndex += 17
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO shrinkflation_reports (
+				retailer, product_id, old_observed_at, new_observed_at,
+				old_pack_size_description, new_pack_size_description,
+				old_net_quantity, new_net_quantity, base_unit,
+				old_price, new_price, old_unit_price, new_unit_price,
+				unit_price_unit, unit_price_increase_percent, low_confidence, detected_at
+			) VALUES %s
+			ON CONFLICT (retailer, product_id, new_observed_at) DO UPDATE SET
+				old_observed_at = EXCLUDED.old_observed_at,
+				old_pack_size_description = EXCLUDED.old_pack_size_description,
+				new_pack_size_description = EXCLUDED.new_pack_size_description,
+				old_net_quantity = EXCLUDED.old_net_quantity,
+				new_net_quantity = EXCLUDED.new_net_quantity,
+				base_unit = EXCLUDED.base_unit,
+				old_price = EXCLUDED.old_price,
+				new_price = EXCLUDED.new_price,
+				old_unit_price = EXCLUDED.old_unit_price,
+				new_unit_price = EXCLUDED.new_unit_price,
+				unit_price_unit = EXCLUDED.unit_price_unit,
+				unit_price_increase_percent = EXCLUDED.unit_price_increase_percent,
+				low_confidence = EXCLUDED.low_confidence,
+				detected_at = EXCLUDED.detected_at
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := tx.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "shrinkflation_reports")
+		if err != nil {
+			return fmt.Errorf("failed to save shrinkflation events batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	metrics.RowsSaved.Add(float64(len(events)), "shrinkflation_reports")
+	return nil
+}
+
+// GetShrinkflationEvents returns the recorded shrinkflation events whose newer
+// observation is at or after since, largest unit price increase first.
+func (d *DatabaseService) GetShrinkflationEvents(since time.Time) ([]models.ShrinkflationEvent, error) {
+	rows, err := d.db.Query(`
+		SELECT r.retailer, r.product_id, p.product_name, COALESCE(p.product_brand, ''),
+			r.old_observed_at, r.new_observed_at,
+			r.old_pack_size_description, r.new_pack_size_description,
+			r.old_net_quantity, r.new_net_quantity, r.base_unit,
+			r.old_price, r.new_price, r.old_unit_price, r.new_unit_price,
+			r.unit_price_unit, r.unit_price_increase_percent, r.low_confidence, r.detected_at
+		FROM shrinkflation_reports r
+		JOIN products p ON p.retailer = r.retailer AND p.product_id = r.product_id
+		WHERE r.new_observed_at >= $1
+		ORDER BY r.unit_price_increase_percent DESC, r.retailer, r.product_id
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shrinkflation events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.ShrinkflationEvent
+	for rows.Next() {
+		var event models.ShrinkflationEvent
+		if err := rows.Scan(
+			&event.Retailer,
+			&event.ProductID,
+			&event.ProductName,
+			&event.ProductBrand,
+			&event.OldObservedAt,
+			&event.NewObservedAt,
+			&event.OldDescription,
+			&event.NewDescription,
+			&event.OldNetQuantity,
+			&event.NewNetQuantity,
+			&event.BaseUnit,
+			&event.OldPrice,
+			&event.NewPrice,
+			&event.OldUnitPrice,
+			&event.NewUnitPrice,
+			&event.UnitPriceUnit,
+			&event.UnitPriceIncrease,
+			&event.LowConfidence,
+			&event.DetectedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan shrinkflation event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read shrinkflation events: %w", err)
+	}
+
+	return events, nil
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"bonpreu-go/pkg/metrics"
 	"bonpreu-go/pkg/models"
 	"bonpreu-go/pkg/utils"
 )
@@ -30,18 +31,27 @@ func NewSitemapService() *SitemapService {
 	}
 }
 
+// SetTransport replaces the transport of the HTTP client, e.g. to record or replay cassettes
+func (s *SitemapService) SetTransport(transport http.RoundTripper) {
+	s.client.Transport = transport
+}
+
 // FetchProductIds fetches product IDs from the sitemap XML
 func (s *SitemapService) FetchProductIds(sitemapURL string) ([]models.ItemIds, error) {
 	start := time.Now()
 	s.logger.Info("Starting to fetch product IDs from sitemap: %s", sitemapURL)
 
 	// Make HTTP request to the sitemap
+	requestStart := time.Now()
 	resp, err := s.client.Get(sitemapURL)
+	metrics.HTTPRequestDuration.Observe(time.Since(requestStart).Seconds(), "sitemap")
 	if err != nil {
+		metrics.HTTPRequests.Inc("sitemap", "error")
 		s.logger.Error("Failed to fetch sitemap: %v", err)
 		return nil, fmt.Errorf("failed to fetch sitemap: %w", err)
 	}
 	defer resp.Body.Close()
+	metrics.HTTPRequests.Inc("sitemap", strconv.Itoa(resp.StatusCode))
 
 	if resp.StatusCode != 200 {
 		s.logger.Error("Failed to fetch URL list, status code: %d", resp.StatusCode)
@@ -56,6 +66,7 @@ func (s *SitemapService) FetchProductIds(sitemapURL string) ([]models.ItemIds, e
 	}
 
 	s.logger.Info("Successfully downloaded sitemap, size: %d bytes", len(body))
+	metrics.SitemapBytes.Set(float64(len(body)))
 
 	// Parse the XML
 	var sitemap models.Sitemap
@@ -65,6 +76,7 @@ func (s *SitemapService) FetchProductIds(sitemapURL string) ([]models.ItemIds, e
 	}
 
 	s.logger.Info("Found %d URLs in sitemap", len(sitemap.URLs))
+	metrics.SitemapURLs.Set(float64(len(sitemap.URLs)))
 
 	var itemIdsToInsert []models.ItemIds
 
@@ -99,6 +111,7 @@ func (s *SitemapService) FetchProductIds(sitemapURL string) ([]models.ItemIds, e
 	}
 
 	s.logger.Info("Successfully extracted %d product IDs", len(itemIdsToInsert))
+	metrics.SitemapProductIDs.Set(float64(len(itemIdsToInsert)))
 	s.logger.LogDuration("FetchProductIds", start)
 
 	return itemIdsToInsert, nil
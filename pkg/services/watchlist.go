@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// AddWatchlistEntry adds an entry to the watchlist and returns its ID.
+func (d *DatabaseService) AddWatchlistEntry(entry models.WatchlistEntry) (int, error) {
+	var eventTypes interface{}
+	if len(entry.EventTypes) > 0 {
+		eventTypes = pq.Array(entry.EventTypes)
+	}
+
+	var id int
+	err := d.db.QueryRow(`
+		INSERT INTO watchlist (retailer, product_id, search_query, category, event_types, note)
+		VALUES (NULLIF($1, ''), NULLIF($2, 0), NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''))
+		RETURNING id
+	`, entry.Retailer, entry.ProductID, entry.SearchQuery, entry.Category, eventTypes, entry.Note).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add watchlist entry: %w", err)
+	}
+	return id, nil
+}
+
+// RemoveWatchlistEntry removes an entry from the watchlist.
+func (d *DatabaseService) RemoveWatchlistEntry(id int) error {
+	result, err := d.db.Exec("DELETE FROM watchlist WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to remove watchlist entry %d: %w", id, err)
+	}
+	if removed, err := result.RowsAffected(); err == nil && removed == 0 {
+		return fmt.Errorf("watchlist entry %d not found", id)
+	}
+	return nil
+}
+
+// GetWatchlist returns every watchlist entry, oldest first.
+func (d *DatabaseService) GetWatchlist() ([]models.WatchlistEntry, error) {
+	rows, err := d.db.Query(`
+		SELECT id, COALESCE(retailer, ''), COALESCE(product_id, 0), COALESCE(search_query, ''), COALESCE(category, ''),
+			COALESCE(event_types, '{}'), COALESCE(note, ''), created_at
+		FROM watchlist
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watchlist: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.WatchlistEntry
+	for rows.Next() {
+		var entry models.WatchlistEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Retailer,
+			&entry.ProductID,
+			&entry.SearchQuery,
+			&entry.Category,
+			pq.Array(&entry.EventTypes),
+			&entry.Note,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan watchlist entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read watchlist: %w", err)
+	}
+
+	return entries, nil
+}
+
+// GetWatchedProductIDs returns the IDs of the retailer's known products selected
+// by some watchlist entry, matched as in WatchlistEntry.Matches.
+func (d *DatabaseService) GetWatchedProductIDs(retailer string) ([]int, error) {
+	rows, err := d.db.Query(`
+		SELECT DISTINCT p.product_id
+		FROM products p
+		JOIN watchlist w ON (w.product_id IS NOT NULL OR w.search_query IS NOT NULL OR w.category IS NOT NULL)
+			AND (w.retailer IS NULL OR w.retailer = p.retailer)
+			AND (w.product_id IS NULL OR w.product_id = p.product_id)
+			AND (w.search_query IS NULL
+				OR strpos(lower(p.product_name), lower(w.search_query)) > 0
+				OR strpos(lower(COALESCE(p.product_brand, '')), lower(w.search_query)) > 0)
+			AND (w.category IS NULL
+				OR EXISTS (SELECT 1 FROM unnest(p.product_categories) c WHERE lower(c) = lower(w.category)))
+		WHERE p.retailer = $1
+		ORDER BY p.product_id
+	`, retailer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watched products: %w", err)
+	}
+	defer rows.Close()
+
+	var productIDs []int
+	for rows.Next() {
+		var productID int
+		if err := rows.Scan(&productID); err != nil {
+			return nil, fmt.Errorf("failed to scan watched product: %w", err)
+		}
+		productIDs = append(productIDs, productID)
+	}
+	return productIDs, rows.Err()
+}
+
+// DetectWatchedEvents compares every watched product with its previous
+// observation and returns the price changes, promotion starts and returns to
+// stock that some watchlist entry subscribes to. It must run after the
+// products' own observations have been saved, e.g. after SaveAllData.
+// Products seen for the first time produce no events.
+func (d *DatabaseService) DetectWatchedEvents(products []models.Product) ([]models.ProductEvent, error) {
+	entries, err := d.GetWatchlist()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	watched := make(map[models.ProductKey][]models.WatchlistEntry)
+	var retailers []string
+	var productIDs []int
+	var observedAt []string
+	for _, product := range products {
+		key := product.Key()
+		for _, entry := range entries {
+			if entry.Matches(product) {
+				watched[key] = append(watched[key], entry)
+			}
+		}
+		if len(watched[key]) > 0 {
+			retailers = append(retailers, product.Retailer)
+			productIDs = append(productIDs, product.ProductID)
+			observedAt = append(observedAt, product.CreatedAt.Format(time.RFC3339Nano))
+		}
+	}
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+
+	previous, err := d.getPreviousStates(retailers, productIDs, observedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []models.ProductEvent
+	for _, product := range products {
+		state, ok := previous[product.Key()]
+		if !ok {
+			continue
+		}
+		for _, event := range models.DiffProduct(state, product) {
+			for _, entry := range watched[product.Key()] {
+				if entry.Wants(event.Type) {
+					event.WatchlistIDs = append(event.WatchlistIDs, entry.ID)
+				}
+			}
+			if len(event.WatchlistIDs) > 0 {
+				events = append(events, event)
+			}
+		}
+	}
+
+	d.logger.Info("Detected %d events for %d watched products", len(events), len(productIDs))
+	return events, nil
+}
+
+// getPreviousStates returns, for each product, the latest observation made
+// before the given time, keyed by retailer and product ID.
+func (d *DatabaseService) getPreviousStates(retailers []string, productIDs []int, observedAt []string) (map[models.ProductKey]models.ProductState, error) {
+	rows, err := d.db.Query(`
+		SELECT c.retailer, c.product_id, prev.product_price_amount, prev.product_currency,
+			prev.product_unit_price_amount, prev.product_unit_price_unit,
+			prev.product_available, prev.promotion_type, prev.observed_at
+		FROM unnest($1::text[], $2::integer[], $3::timestamptz[]) AS c(retailer, product_id, observed_at)
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(o.product_price_amount, 0) AS product_price_amount,
+				COALESCE(o.product_currency, '') AS product_currency,
+				COALESCE(o.product_unit_price_amount, 0) AS product_unit_price_amount,
+				COALESCE(o.product_unit_price_unit, '') AS product_unit_price_unit,
+				COALESCE(o.product_available, false) AS product_available,
+				COALESCE(o.promotion_type, '') AS promotion_type,
+				o.observed_at
+			FROM product_observations o
+			WHERE o.retailer = c.retailer AND o.product_id = c.product_id AND o.observed_at < c.observed_at
+			ORDER BY o.observed_at DESC
+			LIMIT 1
+		) prev
+	`, pq.Array(retailers), pq.Array(productIDs), pq.Array(observedAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query previous observations: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[models.ProductKey]models.ProductState, len(productIDs))
+	for rows.Next() {
+		var key models.ProductKey
+		var state models.ProductState
+		if err := rows.Scan(
+			&key.Retailer,
+			&key.ProductID,
+			&state.ProductPriceAmount,
+			&state.ProductCurrency,
+			&state.ProductUnitPriceAmount,
+			&state.ProductUnitPriceUnit,
+			&state.ProductAvailable,
+			&state.PromotionType,
+			&state.ObservedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan previous observation: %w", err)
+		}
+		states[key] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read previous observations: %w", err)
+	}
+
+	return states, nil
+}
+
+// SaveWebhookDeadLetters records webhook events that could not be delivered.
+func (d *DatabaseService) SaveWebhookDeadLetters(deadLetters []models.WebhookDeadLetter) error {
+	if len(deadLetters) == 0 {
+		return nil
+	}
+
+	// Dead letters have 9 parameters per record
+	maxDeadLettersPerBatch := 60000 / 9
+
+	for i := 0; i < len(deadLetters); i += maxDeadLettersPerBatch {
+		end := i + maxDeadLettersPerBatch
+		if end > len(deadLetters) {
+			end = len(deadLetters)
+		}
+
+		batch := deadLetters[i:end]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*9)
+		argIndex := 1
+
+		for _, deadLetter := range batch {
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d::jsonb, $%d, NULLIF($%d, 0), $%d, $%d)",
+				argIndex, argIndex+1, argIndex+2, argIndex+3, argIndex+4, argIndex+5, argIndex+6, argIndex+7, argIndex+8))
+			args = append(args,
+				deadLetter.EventType,
+				deadLetter.Retailer,
+				deadLetter.ProductID,
+				deadLetter.URL,
+				string(deadLetter.Payload),
+				deadLetter.Attempts,
+				deadLetter.LastStatus,
+				deadLetter.ErrorMessage,
+				deadLetter.FailedAt,
+			)
+			argIndex += 9
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO webhook_dead_letters (
+				event_type, retailer, product_id, url, payload, attempts, last_status, error_message, failed_at
+			) VALUES %s
+		`, strings.Join(values, ","))
+
+		batchStart := time.Now()
+		_, err := d.db.Exec(query, args...)
+		metrics.DBBatchDuration.Observe(time.Since(batchStart).Seconds(), "webhook_dead_letters")
+		if err != nil {
+			return fmt.Errorf("failed to save webhook dead letters batch %d-%d: %w", i+1, end, err)
+		}
+	}
+
+	metrics.RowsSaved.Add(float64(len(deadLetters)), "webhook_dead_letters")
+	return nil
+}
+
+// GetWebhookDeadLetters returns the undelivered webhook events since the given
+// time, most recent first.
+func (d *DatabaseService) GetWebhookDeadLetters(since time.Time) ([]models.WebhookDeadLetter, error) {
+	rows, err := d.db.Query(`
+		SELECT id, event_type, retailer, product_id, url, payload, attempts,
+			COALESCE(last_status, 0), COALESCE(error_message, ''), failed_at
+		FROM webhook_dead_letters
+		WHERE failed_at >= $1
+		ORDER BY failed_at DESC, id DESC
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var deadLetters []models.WebhookDeadLetter
+	for rows.Next() {
+		var deadLetter models.WebhookDeadLetter
+		var payload []byte
+		if err := rows.Scan(
+			&deadLetter.ID,
+			&deadLetter.EventType,
+			&deadLetter.Retailer,
+			&deadLetter.ProductID,
+			&deadLetter.URL,
+			&payload,
+			&deadLetter.Attempts,
+			&deadLetter.LastStatus,
+			&deadLetter.ErrorMessage,
+			&deadLetter.FailedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook dead letter: %w", err)
+		}
+		deadLetter.Payload = payload
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook dead letters: %w", err)
+	}
+
+	return deadLetters, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/metrics"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/utils"
+)
+
+// maxRetryWait caps how long a single webhook retry waits.
+const maxRetryWait = 30 * time.Second
+
+// Headers set on webhook requests.
+const (
+	WebhookSignatureHeader = "X-Bonpreu-Signature"
+	WebhookTimestampHeader = "X-Bonpreu-Timestamp"
+	WebhookEventHeader     = "X-Bonpreu-Event"
+	WebhookDeliveryHeader  = "X-Bonpreu-Delivery"
+)
+
+// WebhookService delivers product events to a webhook as signed JSON payloads.
+// The timestamp header holds the Unix time at which the request was sent, and
+// the signature header "sha256=" followed by the hex-encoded HMAC-SHA256 of
+// the timestamp, a dot and the request body, keyed with the shared secret.
+// Receivers should reject requests whose timestamp is more than a few
+// minutes old, so that captured requests cannot be replayed.
+type WebhookService struct {
+	url        string
+	secret     []byte
+	client     *http.Client
+	maxRetries int
+	retryDelay time.Duration
+	logger     *utils.Logger
+}
+
+// NewWebhookService creates a new WebhookService from the webhook configuration.
+func NewWebhookService(cfg config.WebhookConfig) *WebhookService {
+	return &WebhookService{
+		url:        cfg.URL,
+		secret:     []byte(cfg.Secret),
+		client:     &http.Client{Timeout: cfg.Timeout},
+		maxRetries: cfg.MaxRetries,
+		retryDelay: cfg.RetryDelay,
+		logger:     utils.NewLogger("WebhookService"),
+	}
+}
+
+// Sign returns the signature header value of a payload sent with the given
+// timestamp header value.
+func (w *WebhookService) Sign(timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverAll sends every event to the webhook and returns the events that
+// could not be delivered after all retries, ready for the dead-letter log.
+// Once an event fails for a reason that retrying did not fix, such as an
+// unreachable endpoint or repeated 5xx responses, the remaining events go to
+// the dead-letter log without being sent, so that a dead endpoint delays the
+// crawl by one event's retries at most.
+func (w *WebhookService) DeliverAll(events []models.ProductEvent) []models.WebhookDeadLetter {
+	var deadLetters []models.WebhookDeadLetter
+	var unavailable error
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			w.logger.Error("Failed to encode %s event for product %d: %v", event.Type, event.ProductID, err)
+			continue
+		}
+
+		var attempts, status int
+		if unavailable != nil {
+			err = fmt.Errorf("not sent, webhook unavailable: %w", unavailable)
+		} else {
+			attempts, status, err = w.deliver(event.Type, payload)
+			if err == nil {
+				metrics.WebhookDeliveries.Inc("delivered")
+				continue
+			}
+			if status == 0 || isRetryableStatus(status) {
+				unavailable = err
+			}
+		}
+
+		metrics.WebhookDeliveries.Inc("dead_letter")
+		w.logger.With(utils.ProductIDKey, event.ProductID).Error("Failed to deliver %s event for product %d after %d attempts: %v",
+			event.Type, event.ProductID, attempts, err)
+		deadLetters = append(deadLetters, models.WebhookDeadLetter{
+			EventType:    event.Type,
+			Retailer:     event.Retailer,
+			ProductID:    event.ProductID,
+			URL:          w.url,
+			Payload:      payload,
+			Attempts:     attempts,
+			LastStatus:   status,
+			ErrorMessage: err.Error(),
+			FailedAt:     time.Now(),
+		})
+	}
+
+	if len(events) > 0 {
+		w.logger.Info("Delivered %d of %d webhook events", len(events)-len(deadLetters), len(events))
+	}
+	return deadLetters
+}
+
+// deliver posts a payload, retrying transport errors, 429 and 5xx responses up
+// to maxRetries times with exponential backoff. It returns the number of
+// attempts made and the last HTTP status code, zero when no response was received.
+func (w *WebhookService) deliver(eventType string, payload []byte) (int, int, error) {
+	deliveryID := utils.NewRunID()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(payload))
+		if err != nil {
+			return attempt, 0, fmt.Errorf("failed to create webhook request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "bonpreu-go")
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, w.Sign(timestamp, payload))
+		req.Header.Set(WebhookEventHeader, eventType)
+		req.Header.Set(WebhookDeliveryHeader, deliveryID)
+
+		var status int
+		resp, err := w.client.Do(req)
+		if err != nil {
+			metrics.HTTPRequests.Inc("webhook", "error")
+			err = fmt.Errorf("failed to send webhook: %w", err)
+		} else {
+			metrics.HTTPRequests.Inc("webhook", strconv.Itoa(resp.StatusCode))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			status = resp.StatusCode
+			if status/100 == 2 {
+				return attempt + 1, status, nil
+			}
+			err = fmt.Errorf("webhook returned status code %d", status)
+			if !isRetryableStatus(status) {
+				return attempt + 1, status, err
+			}
+		}
+
+		if attempt >= w.maxRetries {
+			return attempt + 1, status, err
+		}
+
+		wait := w.retryDelay << attempt
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+		metrics.HTTPRetries.Inc("webhook", strconv.Itoa(status))
+		w.logger.Warn("Retrying %s webhook in %v (attempt %d): %v", eventType, wait, attempt+1, err)
+		time.Sleep(wait)
+	}
+}
+
+// isRetryableStatus reports whether a response status code is worth retrying.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
@@ -0,0 +1,111 @@
+package services_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bonpreu-go/pkg/config"
+	"bonpreu-go/pkg/models"
+	"bonpreu-go/pkg/services"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	var requests atomic.Int32
+	var webhook *services.WebhookService
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(services.WebhookTimestampHeader)
+		if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+			t.Errorf("timestamp = %q, want the current Unix time", timestamp)
+		}
+		if got, want := r.Header.Get(services.WebhookSignatureHeader), webhook.Sign(timestamp, body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+
+		var event models.ProductEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+
+		switch event.ProductID {
+		case 1:
+			// Fails once, then succeeds on retry
+			if requests.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	webhook = services.NewWebhookService(config.WebhookConfig{
+		URL:        server.URL,
+		Secret:     "s3cret",
+		Timeout:    time.Second,
+		MaxRetries: 2,
+		RetryDelay: time.Millisecond,
+	})
+
+	deadLetters := webhook.DeliverAll([]models.ProductEvent{
+		{Type: models.EventPriceChanged, ProductID: 1},
+		{Type: models.EventBackInStock, ProductID: 2},
+	})
+
+	if requests.Load() != 2 {
+		t.Errorf("product 1 was sent %d times, want 2", requests.Load())
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("got %d dead letters, want 1: %+v", len(deadLetters), deadLetters)
+	}
+	if deadLetter := deadLetters[0]; deadLetter.ProductID != 2 || deadLetter.LastStatus != http.StatusBadRequest || deadLetter.Attempts != 1 {
+		t.Errorf("dead letter = %+v, want product 2 rejected once with 400", deadLetter)
+	}
+}
+
+func TestWebhookDeliveryStopsWhenUnavailable(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	webhook := services.NewWebhookService(config.WebhookConfig{
+		URL:        server.URL,
+		Timeout:    time.Second,
+		MaxRetries: 2,
+		RetryDelay: time.Millisecond,
+	})
+
+	events := make([]models.ProductEvent, 5)
+	for i := range events {
+		events[i] = models.ProductEvent{Type: models.EventPriceChanged, ProductID: i + 1}
+	}
+	deadLetters := webhook.DeliverAll(events)
+
+	// Only the first event is tried; the rest are dead-lettered unsent
+	if requests.Load() != 3 {
+		t.Errorf("webhook was called %d times, want 3", requests.Load())
+	}
+	if len(deadLetters) != len(events) {
+		t.Fatalf("got %d dead letters, want %d", len(deadLetters), len(events))
+	}
+	if deadLetters[0].Attempts != 3 || deadLetters[0].LastStatus != http.StatusBadGateway {
+		t.Errorf("first dead letter = %+v, want 3 attempts ending with 502", deadLetters[0])
+	}
+	for _, deadLetter := range deadLetters[1:] {
+		if deadLetter.Attempts != 0 || deadLetter.Payload == nil {
+			t.Errorf("dead letter = %+v, want an unsent payload", deadLetter)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package testdb provides the Postgres database of tests that need one.
+package testdb
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// URL returns the connection URL of a fresh schema of the Postgres database
+// in TEST_DATABASE_URL, created from scripts/schema.sql and dropped when the
+// test ends. Every connection opened with the URL uses the schema. Tests
+// that need Postgres are skipped when the variable is not set.
+func URL(t *testing.T) string {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	// The schema is found from this file, whichever package the test is in
+	_, file, _, _ := runtime.Caller(0)
+	schemaSQL, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "scripts", "schema.sql"))
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+
+	admin, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if db, err := sql.Open("postgres", databaseURL); err == nil {
+			db.Exec("DROP SCHEMA " + schema + " CASCADE")
+			db.Close()
+		}
+	})
+
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		t.Fatalf("parsing TEST_DATABASE_URL: %v", err)
+	}
+	query := parsed.Query()
+	query.Set("search_path", schema)
+	parsed.RawQuery = query.Encode()
+
+	setup, err := sql.Open("postgres", parsed.String())
+	if err != nil {
+		t.Fatalf("opening test schema: %v", err)
+	}
+	defer setup.Close()
+	if _, err := setup.Exec(string(schemaSQL)); err != nil {
+		t.Fatalf("applying schema: %v", err)
+	}
+	return parsed.String()
+}
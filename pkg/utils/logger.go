@@ -1,41 +1,254 @@
 package utils
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// Logger provides logging functionality
+// Attribute keys used for contextual logging across the application.
+const (
+	ComponentKey = "component"
+	RunIDKey     = "run_id"
+	ProductIDKey = "product_id"
+	WorkerIDKey  = "worker_id"
+)
+
+// LogOptions configures the global logging backend.
+type LogOptions struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string
+	// Format is the output format: text or json.
+	Format string
+	// ComponentLevels overrides the level per component, formatted as
+	// "ProductService=debug,DatabaseService=warn".
+	ComponentLevels string
+	// Output is where log records are written. Defaults to os.Stderr.
+	Output io.Writer
+}
+
+// logState is the global logging configuration shared by every Logger.
+// It is swapped atomically so that loggers created before ConfigureLogging
+// pick up the new settings.
+type logState struct {
+	handler         slog.Handler
+	level           slog.Level
+	componentLevels map[string]slog.Level
+}
+
+var state atomic.Pointer[logState]
+
+func init() {
+	state.Store(&logState{
+		handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// ConfigureLogging replaces the global logging backend using the given options.
+// It returns an error if the level, format or component overrides are invalid.
+func ConfigureLogging(opts LogOptions) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	componentLevels, err := ParseComponentLevels(opts.ComponentLevels)
+	if err != nil {
+		return err
+	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	// Filtering happens in Logger, so the handler accepts every level
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(output, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(output, handlerOpts)
+	default:
+		return fmt.Errorf("invalid log format %q: must be text or json", opts.Format)
+	}
+
+	state.Store(&logState{
+		handler:         handler,
+		level:           level,
+		componentLevels: componentLevels,
+	})
+	return nil
+}
+
+// SetGlobalAttrs adds attributes, such as the run ID, to every subsequent log record.
+// Arguments are key/value pairs as accepted by slog.
+func SetGlobalAttrs(args ...any) {
+	current := state.Load()
+	state.Store(&logState{
+		handler:         current.handler.WithAttrs(argsToAttrs(args)),
+		level:           current.level,
+		componentLevels: current.componentLevels,
+	})
+}
+
+// ParseLevel parses a level name (debug, info, warn, error). An empty name means info.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", name)
+}
+
+// ParseComponentLevels parses per-component overrides such as
+// "ProductService=debug,DatabaseService=warn".
+func ParseComponentLevels(spec string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		component, levelName, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(component) == "" {
+			return nil, fmt.Errorf("invalid component log level %q: expected component=level", entry)
+		}
+
+		level, err := ParseLevel(levelName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level for component %s: %w", component, err)
+		}
+		levels[strings.TrimSpace(component)] = level
+	}
+	return levels, nil
+}
+
+// NewRunID returns a short random identifier used to correlate the logs of one run.
+func NewRunID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Logger provides levelled, structured logging for a component.
+// Messages are printf-style; contextual attributes are added with With.
 type Logger struct {
 	prefix string
+	attrs  []slog.Attr
 }
 
-// NewLogger creates a new logger instance
+// NewLogger creates a new logger instance for the named component
 func NewLogger(prefix string) *Logger {
 	return &Logger{prefix: prefix}
 }
 
-// Info logs an info message
-func (l *Logger) Info(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	log.Printf("[INFO] %s: %s", l.prefix, message)
+// With returns a logger that adds the given key/value attributes to every record.
+func (l *Logger) With(args ...any) *Logger {
+	attrs := make([]slog.Attr, 0, len(l.attrs)+len(args)/2)
+	attrs = append(attrs, l.attrs...)
+	attrs = append(attrs, argsToAttrs(args)...)
+	return &Logger{prefix: l.prefix, attrs: attrs}
 }
 
-// Error logs an error message
-func (l *Logger) Error(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	log.Printf("[ERROR] %s: %s", l.prefix, message)
+// Enabled reports whether records at the given level are logged for this component.
+func (l *Logger) Enabled(level slog.Level) bool {
+	current := state.Load()
+	minLevel := current.level
+	if componentLevel, ok := current.componentLevels[l.prefix]; ok {
+		minLevel = componentLevel
+	}
+	return level >= minLevel
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	log.Printf("[DEBUG] %s: %s", l.prefix, message)
+	l.log(slog.LevelDebug, format, args...)
+}
+
+// Info logs an info message
+func (l *Logger) Info(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, format, args...)
+}
+
+// Warn logs a warning message
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, format, args...)
+}
+
+// Error logs an error message
+func (l *Logger) Error(format string, args ...interface{}) {
+	l.log(slog.LevelError, format, args...)
 }
 
 // LogDuration logs the duration of an operation
 func (l *Logger) LogDuration(operation string, start time.Time) {
 	duration := time.Since(start)
-	l.Info("%s completed in %v", operation, duration)
+	if !l.Enabled(slog.LevelInfo) {
+		return
+	}
+	l.emit(slog.LevelInfo, fmt.Sprintf("%s completed in %v", operation, duration),
+		slog.String("operation", operation), slog.Duration("duration", duration))
+}
+
+// log formats the message and emits it if the level is enabled.
+func (l *Logger) log(level slog.Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.emit(level, fmt.Sprintf(format, args...))
+}
+
+// emit writes a record with the component and contextual attributes to the global handler.
+func (l *Logger) emit(level slog.Level, message string, extra ...slog.Attr) {
+	record := slog.NewRecord(time.Now(), level, message, 0)
+	record.AddAttrs(slog.String(ComponentKey, l.prefix))
+	record.AddAttrs(l.attrs...)
+	record.AddAttrs(extra...)
+
+	// Logging must never fail the caller, so handler errors are ignored
+	_ = state.Load().handler.Handle(context.Background(), record)
+}
+
+// argsToAttrs converts slog-style key/value arguments into attributes.
+func argsToAttrs(args []any) []slog.Attr {
+	var attrs []slog.Attr
+	for len(args) > 0 {
+		switch key := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, key)
+			args = args[1:]
+		case string:
+			if len(args) < 2 {
+				attrs = append(attrs, slog.Any("!BADKEY", key))
+				args = args[1:]
+				continue
+			}
+			attrs = append(attrs, slog.Any(key, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", key))
+			args = args[1:]
+		}
+	}
+	return attrs
 }
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configureTestLogging points the global logging backend at a buffer and
+// restores the previous backend when the test ends.
+func configureTestLogging(t *testing.T, opts LogOptions) *bytes.Buffer {
+	t.Helper()
+
+	previous := state.Load()
+	t.Cleanup(func() { state.Store(previous) })
+
+	var buf bytes.Buffer
+	opts.Output = &buf
+	if err := ConfigureLogging(opts); err != nil {
+		t.Fatalf("ConfigureLogging: %v", err)
+	}
+	return &buf
+}
+
+func TestTextOutput(t *testing.T) {
+	buf := configureTestLogging(t, LogOptions{Level: "info", Format: "text"})
+
+	NewLogger("ProductService").With(ProductIDKey, 90001).Info("Fetched %d products", 3)
+
+	line := buf.String()
+	for _, want := range []string{"level=INFO", `msg="Fetched 3 products"`, "component=ProductService", "product_id=90001"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("text output %q is missing %s", line, want)
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	buf := configureTestLogging(t, LogOptions{Level: "info", Format: "json"})
+	SetGlobalAttrs(RunIDKey, "abc123")
+
+	NewLogger("DatabaseService").With(WorkerIDKey, "worker-1").Warn("Saved %d of %d rows", 1, 2)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("output %q is not JSON: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":      "WARN",
+		"msg":        "Saved 1 of 2 rows",
+		ComponentKey: "DatabaseService",
+		RunIDKey:     "abc123",
+		WorkerIDKey:  "worker-1",
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("%s = %v, want %v", key, record[key], value)
+		}
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	buf := configureTestLogging(t, LogOptions{
+		Level:           "warn",
+		Format:          "json",
+		ComponentLevels: "ProductService=debug, DatabaseService=error",
+	})
+
+	NewLogger("Main").Info("main info")
+	NewLogger("Main").Warn("main warn")
+	NewLogger("ProductService").Debug("product debug")
+	NewLogger("DatabaseService").Warn("database warn")
+	NewLogger("DatabaseService").Error("database error")
+	NewLogger("Main").LogDuration("crawl", time.Now())
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record struct {
+			Msg string `json:"msg"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		messages = append(messages, record.Msg)
+	}
+
+	want := []string{"main warn", "product debug", "database error"}
+	if strings.Join(messages, "|") != strings.Join(want, "|") {
+		t.Errorf("logged %q, want %q", messages, want)
+	}
+
+	if !NewLogger("ProductService").Enabled(slog.LevelDebug) || NewLogger("Main").Enabled(slog.LevelInfo) {
+		t.Error("Enabled does not follow the configured levels")
+	}
+}
+
+func TestConfigureLoggingRejectsInvalidOptions(t *testing.T) {
+	previous := state.Load()
+	t.Cleanup(func() { state.Store(previous) })
+
+	tests := []struct {
+		name string
+		opts LogOptions
+	}{
+		{"level", LogOptions{Level: "verbose"}},
+		{"format", LogOptions{Format: "xml"}},
+		{"component level", LogOptions{ComponentLevels: "ProductService=loud"}},
+		{"component without level", LogOptions{ComponentLevels: "ProductService"}},
+	}
+
+	for _, tt := range tests {
+		if err := ConfigureLogging(tt.opts); err == nil {
+			t.Errorf("%s: expected an error for %+v", tt.name, tt.opts)
+		}
+	}
+	if state.Load() != previous {
+		t.Error("invalid options replaced the logging backend")
+	}
+}
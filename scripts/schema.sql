@@ -62,4 +62,454 @@ COMMENT ON TABLE products IS 'Stores product information from Bonpreu API';
 COMMENT ON TABLE product_nutritional_data IS 'Stores nutritional information for products';
 COMMENT ON COLUMN products.product_categories IS 'Array of category strings for the product';
 COMMENT ON COLUMN products.created_at IS 'Timestamp when the record was created';
-COMMENT ON COLUMN products.updated_at IS 'Timestamp when the record was last updated'; 
\ No newline at end of file
+COMMENT ON COLUMN products.updated_at IS 'Timestamp when the record was last updated'; 
+
+-- Create product_fetch_failures table
+-- Products that failed to fetch are kept here between runs so that
+-- `bonpreu retry-failures` can refetch them without a full crawl.
+CREATE TABLE IF NOT EXISTS product_fetch_failures (
+    product_id INTEGER PRIMARY KEY,
+    error_category VARCHAR(50) NOT NULL, -- rate_limited, http_status, parse or transport
+    http_status INTEGER, -- NULL when no response was received
+    attempts INTEGER NOT NULL DEFAULT 1,
+    error_message TEXT,
+    first_failed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_failed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    failure_count INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_fetch_failures_error_category ON product_fetch_failures(error_category);
+
+COMMENT ON TABLE product_fetch_failures IS 'Products that could not be fetched, kept for retrying';
+COMMENT ON COLUMN product_fetch_failures.failure_count IS 'Number of consecutive runs in which the product failed';
+
+-- Create product_observations table
+-- One row per product per crawl. Unlike products, which holds the latest state,
+-- observations are never overwritten so that changes can be tracked over time.
+CREATE TABLE IF NOT EXISTS product_observations (
+    id BIGSERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL,
+    observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    product_price_amount DECIMAL(10,2),
+    product_currency VARCHAR(10),
+    product_unit_price_amount DECIMAL(10,2),
+    product_unit_price_unit VARCHAR(50),
+    product_pack_size_description VARCHAR(255),
+    product_available BOOLEAN DEFAULT false,
+    promotion_type VARCHAR(255),
+    UNIQUE (product_id, observed_at),
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_observations_product_observed ON product_observations(product_id, observed_at DESC);
+
+-- Create product_promotions table
+-- Every entry of bopPromotions, per product per observation.
+CREATE TABLE IF NOT EXISTS product_promotions (
+    id BIGSERIAL PRIMARY KEY,
+    observation_id BIGINT NOT NULL,
+    product_id INTEGER NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0, -- Index in bopPromotions
+    promotion_type VARCHAR(255),
+    description TEXT, -- Mechanics, e.g. "3x2" or "2a unitat -50%"
+    start_date TIMESTAMP WITH TIME ZONE,
+    end_date TIMESTAMP WITH TIME ZONE,
+    discounted_price DECIMAL(10,2), -- Average unit price with the promotion, exposed or derived from the mechanics
+    required_quantity INTEGER NOT NULL DEFAULT 1,
+    observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (observation_id, position),
+    FOREIGN KEY (observation_id) REFERENCES product_observations(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_promotions_product_id ON product_promotions(product_id);
+CREATE INDEX IF NOT EXISTS idx_product_promotions_dates ON product_promotions(start_date, end_date);
+
+COMMENT ON TABLE product_observations IS 'Snapshot of each product per crawl';
+COMMENT ON TABLE product_promotions IS 'Every promotion of a product per observation';
+
+-- Effective prices, with the cheapest active promotion applied, per observation
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS effective_unit_price DECIMAL(10,2);
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS effective_price_per_unit DECIMAL(10,2); -- Per kg, litre or unit
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS effective_price_base_unit VARCHAR(10); -- kg, l or unit
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS effective_mechanic VARCHAR(50); -- Promotion mechanic applied, NULL for the shelf price
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS effective_required_quantity INTEGER DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_product_observations_effective_price ON product_observations(effective_price_base_unit, effective_price_per_unit);
+
+COMMENT ON COLUMN product_observations.effective_price_per_unit IS 'Effective price per kg, litre or unit, used to rank products by real cost';
+
+-- Structured pack sizes parsed from product_pack_size_description
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_units INTEGER; -- Items in the pack
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_unit_quantity DECIMAL(12,3); -- Quantity per item, in pack_unit_of_measure
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_unit_of_measure VARCHAR(10); -- g, kg, ml, cl, l or u
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_net_quantity DECIMAL(12,3); -- Total, in pack_base_unit
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_base_unit VARCHAR(10); -- g, ml or units
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_size_confidence VARCHAR(10); -- high, low, inferred or none
+ALTER TABLE products ADD COLUMN IF NOT EXISTS pack_unit_price_check VARCHAR(10); -- match, mismatch or unchecked
+
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS pack_net_quantity DECIMAL(12,3);
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS pack_base_unit VARCHAR(10);
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS pack_size_confidence VARCHAR(10);
+
+COMMENT ON COLUMN products.pack_size_confidence IS 'low when the description is approximate, ambiguous or disagrees with the unit price';
+
+-- Create shrinkflation_reports table
+-- Products whose net quantity decreased between two successive observations
+-- while the shelf price stayed the same or rose.
+CREATE TABLE IF NOT EXISTS shrinkflation_reports (
+    id BIGSERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL,
+    old_observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    new_observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    old_pack_size_description VARCHAR(255),
+    new_pack_size_description VARCHAR(255),
+    old_net_quantity DECIMAL(12,3),
+    new_net_quantity DECIMAL(12,3),
+    base_unit VARCHAR(10), -- g, ml or units
+    old_price DECIMAL(10,2),
+    new_price DECIMAL(10,2),
+    old_unit_price DECIMAL(10,2),
+    new_unit_price DECIMAL(10,2),
+    unit_price_unit VARCHAR(10), -- kg, l or unit
+    unit_price_increase_percent DECIMAL(8,2),
+    low_confidence BOOLEAN DEFAULT false, -- Either pack size was parsed with low confidence
+    detected_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (product_id, new_observed_at),
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_shrinkflation_reports_new_observed_at ON shrinkflation_reports(new_observed_at);
+
+COMMENT ON TABLE shrinkflation_reports IS 'Pack size decreases without a matching price decrease';
+
+-- Create categories table
+-- Category taxonomy built from the categoryPath of every observed product.
+-- IDs are kept when a category is renamed.
+CREATE TABLE IF NOT EXISTS categories (
+    id SERIAL PRIMARY KEY,
+    parent_id INTEGER REFERENCES categories(id) ON DELETE CASCADE, -- NULL for top-level categories
+    name VARCHAR(255) NOT NULL,
+    path TEXT[] NOT NULL UNIQUE, -- Full path from the top-level category, including name
+    depth INTEGER NOT NULL,
+    first_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories(parent_id);
+
+COMMENT ON TABLE categories IS 'Category taxonomy built from product category paths';
+
+-- Leaf category of each product
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_products_category_id ON products(category_id);
+
+-- Create category_renames table
+CREATE TABLE IF NOT EXISTS category_renames (
+    id BIGSERIAL PRIMARY KEY,
+    category_id INTEGER NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    old_path TEXT[] NOT NULL,
+    new_path TEXT[] NOT NULL,
+    renamed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create product_category_moves table
+-- Products whose leaf category changed between crawls.
+CREATE TABLE IF NOT EXISTS product_category_moves (
+    id BIGSERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    old_category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL,
+    new_category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL,
+    old_path TEXT[],
+    new_path TEXT[],
+    moved_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_category_moves_moved_at ON product_category_moves(moved_at);
+
+-- Create watchlist table
+-- Products to send webhook notifications about, by ID or by search and category filters.
+CREATE TABLE IF NOT EXISTS watchlist (
+    id SERIAL PRIMARY KEY,
+    product_id INTEGER, -- Single product, NULL to match by filters
+    search_query VARCHAR(255), -- Case-insensitive match on name or brand
+    category VARCHAR(255), -- Any level of the category path
+    event_types TEXT[], -- price_changed, promotion_started, back_in_stock; NULL for all
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (product_id IS NOT NULL OR search_query IS NOT NULL OR category IS NOT NULL)
+);
+
+COMMENT ON TABLE watchlist IS 'Products to notify about when their price, promotion or stock changes';
+
+-- Create webhook_dead_letters table
+-- Webhook events that could not be delivered after all retries.
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    product_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_status INTEGER, -- NULL when no response was received
+    error_message TEXT,
+    failed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_dead_letters_failed_at ON webhook_dead_letters(failed_at);
+
+-- Create email_digests table
+-- Days for which the email digest was sent, so that it goes out once a day.
+CREATE TABLE IF NOT EXISTS email_digests (
+    digest_date DATE PRIMARY KEY,
+    recipients INTEGER NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create crawl_jobs table
+-- Work queue of the distributed crawl. Workers claim jobs with FOR UPDATE SKIP LOCKED
+-- and hold them under a lease that their heartbeats renew; expired leases are reassigned.
+CREATE TABLE IF NOT EXISTS crawl_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    run_id VARCHAR(64) NOT NULL,
+    product_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, running, done or failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    worker_id VARCHAR(255),
+    leased_until TIMESTAMP WITH TIME ZONE,
+    error_category VARCHAR(50),
+    error_message TEXT,
+    enqueued_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (run_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_crawl_jobs_claimable ON crawl_jobs(id) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_crawl_jobs_open_product_id ON crawl_jobs(product_id) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_crawl_jobs_worker_id ON crawl_jobs(worker_id) WHERE status = 'running';
+
+COMMENT ON TABLE crawl_jobs IS 'Products queued for fetching by distributed crawl workers';
+
+-- Create crawl_workers table
+-- Worker processes of the distributed crawl and their last heartbeat.
+CREATE TABLE IF NOT EXISTS crawl_workers (
+    worker_id VARCHAR(255) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    heartbeat_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    jobs_done INTEGER NOT NULL DEFAULT 0,
+    jobs_failed INTEGER NOT NULL DEFAULT 0
+);
+
+-- Retailers
+-- Products are keyed by (retailer, product_id) since product IDs of different
+-- retailers may collide. Rows stored before retailers were introduced are Bonpreu's.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE product_nutritional_data ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE product_fetch_failures ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE product_observations ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE product_promotions ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE shrinkflation_reports ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE product_category_moves ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE webhook_dead_letters ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE crawl_jobs ADD COLUMN IF NOT EXISTS retailer VARCHAR(50) NOT NULL DEFAULT 'bonpreu';
+ALTER TABLE watchlist ADD COLUMN IF NOT EXISTS retailer VARCHAR(50); -- NULL matches every retailer
+
+-- Move the product_id keys to (retailer, product_id), once
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'products_pkey' AND conrelid = 'products'::regclass
+            AND array_length(conkey, 1) = 2
+    ) THEN
+        ALTER TABLE product_nutritional_data DROP CONSTRAINT IF EXISTS product_nutritional_data_product_id_fkey;
+        ALTER TABLE product_observations DROP CONSTRAINT IF EXISTS product_observations_product_id_fkey;
+        ALTER TABLE shrinkflation_reports DROP CONSTRAINT IF EXISTS shrinkflation_reports_product_id_fkey;
+        ALTER TABLE product_category_moves DROP CONSTRAINT IF EXISTS product_category_moves_product_id_fkey;
+
+        ALTER TABLE products DROP CONSTRAINT products_pkey;
+        ALTER TABLE products ADD CONSTRAINT products_pkey PRIMARY KEY (retailer, product_id);
+
+        ALTER TABLE product_fetch_failures DROP CONSTRAINT IF EXISTS product_fetch_failures_pkey;
+        ALTER TABLE product_fetch_failures ADD CONSTRAINT product_fetch_failures_pkey PRIMARY KEY (retailer, product_id);
+
+        ALTER TABLE product_observations DROP CONSTRAINT IF EXISTS product_observations_product_id_observed_at_key;
+        ALTER TABLE product_observations ADD CONSTRAINT product_observations_retailer_product_id_observed_at_key
+            UNIQUE (retailer, product_id, observed_at);
+
+        ALTER TABLE shrinkflation_reports DROP CONSTRAINT IF EXISTS shrinkflation_reports_product_id_new_observed_at_key;
+        ALTER TABLE shrinkflation_reports ADD CONSTRAINT shrinkflation_reports_retailer_product_id_new_observed_at_key
+            UNIQUE (retailer, product_id, new_observed_at);
+
+        ALTER TABLE crawl_jobs DROP CONSTRAINT IF EXISTS crawl_jobs_run_id_product_id_key;
+        ALTER TABLE crawl_jobs ADD CONSTRAINT crawl_jobs_run_id_retailer_product_id_key
+            UNIQUE (run_id, retailer, product_id);
+
+        ALTER TABLE product_nutritional_data ADD CONSTRAINT product_nutritional_data_product_fkey
+            FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE;
+        ALTER TABLE product_observations ADD CONSTRAINT product_observations_product_fkey
+            FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE;
+        ALTER TABLE shrinkflation_reports ADD CONSTRAINT shrinkflation_reports_product_fkey
+            FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE;
+        ALTER TABLE product_category_moves ADD CONSTRAINT product_category_moves_product_fkey
+            FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE;
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS idx_product_nutritional_data_product_id;
+DROP INDEX IF EXISTS idx_product_observations_product_observed;
+DROP INDEX IF EXISTS idx_product_promotions_product_id;
+DROP INDEX IF EXISTS idx_crawl_jobs_open_product_id;
+
+CREATE INDEX IF NOT EXISTS idx_product_nutritional_data_product ON product_nutritional_data(retailer, product_id);
+CREATE INDEX IF NOT EXISTS idx_product_observations_retailer_product_observed ON product_observations(retailer, product_id, observed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_product_promotions_product ON product_promotions(retailer, product_id);
+CREATE INDEX IF NOT EXISTS idx_crawl_jobs_open_product ON crawl_jobs(retailer, product_id) WHERE status IN ('pending', 'running');
+
+-- Product matching
+-- GTINs (EAN barcodes) extracted from the API, normalised to 13 digits
+ALTER TABLE products ADD COLUMN IF NOT EXISTS product_gtins TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_products_gtins ON products USING GIN (product_gtins);
+
+-- Create product_matches table
+-- Links between the same product at two retailers. The left retailer sorts first.
+-- Accepted and pending matches are recomputed by every matching run; approved and
+-- rejected ones were reviewed and are kept.
+CREATE TABLE IF NOT EXISTS product_matches (
+    id BIGSERIAL PRIMARY KEY,
+    left_retailer VARCHAR(50) NOT NULL,
+    left_product_id INTEGER NOT NULL,
+    right_retailer VARCHAR(50) NOT NULL,
+    right_product_id INTEGER NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    score NUMERIC(5, 4) NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    matched_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (left_retailer, left_product_id, right_retailer, right_product_id),
+    FOREIGN KEY (left_retailer, left_product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE,
+    FOREIGN KEY (right_retailer, right_product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_matches_status ON product_matches(status);
+CREATE INDEX IF NOT EXISTS idx_product_matches_right ON product_matches(right_retailer, right_product_id);
+
+-- Create product_details table
+-- Label information from the bopData fields, replaced on every crawl of the product.
+CREATE TABLE IF NOT EXISTS product_details (
+    retailer VARCHAR(50) NOT NULL,
+    product_id INTEGER NOT NULL,
+    ingredients_text TEXT,
+    ingredients TEXT[],
+    allergens_text TEXT,
+    storage_conditions TEXT,
+    origin TEXT,
+    manufacturer TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (retailer, product_id),
+    FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE
+);
+
+-- Create product_allergens table
+-- EU allergens (Regulation 1169/2011, Annex II) that a product contains or may contain.
+CREATE TABLE IF NOT EXISTS product_allergens (
+    retailer VARCHAR(50) NOT NULL,
+    product_id INTEGER NOT NULL,
+    allergen VARCHAR(20) NOT NULL,
+    presence VARCHAR(12) NOT NULL CHECK (presence IN ('contains', 'may_contain')),
+    PRIMARY KEY (retailer, product_id, allergen),
+    FOREIGN KEY (retailer, product_id) REFERENCES product_details(retailer, product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_allergens_allergen ON product_allergens(allergen, presence);
+
+-- Create product_attributes table
+-- Every bopData field of every product, as plain text and as received. A new version is
+-- stored, linked to the observation that first saw it, only when the content changes;
+-- is_current marks the latest version of each field a product still has.
+CREATE TABLE IF NOT EXISTS product_attributes (
+    id BIGSERIAL PRIMARY KEY,
+    retailer VARCHAR(50) NOT NULL,
+    product_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    content_text TEXT NOT NULL,
+    content_html TEXT NOT NULL,
+    content_hash CHAR(64) NOT NULL,
+    observation_id BIGINT REFERENCES product_observations(id) ON DELETE SET NULL,
+    first_observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    is_current BOOLEAN NOT NULL DEFAULT TRUE,
+    FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_attributes_current ON product_attributes(retailer, product_id, title) WHERE is_current;
+CREATE INDEX IF NOT EXISTS idx_product_attributes_title ON product_attributes(title);
+
+-- Create product_images table
+-- One row per version of each image URL, keyed by position and size; a new
+-- version is stored only when the URL changes
+CREATE TABLE IF NOT EXISTS product_images (
+    id BIGSERIAL PRIMARY KEY,
+    retailer VARCHAR(50) NOT NULL,
+    product_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    size VARCHAR(50) NOT NULL,
+    url TEXT NOT NULL,
+    observation_id BIGINT REFERENCES product_observations(id) ON DELETE SET NULL,
+    first_observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    is_current BOOLEAN NOT NULL DEFAULT TRUE,
+    FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_images_current ON product_images(retailer, product_id, position, size) WHERE is_current;
+
+-- Create image_files table
+-- Images in the local mirror, named after the SHA-256 of their content
+CREATE TABLE IF NOT EXISTS image_files (
+    content_hash CHAR(64) PRIMARY KEY,
+    content_type VARCHAR(100) NOT NULL,
+    bytes BIGINT NOT NULL,
+    path TEXT NOT NULL,
+    first_downloaded_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Create product_image_downloads table
+CREATE TABLE IF NOT EXISTS product_image_downloads (
+    id BIGSERIAL PRIMARY KEY,
+    product_image_id BIGINT NOT NULL REFERENCES product_images(id) ON DELETE CASCADE,
+    content_hash CHAR(64) NOT NULL REFERENCES image_files(content_hash),
+    downloaded_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_image_downloads_image ON product_image_downloads(product_image_id, downloaded_at);
+
+-- Create product_nutriscores table
+-- The Nutri-Score (2023 algorithm) of every product, with the nutrition facts per 100 g
+-- or 100 ml it was computed from; missing lists the inputs that prevent a grade
+CREATE TABLE IF NOT EXISTS product_nutriscores (
+    retailer VARCHAR(50) NOT NULL,
+    product_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('graded', 'missing_inputs', 'not_applicable')),
+    category VARCHAR(20) NOT NULL,
+    grade CHAR(1) CHECK (grade IN ('A', 'B', 'C', 'D', 'E')),
+    score INTEGER,
+    negative_points INTEGER NOT NULL DEFAULT 0,
+    positive_points INTEGER NOT NULL DEFAULT 0,
+    missing TEXT[] NOT NULL DEFAULT '{}',
+    assumed TEXT[] NOT NULL DEFAULT '{}',
+    energy_kj NUMERIC,
+    fat NUMERIC,
+    saturated_fat NUMERIC,
+    sugars NUMERIC,
+    fibre NUMERIC,
+    protein NUMERIC,
+    salt NUMERIC,
+    computed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (retailer, product_id),
+    FOREIGN KEY (retailer, product_id) REFERENCES products(retailer, product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_nutriscores_grade ON product_nutriscores(grade);